@@ -0,0 +1,67 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/RedHatInsights/haberdasher/buildinfo"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+var mux = http.NewServeMux()
+
+func init() {
+	Handle("/metrics", metrics.Handler())
+	Handle("/buildinfo", buildinfo.Handler())
+	Handle("/config", config.Handler())
+}
+
+// Handle adds a read-only endpoint to the admin listener
+func Handle(pattern string, handler http.Handler) {
+	mux.Handle(pattern, authorize(Read, handler))
+}
+
+// HandleControl adds an endpoint which changes something, and so needs the
+// control scope. It's refused until credentials granting control are
+// configured.
+func HandleControl(pattern string, handler http.Handler) {
+	mux.Handle(pattern, authorize(Control, handler))
+}
+
+// HandlePublic adds an endpoint which never needs credentials, for callers
+// like the kubelet's probes which can't present them
+func HandlePublic(pattern string, handler http.Handler) {
+	mux.Handle(pattern, handler)
+}
+
+// Start launches the admin listener in the background if
+// HABERDASHER_ADMIN_ADDR is set to a listen address like ":9000". It is
+// optional, and nothing is exposed unless it's configured. It serves HTTPS if
+// it has a certificate, and requires credentials if any are configured; see
+// configureAuth. Control endpoints always do.
+func Start() {
+	addr, exists := config.Setting("HABERDASHER_ADMIN_ADDR")
+	if !exists {
+		return
+	}
+	server := &http.Server{Addr: addr, Handler: mux, TLSConfig: configureAuth()}
+	if !authEnabled {
+		log.Println("Warning: the admin listener has no authentication")
+	}
+	if !controlEnabled {
+		log.Println("The admin listener's control endpoints are disabled, since no credentials grant control")
+	}
+	log.Println("Starting admin listener on", addr)
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil {
+			log.Println("Admin listener stopped:", err)
+		}
+	}()
+}
@@ -0,0 +1,160 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/fips"
+)
+
+// A Scope is what a caller needs to be allowed to use an endpoint
+type Scope int
+
+const (
+	// Public endpoints, like readiness probes, need no credentials
+	Public Scope = iota
+	// Read endpoints report on haberdasher without changing anything
+	Read
+	// Control endpoints act on haberdasher or the child
+	Control
+)
+
+var readToken, controlToken string
+var controlClients map[string]bool
+
+// authEnabled is set once any credentials are configured. Until then the
+// read endpoints are open, as they always have been.
+var authEnabled bool
+
+// controlEnabled is set once credentials granting control are configured.
+// Until then the control endpoints refuse everyone, so a neighbour which can
+// reach the listener can't stop or signal the child.
+var controlEnabled bool
+
+// configureAuth reads the admin listener's credentials: bearer tokens for each
+// scope, HABERDASHER_ADMIN_READ_TOKEN and HABERDASHER_ADMIN_CONTROL_TOKEN (which
+// also grants read), and client certificates signed by
+// HABERDASHER_ADMIN_CLIENT_CA, which grant read, or control if their common
+// name or a SAN is in HABERDASHER_ADMIN_CONTROL_CLIENTS. Tokens need the
+// listener to serve HTTPS, so they aren't sent in the clear. It returns the
+// TLS configuration to serve with, or nil for plain HTTP.
+func configureAuth() *tls.Config {
+	readToken, _ = config.Setting("HABERDASHER_ADMIN_READ_TOKEN")
+	controlToken, _ = config.Setting("HABERDASHER_ADMIN_CONTROL_TOKEN")
+	controlClients = make(map[string]bool)
+	clients, _ := config.Setting("HABERDASHER_ADMIN_CONTROL_CLIENTS")
+	for _, client := range strings.Split(clients, ",") {
+		if client = strings.TrimSpace(client); client != "" {
+			controlClients[client] = true
+		}
+	}
+	authEnabled = readToken != "" || controlToken != ""
+
+	certFile, hasCert := config.Setting("HABERDASHER_ADMIN_TLS_CERT")
+	keyFile, hasKey := config.Setting("HABERDASHER_ADMIN_TLS_KEY")
+	caFile, hasCA := config.Setting("HABERDASHER_ADMIN_CLIENT_CA")
+	if hasCert != hasKey {
+		log.Fatal("HABERDASHER_ADMIN_TLS_CERT and HABERDASHER_ADMIN_TLS_KEY must be set together")
+	}
+	if hasCA && !hasCert {
+		log.Fatal("HABERDASHER_ADMIN_CLIENT_CA needs HABERDASHER_ADMIN_TLS_CERT and HABERDASHER_ADMIN_TLS_KEY")
+	}
+	if authEnabled && !hasCert {
+		log.Fatal("HABERDASHER_ADMIN_READ_TOKEN and HABERDASHER_ADMIN_CONTROL_TOKEN need HABERDASHER_ADMIN_TLS_CERT and HABERDASHER_ADMIN_TLS_KEY, so tokens aren't sent in the clear")
+	}
+	controlEnabled = controlToken != "" || (hasCA && len(controlClients) > 0)
+	if !hasCert {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		log.Fatal("HABERDASHER_ADMIN_TLS_CERT: ", err)
+	}
+	config := fips.TLS(&tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}})
+	if hasCA {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			log.Fatal("HABERDASHER_ADMIN_CLIENT_CA: ", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			log.Fatal("HABERDASHER_ADMIN_CLIENT_CA: no certificates found in ", caFile)
+		}
+		// Public endpoints must still work without a certificate
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		authEnabled = true
+	}
+	return config
+}
+
+// authorize wraps a handler so only callers granted its scope can use it
+func authorize(scope Scope, handler http.Handler) http.Handler {
+	if scope == Public {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if scope == Control && !controlEnabled {
+			http.Error(w, "forbidden: control is disabled until HABERDASHER_ADMIN_CONTROL_TOKEN or HABERDASHER_ADMIN_CONTROL_CLIENTS is configured", http.StatusForbidden)
+			return
+		}
+		if !authEnabled || granted(r) >= scope {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		if _, hasAuth := bearer(r); !hasAuth && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="haberdasher"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+}
+
+// granted works out the most a request's credentials allow
+func granted(r *http.Request) Scope {
+	best := Public
+	if token, ok := bearer(r); ok {
+		if controlToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(controlToken)) == 1 {
+			return Control
+		}
+		if readToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(readToken)) == 1 {
+			best = Read
+		}
+	}
+	// The TLS handshake has already verified any certificate against the CA
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		client := r.TLS.VerifiedChains[0][0]
+		for _, identity := range certIdentities(client) {
+			if controlClients[identity] {
+				return Control
+			}
+		}
+		best = Read
+	}
+	return best
+}
+
+// bearer finds the request's bearer token, if it has one
+func bearer(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// certIdentities lists the names a client certificate can be recognized by
+func certIdentities(cert *x509.Certificate) []string {
+	identities := []string{cert.Subject.CommonName}
+	identities = append(identities, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	defer func(read, control string, auth, controls bool) {
+		readToken, controlToken, authEnabled, controlEnabled = read, control, auth, controls
+	}(readToken, controlToken, authEnabled, controlEnabled)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		read       string
+		control    string
+		scope      Scope
+		credential string
+		want       int
+	}{
+		{"read without credentials configured", "", "", Read, "", http.StatusOK},
+		{"control without credentials configured", "", "", Control, "", http.StatusForbidden},
+		{"control with only a read token configured", "reader", "", Control, "reader", http.StatusForbidden},
+		{"read with the read token", "reader", "controller", Read, "reader", http.StatusOK},
+		{"read with the control token", "reader", "controller", Read, "controller", http.StatusOK},
+		{"control with the read token", "reader", "controller", Control, "reader", http.StatusForbidden},
+		{"control with the control token", "reader", "controller", Control, "controller", http.StatusOK},
+		{"control without a token", "reader", "controller", Control, "", http.StatusUnauthorized},
+		{"public without a token", "reader", "controller", Public, "", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			readToken, controlToken = test.read, test.control
+			authEnabled = test.read != "" || test.control != ""
+			controlEnabled = test.control != ""
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			if test.credential != "" {
+				r.Header.Set("Authorization", "Bearer "+test.credential)
+			}
+			w := httptest.NewRecorder()
+			authorize(test.scope, ok).ServeHTTP(w, r)
+			if w.Code != test.want {
+				t.Errorf("got %d, want %d", w.Code, test.want)
+			}
+		})
+	}
+}
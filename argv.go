@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+var argvFuncs = template.FuncMap{
+	"env": os.Getenv,
+	// {{env "WORKERS" | default "4"}}
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+	// {{required "WORKERS"}} fails startup if the variable isn't set
+	"required": func(name string) (string, error) {
+		value, exists := os.LookupEnv(name)
+		if !exists {
+			return "", fmt.Errorf("%s must be set", name)
+		}
+		return value, nil
+	},
+}
+
+// childArgv works out the command to wrap. It's normally our own arguments,
+// but can also be given as a single string in HABERDASHER_COMMAND, which is
+// split into words like a shell would without needing `sh -c` (which breaks
+// signal forwarding).
+func childArgv(args []string) ([]string, error) {
+	if command, exists := config.Setting("HABERDASHER_COMMAND"); exists && len(args) == 0 {
+		words, err := splitCommand(command)
+		if err != nil {
+			return nil, fmt.Errorf("HABERDASHER_COMMAND: %v", err)
+		}
+		args = words
+	}
+	return renderArgv(args)
+}
+
+// Entrypoints often need a shell wrapper just to put environment variables in
+// their arguments, and the shell then swallows our signals. If
+// HABERDASHER_TEMPLATE_ARGS is set, every argument of the child command is
+// rendered as a Go template first, e.g. --workers={{env "WORKERS"}}.
+func renderArgv(args []string) ([]string, error) {
+	if !config.FlagSetting("HABERDASHER_TEMPLATE_ARGS") {
+		return args, nil
+	}
+	rendered := make([]string, len(args))
+	for i, arg := range args {
+		tmpl, err := template.New("arg").Funcs(argvFuncs).Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, nil); err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		rendered[i] = out.String()
+	}
+	return rendered, nil
+}
@@ -0,0 +1,339 @@
+// Package aws finds AWS credentials the way the AWS SDKs do, and signs
+// requests with them, for the emitters which talk to AWS services directly.
+package aws
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Temporary credentials are refreshed this long before they expire, so a
+// request signed with them doesn't arrive after they have
+const refreshWindow = 5 * time.Minute
+
+const (
+	defaultMetadataEndpoint  = "http://169.254.169.254"
+	containerMetadataAddress = "http://169.254.170.2"
+)
+
+// Credentials are an access key, with a session token and expiry if they're
+// temporary
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+	Source          string
+}
+
+// A Chain looks for credentials in each of the standard places in turn:
+//
+//  1. AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN
+//  2. a web identity token, AWS_WEB_IDENTITY_TOKEN_FILE, exchanged for
+//     AWS_ROLE_ARN's credentials, as for EKS service accounts (IRSA)
+//  3. the shared credentials file, AWS_SHARED_CREDENTIALS_FILE or
+//     ~/.aws/credentials, with the AWS_PROFILE profile, or default
+//  4. the ECS or EKS Pod Identity container credentials endpoint
+//  5. the EC2 instance metadata service, unless AWS_EC2_METADATA_DISABLED
+//
+// and caches what it finds until shortly before they expire.
+type Chain struct {
+	client *http.Client
+	region string
+
+	lock   sync.Mutex
+	cached *Credentials
+}
+
+// NewChain returns a Chain. region is where to exchange web identity
+// tokens.
+func NewChain(region string) *Chain {
+	return &Chain{client: &http.Client{Timeout: 10 * time.Second}, region: region}
+}
+
+// Get returns credentials, from the cache while they're fresh
+func (c *Chain) Get() (Credentials, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.cached != nil && (c.cached.Expires.IsZero() || time.Until(c.cached.Expires) > refreshWindow) {
+		return *c.cached, nil
+	}
+	sources := []func() (*Credentials, error){
+		fromEnv,
+		c.fromWebIdentity,
+		fromSharedFile,
+		c.fromContainer,
+		c.fromInstanceMetadata,
+	}
+	var errs []string
+	for _, source := range sources {
+		creds, err := source()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if creds != nil {
+			c.cached = creds
+			return *creds, nil
+		}
+	}
+	if len(errs) > 0 {
+		return Credentials{}, fmt.Errorf("no AWS credentials found: %s", strings.Join(errs, "; "))
+	}
+	return Credentials{}, errors.New("no AWS credentials found")
+}
+
+// Region returns the region from AWS_REGION or AWS_DEFAULT_REGION
+func Region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// Each source returns nil credentials, and no error, if it isn't configured
+
+func fromEnv() (*Credentials, error) {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if id == "" || secret == "" {
+		return nil, nil
+	}
+	return &Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN"), Source: "environment"}, nil
+}
+
+func (c *Chain) fromWebIdentity() (*Credentials, error) {
+	tokenFile, role := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN")
+	if tokenFile == "" || role == "" {
+		return nil, nil
+	}
+	token, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("web identity: %v", err)
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = fmt.Sprintf("haberdasher-%d", time.Now().UnixNano())
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_STS")
+	if endpoint == "" {
+		endpoint = "https://sts.amazonaws.com"
+		if c.region != "" {
+			endpoint = "https://sts." + c.region + ".amazonaws.com"
+		}
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	response, err := c.client.PostForm(endpoint, query)
+	if err != nil {
+		return nil, fmt.Errorf("web identity: %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("web identity: %s %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("web identity: %v", err)
+	}
+	creds := result.Credentials
+	return &Credentials{
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+		Expires:         creds.Expiration,
+		Source:          "web identity",
+	}, nil
+}
+
+func fromSharedFile() (*Credentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("shared credentials: %v", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			section = strings.TrimSpace(line[1 : len(line)-1])
+		case section == profile:
+			if i := strings.IndexByte(line, '='); i > 0 {
+				values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	if values["aws_access_key_id"] == "" || values["aws_secret_access_key"] == "" {
+		return nil, nil
+	}
+	return &Credentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+		Source:          "shared credentials file",
+	}, nil
+}
+
+// metadataCredentials is how both the container and instance metadata
+// services describe credentials
+type metadataCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func (m metadataCredentials) credentials(source string) *Credentials {
+	return &Credentials{
+		AccessKeyID:     m.AccessKeyID,
+		SecretAccessKey: m.SecretAccessKey,
+		SessionToken:    m.Token,
+		Expires:         m.Expiration,
+		Source:          source,
+	}
+}
+
+func (c *Chain) fromContainer() (*Credentials, error) {
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = containerMetadataAddress + relative
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	request, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("container credentials: %v", err)
+	}
+	token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); tokenFile != "" {
+		fromFile, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("container credentials: %v", err)
+		}
+		token = strings.TrimSpace(string(fromFile))
+	}
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+	var creds metadataCredentials
+	if err := c.getJSON(request, &creds); err != nil {
+		return nil, fmt.Errorf("container credentials: %v", err)
+	}
+	return creds.credentials("container"), nil
+}
+
+func (c *Chain) fromInstanceMetadata() (*Credentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, nil
+	}
+	endpoint := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultMetadataEndpoint
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	// Off EC2 there's nothing there, so don't wait long to find out
+	client := &http.Client{Timeout: 2 * time.Second}
+
+	// IMDSv2: a session token first
+	request, _ := http.NewRequest(http.MethodPut, endpoint+"/latest/api/token", nil)
+	request.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata: %v", err)
+	}
+	token, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("instance metadata: %s", response.Status)
+	}
+
+	get := func(path string) (*http.Request, error) {
+		request, err := http.NewRequest(http.MethodGet, endpoint+path, nil)
+		if err == nil {
+			request.Header.Set("X-aws-ec2-metadata-token", string(token))
+		}
+		return request, err
+	}
+	request, err = get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	response, err = client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("instance metadata: %v", err)
+	}
+	roles, _ := ioutil.ReadAll(io.LimitReader(response.Body, 4096))
+	response.Body.Close()
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if response.StatusCode != http.StatusOK || role == "" {
+		return nil, errors.New("instance metadata: the instance has no role")
+	}
+	if request, err = get("/latest/meta-data/iam/security-credentials/" + role); err != nil {
+		return nil, err
+	}
+	var creds metadataCredentials
+	if err := getJSONWith(client, request, &creds); err != nil {
+		return nil, fmt.Errorf("instance metadata: %v", err)
+	}
+	return creds.credentials("instance metadata"), nil
+}
+
+func (c *Chain) getJSON(request *http.Request, into interface{}) error {
+	return getJSONWith(c.client, request, into)
+}
+
+func getJSONWith(client *http.Client, request *http.Request, into interface{}) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New(response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(into)
+}
@@ -0,0 +1,145 @@
+package aws
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The credentials, time, and scope of AWS's Signature Version 4 test suite
+var (
+	suiteCredentials = Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	suiteTime        = time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+)
+
+// Cases from the test suite whose requests only carry the headers Sign signs.
+// Each was signed with the suite's X-Amz-Date header alone.
+func TestSignatureTestSuite(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		contentType string
+		body        string
+		creq        string
+		signature   string
+	}{
+		{
+			name:      "get-vanilla",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/",
+			creq:      "GET\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:      "post-vanilla",
+			method:    "POST",
+			url:       "https://example.amazonaws.com/",
+			creq:      "POST\n/\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:      "get-vanilla-query-order-key-case",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			creq:      "GET\n/\nParam1=value1&Param2=value2\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signature: "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:      "get-vanilla-empty-query-key",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?Param1=value1",
+			creq:      "GET\n/\nParam1=value1\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signature: "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		},
+		{
+			name:      "get-vanilla-utf8-query",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/?ሴ=bar",
+			creq:      "GET\n/\n%E1%88%B4=bar\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signature: "2cdec8eed098649ff3a119c94853b13c643bcf08f8b0a1d91e12c9027818dd04",
+		},
+		{
+			name:      "get-unreserved",
+			method:    "GET",
+			url:       "https://example.amazonaws.com/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			creq:      "GET\n/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz\n\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\nhost;x-amz-date\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signature: "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f",
+		},
+		{
+			name:        "post-x-www-form-urlencoded",
+			method:      "POST",
+			url:         "https://example.amazonaws.com/",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "Param1=value1",
+			creq:        "POST\n/\n\ncontent-type:application/x-www-form-urlencoded\nhost:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\ncontent-type;host;x-amz-date\n9095672bbd1f56dfc5b65f3e153adc8731a4a654192329106275f4c7b24d0b6e",
+			signature:   "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("X-Amz-Date", suiteTime.Format(amzDateFormat))
+			if test.contentType != "" {
+				r.Header.Set("Content-Type", test.contentType)
+			}
+			creq, _ := canonicalRequest(r, sha256Hex([]byte(test.body)))
+			if creq != test.creq {
+				t.Errorf("canonical request\n%s\nwant\n%s", creq, test.creq)
+			}
+			if got := signature(suiteCredentials, suiteTime, "us-east-1", "service", creq); got != test.signature {
+				t.Errorf("signature %s, want %s", got, test.signature)
+			}
+		})
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"b=2&a=1", "a=1&b=2"},
+		{"a-b=2&a=1", "a=1&a-b=2"},
+		{"a=2&a=1&a=10", "a=1&a=10&a=2"},
+		{"a_b=1&a=1&a.b=1", "a=1&a.b=1&a_b=1"},
+		{"k=a b&j=ሴ", "j=%E1%88%B4&k=a%20b"},
+	}
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := canonicalQuery(query); got != test.want {
+			t.Errorf("canonicalQuery(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	r, err := http.NewRequest("POST", "https://logs.us-east-1.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	creds := suiteCredentials
+	creds.SessionToken = "token"
+	Sign(r, []byte("{}"), creds, "us-east-1", "logs", suiteTime)
+
+	if got := r.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date %q", got)
+	}
+	if got := r.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("X-Amz-Security-Token %q", got)
+	}
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/logs/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature="
+	if got := r.Header.Get("Authorization"); !strings.HasPrefix(got, want) {
+		t.Errorf("Authorization %q, want it to start %q", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+// Package batch groups log messages written by many goroutines into batches
+// for emitters whose backends accept several messages per request.
+package batch
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// Limits on a batch. A batch is sent as soon as adding another message would
+// take its estimated compressed size past MaxBytes, once it holds MaxItems
+// messages if that's set, or once its first message has waited MaxWait.
+//
+// If TargetLatency is set, the limits are ceilings and the batcher tunes its
+// batches to the backend, AIMD-style: every batch acknowledged within the
+// target grows the size limit a little and shortens the wait a little, while a
+// failure or a slow acknowledgement halves the size limit and doubles the wait,
+// sending fewer, smaller requests to a struggling backend.
+type Limits struct {
+	MaxBytes      int
+	MaxItems      int
+	MaxWait       time.Duration
+	TargetLatency time.Duration
+
+	// Clock times the batches. Nil means the real clock.
+	Clock clock.Clock
+}
+
+// How many steps it takes to tune from the smallest limits to the largest
+const adaptiveSteps = 32
+
+// The limits never shrink below this fraction of the ceilings
+const adaptiveFloor = 64
+
+// ErrClosed is returned by Add once the Batcher is closed
+var ErrClosed = errors.New("the batcher is closed")
+
+type pending struct {
+	item   []byte
+	result chan error
+}
+
+// A Batcher collects messages from concurrent callers and hands them to a
+// flush function a batch at a time. Batches are flushed one after another, so
+// the flush function never runs concurrently with itself.
+type Batcher struct {
+	limits    Limits
+	estimator *Estimator
+	flush     func(items [][]byte) error
+	incoming  chan pending
+	done      chan struct{}
+
+	// Held for reading by every Add, so Close doesn't close incoming under
+	// one
+	lock   sync.RWMutex
+	closed bool
+
+	// The limits currently in force
+	maxBytes int
+	maxWait  time.Duration
+}
+
+// New starts a Batcher. The estimator may be nil for uncompressed payloads.
+func New(limits Limits, estimator *Estimator, flush func(items [][]byte) error) *Batcher {
+	b := &Batcher{
+		limits:    limits,
+		estimator: estimator,
+		flush:     flush,
+		incoming:  make(chan pending),
+		done:      make(chan struct{}),
+		maxBytes:  limits.MaxBytes,
+		maxWait:   limits.MaxWait,
+	}
+	if limits.TargetLatency > 0 {
+		// Start modestly and work up, rather than swamping the backend
+		b.maxBytes = limits.MaxBytes / 4
+		b.maxWait = limits.MaxWait / 4
+	}
+	if b.limits.Clock == nil {
+		b.limits.Clock = clock.Real
+	}
+	go b.run()
+	return b
+}
+
+// Add queues a message and waits until the batch it's in has been flushed,
+// returning the flush function's error, or ErrClosed if the Batcher is closed
+func (b *Batcher) Add(item []byte) error {
+	p := pending{item: item, result: make(chan error, 1)}
+	b.lock.RLock()
+	if b.closed {
+		b.lock.RUnlock()
+		return ErrClosed
+	}
+	b.incoming <- p
+	b.lock.RUnlock()
+	return <-p.result
+}
+
+// Close stops accepting messages, then flushes anything waiting and stops the
+// Batcher. Messages already being added make it into the last batch.
+func (b *Batcher) Close() {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	b.closed = true
+	close(b.incoming)
+	b.lock.Unlock()
+	<-b.done
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	var batch []pending
+	var timeout <-chan time.Time
+	raw := 0
+
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		items := make([][]byte, len(batch))
+		for i, p := range batch {
+			items[i] = p.item
+		}
+		start := b.limits.Clock.Now()
+		// A panicking flush fails its batch, rather than the batcher, and with
+		// it every later batch
+		var err error
+		if panicked := logging.Recover("a batch flush", func() { err = b.flush(items) }); panicked != nil {
+			err = panicked
+		}
+		b.adapt(b.limits.Clock.Since(start), err)
+		b.estimator.Observe(items)
+		for _, p := range batch {
+			p.result <- err
+		}
+		batch, raw, timeout = nil, 0, nil
+	}
+
+	for {
+		select {
+		case p, ok := <-b.incoming:
+			if !ok {
+				send()
+				return
+			}
+			if len(batch) > 0 && b.estimator.Estimate(raw+len(p.item)) > b.maxBytes {
+				send()
+			}
+			if len(batch) == 0 {
+				timeout = b.limits.Clock.After(b.maxWait)
+			}
+			batch = append(batch, p)
+			raw += len(p.item)
+			if b.limits.MaxItems > 0 && len(batch) >= b.limits.MaxItems {
+				send()
+			}
+		case <-timeout:
+			send()
+		}
+	}
+}
+
+// adapt tunes the limits after a flush which took latency
+func (b *Batcher) adapt(latency time.Duration, err error) {
+	if b.limits.TargetLatency <= 0 {
+		return
+	}
+	minBytes, minWait := b.limits.MaxBytes/adaptiveFloor, b.limits.MaxWait/adaptiveFloor
+	if err != nil || latency > b.limits.TargetLatency {
+		b.maxBytes /= 2
+		b.maxWait *= 2
+	} else {
+		b.maxBytes += (b.limits.MaxBytes - minBytes) / adaptiveSteps
+		b.maxWait -= (b.limits.MaxWait - minWait) / adaptiveSteps
+	}
+	b.maxBytes = clampInt(b.maxBytes, minBytes, b.limits.MaxBytes)
+	if b.maxWait < minWait {
+		b.maxWait = minWait
+	} else if b.maxWait > b.limits.MaxWait {
+		b.maxWait = b.limits.MaxWait
+	}
+}
+
+func clampInt(value, min, max int) int {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
@@ -0,0 +1,73 @@
+package batch
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+// A batch which doesn't fill is sent once its first message has waited
+// MaxWait
+func TestMaxWait(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	flushed := make(chan [][]byte, 1)
+	b := New(Limits{MaxBytes: 1000, MaxWait: time.Second, Clock: fake}, nil, func(items [][]byte) error {
+		flushed <- items
+		return nil
+	})
+	defer b.Close()
+
+	results := make(chan error, 2)
+	go func() { results <- b.Add([]byte("one")) }()
+	for fake.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() { results <- b.Add([]byte("two")) }()
+	fake.Advance(500 * time.Millisecond)
+	select {
+	case items := <-flushed:
+		t.Fatalf("flushed %q before MaxWait", items)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fake.Advance(500 * time.Millisecond)
+	if items := <-flushed; !reflect.DeepEqual(items, [][]byte{[]byte("one"), []byte("two")}) {
+		t.Errorf("flushed %q", items)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// A batch is sent as soon as another message would take it past MaxBytes,
+// and what doesn't fit starts the next one
+func TestMaxBytes(t *testing.T) {
+	var batches [][][]byte
+	b := New(Limits{MaxBytes: 8, MaxWait: time.Hour, Clock: clock.NewFake(time.Unix(0, 0))}, nil, func(items [][]byte) error {
+		batches = append(batches, items)
+		return errors.New("down")
+	})
+	done := make(chan error)
+	go func() { done <- b.Add([]byte("12345")) }()
+	go func() {
+		// After the first is in its batch
+		time.Sleep(10 * time.Millisecond)
+		done <- b.Add([]byte("6789"))
+	}()
+	if err := <-done; err == nil || err.Error() != "down" {
+		t.Errorf("Add returned %v, want the flush's error", err)
+	}
+	b.Close()
+	<-done
+	if len(batches) != 2 || string(batches[0][0]) != "12345" || string(batches[1][0]) != "6789" {
+		t.Errorf("batches %q", batches)
+	}
+	if err := b.Add([]byte("late")); err != ErrClosed {
+		t.Errorf("Add after Close returned %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,82 @@
+package batch
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// How often a flushed batch is compressed to refresh the estimate
+const sampleEvery = 10
+
+// Compressed sizes vary from batch to batch, so leave some headroom
+const headroom = 1.1
+
+// How much each sample moves the estimate
+const smoothing = 0.3
+
+// An Estimator predicts how big a payload will be once it's compressed, so
+// batches can be sized against a backend's limit on compressed request size
+// without compressing every batch twice. It compresses a sample of the
+// batches which are sent and keeps a moving average of the ratio. Until it has
+// seen a sample, it assumes nothing compresses.
+type Estimator struct {
+	compress func(io.Writer) io.WriteCloser
+	lock     sync.Mutex
+	ratio    float64
+	batches  int
+}
+
+// NewEstimator returns an Estimator for a codec, given as a constructor for
+// compressing writers. A nil codec means payloads aren't compressed.
+func NewEstimator(compress func(io.Writer) io.WriteCloser) *Estimator {
+	return &Estimator{compress: compress, ratio: 1}
+}
+
+// Estimate returns the expected compressed size of rawBytes of payload
+func (e *Estimator) Estimate(rawBytes int) int {
+	if e == nil || e.compress == nil {
+		return rawBytes
+	}
+	e.lock.Lock()
+	ratio := e.ratio
+	e.lock.Unlock()
+	return int(float64(rawBytes) * ratio * headroom)
+}
+
+// Observe tells the Estimator about a batch being sent, compressing it if it
+// falls in the sample
+func (e *Estimator) Observe(items [][]byte) {
+	if e == nil || e.compress == nil {
+		return
+	}
+	e.lock.Lock()
+	sample := e.batches%sampleEvery == 0
+	first := e.batches == 0
+	e.batches++
+	e.lock.Unlock()
+	if !sample {
+		return
+	}
+
+	var compressed bytes.Buffer
+	raw := 0
+	w := e.compress(&compressed)
+	for _, item := range items {
+		raw += len(item)
+		w.Write(item)
+	}
+	w.Close()
+	if raw == 0 {
+		return
+	}
+	ratio := float64(compressed.Len()) / float64(raw)
+
+	e.lock.Lock()
+	if first {
+		e.ratio = ratio
+	} else {
+		e.ratio = smoothing*ratio + (1-smoothing)*e.ratio
+	}
+	e.lock.Unlock()
+}
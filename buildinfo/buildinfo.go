@@ -0,0 +1,54 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// These are set at build time with -ldflags "-X ...", see .goreleaser.yml
+var (
+	Version = "dev"
+	Commit  = "unknown"
+	Date    = "unknown"
+)
+
+// Info describes the running binary
+type Info struct {
+	Version   string            `json:"version"`
+	Commit    string            `json:"commit"`
+	Date      string            `json:"date"`
+	GoVersion string            `json:"go_version"`
+	Platform  string            `json:"platform"`
+	Cgo       bool              `json:"cgo"`
+	Deps      map[string]string `json:"deps,omitempty"`
+}
+
+// Get returns the build information of the running binary, including the
+// versions of the modules compiled into it
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Cgo:       CgoEnabled,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Deps = make(map[string]string, len(build.Deps))
+		for _, dep := range build.Deps {
+			info.Deps[dep.Path] = dep.Version
+		}
+	}
+	return info
+}
+
+// Handler serves the build information as JSON
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	})
+}
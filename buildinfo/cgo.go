@@ -0,0 +1,9 @@
+//go:build cgo
+// +build cgo
+
+package buildinfo
+
+// CgoEnabled reports whether this binary was built with cgo. Release builds
+// are fully static and never are, so features which need cgo must check this
+// and degrade gracefully.
+const CgoEnabled = true
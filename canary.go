@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+var canaryLastSuccess = metrics.NewGauge("haberdasher_canary_last_success_timestamp_seconds", "Unix time of the last canary message the emitter accepted.")
+var canaryFailures = metrics.NewCounter("haberdasher_canary_failures_total", "Canary messages the emitter failed to deliver.")
+
+// If HABERDASHER_CANARY_INTERVAL is set (e.g. "5m"), inject a uniquely
+// identifiable canary message on that interval. External monitors can look
+// for the canary downstream, or alert on the last success metric, to verify
+// end-to-end delivery regardless of how chatty the wrapped application is.
+func startCanary(emitter logging.Emitter) {
+	interval, exists := config.DurationSetting("HABERDASHER_CANARY_INTERVAL")
+	if !exists {
+		return
+	}
+	hostname, _ := os.Hostname()
+	log.Println("Emitting canary messages every", interval)
+
+	producers.start(func() {
+		for seq := 1; producers.sleep(interval); seq++ {
+			canaryID := fmt.Sprintf("%s-%d-%d", hostname, os.Getpid(), seq)
+			m := logging.NewMessage("haberdasher canary " + canaryID)
+			m.AddLabel("haberdasher_canary", canaryID)
+			if err := emitter.HandleLogMessage(m); err != nil {
+				log.Println("Error emitting canary", canaryID+":", err)
+				canaryFailures.Inc()
+				continue
+			}
+			canaryLastSuccess.Set(float64(logging.Clock.Now().Unix()))
+		}
+	})
+}
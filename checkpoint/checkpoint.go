@@ -0,0 +1,176 @@
+package checkpoint
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// A Position is how far through a source we've shipped. For files, the inode
+// tells us whether the file at the path is still the one we were reading.
+type Position struct {
+	Offset int64  `json:"offset"`
+	Inode  uint64 `json:"inode,omitempty"`
+}
+
+// A Store persists Positions across restarts
+type Store interface {
+	Load() (map[string]Position, error)
+	Save(positions map[string]Position) error
+}
+
+// A Checkpointer keeps track of Positions in memory and periodically saves
+// them to a Store, so frequent updates don't each cost a write
+type Checkpointer struct {
+	store Store
+	// Held for the whole of a save, so a Flush on shutdown waits for any save
+	// already in progress
+	saveLock sync.Mutex
+
+	lock      sync.Mutex
+	positions map[string]Position
+	// The keys set or deleted since the last save
+	changed map[string]bool
+	saving  func() bool
+}
+
+// New loads the saved Positions from store
+func New(store Store) (*Checkpointer, error) {
+	positions, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if positions == nil {
+		positions = make(map[string]Position)
+	}
+	return &Checkpointer{store: store, positions: positions, changed: make(map[string]bool)}, nil
+}
+
+// Get returns the saved Position for key, if there is one
+func (c *Checkpointer) Get(key string) (Position, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	position, ok := c.positions[key]
+	return position, ok
+}
+
+// Set records a new Position for key
+func (c *Checkpointer) Set(key string, position Position) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.positions[key] != position {
+		c.positions[key] = position
+		c.change(key)
+	}
+}
+
+// Delete forgets key, for sources which are gone for good
+func (c *Checkpointer) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.positions[key]; ok {
+		delete(c.positions, key)
+		c.change(key)
+	}
+}
+
+// change marks key to be saved, unless we aren't saving, when whatever moved
+// it isn't ours to save
+func (c *Checkpointer) change(key string) {
+	if c.saving == nil || c.saving() {
+		c.changed[key] = true
+	}
+}
+
+// SaveWhile shares the store with other replicas, only one of which, the one
+// saving says should, saves to it at a time. Flush then saves nothing while
+// saving returns false, and saves only the keys which have changed since,
+// keeping whatever the store has for the rest.
+func (c *Checkpointer) SaveWhile(saving func() bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.saving = saving
+}
+
+// Refresh replaces key's Position with the one in the store, for a store
+// shared with other replicas which will have moved it on since we loaded it
+func (c *Checkpointer) Refresh(key string) error {
+	positions, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if position, ok := positions[key]; ok {
+		c.positions[key] = position
+	} else {
+		delete(c.positions, key)
+	}
+	delete(c.changed, key)
+	return nil
+}
+
+// Flush saves the Positions if anything has changed since the last save
+func (c *Checkpointer) Flush() error {
+	c.saveLock.Lock()
+	defer c.saveLock.Unlock()
+	c.lock.Lock()
+	shared := c.saving != nil
+	if shared && !c.saving() {
+		c.changed = make(map[string]bool)
+	}
+	if len(c.changed) == 0 {
+		c.lock.Unlock()
+		return nil
+	}
+	changed := c.changed
+	c.changed = make(map[string]bool)
+	positions := make(map[string]Position, len(c.positions))
+	for k, v := range c.positions {
+		positions[k] = v
+	}
+	c.lock.Unlock()
+
+	err := c.save(positions, changed, shared)
+	if err != nil {
+		c.lock.Lock()
+		for key := range changed {
+			c.changed[key] = true
+		}
+		c.lock.Unlock()
+	}
+	return err
+}
+
+func (c *Checkpointer) save(positions map[string]Position, changed map[string]bool, shared bool) error {
+	if !shared {
+		return c.store.Save(positions)
+	}
+	saved, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+	if saved == nil {
+		saved = make(map[string]Position)
+	}
+	for key := range changed {
+		if position, ok := positions[key]; ok {
+			saved[key] = position
+		} else {
+			delete(saved, key)
+		}
+	}
+	return c.store.Save(saved)
+}
+
+// Run flushes on an interval, forever
+func (c *Checkpointer) Run(interval time.Duration) {
+	for {
+		logging.Clock.Sleep(interval)
+		if err := c.Flush(); err != nil {
+			log.Println("Error saving checkpoints:", err)
+		}
+	}
+}
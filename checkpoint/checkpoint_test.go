@@ -0,0 +1,107 @@
+package checkpoint
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+type memoryStore struct {
+	lock      sync.Mutex
+	positions map[string]Position
+	saves     int
+}
+
+func (s *memoryStore) Load() (map[string]Position, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	positions := make(map[string]Position, len(s.positions))
+	for k, v := range s.positions {
+		positions[k] = v
+	}
+	return positions, nil
+}
+
+func (s *memoryStore) Save(positions map[string]Position) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.positions = positions
+	s.saves++
+	return nil
+}
+
+func (s *memoryStore) saved() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.saves
+}
+
+func TestRun(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	defer func(c clock.Clock) { logging.Clock = c }(logging.Clock)
+	logging.Clock = fake
+
+	store := &memoryStore{}
+	c, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go c.Run(time.Second)
+	awaitWaiter := func() {
+		for fake.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	awaitWaiter()
+	c.Set("a", Position{Offset: 1})
+	fake.Advance(time.Second)
+	awaitWaiter()
+	if store.saved() != 1 {
+		t.Fatalf("%d saves after the interval, want 1", store.saved())
+	}
+	// Nothing's changed, so there's nothing to save
+	fake.Advance(time.Second)
+	awaitWaiter()
+	if store.saved() != 1 {
+		t.Errorf("%d saves with nothing changed, want 1", store.saved())
+	}
+}
+
+// Replicas sharing a store only save while they should, and then only what
+// they've changed
+func TestSaveWhile(t *testing.T) {
+	store := &memoryStore{positions: map[string]Position{"a": {Offset: 1}, "b": {Offset: 1}}}
+	c, err := New(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leading := false
+	c.SaveWhile(func() bool { return leading })
+
+	// Another replica moves both on while we aren't leading
+	store.Save(map[string]Position{"a": {Offset: 5}, "b": {Offset: 5}})
+	c.Set("a", Position{Offset: 2})
+	if err := c.Flush(); err != nil || store.saved() != 1 {
+		t.Fatalf("a replica which isn't leading saved: %v", err)
+	}
+
+	leading = true
+	if err := c.Refresh("a"); err != nil {
+		t.Fatal(err)
+	}
+	if position, _ := c.Get("a"); position.Offset != 5 {
+		t.Errorf("refreshed to %+v, want the store's", position)
+	}
+	c.Set("a", Position{Offset: 6})
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Position{"a": {Offset: 6}, "b": {Offset: 5}}
+	if got, _ := store.Load(); !reflect.DeepEqual(got, want) {
+		t.Errorf("saved %v, want %v", got, want)
+	}
+}
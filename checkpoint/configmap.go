@@ -0,0 +1,94 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/RedHatInsights/haberdasher/kube"
+)
+
+const configMapKey = "checkpoints.json"
+
+// How many times Save reads the ConfigMap again after someone else updated it
+// between our reading and writing it
+const configMapSaveAttempts = 5
+
+// A ConfigMapStore keeps Positions in a Kubernetes ConfigMap, so stateless
+// pods without a writable volume can still resume where they left off. The
+// service account needs permission to get, create, and update configmaps.
+type ConfigMapStore struct {
+	Client    *kube.Client
+	Namespace string
+	Name      string
+}
+
+type configMap struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   kube.ObjectMeta   `json:"metadata"`
+	Data       map[string]string `json:"data"`
+}
+
+func (s *ConfigMapStore) path() string {
+	return fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", s.Namespace, s.Name)
+}
+
+// Load reads the Positions, treating a missing ConfigMap as none saved yet
+func (s *ConfigMapStore) Load() (map[string]Position, error) {
+	var cm configMap
+	err := s.Client.Do("GET", s.path(), nil, &cm)
+	if err == kube.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, ok := cm.Data[configMapKey]
+	if !ok {
+		return nil, nil
+	}
+	var positions map[string]Position
+	err = json.Unmarshal([]byte(data), &positions)
+	return positions, err
+}
+
+// Save writes the Positions to the ConfigMap, creating it if needed. Other
+// replicas, or a pod sharing our name during a rollout, may write it too, so
+// it's read first and updated with the resourceVersion read, trying again if
+// that's been overtaken.
+func (s *ConfigMapStore) Save(positions map[string]Position) error {
+	data, err := json.Marshal(positions)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = s.save(string(data))
+		if err != kube.ErrConflict || attempt == configMapSaveAttempts {
+			return err
+		}
+	}
+}
+
+func (s *ConfigMapStore) save(data string) error {
+	var cm configMap
+	err := s.Client.Do("GET", s.path(), nil, &cm)
+	if err == kube.ErrNotFound {
+		cm = configMap{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			Metadata:   kube.ObjectMeta{Name: s.Name, Namespace: s.Namespace},
+			Data:       map[string]string{configMapKey: data},
+		}
+		return s.Client.Do("POST", fmt.Sprintf("/api/v1/namespaces/%s/configmaps", s.Namespace), cm, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[configMapKey] = data
+	// The resourceVersion makes this fail with a conflict if anyone else
+	// updated the ConfigMap since we read it
+	return s.Client.Do("PUT", s.path(), cm, nil)
+}
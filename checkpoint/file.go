@@ -0,0 +1,49 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A FileStore keeps Positions in a JSON file, for pods with a writable volume
+type FileStore struct {
+	Path string
+}
+
+// Load reads the Positions, treating a missing file as none saved yet
+func (s FileStore) Load() (map[string]Position, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var positions map[string]Position
+	err = json.Unmarshal(data, &positions)
+	return positions, err
+}
+
+// Save replaces the file atomically, so a crash mid-write can't corrupt it
+func (s FileStore) Save(positions map[string]Position) error {
+	data, err := json.Marshal(positions)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/checkpoint"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/kube"
+)
+
+const checkpointInterval = 5 * time.Second
+
+// checkpoints records how far through each tailed file we've got, if enabled
+var checkpoints *checkpoint.Checkpointer
+
+// Tailed files can be resumed where we left off after a restart rather than
+// starting over from their end. HABERDASHER_CHECKPOINT_FILE keeps the
+// positions in a file on a writable volume; for stateless pods,
+// HABERDASHER_CHECKPOINT_CONFIGMAP keeps them in a ConfigMap of that name
+// instead.
+//
+// With leader election the store is shared by the replicas, and only the
+// leader, as shouldShip says, writes it; see startFileTails.
+func loadCheckpoints(shouldShip func() bool) {
+	var store checkpoint.Store
+	if path, exists := config.Setting("HABERDASHER_CHECKPOINT_FILE"); exists {
+		store = checkpoint.FileStore{Path: path}
+	} else if name, exists := config.Setting("HABERDASHER_CHECKPOINT_CONFIGMAP"); exists {
+		client, err := kube.InCluster()
+		if err != nil {
+			log.Fatal("HABERDASHER_CHECKPOINT_CONFIGMAP requires the Kubernetes API: ", err)
+		}
+		store = &checkpoint.ConfigMapStore{Client: client, Namespace: kube.Namespace(), Name: name}
+	} else {
+		return
+	}
+
+	var err error
+	if checkpoints, err = checkpoint.New(store); err != nil {
+		log.Fatal("Unable to load checkpoints: ", err)
+	}
+	checkpoints.SaveWhile(shouldShip)
+	go checkpoints.Run(checkpointInterval)
+}
+
+// flushCheckpoints saves the latest positions before we exit
+func flushCheckpoints() {
+	if checkpoints == nil {
+		return
+	}
+	if err := checkpoints.Flush(); err != nil {
+		log.Println("Error saving checkpoints:", err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// As a container entrypoint we're responsible for the environment the child
+// starts in. HABERDASHER_CHILD_DIR sets its working directory,
+// HABERDASHER_CHILD_UMASK its umask (in octal), and HABERDASHER_CHILD_RLIMITS
+// its resource limits, such as "nofile=65536,core=0" or "nofile=1024:4096"
+// for separate soft and hard limits.
+//
+// The umask and resource limits can only be inherited, and setting them on
+// ourselves would change them for our own goroutines too, and for good in the
+// case of lowered limits. So the child is started as a copy of haberdasher
+// named childHelper, which applies them to itself and then execs the command,
+// see execChild. They're checked here, so a mistake still stops us starting.
+//
+// The child leads a process group of its own, so forwardSignals can reach it
+// and whatever it starts.
+func configureChild(subcmd *exec.Cmd) {
+	newProcessGroup(subcmd)
+	if dir, exists := config.Setting("HABERDASHER_CHILD_DIR"); exists {
+		subcmd.Dir = dir
+	}
+
+	limits, limitsSet := config.Setting("HABERDASHER_CHILD_RLIMITS")
+	if limitsSet {
+		for _, limit := range strings.Split(limits, ",") {
+			if err := checkRlimit(strings.TrimSpace(limit)); err != nil {
+				log.Fatal("HABERDASHER_CHILD_RLIMITS: ", err)
+			}
+		}
+	}
+	umask, umaskSet := config.Setting("HABERDASHER_CHILD_UMASK")
+	if umaskSet {
+		if value, err := strconv.ParseUint(umask, 8, 32); err != nil || value > 0777 {
+			log.Fatal("HABERDASHER_CHILD_UMASK must be an octal umask, like 022")
+		}
+		if errNoUmask != nil {
+			log.Fatal("HABERDASHER_CHILD_UMASK: ", errNoUmask)
+		}
+	}
+	if !limitsSet && !umaskSet {
+		return
+	}
+	if _, err := exec.LookPath(subcmd.Path); err != nil {
+		// Start will fail, as it would have without the helper
+		return
+	}
+	self, err := os.Executable()
+	if err != nil {
+		log.Fatal("Unable to find haberdasher's executable for HABERDASHER_CHILD_UMASK and HABERDASHER_CHILD_RLIMITS: ", err)
+	}
+	subcmd.Args = append([]string{childHelper, "--", umask, limits, subcmd.Path}, subcmd.Args...)
+	subcmd.Path = self
+}
+
+// childHelper is the name haberdasher is started under to set up the child's
+// umask and resource limits and exec it. Its arguments are "--", so our own
+// flags aren't looked for in them, the umask and limits, empty if they
+// aren't set, and the command's path and argv.
+const childHelper = "haberdasher-exec-child"
+
+// execChild is main for childHelper, and doesn't return
+func execChild(args []string) {
+	if len(args) < 5 || args[0] != "--" {
+		log.Fatal(childHelper, " is only for haberdasher to run")
+	}
+	umask, limits, path, argv := args[1], args[2], args[3], args[4:]
+	if limits != "" {
+		for _, limit := range strings.Split(limits, ",") {
+			if err := setRlimit(strings.TrimSpace(limit)); err != nil {
+				log.Fatal("HABERDASHER_CHILD_RLIMITS: ", err)
+			}
+		}
+	}
+	if umask != "" {
+		value, _ := strconv.ParseUint(umask, 8, 32)
+		setUmask(int(value))
+	}
+	log.Fatal("Unable to start the child: ", execve(path, argv, os.Environ()))
+}
+
+// childEnv is our environment without the variables our flags and the
+// configuration file set, which the child would otherwise see, secrets
+// included, as though they were its own environment
+func childEnv(configured map[string]bool) []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		if !configured[strings.SplitN(entry, "=", 2)[0]] {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
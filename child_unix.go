@@ -0,0 +1,83 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var rlimitsByName = map[string]int{
+	"core":   syscall.RLIMIT_CORE,
+	"cpu":    syscall.RLIMIT_CPU,
+	"data":   syscall.RLIMIT_DATA,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"nofile": syscall.RLIMIT_NOFILE,
+	"stack":  syscall.RLIMIT_STACK,
+	"as":     syscall.RLIMIT_AS,
+}
+
+// parseRlimit parses a single "name=soft[:hard]" limit, where either value can
+// be "unlimited"
+func parseRlimit(limit string) (int, syscall.Rlimit, error) {
+	parts := strings.SplitN(limit, "=", 2)
+	if len(parts) != 2 {
+		return 0, syscall.Rlimit{}, fmt.Errorf("%q should look like name=value", limit)
+	}
+	resource, ok := rlimitsByName[parts[0]]
+	if !ok {
+		return 0, syscall.Rlimit{}, fmt.Errorf("unknown resource %q", parts[0])
+	}
+	values := strings.SplitN(parts[1], ":", 2)
+	soft, err := parseRlimitValue(values[0])
+	if err != nil {
+		return 0, syscall.Rlimit{}, err
+	}
+	hard := soft
+	if len(values) == 2 {
+		if hard, err = parseRlimitValue(values[1]); err != nil {
+			return 0, syscall.Rlimit{}, err
+		}
+	}
+	return resource, newRlimit(soft, hard), nil
+}
+
+func checkRlimit(limit string) error {
+	_, _, err := parseRlimit(limit)
+	return err
+}
+
+// setRlimit applies a single limit to our own process, which is only ever
+// childHelper's
+func setRlimit(limit string) error {
+	resource, rlimit, err := parseRlimit(limit)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Setrlimit(resource, &rlimit); err != nil {
+		return fmt.Errorf("setting %s: %v", limit[:strings.Index(limit, "=")], err)
+	}
+	return nil
+}
+
+func parseRlimitValue(value string) (uint64, error) {
+	if value == "unlimited" {
+		return ^uint64(0), nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// errNoUmask is why the child's umask can't be set, if it can't
+var errNoUmask error
+
+// setUmask sets our umask, which is only ever childHelper's
+func setUmask(umask int) {
+	syscall.Umask(umask)
+}
+
+func execve(path string, argv []string, env []string) error {
+	return syscall.Exec(path, argv, env)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "errors"
+
+func checkRlimit(limit string) error {
+	return errors.New("resource limits aren't supported on Windows")
+}
+
+func setRlimit(limit string) error {
+	return checkRlimit(limit)
+}
+
+var errNoUmask = errors.New("umasks aren't supported on Windows")
+
+func setUmask(umask int) {}
+
+func execve(path string, argv []string, env []string) error {
+	return errors.New("exec isn't supported on Windows")
+}
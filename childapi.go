@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/RedHatInsights/haberdasher/admin"
+)
+
+// handleChildAPI lets orchestration tooling manage the wrapped command through
+// the admin listener:
+//
+//	GET  /child                      the child's pid, uptime, restarts, and last exit
+//	POST /child/signal?signal=USR1   send the child a signal
+//	POST /child/restart              stop the child and start it again
+//	POST /child/stop                 stop the child, which also stops haberdasher
+//
+// The POST endpoints are in the admin listener's control scope, so they're
+// refused until HABERDASHER_ADMIN_CONTROL_TOKEN or
+// HABERDASHER_ADMIN_CONTROL_CLIENTS says who may use them.
+func handleChildAPI(child *supervisor) {
+	admin.Handle("/child", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(child.Status())
+	}))
+	admin.HandleControl("/child/signal", childControl(func(r *http.Request) error {
+		sig, err := parseSignal(r.URL.Query().Get("signal"))
+		if err != nil {
+			return err
+		}
+		return child.Signal(sig)
+	}))
+	admin.HandleControl("/child/restart", childControl(func(r *http.Request) error {
+		return child.Restart()
+	}))
+	admin.HandleControl("/child/stop", childControl(func(r *http.Request) error {
+		return child.Stop()
+	}))
+}
+
+// childControl wraps an action on the child in a POST-only handler
+func childControl(action func(r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := action(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
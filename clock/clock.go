@@ -0,0 +1,145 @@
+// Package clock puts time behind an interface, so the pipeline's timers
+// (batching, deduplication windows, rate limits, backoff) can be driven by a
+// fake clock in tests instead of waiting on the real one.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+	Sleep(d time.Duration)
+}
+
+// A Timer is a pending AfterFunc
+type Timer interface {
+	// Stop prevents the function running, reporting whether it had yet to
+	Stop() bool
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                            { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration           { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time    { return time.After(d) }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+func (realClock) Sleep(d time.Duration)                     { time.Sleep(d) }
+
+// A Fake clock only moves when it's told to, firing whatever comes due
+type Fake struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *Fake
+	deadline time.Time
+	fire     func(now time.Time)
+	stopped  bool
+}
+
+// NewFake returns a Fake clock reading now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// Since returns how much fake time has passed since t
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// After returns a channel which receives the fake time once d has passed
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	f.schedule(d, func(now time.Time) { c <- now })
+	return c
+}
+
+// AfterFunc runs fn in its own goroutine once d has passed
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.schedule(d, func(time.Time) { go fn() })
+}
+
+// Sleep blocks until the clock has been advanced by d
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+// Advance moves the clock forward, firing every timer which comes due in
+// deadline order
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	f.now = f.now.Add(d)
+	now := f.now
+	sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].deadline.Before(f.waiters[j].deadline) })
+	var due []*fakeTimer
+	pending := f.waiters[:0]
+	for _, t := range f.waiters {
+		if t.stopped {
+			continue
+		}
+		if t.deadline.After(now) {
+			pending = append(pending, t)
+		} else {
+			t.stopped = true
+			due = append(due, t)
+		}
+	}
+	f.waiters = pending
+	f.lock.Unlock()
+
+	for _, t := range due {
+		t.fire(now)
+	}
+}
+
+// Waiters returns how many timers are waiting for the clock to advance, so
+// tests can tell when the code under test has started waiting
+func (f *Fake) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	waiting := 0
+	for _, t := range f.waiters {
+		if !t.stopped {
+			waiting++
+		}
+	}
+	return waiting
+}
+
+func (f *Fake) schedule(d time.Duration, fire func(now time.Time)) *fakeTimer {
+	f.lock.Lock()
+	t := &fakeTimer{clock: f, deadline: f.now.Add(d), fire: fire}
+	f.waiters = append(f.waiters, t)
+	f.lock.Unlock()
+	if d <= 0 {
+		f.Advance(0)
+	}
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	wasPending := !t.stopped
+	t.stopped = true
+	return wasPending
+}
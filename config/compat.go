@@ -0,0 +1,38 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// resolveDeprecated copies each old environment variable of a renamed
+// setting, listed in its deprecated tag, to the setting's current one, unless
+// that's set too, so existing deployments keep working while they migrate.
+// It's called first thing in this package's init, before Load applies the
+// command line and the configuration file.
+func resolveDeprecated() {
+	var c Config
+	fields(&c, func(f field) {
+		for _, old := range f.Deprecated {
+			value, exists := os.LookupEnv(old)
+			if !exists {
+				continue
+			}
+			if _, current := os.LookupEnv(f.Env); current {
+				log.Println("Warning:", old, "is deprecated and ignored, since", f.Env, "is also set")
+				continue
+			}
+			log.Println("Warning:", old, "is deprecated, use", f.Env, "("+f.Key+" in the configuration) instead")
+			os.Setenv(f.Env, value)
+		}
+	})
+}
+
+// deprecatedNames splits a deprecated tag
+func deprecatedNames(tag string) []string {
+	if tag == "" {
+		return nil
+	}
+	return strings.Split(tag, ",")
+}
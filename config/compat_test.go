@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveDeprecated(t *testing.T) {
+	defer restoreEnv("HABERDASHER_CMD")()
+	defer restoreEnv("HABERDASHER_COMMAND")()
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	// Only the old name is set, so it's used
+	os.Setenv("HABERDASHER_CMD", "python old.py")
+	os.Unsetenv("HABERDASHER_COMMAND")
+	resolveDeprecated()
+	if command, _ := Setting("HABERDASHER_COMMAND"); command != "python old.py" {
+		t.Errorf("HABERDASHER_COMMAND is %q, want HABERDASHER_CMD's", command)
+	}
+	if !strings.Contains(logged.String(), "Warning: HABERDASHER_CMD is deprecated, use HABERDASHER_COMMAND (command in the configuration) instead") {
+		t.Errorf("no deprecation warning: %q", logged.String())
+	}
+
+	// Both are set, so the new one wins
+	logged.Reset()
+	os.Setenv("HABERDASHER_COMMAND", "python new.py")
+	resolveDeprecated()
+	if command, _ := Setting("HABERDASHER_COMMAND"); command != "python new.py" {
+		t.Errorf("HABERDASHER_COMMAND is %q, want its own value", command)
+	}
+	if !strings.Contains(logged.String(), "HABERDASHER_CMD is deprecated and ignored, since HABERDASHER_COMMAND is also set") {
+		t.Errorf("no warning that the old name is ignored: %q", logged.String())
+	}
+
+	// Neither is set, so there's nothing to warn about
+	logged.Reset()
+	os.Unsetenv("HABERDASHER_CMD")
+	os.Unsetenv("HABERDASHER_COMMAND")
+	resolveDeprecated()
+	if logged.Len() > 0 {
+		t.Errorf("warned about an unset name: %q", logged.String())
+	}
+}
+
+func TestKnownDeprecated(t *testing.T) {
+	if !Known("HABERDASHER_CMD") || !Known("HABERDASHER_COMMAND") {
+		t.Error("the old and new names of the command aren't both known")
+	}
+}
@@ -0,0 +1,476 @@
+// Package config describes everything Haberdasher can be configured with, as
+// one structure. Each setting is read from its environment variable, named by
+// the field's env tag, so the structure can be resolved from the environment,
+// dumped for support with secrets redacted, and described as a JSON Schema.
+package config
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// A Duration is a time.Duration written as a string like "10s"
+type Duration time.Duration
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads a duration from a string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	*d = Duration(parsed)
+	return err
+}
+
+// Config is Haberdasher's whole configuration. Fields tagged secret are
+// redacted whenever the configuration is shown. Settings which are themselves
+// JSON say whether they're an array or object in their schema tag, and
+// renamed settings list their old environment variables in a deprecated tag.
+type Config struct {
+	Emitter          string          `json:"emitter" env:"HABERDASHER_EMITTER" default:"stderr" description:"The emitter to ship messages with, or a comma separated list of emitters."`
+	LogLevel         string          `json:"log_level,omitempty" env:"HABERDASHER_LOG_LEVEL" enum:"trace,debug,info,warn,error,fatal" description:"Drop the child's lines logged below this level."`
+	Tags             json.RawMessage `json:"tags,omitempty" env:"HABERDASHER_TAGS" schema:"array" description:"A JSON array of ECS tags for wrapped messages."`
+	Labels           json.RawMessage `json:"labels,omitempty" env:"HABERDASHER_LABELS" schema:"object" description:"A JSON object of ECS labels for wrapped messages."`
+	StripANSI        string          `json:"strip_ansi" env:"HABERDASHER_STRIP_ANSI" default:"auto" enum:"auto,on,off" description:"Which emitters to strip ANSI escape sequences from messages for: all but stderr, all, or none."`
+	JSON             string          `json:"json" env:"HABERDASHER_JSON" default:"passthrough" enum:"passthrough,merge" description:"How lines which are JSON objects are shipped: as they are, or merged over the envelope."`
+	Schema           int             `json:"schema" env:"HABERDASHER_SCHEMA" default:"2" description:"The version of the envelope wrapped messages are shipped in, 1 or 2."`
+	Command          string          `json:"command,omitempty" env:"HABERDASHER_COMMAND" deprecated:"HABERDASHER_CMD" description:"The command to wrap, split with shell-style quoting, when none is given as arguments."`
+	TemplateArgs     bool            `json:"template_args,omitempty" env:"HABERDASHER_TEMPLATE_ARGS" description:"Render the command's arguments as Go templates."`
+	Splitter         string          `json:"splitter" env:"HABERDASHER_SPLITTER" default:"legacy" enum:"legacy,patterns,python,java,go,json,raw" description:"The profile for joining lines of the child's into records."`
+	Multiline        string          `json:"multiline" env:"HABERDASHER_MULTILINE" default:"off" description:"How to join lines of the child's into records, like stack traces: off, or a comma separated list of indent and backslash."`
+	MultilinePattern json.RawMessage `json:"multiline_patterns,omitempty" env:"HABERDASHER_MULTILINE_PATTERNS" schema:"array" description:"A JSON array of start and continue regexes, or profiles, for the patterns splitter."`
+	MultilineIndent  int             `json:"multiline_indent" env:"HABERDASHER_MULTILINE_INDENT" default:"1" description:"How many columns of indentation continue a record."`
+	MultilineTabs    int             `json:"multiline_tab_width" env:"HABERDASHER_MULTILINE_TAB_WIDTH" default:"8" description:"How many columns of indentation a tab counts as, or 0 for none."`
+	MaxLineBytes     int             `json:"max_line_bytes" env:"HABERDASHER_MAX_LINE_BYTES" default:"262144" description:"The longest line read from the child before it's cut short."`
+	RecordMaxLines   int             `json:"record_max_lines" env:"HABERDASHER_RECORD_MAX_LINES" default:"1000" description:"The most lines a joined record can have, or 0 for no cap."`
+	RecordMaxBytes   int             `json:"record_max_bytes" env:"HABERDASHER_RECORD_MAX_BYTES" default:"262144" description:"The most bytes a joined record can have, or 0 for no cap."`
+	RecordHold       Duration        `json:"record_hold" env:"HABERDASHER_RECORD_HOLD" default:"500ms" description:"How long a record waits for its next line before it's shipped, or 0 to wait for it."`
+	Streams          string          `json:"streams" env:"HABERDASHER_STREAMS" default:"stderr" description:"The child's streams to ship: stderr, or stdout,stderr."`
+	Interleave       Duration        `json:"interleave,omitempty" env:"HABERDASHER_INTERLEAVE" description:"How long to hold lines of stdout and stderr to ship them in the order they were written in."`
+	DedupWindow      Duration        `json:"dedup_window,omitempty" env:"HABERDASHER_DEDUP_WINDOW" description:"Capture stdout too, shipping lines written to both streams within this window once."`
+	VirtualSources   json.RawMessage `json:"virtual_sources,omitempty" env:"HABERDASHER_VIRTUAL_SOURCES" schema:"array" description:"A JSON array of virtual sources to split the child's stderr into."`
+	Redactions       json.RawMessage `json:"redactions,omitempty" env:"HABERDASHER_REDACTIONS" schema:"array" description:"A JSON array of patterns to redact from every line."`
+	PolicyDir        string          `json:"policy_dir,omitempty" env:"HABERDASHER_POLICY_DIR" description:"A directory of JSON policy fragments to merge over the redactions and virtual sources."`
+	ReloadMaxDrop    float64         `json:"reload_max_drop" env:"HABERDASHER_RELOAD_MAX_DROP" default:"0.2" description:"How much more of the recent traffic a policy reload may drop before it must be forced."`
+	SigningKey       string          `json:"signing_key,omitempty" env:"HABERDASHER_SIGNING_KEY" description:"A cosign or minisign public key policy fragments must be signed with."`
+	RawTee           string          `json:"raw_tee,omitempty" env:"HABERDASHER_RAW_TEE" description:"Forward stderr untouched to a file, tcp://host:port, or unix:///path instead of shipping it."`
+	RecordRaw        string          `json:"record_raw,omitempty" env:"HABERDASHER_RECORD_RAW" description:"A file to record everything read from the child to, for replay-raw."`
+	PipeBuffer       int             `json:"pipe_buffer,omitempty" env:"HABERDASHER_PIPE_BUFFER" description:"The size in bytes to grow the child's stderr pipe buffer to."`
+	PTY              bool            `json:"pty,omitempty" env:"HABERDASHER_PTY" description:"Give the child a pseudo-terminal as its stderr instead of a pipe."`
+	WatchDescendants bool            `json:"watch_descendants,omitempty" env:"HABERDASHER_WATCH_DESCENDANTS" description:"Report descendants of the child whose stderr isn't Haberdasher."`
+	FIPS             bool            `json:"fips,omitempty" env:"HABERDASHER_FIPS" description:"Only use FIPS 140 approved cryptography."`
+	SPIFFESocket     string          `json:"spiffe_endpoint_socket,omitempty" env:"SPIFFE_ENDPOINT_SOCKET" description:"The SPIFFE Workload API's address, like unix:///run/spire/sockets/agent.sock."`
+
+	Timestamps TimestampConfig  `json:"timestamps"`
+	Events     EventsConfig     `json:"events"`
+	Child      ChildConfig      `json:"child"`
+	Shutdown   ShutdownConfig   `json:"shutdown"`
+	Restart    RestartConfig    `json:"restart"`
+	Rotate     RotateConfig     `json:"rotate"`
+	Queue      QueueConfig      `json:"queue"`
+	Pressure   PressureConfig   `json:"pressure"`
+	Thresholds ThresholdsConfig `json:"thresholds"`
+	Watchdog   WatchdogConfig   `json:"watchdog"`
+	Ready      ReadyConfig      `json:"ready"`
+	Admin      AdminConfig      `json:"admin"`
+	Tail       TailConfig       `json:"tail"`
+	Checkpoint CheckpointConfig `json:"checkpoint"`
+	Pods       PodsConfig       `json:"pods"`
+	Kafka      KafkaConfig      `json:"kafka"`
+	Syslog     SyslogConfig     `json:"syslog"`
+	HTTP       HTTPConfig       `json:"http"`
+	Loki       LokiConfig       `json:"loki"`
+	Splunk     SplunkConfig     `json:"splunk"`
+	Fluentd    FluentdConfig    `json:"fluentd"`
+	File       FileConfig       `json:"file"`
+	CloudWatch CloudWatchConfig `json:"cloudwatch"`
+	GCL        GCLConfig        `json:"gcl"`
+	OTLP       OTLPConfig       `json:"otlp"`
+	Unix       UnixConfig       `json:"unix"`
+	Journald   JournaldConfig   `json:"journald"`
+	EventLog   EventLogConfig   `json:"eventlog"`
+	OSLog      OSLogConfig      `json:"oslog"`
+	Stderr     StderrConfig     `json:"stderr"`
+}
+
+// EventsConfig covers where haberdasher's own events go
+type EventsConfig struct {
+	Emitter string          `json:"emitter,omitempty" env:"HABERDASHER_EVENTS_EMITTER" description:"The emitter, or comma separated emitters, to send haberdasher's own events to."`
+	SelfLog bool            `json:"self_log,omitempty" env:"HABERDASHER_EVENTS_SELF_LOG" description:"Send haberdasher's own log output with its events."`
+	Labels  json.RawMessage `json:"labels,omitempty" env:"HABERDASHER_EVENTS_LABELS" schema:"object" description:"A JSON object of ECS labels for haberdasher's own events."`
+	Dataset string          `json:"dataset,omitempty" env:"HABERDASHER_EVENTS_DATASET" description:"The event.dataset of haberdasher's own events."`
+}
+
+// TimestampConfig covers timestamps found in log lines
+type TimestampConfig struct {
+	Parse       bool            `json:"parse,omitempty" env:"HABERDASHER_PARSE_TIMESTAMPS" description:"Take wrapped messages' timestamps from the lines themselves."`
+	AssumeTZ    string          `json:"assume_tz,omitempty" env:"HABERDASHER_ASSUME_TZ" description:"The time zone of parsed timestamps which don't say."`
+	TZOverrides json.RawMessage `json:"tz_overrides,omitempty" env:"HABERDASHER_ASSUME_TZ_OVERRIDES" schema:"object" description:"A JSON object mapping sources to the time zone of their zoneless timestamps."`
+	MaxAge      Duration        `json:"max_age,omitempty" env:"HABERDASHER_MAX_AGE" description:"Drop messages whose timestamp is older than this."`
+}
+
+// ChildConfig covers how the wrapped command is started
+type ChildConfig struct {
+	Dir     string `json:"dir,omitempty" env:"HABERDASHER_CHILD_DIR" description:"The working directory to start the command in."`
+	Umask   string `json:"umask,omitempty" env:"HABERDASHER_CHILD_UMASK" description:"The umask, in octal, to start the command with."`
+	Rlimits string `json:"rlimits,omitempty" env:"HABERDASHER_CHILD_RLIMITS" description:"Resource limits for the command, like nofile=65536,core=0."`
+	Reaper  string `json:"reaper" env:"HABERDASHER_REAPER" default:"auto" enum:"auto,on,off" description:"Whether to reap zombie processes."`
+}
+
+// ShutdownConfig covers how the child is stopped
+type ShutdownConfig struct {
+	KillTimeout      Duration `json:"kill_timeout" env:"HABERDASHER_KILL_TIMEOUT" default:"10s" description:"How long the child gets to exit after being signalled, when it would outlive us."`
+	PipeDrainTimeout Duration `json:"pipe_drain_timeout" env:"HABERDASHER_PIPE_DRAIN_TIMEOUT" default:"5s" description:"How long the child's output is still read for on shutdown once it has exited."`
+	GracePeriod      string   `json:"grace_period,omitempty" env:"HABERDASHER_GRACE_PERIOD" description:"The pod's terminationGracePeriodSeconds, to budget shutdown within."`
+	TerminationFile  string   `json:"termination_file,omitempty" env:"HABERDASHER_TERMINATION_FILE" description:"Where to write why the child is being stopped."`
+}
+
+// RestartConfig covers starting the child again when it exits
+type RestartConfig struct {
+	Policy     string   `json:"policy" env:"HABERDASHER_RESTART" default:"never" enum:"never,on-failure,always" description:"When to restart the child after it exits."`
+	Backoff    Duration `json:"backoff" env:"HABERDASHER_RESTART_BACKOFF" default:"1s" description:"How long to wait before the first restart, doubling with each one after."`
+	MaxBackoff Duration `json:"max_backoff" env:"HABERDASHER_RESTART_MAX_BACKOFF" default:"1m" description:"The longest wait between restarts."`
+	MaxRetries int      `json:"max_retries,omitempty" env:"HABERDASHER_RESTART_MAX_RETRIES" description:"How many restarts in a row to make before giving up, or 0 for no limit."`
+}
+
+// RotateConfig covers signalling the child to reopen its log files
+type RotateConfig struct {
+	Interval Duration `json:"interval,omitempty" env:"HABERDASHER_ROTATE_INTERVAL" description:"Signal the child at every boundary of this interval."`
+	Signal   string   `json:"signal" env:"HABERDASHER_ROTATE_SIGNAL" default:"SIGUSR1" description:"The signal to send at each rotation boundary."`
+}
+
+// QueueConfig covers the queue between the child and the emitter
+type QueueConfig struct {
+	Size                  int      `json:"size" env:"HABERDASHER_QUEUE_SIZE" default:"10000" description:"How many lines each lane of the queue holds before we stop reading from the child."`
+	Workers               int      `json:"workers" env:"HABERDASHER_QUEUE_WORKERS" default:"100" description:"How many lines are shipped concurrently."`
+	BackpressureThreshold Duration `json:"backpressure_threshold" env:"HABERDASHER_BACKPRESSURE_THRESHOLD" default:"100ms" description:"Report stops reading from the child longer than this."`
+}
+
+// PressureConfig covers shedding under resource pressure
+type PressureConfig struct {
+	Memory   int      `json:"memory,omitempty" env:"HABERDASHER_PRESSURE_MEMORY" description:"Shed debug lines while resident memory exceeds this many bytes."`
+	CPU      float64  `json:"cpu,omitempty" env:"HABERDASHER_PRESSURE_CPU" description:"Shed debug lines while CPU use exceeds this many cores."`
+	Interval Duration `json:"interval" env:"HABERDASHER_PRESSURE_INTERVAL" default:"10s" description:"How often resource use is sampled."`
+}
+
+// ThresholdsConfig covers warning of the child's resource use
+type ThresholdsConfig struct {
+	Memory   float64  `json:"memory,omitempty" env:"HABERDASHER_CHILD_MEMORY_THRESHOLD" description:"Warn when the child's resident memory exceeds this percentage of its memory limit."`
+	CPU      float64  `json:"cpu,omitempty" env:"HABERDASHER_CHILD_CPU_THRESHOLD" description:"Warn when the child's CPU use exceeds this percentage of its CPU limit."`
+	Interval Duration `json:"interval" env:"HABERDASHER_CHILD_USAGE_INTERVAL" default:"10s" description:"How often the child's resource use is sampled."`
+	Signal   string   `json:"signal,omitempty" env:"HABERDASHER_CHILD_THRESHOLD_SIGNAL" description:"A signal to send the child when it crosses a threshold."`
+}
+
+// WatchdogConfig covers spotting a stalled pipeline
+type WatchdogConfig struct {
+	Timeout Duration `json:"timeout,omitempty" env:"HABERDASHER_WATCHDOG_TIMEOUT" description:"Dump goroutine stacks when lines have waited this long with none shipped and the emitter healthy."`
+	Dir     string   `json:"dir,omitempty" env:"HABERDASHER_WATCHDOG_DIR" description:"A directory to also write goroutine dumps to."`
+}
+
+// ReadyConfig covers deciding when the child is ready
+type ReadyConfig struct {
+	Pattern string   `json:"pattern,omitempty" env:"HABERDASHER_READY_PATTERN" description:"A regular expression a log line must match before the child is ready."`
+	Delay   Duration `json:"delay,omitempty" env:"HABERDASHER_READY_DELAY" description:"How long the child must have been running before it's ready."`
+	File    string   `json:"file,omitempty" env:"HABERDASHER_READY_FILE" description:"A file to write once ready."`
+}
+
+// AdminConfig covers the admin listener
+type AdminConfig struct {
+	Addr           string   `json:"addr,omitempty" env:"HABERDASHER_ADMIN_ADDR" description:"The address to serve metrics and the admin API on."`
+	CanaryInterval Duration `json:"canary_interval,omitempty" env:"HABERDASHER_CANARY_INTERVAL" description:"Inject a canary message on this interval."`
+	ReadToken      string   `json:"read_token,omitempty" env:"HABERDASHER_ADMIN_READ_TOKEN" secret:"true" description:"A bearer token granting read-only access to the admin listener."`
+	ControlToken   string   `json:"control_token,omitempty" env:"HABERDASHER_ADMIN_CONTROL_TOKEN" secret:"true" description:"A bearer token granting control of the child through the admin listener."`
+	TLSCert        string   `json:"tls_cert,omitempty" env:"HABERDASHER_ADMIN_TLS_CERT" description:"A PEM certificate to serve the admin listener over HTTPS with."`
+	TLSKey         string   `json:"tls_key,omitempty" env:"HABERDASHER_ADMIN_TLS_KEY" description:"The admin listener certificate's PEM private key."`
+	ClientCA       string   `json:"client_ca,omitempty" env:"HABERDASHER_ADMIN_CLIENT_CA" description:"A PEM file of the CAs whose client certificates grant read-only access."`
+	ControlClients string   `json:"control_clients,omitempty" env:"HABERDASHER_ADMIN_CONTROL_CLIENTS" description:"Client certificate names, comma separated, which also grant control."`
+}
+
+// TailConfig covers tailing log files
+type TailConfig struct {
+	Files          string   `json:"files,omitempty" env:"HABERDASHER_TAIL_FILES" description:"A comma separated list of log files, or glob patterns matching them, to tail."`
+	Truncate       bool     `json:"truncate,omitempty" env:"HABERDASHER_TAIL_TRUNCATE" description:"Truncate tailed files once they've been read."`
+	Format         string   `json:"format" env:"HABERDASHER_TAIL_FORMAT" default:"raw" enum:"raw,docker,cri" description:"How tailed files are decoded."`
+	Poll           bool     `json:"poll,omitempty" env:"HABERDASHER_TAIL_POLL" description:"Poll tailed files instead of watching them."`
+	PollInterval   Duration `json:"poll_interval" env:"HABERDASHER_TAIL_POLL_INTERVAL" default:"250ms" description:"How often to check tailed files when polling."`
+	LeaderElection string   `json:"leader_election,omitempty" env:"HABERDASHER_LEADER_ELECTION" description:"The Lease replicas tailing the same files elect a leader with."`
+}
+
+// CheckpointConfig covers remembering positions in tailed files
+type CheckpointConfig struct {
+	File      string `json:"file,omitempty" env:"HABERDASHER_CHECKPOINT_FILE" description:"A file to record positions in tailed files in."`
+	ConfigMap string `json:"configmap,omitempty" env:"HABERDASHER_CHECKPOINT_CONFIGMAP" description:"A ConfigMap to record positions in tailed files in."`
+}
+
+// PodsConfig covers DaemonSet mode
+type PodsConfig struct {
+	Dir      string          `json:"dir,omitempty" env:"HABERDASHER_PODS_DIR" description:"The node's pod log directory, to collect every pod's logs from."`
+	Metadata bool            `json:"metadata,omitempty" env:"HABERDASHER_PODS_METADATA" description:"Label messages with their pod's labels."`
+	Routes   json.RawMessage `json:"routes,omitempty" env:"HABERDASHER_PODS_ROUTES" schema:"object" description:"A JSON object mapping namespaces to emitters, or drop."`
+}
+
+// KafkaConfig covers the kafka emitter
+type KafkaConfig struct {
+	Bootstrap         string   `json:"bootstrap,omitempty" env:"HABERDASHER_KAFKA_BOOTSTRAP" description:"The Kafka cluster's bootstrap servers."`
+	Topic             string   `json:"topic,omitempty" env:"HABERDASHER_KAFKA_TOPIC" description:"The topic to write log messages to."`
+	Compression       string   `json:"compression" env:"HABERDASHER_KAFKA_COMPRESSION" default:"none" enum:"none,gzip,snappy,lz4,zstd" description:"The codec to compress batches with."`
+	BatchBytes        int      `json:"batch_bytes" env:"HABERDASHER_KAFKA_BATCH_BYTES" default:"1000000" description:"The largest batch to send, in bytes after compression."`
+	TargetLatency     Duration `json:"target_latency" env:"HABERDASHER_KAFKA_TARGET_LATENCY" default:"500ms" description:"How quickly batches should be acknowledged before they're made smaller."`
+	SASLMechanism     string   `json:"sasl_mechanism,omitempty" env:"HABERDASHER_KAFKA_SASL_MECHANISM" enum:"PLAIN,SCRAM-SHA-256,SCRAM-SHA-512,AWS_MSK_IAM" description:"The SASL mechanism to authenticate with."`
+	SASLUsername      string   `json:"sasl_username,omitempty" env:"HABERDASHER_KAFKA_SASL_USERNAME" description:"The SASL username."`
+	SASLPassword      string   `json:"sasl_password,omitempty" env:"HABERDASHER_KAFKA_SASL_PASSWORD" secret:"true" description:"The SASL password."`
+	AWSRegion         string   `json:"aws_region,omitempty" env:"HABERDASHER_KAFKA_AWS_REGION" description:"The MSK cluster's region, for AWS_MSK_IAM, if it isn't AWS_REGION."`
+	TLS               bool     `json:"tls,omitempty" env:"HABERDASHER_KAFKA_TLS" description:"Connect to the brokers over TLS."`
+	CACert            string   `json:"ca_cert,omitempty" env:"HABERDASHER_KAFKA_CA_CERT" description:"A PEM or PKCS#12 file of the CAs to trust."`
+	ClientCert        string   `json:"client_cert,omitempty" env:"HABERDASHER_KAFKA_CLIENT_CERT" description:"A PEM client certificate, for mutual TLS."`
+	ClientKey         string   `json:"client_key,omitempty" env:"HABERDASHER_KAFKA_CLIENT_KEY" description:"The client certificate's PEM private key."`
+	ClientP12         string   `json:"client_p12,omitempty" env:"HABERDASHER_KAFKA_CLIENT_P12" description:"A PKCS#12 file of the client certificate, its chain, and its key, instead of PEM files."`
+	TLSPassphrase     string   `json:"tls_passphrase,omitempty" env:"HABERDASHER_KAFKA_TLS_PASSPHRASE" secret:"true" description:"The passphrase of an encrypted private key or PKCS#12 file."`
+	TLSPassphraseFile string   `json:"tls_passphrase_file,omitempty" env:"HABERDASHER_KAFKA_TLS_PASSPHRASE_FILE" description:"A file holding the passphrase instead."`
+	SPIFFE            bool     `json:"spiffe,omitempty" env:"HABERDASHER_KAFKA_SPIFFE" description:"Authenticate with the workload's SPIFFE SVID, from the Workload API."`
+	SPIFFEServerID    string   `json:"spiffe_server_id,omitempty" env:"HABERDASHER_KAFKA_SPIFFE_SERVER_ID" description:"The SPIFFE ID the server must have, by default any in our trust domain."`
+	TLSSkipVerify     bool     `json:"tls_skip_verify,omitempty" env:"HABERDASHER_KAFKA_TLS_SKIP_VERIFY" description:"Don't verify the brokers' certificates."`
+}
+
+// SyslogConfig covers the syslog emitter
+type SyslogConfig struct {
+	Address           string   `json:"address,omitempty" env:"HABERDASHER_SYSLOG_ADDRESS" description:"The collector to send messages to: udp://, tcp://, or tls://host:port."`
+	Facility          string   `json:"facility" env:"HABERDASHER_SYSLOG_FACILITY" default:"user" description:"The facility to send messages as."`
+	AppName           string   `json:"app_name" env:"HABERDASHER_SYSLOG_APP_NAME" default:"haberdasher" description:"The APP-NAME of messages."`
+	WriteTimeout      Duration `json:"write_timeout" env:"HABERDASHER_SYSLOG_WRITE_TIMEOUT" default:"10s" description:"How long a write to the collector may take before the connection is dropped."`
+	TLS               bool     `json:"tls,omitempty" env:"HABERDASHER_SYSLOG_TLS" description:"Connect to the collector over TLS."`
+	CACert            string   `json:"ca_cert,omitempty" env:"HABERDASHER_SYSLOG_CA_CERT" description:"A PEM or PKCS#12 file of the CAs to trust."`
+	ClientCert        string   `json:"client_cert,omitempty" env:"HABERDASHER_SYSLOG_CLIENT_CERT" description:"A PEM client certificate, for mutual TLS."`
+	ClientKey         string   `json:"client_key,omitempty" env:"HABERDASHER_SYSLOG_CLIENT_KEY" description:"The client certificate's PEM private key."`
+	ClientP12         string   `json:"client_p12,omitempty" env:"HABERDASHER_SYSLOG_CLIENT_P12" description:"A PKCS#12 file of the client certificate, its chain, and its key, instead of PEM files."`
+	TLSPassphrase     string   `json:"tls_passphrase,omitempty" env:"HABERDASHER_SYSLOG_TLS_PASSPHRASE" secret:"true" description:"The passphrase of an encrypted private key or PKCS#12 file."`
+	TLSPassphraseFile string   `json:"tls_passphrase_file,omitempty" env:"HABERDASHER_SYSLOG_TLS_PASSPHRASE_FILE" description:"A file holding the passphrase instead."`
+	SPIFFE            bool     `json:"spiffe,omitempty" env:"HABERDASHER_SYSLOG_SPIFFE" description:"Authenticate with the workload's SPIFFE SVID, from the Workload API."`
+	SPIFFEServerID    string   `json:"spiffe_server_id,omitempty" env:"HABERDASHER_SYSLOG_SPIFFE_SERVER_ID" description:"The SPIFFE ID the server must have, by default any in our trust domain."`
+	TLSSkipVerify     bool     `json:"tls_skip_verify,omitempty" env:"HABERDASHER_SYSLOG_TLS_SKIP_VERIFY" description:"Don't verify the collector's certificate."`
+}
+
+// HTTPConfig covers the http emitter
+type HTTPConfig struct {
+	URL               string          `json:"url,omitempty" env:"HABERDASHER_HTTP_URL" description:"The endpoint, or comma separated endpoints, to POST batches to."`
+	Balance           string          `json:"balance" env:"HABERDASHER_HTTP_BALANCE" default:"round-robin" enum:"round-robin,least-pending,hash" description:"How to pick an endpoint for each request."`
+	HashLabel         string          `json:"hash_label,omitempty" env:"HABERDASHER_HTTP_HASH_LABEL" description:"The label whose value picks the endpoint, when hashing."`
+	MaxConns          int             `json:"max_conns" env:"HABERDASHER_HTTP_MAX_CONNS" default:"16" description:"The most connections to keep open to each endpoint."`
+	IdleTimeout       Duration        `json:"idle_timeout" env:"HABERDASHER_HTTP_IDLE_TIMEOUT" default:"90s" description:"How long an unused connection is kept open."`
+	Timeout           Duration        `json:"timeout" env:"HABERDASHER_HTTP_TIMEOUT" default:"30s" description:"How long a request may take."`
+	UnixSocket        string          `json:"unix_socket,omitempty" env:"HABERDASHER_HTTP_UNIX_SOCKET" description:"A Unix domain socket to connect to instead."`
+	CACert            string          `json:"ca_cert,omitempty" env:"HABERDASHER_HTTP_CA_CERT" description:"A PEM or PKCS#12 file of the CAs to trust."`
+	ClientCert        string          `json:"client_cert,omitempty" env:"HABERDASHER_HTTP_CLIENT_CERT" description:"A PEM client certificate, for mutual TLS."`
+	ClientKey         string          `json:"client_key,omitempty" env:"HABERDASHER_HTTP_CLIENT_KEY" description:"The client certificate's PEM private key."`
+	ClientP12         string          `json:"client_p12,omitempty" env:"HABERDASHER_HTTP_CLIENT_P12" description:"A PKCS#12 file of the client certificate, its chain, and its key, instead of PEM files."`
+	TLSPassphrase     string          `json:"tls_passphrase,omitempty" env:"HABERDASHER_HTTP_TLS_PASSPHRASE" secret:"true" description:"The passphrase of an encrypted private key or PKCS#12 file."`
+	TLSPassphraseFile string          `json:"tls_passphrase_file,omitempty" env:"HABERDASHER_HTTP_TLS_PASSPHRASE_FILE" description:"A file holding the passphrase instead."`
+	SPIFFE            bool            `json:"spiffe,omitempty" env:"HABERDASHER_HTTP_SPIFFE" description:"Authenticate with the workload's SPIFFE SVID, from the Workload API."`
+	SPIFFEServerID    string          `json:"spiffe_server_id,omitempty" env:"HABERDASHER_HTTP_SPIFFE_SERVER_ID" description:"The SPIFFE ID the server must have, by default any in our trust domain."`
+	TLSSkipVerify     bool            `json:"tls_skip_verify,omitempty" env:"HABERDASHER_HTTP_TLS_SKIP_VERIFY" description:"Don't verify the endpoints' certificates."`
+	OAuthTokenURL     string          `json:"oauth_token_url,omitempty" env:"HABERDASHER_HTTP_OAUTH_TOKEN_URL" description:"An OAuth2 token endpoint to authenticate requests with client credentials from."`
+	OAuthClientID     string          `json:"oauth_client_id,omitempty" env:"HABERDASHER_HTTP_OAUTH_CLIENT_ID" description:"The OAuth2 client to authenticate as."`
+	OAuthClientSecret string          `json:"oauth_client_secret,omitempty" env:"HABERDASHER_HTTP_OAUTH_CLIENT_SECRET" secret:"true" description:"The OAuth2 client's secret."`
+	OAuthScopes       string          `json:"oauth_scopes,omitempty" env:"HABERDASHER_HTTP_OAUTH_SCOPES" description:"The scopes to ask for, comma separated."`
+	OAuthClientAuth   string          `json:"oauth_client_auth" env:"HABERDASHER_HTTP_OAUTH_CLIENT_AUTH" default:"basic" enum:"basic,post" description:"How to send the client's credentials."`
+	BatchSize         int             `json:"batch_size" env:"HABERDASHER_HTTP_BATCH_SIZE" default:"500" description:"The most messages to send in one request."`
+	BatchBytes        int             `json:"batch_bytes" env:"HABERDASHER_HTTP_BATCH_BYTES" default:"1000000" description:"The largest request body to send."`
+	FlushInterval     Duration        `json:"flush_interval" env:"HABERDASHER_HTTP_FLUSH_INTERVAL" default:"1s" description:"How long a message may wait for its batch to fill."`
+	Headers           json.RawMessage `json:"headers,omitempty" env:"HABERDASHER_HTTP_HEADERS" schema:"object" secret:"true" description:"A JSON object of headers to send with every request."`
+	BearerToken       string          `json:"bearer_token,omitempty" env:"HABERDASHER_HTTP_BEARER_TOKEN" secret:"true" description:"A token to send as a bearer token."`
+	AWSService        string          `json:"aws_service,omitempty" env:"HABERDASHER_HTTP_AWS_SERVICE" description:"The AWS service to sign requests for with SigV4, like aoss or execute-api."`
+	AWSRegion         string          `json:"aws_region,omitempty" env:"HABERDASHER_HTTP_AWS_REGION" description:"The AWS region to sign for, by default AWS_REGION."`
+}
+
+// LokiConfig covers the loki emitter
+type LokiConfig struct {
+	URL               string          `json:"url,omitempty" env:"HABERDASHER_LOKI_URL" description:"Loki's push API, or comma separated push APIs."`
+	Balance           string          `json:"balance" env:"HABERDASHER_LOKI_BALANCE" default:"round-robin" enum:"round-robin,least-pending,hash" description:"How to pick an endpoint for each request."`
+	HashLabel         string          `json:"hash_label,omitempty" env:"HABERDASHER_LOKI_HASH_LABEL" description:"The stream label whose value picks the endpoint, when hashing."`
+	MaxConns          int             `json:"max_conns" env:"HABERDASHER_LOKI_MAX_CONNS" default:"16" description:"The most connections to keep open to each endpoint."`
+	IdleTimeout       Duration        `json:"idle_timeout" env:"HABERDASHER_LOKI_IDLE_TIMEOUT" default:"90s" description:"How long an unused connection is kept open."`
+	Timeout           Duration        `json:"timeout" env:"HABERDASHER_LOKI_TIMEOUT" default:"30s" description:"How long a request may take."`
+	UnixSocket        string          `json:"unix_socket,omitempty" env:"HABERDASHER_LOKI_UNIX_SOCKET" description:"A Unix domain socket to connect to instead."`
+	CACert            string          `json:"ca_cert,omitempty" env:"HABERDASHER_LOKI_CA_CERT" description:"A PEM or PKCS#12 file of the CAs to trust."`
+	ClientCert        string          `json:"client_cert,omitempty" env:"HABERDASHER_LOKI_CLIENT_CERT" description:"A PEM client certificate, for mutual TLS."`
+	ClientKey         string          `json:"client_key,omitempty" env:"HABERDASHER_LOKI_CLIENT_KEY" description:"The client certificate's PEM private key."`
+	ClientP12         string          `json:"client_p12,omitempty" env:"HABERDASHER_LOKI_CLIENT_P12" description:"A PKCS#12 file of the client certificate, its chain, and its key, instead of PEM files."`
+	TLSPassphrase     string          `json:"tls_passphrase,omitempty" env:"HABERDASHER_LOKI_TLS_PASSPHRASE" secret:"true" description:"The passphrase of an encrypted private key or PKCS#12 file."`
+	TLSPassphraseFile string          `json:"tls_passphrase_file,omitempty" env:"HABERDASHER_LOKI_TLS_PASSPHRASE_FILE" description:"A file holding the passphrase instead."`
+	SPIFFE            bool            `json:"spiffe,omitempty" env:"HABERDASHER_LOKI_SPIFFE" description:"Authenticate with the workload's SPIFFE SVID, from the Workload API."`
+	SPIFFEServerID    string          `json:"spiffe_server_id,omitempty" env:"HABERDASHER_LOKI_SPIFFE_SERVER_ID" description:"The SPIFFE ID the server must have, by default any in our trust domain."`
+	TLSSkipVerify     bool            `json:"tls_skip_verify,omitempty" env:"HABERDASHER_LOKI_TLS_SKIP_VERIFY" description:"Don't verify the endpoints' certificates."`
+	OAuthTokenURL     string          `json:"oauth_token_url,omitempty" env:"HABERDASHER_LOKI_OAUTH_TOKEN_URL" description:"An OAuth2 token endpoint to authenticate requests with client credentials from."`
+	OAuthClientID     string          `json:"oauth_client_id,omitempty" env:"HABERDASHER_LOKI_OAUTH_CLIENT_ID" description:"The OAuth2 client to authenticate as."`
+	OAuthClientSecret string          `json:"oauth_client_secret,omitempty" env:"HABERDASHER_LOKI_OAUTH_CLIENT_SECRET" secret:"true" description:"The OAuth2 client's secret."`
+	OAuthScopes       string          `json:"oauth_scopes,omitempty" env:"HABERDASHER_LOKI_OAUTH_SCOPES" description:"The scopes to ask for, comma separated."`
+	OAuthClientAuth   string          `json:"oauth_client_auth" env:"HABERDASHER_LOKI_OAUTH_CLIENT_AUTH" default:"basic" enum:"basic,post" description:"How to send the client's credentials."`
+	Labels            json.RawMessage `json:"labels,omitempty" env:"HABERDASHER_LOKI_LABELS" schema:"object" description:"A JSON object of the stream labels to push with."`
+	StreamLabels      string          `json:"stream_labels,omitempty" env:"HABERDASHER_LOKI_STREAM_LABELS" description:"Message labels, comma separated, to also use as stream labels."`
+	Tenant            string          `json:"tenant,omitempty" env:"HABERDASHER_LOKI_TENANT" description:"The tenant to push as."`
+	BatchBytes        int             `json:"batch_bytes" env:"HABERDASHER_LOKI_BATCH_BYTES" default:"1000000" description:"The largest push to send."`
+	FlushInterval     Duration        `json:"flush_interval" env:"HABERDASHER_LOKI_FLUSH_INTERVAL" default:"1s" description:"How long a message may wait for its batch to fill."`
+	Attempts          int             `json:"attempts" env:"HABERDASHER_LOKI_ATTEMPTS" default:"5" description:"How many times to try a push before giving up on it."`
+}
+
+// SplunkConfig covers the splunk emitter
+type SplunkConfig struct {
+	URL               string   `json:"url,omitempty" env:"HABERDASHER_SPLUNK_URL" description:"The HTTP Event Collector's endpoint, or comma separated endpoints."`
+	Balance           string   `json:"balance" env:"HABERDASHER_SPLUNK_BALANCE" default:"round-robin" enum:"round-robin,least-pending,hash" description:"How to pick an endpoint for each request."`
+	HashLabel         string   `json:"hash_label,omitempty" env:"HABERDASHER_SPLUNK_HASH_LABEL" description:"The label whose value picks the endpoint, when hashing."`
+	MaxConns          int      `json:"max_conns" env:"HABERDASHER_SPLUNK_MAX_CONNS" default:"16" description:"The most connections to keep open to each endpoint."`
+	IdleTimeout       Duration `json:"idle_timeout" env:"HABERDASHER_SPLUNK_IDLE_TIMEOUT" default:"90s" description:"How long an unused connection is kept open."`
+	Timeout           Duration `json:"timeout" env:"HABERDASHER_SPLUNK_TIMEOUT" default:"30s" description:"How long a request may take."`
+	UnixSocket        string   `json:"unix_socket,omitempty" env:"HABERDASHER_SPLUNK_UNIX_SOCKET" description:"A Unix domain socket to connect to instead."`
+	CACert            string   `json:"ca_cert,omitempty" env:"HABERDASHER_SPLUNK_CA_CERT" description:"A PEM or PKCS#12 file of the CAs to trust."`
+	ClientCert        string   `json:"client_cert,omitempty" env:"HABERDASHER_SPLUNK_CLIENT_CERT" description:"A PEM client certificate, for mutual TLS."`
+	ClientKey         string   `json:"client_key,omitempty" env:"HABERDASHER_SPLUNK_CLIENT_KEY" description:"The client certificate's PEM private key."`
+	ClientP12         string   `json:"client_p12,omitempty" env:"HABERDASHER_SPLUNK_CLIENT_P12" description:"A PKCS#12 file of the client certificate, its chain, and its key, instead of PEM files."`
+	TLSPassphrase     string   `json:"tls_passphrase,omitempty" env:"HABERDASHER_SPLUNK_TLS_PASSPHRASE" secret:"true" description:"The passphrase of an encrypted private key or PKCS#12 file."`
+	TLSPassphraseFile string   `json:"tls_passphrase_file,omitempty" env:"HABERDASHER_SPLUNK_TLS_PASSPHRASE_FILE" description:"A file holding the passphrase instead."`
+	SPIFFE            bool     `json:"spiffe,omitempty" env:"HABERDASHER_SPLUNK_SPIFFE" description:"Authenticate with the workload's SPIFFE SVID, from the Workload API."`
+	SPIFFEServerID    string   `json:"spiffe_server_id,omitempty" env:"HABERDASHER_SPLUNK_SPIFFE_SERVER_ID" description:"The SPIFFE ID the server must have, by default any in our trust domain."`
+	TLSSkipVerify     bool     `json:"tls_skip_verify,omitempty" env:"HABERDASHER_SPLUNK_TLS_SKIP_VERIFY" description:"Don't verify the endpoints' certificates."`
+	OAuthTokenURL     string   `json:"oauth_token_url,omitempty" env:"HABERDASHER_SPLUNK_OAUTH_TOKEN_URL" description:"An OAuth2 token endpoint to authenticate requests with client credentials from."`
+	OAuthClientID     string   `json:"oauth_client_id,omitempty" env:"HABERDASHER_SPLUNK_OAUTH_CLIENT_ID" description:"The OAuth2 client to authenticate as."`
+	OAuthClientSecret string   `json:"oauth_client_secret,omitempty" env:"HABERDASHER_SPLUNK_OAUTH_CLIENT_SECRET" secret:"true" description:"The OAuth2 client's secret."`
+	OAuthScopes       string   `json:"oauth_scopes,omitempty" env:"HABERDASHER_SPLUNK_OAUTH_SCOPES" description:"The scopes to ask for, comma separated."`
+	OAuthClientAuth   string   `json:"oauth_client_auth" env:"HABERDASHER_SPLUNK_OAUTH_CLIENT_AUTH" default:"basic" enum:"basic,post" description:"How to send the client's credentials."`
+	Token             string   `json:"token,omitempty" env:"HABERDASHER_SPLUNK_TOKEN" secret:"true" description:"The HEC token to send with."`
+	Index             string   `json:"index,omitempty" env:"HABERDASHER_SPLUNK_INDEX" description:"The index to send events to."`
+	Source            string   `json:"source,omitempty" env:"HABERDASHER_SPLUNK_SOURCE" description:"The source of events."`
+	Sourcetype        string   `json:"sourcetype" env:"HABERDASHER_SPLUNK_SOURCETYPE" default:"_json" description:"The sourcetype of events."`
+	Host              string   `json:"host,omitempty" env:"HABERDASHER_SPLUNK_HOST" description:"The host of events, by default the hostname."`
+	BatchBytes        int      `json:"batch_bytes" env:"HABERDASHER_SPLUNK_BATCH_BYTES" default:"1000000" description:"The largest request to send."`
+	FlushInterval     Duration `json:"flush_interval" env:"HABERDASHER_SPLUNK_FLUSH_INTERVAL" default:"1s" description:"How long an event may wait for its batch to fill."`
+	Attempts          int      `json:"attempts" env:"HABERDASHER_SPLUNK_ATTEMPTS" default:"5" description:"How many times to try a batch before giving up on it."`
+	Ack               bool     `json:"ack,omitempty" env:"HABERDASHER_SPLUNK_ACK" description:"Wait for indexer acknowledgment of every batch."`
+	AckInterval       Duration `json:"ack_interval" env:"HABERDASHER_SPLUNK_ACK_INTERVAL" default:"1s" description:"How often to poll for acknowledgments."`
+	AckTimeout        Duration `json:"ack_timeout" env:"HABERDASHER_SPLUNK_ACK_TIMEOUT" default:"1m" description:"How long to wait for a batch's acknowledgment before sending it again."`
+}
+
+// FluentdConfig covers the fluentd emitter
+type FluentdConfig struct {
+	Address           string   `json:"address,omitempty" env:"HABERDASHER_FLUENTD_ADDRESS" description:"The forward input to send to, as host:port."`
+	TLS               bool     `json:"tls,omitempty" env:"HABERDASHER_FLUENTD_TLS" description:"Connect with TLS."`
+	CACert            string   `json:"ca_cert,omitempty" env:"HABERDASHER_FLUENTD_CA_CERT" description:"A PEM or PKCS#12 file of the CAs to trust."`
+	ClientCert        string   `json:"client_cert,omitempty" env:"HABERDASHER_FLUENTD_CLIENT_CERT" description:"A PEM client certificate, for mutual TLS."`
+	ClientKey         string   `json:"client_key,omitempty" env:"HABERDASHER_FLUENTD_CLIENT_KEY" description:"The client certificate's PEM private key."`
+	ClientP12         string   `json:"client_p12,omitempty" env:"HABERDASHER_FLUENTD_CLIENT_P12" description:"A PKCS#12 file of the client certificate, its chain, and its key, instead of PEM files."`
+	TLSPassphrase     string   `json:"tls_passphrase,omitempty" env:"HABERDASHER_FLUENTD_TLS_PASSPHRASE" secret:"true" description:"The passphrase of an encrypted private key or PKCS#12 file."`
+	TLSPassphraseFile string   `json:"tls_passphrase_file,omitempty" env:"HABERDASHER_FLUENTD_TLS_PASSPHRASE_FILE" description:"A file holding the passphrase instead."`
+	SPIFFE            bool     `json:"spiffe,omitempty" env:"HABERDASHER_FLUENTD_SPIFFE" description:"Authenticate with the workload's SPIFFE SVID, from the Workload API."`
+	SPIFFEServerID    string   `json:"spiffe_server_id,omitempty" env:"HABERDASHER_FLUENTD_SPIFFE_SERVER_ID" description:"The SPIFFE ID the server must have, by default any in our trust domain."`
+	TLSSkipVerify     bool     `json:"tls_skip_verify,omitempty" env:"HABERDASHER_FLUENTD_TLS_SKIP_VERIFY" description:"Don't verify the server's certificate."`
+	Tag               string   `json:"tag" env:"HABERDASHER_FLUENTD_TAG" default:"haberdasher" description:"The tag to send records with."`
+	SharedKey         string   `json:"shared_key,omitempty" env:"HABERDASHER_FLUENTD_SHARED_KEY" secret:"true" description:"The shared key to authenticate with."`
+	Username          string   `json:"username,omitempty" env:"HABERDASHER_FLUENTD_USERNAME" description:"The user to authenticate as, if the server requires one."`
+	Password          string   `json:"password,omitempty" env:"HABERDASHER_FLUENTD_PASSWORD" secret:"true" description:"The user's password."`
+	Hostname          string   `json:"hostname,omitempty" env:"HABERDASHER_FLUENTD_HOSTNAME" description:"The hostname to authenticate with, by default the hostname."`
+	Ack               bool     `json:"ack,omitempty" env:"HABERDASHER_FLUENTD_ACK" description:"Wait for the server to acknowledge every batch."`
+	AckTimeout        Duration `json:"ack_timeout" env:"HABERDASHER_FLUENTD_ACK_TIMEOUT" default:"30s" description:"How long to wait for a batch's acknowledgment before sending it again."`
+	BatchBytes        int      `json:"batch_bytes" env:"HABERDASHER_FLUENTD_BATCH_BYTES" default:"1000000" description:"The largest batch to send."`
+	FlushInterval     Duration `json:"flush_interval" env:"HABERDASHER_FLUENTD_FLUSH_INTERVAL" default:"1s" description:"How long a message may wait for its batch to fill."`
+}
+
+// FileConfig covers the file emitter
+type FileConfig struct {
+	Path     string   `json:"path,omitempty" env:"HABERDASHER_FILE_PATH" description:"The file to write messages to."`
+	MaxBytes int      `json:"max_bytes" env:"HABERDASHER_FILE_MAX_BYTES" default:"104857600" description:"Rotate the file before it grows past this size."`
+	MaxAge   Duration `json:"max_age,omitempty" env:"HABERDASHER_FILE_MAX_AGE" description:"Rotate the file at every boundary of this interval, aligned to the wall clock. Defaults to HABERDASHER_ROTATE_INTERVAL."`
+	Retain   int      `json:"retain" env:"HABERDASHER_FILE_RETAIN" default:"5" description:"How many rotated files to keep."`
+	Compress bool     `json:"compress,omitempty" env:"HABERDASHER_FILE_COMPRESS" description:"Gzip rotated files."`
+}
+
+// CloudWatchConfig covers the cloudwatch emitter
+type CloudWatchConfig struct {
+	LogGroup      string   `json:"log_group,omitempty" env:"HABERDASHER_CLOUDWATCH_LOG_GROUP" description:"The log group to send to."`
+	LogStream     string   `json:"log_stream,omitempty" env:"HABERDASHER_CLOUDWATCH_LOG_STREAM" description:"The log stream to send to, by default the hostname."`
+	CreateGroup   bool     `json:"create_group,omitempty" env:"HABERDASHER_CLOUDWATCH_CREATE_GROUP" description:"Create the log group if it doesn't exist."`
+	Region        string   `json:"region,omitempty" env:"HABERDASHER_CLOUDWATCH_REGION" description:"The AWS region, by default AWS_REGION."`
+	Endpoint      string   `json:"endpoint,omitempty" env:"HABERDASHER_CLOUDWATCH_ENDPOINT" description:"The CloudWatch Logs endpoint, by default the region's."`
+	MaxConns      int      `json:"max_conns" env:"HABERDASHER_CLOUDWATCH_MAX_CONNS" default:"16" description:"The most connections to keep open to the endpoint."`
+	IdleTimeout   Duration `json:"idle_timeout" env:"HABERDASHER_CLOUDWATCH_IDLE_TIMEOUT" default:"90s" description:"How long an unused connection is kept open."`
+	Timeout       Duration `json:"timeout" env:"HABERDASHER_CLOUDWATCH_TIMEOUT" default:"30s" description:"How long a request may take."`
+	FlushInterval Duration `json:"flush_interval" env:"HABERDASHER_CLOUDWATCH_FLUSH_INTERVAL" default:"1s" description:"How long an event may wait for its batch to fill."`
+	Attempts      int      `json:"attempts" env:"HABERDASHER_CLOUDWATCH_ATTEMPTS" default:"5" description:"How many times to try a batch before giving up on it."`
+}
+
+// GCLConfig covers the gcl emitter
+type GCLConfig struct {
+	Project        string          `json:"project,omitempty" env:"HABERDASHER_GCL_PROJECT" description:"The Google Cloud project to write to, by default GOOGLE_CLOUD_PROJECT or the metadata server's."`
+	LogName        string          `json:"log_name" env:"HABERDASHER_GCL_LOG_NAME" default:"haberdasher" description:"The log to write to."`
+	ResourceType   string          `json:"resource_type,omitempty" env:"HABERDASHER_GCL_RESOURCE_TYPE" description:"The monitored resource type, by default detected."`
+	ResourceLabels json.RawMessage `json:"resource_labels,omitempty" env:"HABERDASHER_GCL_RESOURCE_LABELS" schema:"object" description:"A JSON object of monitored resource labels, over those detected."`
+	Endpoint       string          `json:"endpoint,omitempty" env:"HABERDASHER_GCL_ENDPOINT" description:"The Cloud Logging API endpoint, by default logging.googleapis.com."`
+	MaxConns       int             `json:"max_conns" env:"HABERDASHER_GCL_MAX_CONNS" default:"16" description:"The most connections to keep open to the endpoint."`
+	IdleTimeout    Duration        `json:"idle_timeout" env:"HABERDASHER_GCL_IDLE_TIMEOUT" default:"90s" description:"How long an unused connection is kept open."`
+	Timeout        Duration        `json:"timeout" env:"HABERDASHER_GCL_TIMEOUT" default:"30s" description:"How long a request may take."`
+	FlushInterval  Duration        `json:"flush_interval" env:"HABERDASHER_GCL_FLUSH_INTERVAL" default:"1s" description:"How long an entry may wait for its batch to fill."`
+	Attempts       int             `json:"attempts" env:"HABERDASHER_GCL_ATTEMPTS" default:"5" description:"How many times to try a batch before giving up on it."`
+}
+
+// OTLPConfig covers the otlp emitter, which mostly takes the OpenTelemetry
+// SDKs' standard settings
+type OTLPConfig struct {
+	Endpoint           string   `json:"endpoint,omitempty" env:"OTEL_EXPORTER_OTLP_ENDPOINT" description:"The collector's base URL."`
+	LogsEndpoint       string   `json:"logs_endpoint,omitempty" env:"OTEL_EXPORTER_OTLP_LOGS_ENDPOINT" description:"The collector's URL for logs, used as it is."`
+	Protocol           string   `json:"protocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL" default:"http/protobuf" enum:"grpc,http/protobuf,http/json" description:"How to export."`
+	Headers            string   `json:"headers,omitempty" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true" description:"Comma separated key=value headers to send."`
+	Compression        string   `json:"compression,omitempty" env:"OTEL_EXPORTER_OTLP_COMPRESSION" enum:"gzip,none" description:"How to compress requests."`
+	Timeout            int      `json:"timeout,omitempty" env:"OTEL_EXPORTER_OTLP_TIMEOUT" description:"How long a request may take, in milliseconds."`
+	Insecure           bool     `json:"insecure,omitempty" env:"OTEL_EXPORTER_OTLP_INSECURE" description:"Use plaintext for a gRPC endpoint without a scheme."`
+	ServiceName        string   `json:"service_name,omitempty" env:"OTEL_SERVICE_NAME" description:"The service.name resource attribute."`
+	ResourceAttributes string   `json:"resource_attributes,omitempty" env:"OTEL_RESOURCE_ATTRIBUTES" description:"Comma separated key=value resource attributes."`
+	CACert             string   `json:"ca_cert,omitempty" env:"HABERDASHER_OTLP_CA_CERT" description:"A PEM or PKCS#12 file of the CAs to trust."`
+	ClientCert         string   `json:"client_cert,omitempty" env:"HABERDASHER_OTLP_CLIENT_CERT" description:"A PEM client certificate, for mutual TLS."`
+	ClientKey          string   `json:"client_key,omitempty" env:"HABERDASHER_OTLP_CLIENT_KEY" description:"The client certificate's PEM private key."`
+	ClientP12          string   `json:"client_p12,omitempty" env:"HABERDASHER_OTLP_CLIENT_P12" description:"A PKCS#12 file of the client certificate, its chain, and its key, instead of PEM files."`
+	TLSPassphrase      string   `json:"tls_passphrase,omitempty" env:"HABERDASHER_OTLP_TLS_PASSPHRASE" secret:"true" description:"The passphrase of an encrypted private key or PKCS#12 file."`
+	TLSPassphraseFile  string   `json:"tls_passphrase_file,omitempty" env:"HABERDASHER_OTLP_TLS_PASSPHRASE_FILE" description:"A file holding the passphrase instead."`
+	SPIFFE             bool     `json:"spiffe,omitempty" env:"HABERDASHER_OTLP_SPIFFE" description:"Authenticate with the workload's SPIFFE SVID, from the Workload API."`
+	SPIFFEServerID     string   `json:"spiffe_server_id,omitempty" env:"HABERDASHER_OTLP_SPIFFE_SERVER_ID" description:"The SPIFFE ID the server must have, by default any in our trust domain."`
+	MaxConns           int      `json:"max_conns" env:"HABERDASHER_OTLP_MAX_CONNS" default:"16" description:"The most connections to keep open to the collector."`
+	IdleTimeout        Duration `json:"idle_timeout" env:"HABERDASHER_OTLP_IDLE_TIMEOUT" default:"90s" description:"How long an unused connection is kept open."`
+	RequestTimeout     Duration `json:"request_timeout" env:"HABERDASHER_OTLP_TIMEOUT" default:"30s" description:"How long a request may take, unless OTEL_EXPORTER_OTLP_TIMEOUT says."`
+	BatchBytes         int      `json:"batch_bytes" env:"HABERDASHER_OTLP_BATCH_BYTES" default:"1048576" description:"The largest export to send."`
+	FlushInterval      Duration `json:"flush_interval" env:"HABERDASHER_OTLP_FLUSH_INTERVAL" default:"1s" description:"How long a record may wait for its batch to fill."`
+	Attempts           int      `json:"attempts" env:"HABERDASHER_OTLP_ATTEMPTS" default:"5" description:"How many times to try a batch before giving up on it."`
+}
+
+// UnixConfig covers the unix emitter
+type UnixConfig struct {
+	Path    string `json:"path,omitempty" env:"HABERDASHER_UNIX_PATH" description:"The Unix socket to write messages to."`
+	Type    string `json:"type" env:"HABERDASHER_UNIX_TYPE" default:"stream" enum:"stream,datagram" description:"Whether the socket is a stream or datagram socket."`
+	Framing string `json:"framing" env:"HABERDASHER_UNIX_FRAMING" default:"newline" enum:"newline,length" description:"How messages on a stream are delimited."`
+}
+
+// JournaldConfig covers the journald emitter, on Linux
+type JournaldConfig struct {
+	Socket     string          `json:"socket" env:"HABERDASHER_JOURNALD_SOCKET" default:"/run/systemd/journal/socket" description:"The journal's native protocol socket."`
+	Identifier string          `json:"identifier" env:"HABERDASHER_JOURNALD_IDENTIFIER" default:"haberdasher" description:"The SYSLOG_IDENTIFIER of entries."`
+	Fields     json.RawMessage `json:"fields,omitempty" env:"HABERDASHER_JOURNALD_FIELDS" schema:"object" description:"A JSON object of fields to add to every entry."`
+}
+
+// EventLogConfig covers the eventlog emitter, in Windows builds
+type EventLogConfig struct {
+	Source   string          `json:"source" env:"HABERDASHER_EVENTLOG_SOURCE" default:"haberdasher" description:"The Windows Event Log source to report events as."`
+	EventIDs json.RawMessage `json:"event_ids,omitempty" env:"HABERDASHER_EVENTLOG_EVENT_IDS" schema:"object" description:"A JSON object of levels to event IDs, over the defaults of 1 to info, 2 to warn, 3 to error, and 4 to fatal."`
+}
+
+// OSLogConfig covers the oslog emitter, in macOS builds with cgo
+type OSLogConfig struct {
+	Subsystem string `json:"subsystem" env:"HABERDASHER_OSLOG_SUBSYSTEM" default:"com.redhat.haberdasher" description:"The unified logging subsystem to log as."`
+	Category  string `json:"category" env:"HABERDASHER_OSLOG_CATEGORY" default:"default" description:"The unified logging category to log as."`
+}
+
+// StderrConfig covers the stderr emitter
+type StderrConfig struct {
+	Pretty bool `json:"pretty,omitempty" env:"HABERDASHER_STDERR_PRETTY" description:"Pretty-print messages."`
+}
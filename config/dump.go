@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// Words in environment variable names which suggest their values are secret
+var secretWords = []string{"PASSWORD", "SECRET", "TOKEN", "KEY", "CREDENTIAL", "AUTH"}
+
+// A Dump is the effective configuration as shown to people: the resolved
+// settings, plus any other HABERDASHER_ variables, such as per-emitter
+// settings named after the emitter, with secrets redacted
+type Dump struct {
+	Config      Config            `json:"config"`
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+// Redacted returns the effective configuration ready to be shown
+func Redacted() Dump {
+	dump := Dump{Config: FromEnv(), Environment: make(map[string]string)}
+	fields(&dump.Config, func(f field) {
+		if f.Secret && f.Value.Kind() == reflect.String && f.Value.String() != "" {
+			f.Value.SetString(redacted)
+		}
+	})
+
+	var names []string
+	for _, entry := range os.Environ() {
+		name := strings.SplitN(entry, "=", 2)[0]
+		if strings.HasPrefix(name, "HABERDASHER_") && !Known(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := os.Getenv(name)
+		if looksSecret(name) {
+			value = redacted
+		}
+		dump.Environment[name] = value
+	}
+	return dump
+}
+
+func looksSecret(name string) bool {
+	for _, word := range secretWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler serves the effective configuration as JSON
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(Redacted())
+	})
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A field is one leaf of the configuration
+type field struct {
+	Key         string
+	Env         string
+	Deprecated  []string
+	Default     string
+	Description string
+	Enum        []string
+	Secret      bool
+	Value       reflect.Value
+	Type        reflect.StructField
+}
+
+// fields walks a configuration, calling visit with every setting in it
+func fields(c *Config, visit func(f field)) {
+	walk(reflect.ValueOf(c).Elem(), "", visit)
+}
+
+func walk(v reflect.Value, prefix string, visit func(f field)) {
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		key := prefix + strings.Split(structField.Tag.Get("json"), ",")[0]
+		env, ok := structField.Tag.Lookup("env")
+		if !ok {
+			if v.Field(i).Kind() == reflect.Struct {
+				walk(v.Field(i), key+".", visit)
+			}
+			continue
+		}
+		f := field{
+			Key:         key,
+			Env:         env,
+			Deprecated:  deprecatedNames(structField.Tag.Get("deprecated")),
+			Default:     structField.Tag.Get("default"),
+			Description: structField.Tag.Get("description"),
+			Secret:      structField.Tag.Get("secret") == "true",
+			Value:       v.Field(i),
+			Type:        structField,
+		}
+		if enum := structField.Tag.Get("enum"); enum != "" {
+			f.Enum = strings.Split(enum, ",")
+		}
+		visit(f)
+	}
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+var rawType = reflect.TypeOf(json.RawMessage(nil))
+
+// FromEnv resolves the configuration in effect: every setting's environment
+// variable, or its default when that isn't set
+func FromEnv() Config {
+	var c Config
+	fields(&c, func(f field) {
+		value, exists := os.LookupEnv(f.Env)
+		if !exists {
+			value = f.Default
+		}
+		setFromString(f.Value, value)
+	})
+	return c
+}
+
+// setFromString sets a setting from its environment variable's value. Values
+// which don't parse are left unset; whatever reads the variable reports them.
+func setFromString(v reflect.Value, value string) {
+	switch {
+	case v.Type() == durationType:
+		if d, err := time.ParseDuration(value); err == nil {
+			v.SetInt(int64(d))
+		}
+	case v.Type() == rawType:
+		if value != "" && json.Valid([]byte(value)) {
+			v.SetBytes([]byte(value))
+		}
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Bool:
+		// Flags are on when set to anything at all
+		v.SetBool(value != "")
+	case v.Kind() == reflect.Int:
+		if n, err := strconv.Atoi(value); err == nil {
+			v.SetInt(int64(n))
+		}
+	case v.Kind() == reflect.Float64:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			v.SetFloat(n)
+		}
+	}
+}
+
+// Known reports whether an environment variable is one of the settings, by
+// its current or a deprecated name
+func Known(env string) bool {
+	known := false
+	var c Config
+	fields(&c, func(f field) {
+		if f.Env == env {
+			known = true
+		}
+		for _, old := range f.Deprecated {
+			if old == env {
+				known = true
+			}
+		}
+	})
+	return known
+}
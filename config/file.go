@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/signature"
+)
+
+// The command line's flags, the variables they and the configuration file
+// set, and the packages' readings of their settings
+var (
+	commandLine Flags
+	variables   []string
+	readers     []func()
+)
+
+// Deprecated names are resolved before anything reads the environment, since
+// packages read their settings as they're initialized; those which do import
+// this package, so this runs first. An old name which is set still counts as
+// setting its replacement.
+func init() {
+	resolveDeprecated()
+}
+
+// OnLoad reads a package's settings now, as it's initialized, and registers
+// read to read them again once Load has applied the command line and
+// configuration file to the environment
+func OnLoad(read func()) {
+	read()
+	readers = append(readers, read)
+}
+
+// Load parses haberdasher's command line, puts its flags into the environment
+// and reads the configuration file its --config names, then has the packages
+// which read their settings as they were initialized read them again. Flags
+// win over the environment, as the environment wins over the file. Only
+// haberdasher's own main calls it, so programs using its packages are
+// configured by the environment alone, and keep their command lines to
+// themselves.
+func Load(args []string) error {
+	var err error
+	if commandLine, err = ParseFlags(args); err != nil {
+		return fmt.Errorf("%v; see haberdasher --help", err)
+	}
+	for name, variable := range flagVariables {
+		if value, given := commandLine.given[name]; given {
+			os.Setenv(variable, value)
+			variables = append(variables, variable)
+		}
+	}
+	if commandLine.Given("config") {
+		path := commandLine.Config
+		if path == "" {
+			return fmt.Errorf("--config needs a file; see haberdasher --help")
+		}
+		fromFile, err := FromFile(path)
+		if err != nil {
+			return fmt.Errorf("invalid configuration file: %v", err)
+		}
+		log.Println("Read", len(fromFile), "settings from", path)
+		variables = append(variables, fromFile...)
+	}
+	for _, read := range readers {
+		read()
+	}
+	return nil
+}
+
+// CommandLine returns the flags the command line was parsed into
+func CommandLine() Flags {
+	return commandLine
+}
+
+// Variables returns the names of the environment variables the command line
+// and configuration file set
+func Variables() []string {
+	return variables
+}
+
+// FromFile reads a configuration file, YAML, TOML, or JSON as its extension
+// says, laid out like the configuration schema, and sets the environment
+// variable of every setting in it, so everything which reads the
+// environment sees it. Variables which are already set win over the file.
+// Settings which aren't modelled can be given as variables in an environment
+// table, as the configuration dump shows them. It returns the names of the
+// variables it set.
+//
+// The file can set redactions and virtual sources, like a policy fragment, so
+// if HABERDASHER_SIGNING_KEY is already set it must be signed with that key
+// in the same way. A key the file names itself doesn't count.
+func FromFile(path string) ([]string, error) {
+	document, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]field)
+	sections := make(map[string]bool)
+	var c Config
+	fields(&c, func(f field) {
+		settings[f.Key] = f
+		parts := strings.Split(f.Key, ".")
+		for i := 1; i < len(parts); i++ {
+			sections[strings.Join(parts[:i], ".")] = true
+		}
+	})
+	values := make(map[string]string)
+	if err := flatten(document, "", settings, sections, values); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var set []string
+	for _, name := range names {
+		if _, exists := os.LookupEnv(name); exists {
+			continue
+		}
+		os.Setenv(name, values[name])
+		set = append(set, name)
+	}
+	return set, nil
+}
+
+// ReadFile reads a configuration file as FromFile does, checking its
+// signature, without setting anything, for commands which only want part of
+// it
+func ReadFile(path string) (map[string]interface{}, error) {
+	key, err := signature.FromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("HABERDASHER_SIGNING_KEY: %v", err)
+	}
+	var contents []byte
+	if key != nil {
+		contents, err = key.ReadVerified(path)
+	} else {
+		contents, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var document map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		document, err = parseYAML(contents)
+	case ".toml":
+		document, err = parseTOML(contents)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.UseNumber()
+		err = decoder.Decode(&document)
+	default:
+		return nil, fmt.Errorf("%s: the configuration file must be .yaml, .yml, .toml, or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return document, nil
+}
+
+// flatten collects the environment variable for every setting in a table of
+// the file, whose keys are below prefix
+func flatten(table map[string]interface{}, prefix string, settings map[string]field, sections map[string]bool, values map[string]string) error {
+	for key, value := range table {
+		path := prefix + key
+		if f, isSetting := settings[path]; isSetting {
+			s, set, err := envValue(f, value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			if set {
+				values[f.Env] = s
+			}
+			continue
+		}
+		nested, isTable := value.(map[string]interface{})
+		switch {
+		case path == "environment" && isTable:
+			for name, value := range nested {
+				s, isString := value.(string)
+				if number, isNumber := value.(json.Number); isNumber {
+					s, isString = string(number), true
+				}
+				if !isString {
+					return fmt.Errorf("environment.%s must be a string", name)
+				}
+				values[name] = s
+			}
+		case sections[path] && isTable:
+			if err := flatten(nested, path+".", settings, sections, values); err != nil {
+				return err
+			}
+		case sections[path] || path == "environment":
+			return fmt.Errorf("%s must be a table of settings", path)
+		default:
+			return fmt.Errorf("%s isn't a setting", path)
+		}
+	}
+	return nil
+}
+
+// envValue converts a setting's value in the file to its environment
+// variable's form. A null, or a flag set to false, leaves the variable unset.
+func envValue(f field, value interface{}) (string, bool, error) {
+	if value == nil {
+		return "", false, nil
+	}
+	t := f.Value.Type()
+	switch {
+	case t == rawType:
+		// JSON written out as a string is taken as it is
+		if s, isString := value.(string); isString && json.Valid([]byte(s)) {
+			return s, true, nil
+		}
+		shape := f.Type.Tag.Get("schema")
+		if _, isArray := value.([]interface{}); shape == "array" && !isArray {
+			return "", false, fmt.Errorf("must be a list")
+		}
+		if _, isObject := value.(map[string]interface{}); shape == "object" && !isObject {
+			return "", false, fmt.Errorf("must be a table")
+		}
+		encoded, err := json.Marshal(value)
+		return string(encoded), err == nil, err
+	case t == durationType:
+		s, isString := value.(string)
+		if _, err := time.ParseDuration(s); !isString || err != nil {
+			return "", false, fmt.Errorf("must be a duration, like \"10s\"")
+		}
+		return s, true, nil
+	case t.Kind() == reflect.Bool:
+		flag, isBool := value.(bool)
+		if !isBool {
+			return "", false, fmt.Errorf("must be true or false")
+		}
+		return "true", flag, nil
+	case t.Kind() == reflect.Int:
+		number, isNumber := value.(json.Number)
+		if _, err := strconv.Atoi(string(number)); !isNumber || err != nil {
+			return "", false, fmt.Errorf("must be a whole number")
+		}
+		return string(number), true, nil
+	case t.Kind() == reflect.Float64:
+		number, isNumber := value.(json.Number)
+		if _, err := strconv.ParseFloat(string(number), 64); !isNumber || err != nil {
+			return "", false, fmt.Errorf("must be a number")
+		}
+		return string(number), true, nil
+	}
+	var s string
+	switch value := value.(type) {
+	case string:
+		s = value
+	case json.Number:
+		s = string(value)
+	default:
+		return "", false, fmt.Errorf("must be a string")
+	}
+	if len(f.Enum) > 0 {
+		valid := false
+		for _, option := range f.Enum {
+			valid = valid || s == option
+		}
+		if !valid {
+			return "", false, fmt.Errorf("must be one of %s", strings.Join(f.Enum, ", "))
+		}
+	}
+	return s, true, nil
+}
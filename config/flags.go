@@ -0,0 +1,109 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Flags are Haberdasher's own options, given on the command line ahead of the
+// command to wrap
+type Flags struct {
+	Config   string
+	Emitter  string
+	LogLevel string
+	Stdin    bool
+	Version  bool
+	Help     bool
+	// Args is the rest of the command line: a subcommand, or the command to
+	// wrap and its arguments
+	Args []string
+	// Separated is set when Args followed a "--", so they're a command even if
+	// they look like a subcommand
+	Separated bool
+	// The flags which were given, and their values
+	given map[string]string
+}
+
+// Given reports whether a flag was on the command line
+func (f Flags) Given(name string) bool {
+	_, given := f.given[name]
+	return given
+}
+
+// Subcommand returns the subcommand the command line names, or ""
+func (f Flags) Subcommand() string {
+	if f.Separated || len(f.Args) == 0 {
+		return ""
+	}
+	switch f.Args[0] {
+	case "config-schema", "test-rules", "selftest", "replay-raw":
+		return f.Args[0]
+	}
+	return ""
+}
+
+// The environment variables flags stand in for
+var flagVariables = map[string]string{
+	"emitter":   "HABERDASHER_EMITTER",
+	"log-level": "HABERDASHER_LOG_LEVEL",
+}
+
+func newFlagSet(f *Flags) *flag.FlagSet {
+	set := flag.NewFlagSet("haberdasher", flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	set.StringVar(&f.Config, "config", "", "read settings from a YAML, TOML, or JSON `file`")
+	set.StringVar(&f.Emitter, "emitter", "", "the `emitter` to ship with, instead of HABERDASHER_EMITTER")
+	set.StringVar(&f.LogLevel, "log-level", "", "drop the child's lines below this `level`, instead of HABERDASHER_LOG_LEVEL")
+	set.BoolVar(&f.Stdin, "stdin", false, "ship the lines piped to us instead of wrapping a command")
+	set.BoolVar(&f.Version, "version", false, "print the version and exit")
+	set.BoolVar(&f.Help, "help", false, "print this help and exit")
+	set.BoolVar(&f.Help, "h", false, "print this help and exit")
+	return set
+}
+
+// ParseFlags parses the flags at the start of a command line, up to the first
+// argument which isn't one, or a "--"
+func ParseFlags(args []string) (Flags, error) {
+	f := Flags{given: make(map[string]string)}
+	if len(args) == 0 {
+		return f, nil
+	}
+	set := newFlagSet(&f)
+	if err := set.Parse(args[1:]); err != nil {
+		return f, err
+	}
+	set.Visit(func(given *flag.Flag) {
+		f.given[given.Name] = given.Value.String()
+	})
+	f.Args = set.Args()
+	parsed := args[1 : len(args)-len(f.Args)]
+	f.Separated = len(parsed) > 0 && parsed[len(parsed)-1] == "--"
+	if f.Stdin && len(f.Args) > 0 {
+		return f, fmt.Errorf("--stdin doesn't take a command")
+	}
+	return f, nil
+}
+
+// Usage describes the command line
+func Usage() string {
+	var out bytes.Buffer
+	out.WriteString(`Usage:
+  haberdasher [flags] [--] command [args...]
+  myapp 2>&1 | haberdasher [flags] --stdin
+  haberdasher [flags] config-schema | selftest | test-rules | replay-raw
+
+Flags:
+`)
+	newFlagSet(&Flags{}).VisitAll(func(f *flag.Flag) {
+		if f.Name == "h" {
+			return
+		}
+		name, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(&out, "  %s\n        %s\n", strings.TrimSpace("--"+f.Name+" "+name), usage)
+	})
+	out.WriteString("\nEverything else is configured with HABERDASHER_* environment variables.\n")
+	return out.String()
+}
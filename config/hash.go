@@ -0,0 +1,48 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// SectionHash summarizes a section of the effective configuration, such as
+// "kafka", with any other HABERDASHER_KAFKA_ variables, so fleet tooling can
+// tell which pods share settings without seeing them. It's taken after
+// secrets are redacted, so rotating a credential doesn't change it. It
+// returns "" for sections which don't exist.
+func SectionHash(section string) string {
+	dump := Redacted()
+	v := reflect.ValueOf(dump.Config)
+	for i := 0; i < v.NumField(); i++ {
+		if strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0] != section || v.Field(i).Kind() != reflect.Struct {
+			continue
+		}
+		prefix := "HABERDASHER_" + strings.ToUpper(section) + "_"
+		environment := make(map[string]string)
+		for name, value := range dump.Environment {
+			if strings.HasPrefix(name, prefix) {
+				environment[name] = value
+			}
+		}
+		return Hash(struct {
+			Settings    interface{}       `json:"settings"`
+			Environment map[string]string `json:"environment,omitempty"`
+		}{v.Field(i).Interface(), environment})
+	}
+	return ""
+}
+
+// Hash is the digest of anything which can be marshalled as JSON, as
+// "sha256:" and its hex. Maps are marshalled in key order, so equal values
+// always hash the same.
+func Hash(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(encoded)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,99 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const schemaURL = "http://json-schema.org/draft-07/schema#"
+
+// Durations as time.ParseDuration accepts them, like "1m30s"
+const durationPattern = `^[-+]?([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$`
+
+// Schema returns a JSON Schema describing the configuration, generated from
+// the Config structure, for validating configuration in editors and policy
+// checks
+func Schema() map[string]interface{} {
+	schema := objectSchema(reflect.TypeOf(Config{}))
+	schema["$schema"] = schemaURL
+	schema["title"] = "Haberdasher configuration"
+	// Configuration files may also set variables which aren't modelled
+	schema["properties"].(map[string]interface{})["environment"] = map[string]interface{}{
+		"description":          "Environment variables to set, for settings not described here.",
+		"type":                 "object",
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	}
+	return schema
+}
+
+func objectSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		name := strings.Split(structField.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if _, isSetting := structField.Tag.Lookup("env"); !isSetting && structField.Type.Kind() == reflect.Struct {
+			properties[name] = objectSchema(structField.Type)
+			continue
+		}
+		properties[name] = settingSchema(structField)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func settingSchema(structField reflect.StructField) map[string]interface{} {
+	schema := map[string]interface{}{}
+	if description := structField.Tag.Get("description"); description != "" {
+		schema["description"] = description + " (" + structField.Tag.Get("env") + ")"
+	}
+	switch {
+	case structField.Type == durationType:
+		schema["type"] = "string"
+		schema["pattern"] = durationPattern
+	case structField.Type == rawType:
+		// Structured settings say what shape they take
+		if shape := structField.Tag.Get("schema"); shape != "" {
+			schema["type"] = shape
+		}
+	case structField.Type.Kind() == reflect.String:
+		schema["type"] = "string"
+	case structField.Type.Kind() == reflect.Bool:
+		schema["type"] = "boolean"
+	case structField.Type.Kind() == reflect.Int:
+		schema["type"] = "integer"
+	case structField.Type.Kind() == reflect.Float64:
+		schema["type"] = "number"
+	}
+	if enum := structField.Tag.Get("enum"); enum != "" {
+		schema["enum"] = strings.Split(enum, ",")
+	}
+	if value, ok := structField.Tag.Lookup("default"); ok {
+		schema["default"] = typedDefault(structField.Type, value)
+	}
+	return schema
+}
+
+// typedDefault converts a default from its environment form to the type the
+// configuration file uses
+func typedDefault(t reflect.Type, value string) interface{} {
+	if t != durationType {
+		switch t.Kind() {
+		case reflect.Int:
+			if n, err := strconv.Atoi(value); err == nil {
+				return n
+			}
+		case reflect.Float64:
+			if n, err := strconv.ParseFloat(value, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return value
+}
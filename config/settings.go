@@ -0,0 +1,114 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every setting's default, by its environment variable, from Config's tags
+var (
+	defaults     map[string]string
+	defaultsOnce sync.Once
+)
+
+// Setting returns a setting's environment variable, or its default if that
+// isn't set, and whether it has either. Packages reading their settings this
+// way take their defaults from the schema, so the two can't disagree, and
+// importing config means deprecated names and the command line are resolved
+// before they read them.
+func Setting(env string) (string, bool) {
+	if value, exists := os.LookupEnv(env); exists {
+		return value, true
+	}
+	return defaultSetting(env)
+}
+
+func defaultSetting(env string) (string, bool) {
+	defaultsOnce.Do(func() {
+		defaults = make(map[string]string)
+		var c Config
+		fields(&c, func(f field) {
+			if f.Default != "" {
+				defaults[f.Env] = f.Default
+			}
+		})
+	})
+	value, exists := defaults[env]
+	return value, exists
+}
+
+// FlagSetting reads a flag, which is on when it's set to anything at all
+func FlagSetting(env string) bool {
+	value, _ := Setting(env)
+	return value != ""
+}
+
+// IntSetting reads a whole number setting, exiting unless it's at least min
+func IntSetting(env string, min int) (int, bool) {
+	fromEnv, exists := Setting(env)
+	if !exists {
+		return 0, false
+	}
+	value, err := strconv.Atoi(fromEnv)
+	if err != nil || value < min {
+		if min == 1 {
+			log.Fatal(env, " must be a positive integer")
+		}
+		log.Fatal(env, " must be a whole number, at least ", min)
+	}
+	return value, true
+}
+
+// PercentSetting reads a positive percentage, like 90 or 90%, exiting if it's
+// anything else
+func PercentSetting(env string) (float64, bool) {
+	fromEnv, exists := Setting(env)
+	if !exists {
+		return 0, false
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(fromEnv, "%"), 64)
+	if err != nil || percent <= 0 {
+		log.Fatal(env, " must be a positive percentage, like 90")
+	}
+	return percent, true
+}
+
+// DurationSetting reads a positive duration, like 10s, exiting if it's
+// anything else
+func DurationSetting(env string) (time.Duration, bool) {
+	fromEnv, exists := Setting(env)
+	if !exists {
+		return 0, false
+	}
+	value, err := time.ParseDuration(fromEnv)
+	if err != nil || value <= 0 {
+		log.Fatal(env, " must be a positive duration, like ", durationExample(env))
+	}
+	return value, true
+}
+
+// DurationOrZeroSetting reads a duration which can also be 0, zero saying
+// what that means for the message it exits with if it's anything else
+func DurationOrZeroSetting(env string, zero string) (time.Duration, bool) {
+	fromEnv, exists := Setting(env)
+	if !exists {
+		return 0, false
+	}
+	value, err := time.ParseDuration(fromEnv)
+	if err != nil || value < 0 {
+		log.Fatal(env, " must be a duration, like ", durationExample(env), ", or 0 ", zero)
+	}
+	return value, true
+}
+
+// durationExample is the setting's default, or failing that 10s
+func durationExample(env string) string {
+	if value, exists := defaultSetting(env); exists && value != "0" {
+		return value
+	}
+	return "10s"
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// What reads a setting takes its default from the schema, so every default
+// has to parse as its setting's type
+func TestDefaultsParse(t *testing.T) {
+	var c Config
+	fields(&c, func(f field) {
+		if f.Default == "" {
+			return
+		}
+		var err error
+		switch {
+		case f.Value.Type() == durationType:
+			_, err = time.ParseDuration(f.Default)
+		case f.Value.Kind() == reflect.Int:
+			_, err = strconv.Atoi(f.Default)
+		case f.Value.Kind() == reflect.Float64:
+			_, err = strconv.ParseFloat(f.Default, 64)
+		case f.Value.Kind() == reflect.Bool:
+			_, err = strconv.ParseBool(f.Default)
+		}
+		if err != nil {
+			t.Errorf("%s: default %q: %v", f.Env, f.Default, err)
+		}
+		if f.Enum != nil && !contains(f.Enum, f.Default) {
+			t.Errorf("%s: default %q isn't one of %v", f.Env, f.Default, f.Enum)
+		}
+	})
+}
+
+func TestSetting(t *testing.T) {
+	const env = "HABERDASHER_QUEUE_SIZE"
+	defer restoreEnv(env)()
+
+	os.Unsetenv(env)
+	if value, exists := Setting(env); !exists || value != "10000" {
+		t.Errorf("unset: %q, %v, want the default", value, exists)
+	}
+	os.Setenv(env, "5")
+	if value, _ := IntSetting(env, 1); value != 5 {
+		t.Errorf("set: %d, want 5", value)
+	}
+	defer restoreEnv("HABERDASHER_WATCHDOG_TIMEOUT")()
+	os.Unsetenv("HABERDASHER_WATCHDOG_TIMEOUT")
+	if value, exists := Setting("HABERDASHER_WATCHDOG_TIMEOUT"); exists {
+		t.Errorf("a setting without a default: %q", value)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreEnv returns a function putting env back as it was
+func restoreEnv(env string) func() {
+	value, exists := os.LookupEnv(env)
+	return func() {
+		if exists {
+			os.Setenv(env, value)
+		} else {
+			os.Unsetenv(env)
+		}
+	}
+}
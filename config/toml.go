@@ -0,0 +1,75 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseTOML reads a TOML configuration file. As with YAML, numbers are kept
+// as json.Number. Dates and times aren't supported, since no setting takes
+// one.
+func parseTOML(data []byte) (map[string]interface{}, error) {
+	var document map[string]interface{}
+	if _, err := toml.Decode(string(data), &document); err != nil {
+		return nil, err
+	}
+	value, err := tomlValue("", document)
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// tomlValue converts what the decoder returns to the values encoding/json
+// would decode, key naming the value in errors
+func tomlValue(key string, value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for name, item := range value {
+			path := name
+			if key != "" {
+				path = key + "." + name
+			}
+			converted, err := tomlValue(path, item)
+			if err != nil {
+				return nil, err
+			}
+			value[name] = converted
+		}
+		return value, nil
+	case []map[string]interface{}:
+		list := make([]interface{}, 0, len(value))
+		for _, table := range value {
+			converted, err := tomlValue(key, table)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, converted)
+		}
+		return list, nil
+	case []interface{}:
+		for i, item := range value {
+			converted, err := tomlValue(key, item)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = converted
+		}
+		return value, nil
+	case int64:
+		return json.Number(strconv.FormatInt(value, 10)), nil
+	case float64:
+		if math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, fmt.Errorf("%s: %v isn't a supported number", key, value)
+		}
+		return json.Number(strconv.FormatFloat(value, 'f', -1, 64)), nil
+	case time.Time:
+		return nil, fmt.Errorf("%s: dates and times aren't supported", key)
+	}
+	return value, nil
+}
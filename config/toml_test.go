@@ -0,0 +1,82 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want document
+	}{
+		{"scalars", "emitter = \"kafka\"\nqueue_size = 100\nratio = 0.5\npoll = true\nneg = -3\nbig = 1_000\n",
+			document{"emitter": "kafka", "queue_size": json.Number("100"), "ratio": json.Number("0.5"), "poll": true, "neg": json.Number("-3"), "big": json.Number("1000")}},
+		{"prefixed integers", "hex = 0xff\noct = 0o755\nbin = 0b101\n", document{"hex": json.Number("255"), "oct": json.Number("493"), "bin": json.Number("5")}},
+		{"basic string escapes", `s = "tab\there \"quoted\" \u00e9 \U0001F600"` + "\n", document{"s": "tab\there \"quoted\" \u00e9 \U0001F600"}},
+		{"literal string", `path = 'C:\logs\app' # not an escape` + "\n", document{"path": `C:\logs\app`}},
+		{"multiline basic string", "s = \"\"\"\none\\\n   two\nthree\"\"\"\n", document{"s": "onetwo\nthree"}},
+		{"multiline literal string", "s = '''\nraw \\n\n'''\n", document{"s": "raw \\n\n"}},
+		{"comments", "# leading\nemitter = \"a # b\" # trailing\n\n  # indented\n", document{"emitter": "a # b"}},
+		{"bare, quoted and dotted keys", "a-b_c = 1\n\"x.y\" = 2\nkafka.tls.verify = false\n",
+			document{"a-b_c": json.Number("1"), "x.y": json.Number("2"), "kafka": document{"tls": document{"verify": false}}}},
+		{"tables", "top = 1\n[kafka]\nbrokers = \"a:9092\"\n[kafka.tls]\nverify = false\n[loki]\nurl = \"http://loki\"\n",
+			document{"top": json.Number("1"), "kafka": document{"brokers": "a:9092", "tls": document{"verify": false}}, "loki": document{"url": "http://loki"}}},
+		{"quoted table names", "[\"a.b\".c]\nd = 1\n", document{"a.b": document{"c": document{"d": json.Number("1")}}}},
+		{"arrays", "brokers = [\"a\", 'b', ]\nnested = [[1, 2], [3]]\nempty = []\n",
+			document{"brokers": list{"a", "b"}, "nested": list{list{json.Number("1"), json.Number("2")}, list{json.Number("3")}}, "empty": list{}}},
+		{"arrays over several lines with comments", "brokers = [\n  \"a\", # first\n  \"b\",\n]\n", document{"brokers": list{"a", "b"}}},
+		{"inline tables", "labels = { team = \"logging\", tier.level = 1 }\nempty = {}\n",
+			document{"labels": document{"team": "logging", "tier": document{"level": json.Number("1")}}, "empty": document{}}},
+		{"arrays of tables", "[[redactions]]\nname = \"bearer\"\n[[redactions]]\nname = \"card\"\nmatch = '[0-9]{16}'\n",
+			document{"redactions": list{document{"name": "bearer"}, document{"name": "card", "match": "[0-9]{16}"}}}},
+		{"inline tables in arrays and tables", "a = [{ b = 1 }, { c = { d = 2 } }]\n[e]\nf = { g = 3 }\n",
+			document{"a": list{document{"b": json.Number("1")}, document{"c": document{"d": json.Number("2")}}}, "e": document{"f": document{"g": json.Number("3")}}}},
+		{"subtable before its table", "[a.b]\nc = 1\n[a]\nd = 2\n", document{"a": document{"b": document{"c": json.Number("1")}, "d": json.Number("2")}}},
+		{"subtable of an array of tables", "[[routes]]\nemitter = \"drop\"\n[routes.labels]\nteam = \"x\"\n",
+			document{"routes": list{document{"emitter": "drop", "labels": document{"team": "x"}}}}},
+		{"CRLF", "emitter = \"stderr\"\r\n", document{"emitter": "stderr"}},
+		{"floats", "a = 1e3\nb = -0.25\n", document{"a": json.Number("1000"), "b": json.Number("-0.25")}},
+		{"empty file", "# nothing\n", document{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(test.toml))
+			if err != nil {
+				t.Fatalf("parseTOML(%q): %v", test.toml, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseTOML(%q) = %#v, want %#v", test.toml, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want string
+	}{
+		{"dates", "when = 1979-05-27\n", "when: dates and times aren't supported"},
+		{"times in a table", "[a]\nb = [07:32:00]\n", "a.b: dates and times aren't supported"},
+		{"special floats", "[a]\nb = inf\n", "a.b: +Inf isn't a supported number"},
+		{"duplicate key", "a = 1\na = 2\n", "line 2"},
+		{"table over a value", "a = 1\n[a]\n", "line 2"},
+		{"unterminated string", "a = \"open\n", "line 1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML([]byte(test.toml))
+			if err == nil {
+				t.Fatalf("parseTOML(%q) = %#v, want an error", test.toml, got)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("parseTOML(%q) failed with %q, want it to mention %q", test.toml, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A number written in decimal, which is kept as it's written
+var yamlDecimal = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+
+// parseYAML reads a YAML configuration file, which must be a single document
+// holding a mapping. Numbers are kept as json.Number, with decimal ones as
+// written, so settings which are strings, like a umask of 022, keep the
+// digits.
+func parseYAML(data []byte) (map[string]interface{}, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	var document yaml.Node
+	if err := decoder.Decode(&document); err == io.EOF {
+		return map[string]interface{}{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var another yaml.Node
+	if err := decoder.Decode(&another); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("line %d: multiple documents aren't supported", another.Line)
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: the file must hold a mapping of settings", root.Line)
+	}
+	value, err := yamlValue(root)
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// yamlValue converts a node to the values encoding/json would decode
+func yamlValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.MappingNode:
+		return yamlMapping(node)
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+	switch node.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		err := node.Decode(&b)
+		return b, err
+	case "!!int", "!!float":
+		if yamlDecimal.MatchString(node.Value) {
+			return json.Number(strings.TrimPrefix(node.Value, "+")), nil
+		}
+		var integer int64
+		if node.ShortTag() == "!!int" && node.Decode(&integer) == nil {
+			return json.Number(strconv.FormatInt(integer, 10)), nil
+		}
+		var number float64
+		if err := node.Decode(&number); err != nil {
+			return nil, err
+		}
+		if math.IsInf(number, 0) || math.IsNaN(number) {
+			return nil, fmt.Errorf("line %d: %s isn't a supported number", node.Line, node.Value)
+		}
+		return json.Number(strconv.FormatFloat(number, 'f', -1, 64)), nil
+	}
+	return node.Value, nil
+}
+
+// yamlMapping converts a mapping, whose keys must be strings. Keys merged
+// in with << don't replace the mapping's own.
+func yamlMapping(node *yaml.Node) (map[string]interface{}, error) {
+	mapping := make(map[string]interface{})
+	var merged []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.ShortTag() == "!!merge" {
+			if value.Kind == yaml.SequenceNode {
+				merged = append(merged, value.Content...)
+			} else {
+				merged = append(merged, value)
+			}
+			continue
+		}
+		if key.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("line %d: keys must be strings", key.Line)
+		}
+		if _, exists := mapping[key.Value]; exists {
+			return nil, fmt.Errorf("line %d: %s appears twice", key.Line, key.Value)
+		}
+		converted, err := yamlValue(value)
+		if err != nil {
+			return nil, err
+		}
+		mapping[key.Value] = converted
+	}
+	for _, node := range merged {
+		value, err := yamlValue(node)
+		if err != nil {
+			return nil, err
+		}
+		table, isMapping := value.(map[string]interface{})
+		if !isMapping {
+			return nil, fmt.Errorf("line %d: only mappings can be merged", node.Line)
+		}
+		for key, value := range table {
+			if _, exists := mapping[key]; !exists {
+				mapping[key] = value
+			}
+		}
+	}
+	return mapping, nil
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type document = map[string]interface{}
+type list = []interface{}
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want document
+	}{
+		{"plain scalars", "emitter: kafka\nqueue_size: 100\nratio: 0.5\npoll: true\nnothing: null\ntilde: ~\n",
+			document{"emitter": "kafka", "queue_size": json.Number("100"), "ratio": json.Number("0.5"), "poll": true, "nothing": nil, "tilde": nil}},
+		{"octal digits are kept", "umask: 022\n", document{"umask": json.Number("022")}},
+		{"double quoted", `greeting: "hello: \"world\"\n\u00e9"` + "\n", document{"greeting": "hello: \"world\"\n\u00e9"}},
+		{"single quoted", "greeting: 'it''s # not a comment'\n", document{"greeting": "it's # not a comment"}},
+		{"quoted numbers stay strings", "port: \"8080\"\n", document{"port": "8080"}},
+		{"inline comments", "# leading\nemitter: kafka # trailing\n  # indented\nurl: http://host/#fragment\n",
+			document{"emitter": "kafka", "url": "http://host/#fragment"}},
+		{"comment after a quoted value", "name: \"a # b\" # c\n", document{"name": "a # b"}},
+		{"nested mappings", "kafka:\n  brokers: a:9092\n  tls:\n    verify: false\nafter: 1\n",
+			document{"kafka": document{"brokers": "a:9092", "tls": document{"verify": false}}, "after": json.Number("1")}},
+		{"block sequence", "brokers:\n  - a:9092\n  - b:9092\n", document{"brokers": list{"a:9092", "b:9092"}}},
+		{"block sequence as indented as its key", "brokers:\n- a\n- b\nnext: c\n", document{"brokers": list{"a", "b"}, "next": "c"}},
+		{"sequence of mappings", "redactions:\n  - name: bearer\n    match: Bearer .*\n  - name: card\n    match: '[0-9]{16}'\n",
+			document{"redactions": list{document{"name": "bearer", "match": "Bearer .*"}, document{"name": "card", "match": "[0-9]{16}"}}}},
+		{"nested sequences", "matrix:\n  - - 1\n    - 2\n  - - 3\n", document{"matrix": list{list{json.Number("1"), json.Number("2")}, list{json.Number("3")}}}},
+		{"flow sequence", "brokers: [a:9092, \"b, c\", 3]\n", document{"brokers": list{"a:9092", "b, c", json.Number("3")}}},
+		{"flow mapping", "labels: {team: logging, \"tier\": 1}\n", document{"labels": document{"team": "logging", "tier": json.Number("1")}}},
+		{"JSON style flow", `routes: [{"match":"x","emitter":"drop"}]` + "\n", document{"routes": list{document{"match": "x", "emitter": "drop"}}}},
+		{"empty flow collections", "a: []\nb: {}\n", document{"a": list{}, "b": document{}}},
+		{"flow collection over several lines", "brokers: [\n  a,\n  b\n]\n", document{"brokers": list{"a", "b"}}},
+		{"literal block scalar", "script: |\n  one\n    two\n\n  three\nnext: x\n", document{"script": "one\n  two\n\nthree\n", "next": "x"}},
+		{"folded block scalar", "text: >\n  one\n  two\n\n  three\n", document{"text": "one two\nthree\n"}},
+		{"kept block scalar", "text: |+\n  one\n\nnext: x\nlast: >+\n  two\n\n", document{"text": "one\n\n", "next": "x", "last": "two\n\n"}},
+		{"quoted scalar over several lines of flow", "a: [\"one\n  two\"]\n", document{"a": list{"one two"}}},
+		{"stripped block scalar", "text: |-\n  one\n  two\n", document{"text": "one\ntwo"}},
+		{"empty value", "empty:\nnext: 1\n", document{"empty": nil, "next": json.Number("1")}},
+		{"document marker and CRLF", "---\r\nemitter: stderr\r\n", document{"emitter": "stderr"}},
+		{"quoted keys", "\"a: b\": 1\n'c': 2\n", document{"a: b": json.Number("1"), "c": json.Number("2")}},
+		{"other integers", "hex: 0x1f\noctal: 0o17\n", document{"hex": json.Number("31"), "octal": json.Number("15")}},
+		{"tags", "a: !!str 1\n", document{"a": "1"}},
+		{"anchors and aliases", "a: &anchor [1, 2]\nb: *anchor\n", document{"a": list{json.Number("1"), json.Number("2")}, "b": list{json.Number("1"), json.Number("2")}}},
+		{"merge keys", "base: &base {a: 1, b: 2}\nderived:\n  <<: *base\n  b: 3\n",
+			document{"base": document{"a": json.Number("1"), "b": json.Number("2")}, "derived": document{"a": json.Number("1"), "b": json.Number("3")}}},
+		{"plain scalar over several lines", "a: one\n  two\n", document{"a": "one two"}},
+		{"empty file", "# nothing\n", document{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(test.yaml))
+			if err != nil {
+				t.Fatalf("parseYAML(%q): %v", test.yaml, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseYAML(%q) = %#v, want %#v", test.yaml, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"not a mapping", "- a\n- b\n", "line 1: the file must hold a mapping of settings"},
+		{"duplicate key", "a: 1\na: 2\n", "line 2: a appears twice"},
+		{"complex keys", "? [a]\n: b\n", "line 1: keys must be strings"},
+		{"merging a list", "a:\n  <<: [[1]]\n", "line 2: only mappings can be merged"},
+		{"special floats", "a: .inf\n", "line 1: .inf isn't a supported number"},
+		{"multiple documents", "a: 1\n---\nb: 2\n", "line 2: multiple documents aren't supported"},
+		{"bad indentation", "a:\n    b: 1\n  c: 2\n", "line 2"},
+		{"unterminated quote", "a: \"open\n", "unexpected end of stream"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseYAML([]byte(test.yaml))
+			if err == nil {
+				t.Fatalf("parseYAML(%q) = %#v, want an error", test.yaml, got)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("parseYAML(%q) failed with %q, want it to mention %q", test.yaml, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+const descendantScanInterval = 2 * time.Second
+
+// Processes spawned by the child inherit our stderr pipe, so their output is
+// captured without any extra work. Ones that point their stderr at /dev/null
+// or a file are invisible to us though, which is confusing when their logs go
+// missing. If HABERDASHER_WATCH_DESCENDANTS is set, periodically walk the
+// child's process tree in /proc and emit a warning event for every process
+// whose stderr isn't our pipe.
+func startDescendantWatch(emitter logging.Emitter, child *supervisor) {
+	if !config.FlagSetting("HABERDASHER_WATCH_DESCENDANTS") {
+		return
+	}
+
+	producers.start(func() {
+		warned := make(map[string]bool)
+		for producers.sleep(descendantScanInterval) {
+			pid, expected := child.stderrIdentity()
+			if expected == "" {
+				continue
+			}
+			for _, descendant := range descendantsOf(pid) {
+				target, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/2", descendant))
+				if err != nil || target == expected {
+					continue
+				}
+				key := fmt.Sprintf("%d:%s", descendant, target)
+				if warned[key] {
+					continue
+				}
+				warned[key] = true
+				logging.EmitEvent(emitter, "stderr-redirected", fmt.Sprintf(
+					"Process %d (%s) has its stderr pointed at %s, so its logs are not being captured",
+					descendant, processName(descendant), target))
+			}
+		}
+	})
+}
+
+// descendantsOf returns the pids of every process below root, including root
+func descendantsOf(root int) []int {
+	if root <= 0 {
+		return nil
+	}
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if ppid := parentOf(pid); ppid > 0 {
+			children[ppid] = append(children[ppid], pid)
+		}
+	}
+
+	found := []int{root}
+	for i := 0; i < len(found); i++ {
+		found = append(found, children[found[i]]...)
+	}
+	return found
+}
+
+// parentOf reads the parent pid out of /proc/<pid>/stat. The process name can
+// contain spaces and parens, so fields are counted from the last ')'.
+func parentOf(pid int) int {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	s := string(stat)
+	fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+func processName(pid int) string {
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(comm))
+}
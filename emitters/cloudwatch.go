@@ -0,0 +1,296 @@
+package emitters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/aws"
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// CloudWatch Logs' limits on a PutLogEvents call: its size, counting each
+// event's message and 26 bytes more, and how many events it holds. Batches
+// are measured by the events' JSON, which is always at least that big.
+const (
+	cloudwatchMaxBatchBytes = 1048576
+	cloudwatchMaxEvents     = 10000
+)
+
+const (
+	cloudwatchMinBackoff = 500 * time.Millisecond
+	cloudwatchMaxBackoff = 30 * time.Second
+)
+
+var cloudwatchGroup, cloudwatchStream string
+var cloudwatchEndpoint, cloudwatchRegion string
+var cloudwatchCredentials *aws.Chain
+var cloudwatchClient *http.Client
+var cloudwatchBatcher *batch.Batcher
+var cloudwatchAttempts int
+var cloudwatchCreateGroup bool
+
+// One batch goes at a time, each with the sequence token the last returned
+var cloudwatchLock sync.Mutex
+var cloudwatchSequenceToken string
+
+type cloudwatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// A cloudwatchError is an error response from CloudWatch Logs
+type cloudwatchError struct {
+	Type                  string `json:"__type"`
+	Message               string `json:"message"`
+	ExpectedSequenceToken string `json:"expectedSequenceToken"`
+	StatusCode            int    `json:"-"`
+}
+
+func (e *cloudwatchError) Error() string {
+	return fmt.Sprintf("CloudWatch Logs: %s: %s", e.Type, e.Message)
+}
+
+// retryable reports whether the request may succeed later: it was throttled,
+// or the service failed
+func (e *cloudwatchError) retryable() bool {
+	switch e.Type {
+	case "ThrottlingException", "ServiceUnavailableException", "LimitExceededException":
+		return true
+	}
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+type cloudwatchEmitter struct{}
+
+func init() {
+	var emitter cloudwatchEmitter
+	logging.Register("cloudwatch", emitter)
+}
+
+// Setup configures the log group and stream to send to, from
+// HABERDASHER_CLOUDWATCH_LOG_GROUP and HABERDASHER_CLOUDWATCH_LOG_STREAM, and
+// creates the stream if it doesn't exist
+func (e cloudwatchEmitter) Setup() {
+	var exists bool
+	if cloudwatchGroup, exists = config.Setting("HABERDASHER_CLOUDWATCH_LOG_GROUP"); !exists || cloudwatchGroup == "" {
+		log.Fatal("To use Haberdasher with CloudWatch Logs, HABERDASHER_CLOUDWATCH_LOG_GROUP must be set to the log group to send to")
+	}
+	if cloudwatchStream, _ = config.Setting("HABERDASHER_CLOUDWATCH_LOG_STREAM"); cloudwatchStream == "" {
+		cloudwatchStream, _ = os.Hostname()
+	}
+	if cloudwatchRegion, _ = config.Setting("HABERDASHER_CLOUDWATCH_REGION"); cloudwatchRegion == "" {
+		cloudwatchRegion = aws.Region()
+	}
+	if cloudwatchRegion == "" {
+		log.Fatal("To use Haberdasher with CloudWatch Logs, HABERDASHER_CLOUDWATCH_REGION or AWS_REGION must be set")
+	}
+	if cloudwatchEndpoint, _ = config.Setting("HABERDASHER_CLOUDWATCH_ENDPOINT"); cloudwatchEndpoint == "" {
+		cloudwatchEndpoint = "https://logs." + cloudwatchRegion + ".amazonaws.com"
+	}
+	cloudwatchCreateGroup = config.FlagSetting("HABERDASHER_CLOUDWATCH_CREATE_GROUP")
+	cloudwatchCredentials = aws.NewChain(cloudwatchRegion)
+	cloudwatchClient = endpoints.NewClient("HABERDASHER_CLOUDWATCH")
+
+	limits := batch.Limits{MaxBytes: cloudwatchMaxBatchBytes, MaxItems: cloudwatchMaxEvents}
+	limits.MaxWait, _ = config.DurationSetting("HABERDASHER_CLOUDWATCH_FLUSH_INTERVAL")
+	cloudwatchAttempts, _ = config.IntSetting("HABERDASHER_CLOUDWATCH_ATTEMPTS", 1)
+
+	// Failing to create the stream now isn't fatal, since it's created again
+	// if a batch finds it missing
+	cloudwatchSequenceToken = ""
+	if err := createCloudwatchStream(); err != nil {
+		log.Println("Warning: couldn't create the CloudWatch Logs stream:", err)
+	}
+	cloudwatchBatcher = batch.New(limits, nil, writeCloudwatchBatch)
+}
+
+// createCloudwatchStream creates the log stream, and the group first if
+// HABERDASHER_CLOUDWATCH_CREATE_GROUP is set, unless they already exist
+func createCloudwatchStream() error {
+	if cloudwatchCreateGroup {
+		err := cloudwatchCall("CreateLogGroup", map[string]string{"logGroupName": cloudwatchGroup}, nil)
+		if cwErr, ok := err.(*cloudwatchError); err != nil && !(ok && cwErr.Type == "ResourceAlreadyExistsException") {
+			return err
+		}
+	}
+	err := cloudwatchCall("CreateLogStream", map[string]string{"logGroupName": cloudwatchGroup, "logStreamName": cloudwatchStream}, nil)
+	if cwErr, ok := err.(*cloudwatchError); ok && cwErr.Type == "ResourceAlreadyExistsException" {
+		return nil
+	}
+	return err
+}
+
+// cloudwatchCall makes a signed CloudWatch Logs API call, decoding its
+// response into result if it's not nil
+func cloudwatchCall(action string, input interface{}, result interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	creds, err := cloudwatchCredentials.Get()
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, cloudwatchEndpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	aws.Sign(request, body, creds, cloudwatchRegion, "logs", time.Now())
+
+	response, err := cloudwatchClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		cwErr := &cloudwatchError{StatusCode: response.StatusCode}
+		if json.Unmarshal(responseBody, cwErr) != nil || cwErr.Type == "" {
+			cwErr.Type, cwErr.Message = response.Status, strings.TrimSpace(string(responseBody))
+		}
+		// The type may be qualified, like "com.amazonaws...#ThrottlingException"
+		cwErr.Type = cwErr.Type[strings.LastIndexByte(cwErr.Type, '#')+1:]
+		return cwErr
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(responseBody, result)
+}
+
+// HandleLogMessage queues the log message as an event for the next batch
+func (e cloudwatchEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	timestamp := logging.Clock.Now()
+	switch m := jsonSerializeable.(type) {
+	case logging.Message:
+		timestamp = m.Timestamp
+	case map[string]interface{}:
+		if stamp, ok := m["@timestamp"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				timestamp = parsed
+			}
+		}
+	}
+	event, err := json.Marshal(cloudwatchEvent{Timestamp: timestamp.UnixNano() / int64(time.Millisecond), Message: string(jsonBytes)})
+	if err != nil {
+		return err
+	}
+	return cloudwatchBatcher.Add(event)
+}
+
+// writeCloudwatchBatch sends a batch in one PutLogEvents call, taking care of
+// the sequence token, the stream going missing, and throttling
+func writeCloudwatchBatch(items [][]byte) error {
+	events := make([]json.RawMessage, len(items))
+	timestamps := make([]int64, len(items))
+	for i, item := range items {
+		events[i] = item
+		var event cloudwatchEvent
+		json.Unmarshal(item, &event)
+		timestamps[i] = event.Timestamp
+	}
+	// Events must be in chronological order
+	sort.Stable(byTimestamp{events, timestamps})
+
+	cloudwatchLock.Lock()
+	defer cloudwatchLock.Unlock()
+	backoff := cloudwatchMinBackoff
+	var err error
+	for attempt := 1; attempt <= cloudwatchAttempts; attempt++ {
+		input := map[string]interface{}{
+			"logGroupName":  cloudwatchGroup,
+			"logStreamName": cloudwatchStream,
+			"logEvents":     events,
+		}
+		if cloudwatchSequenceToken != "" {
+			input["sequenceToken"] = cloudwatchSequenceToken
+		}
+		var result struct {
+			NextSequenceToken string `json:"nextSequenceToken"`
+			RejectedLogEvents *struct {
+				TooOldLogEventEndIndex   *int `json:"tooOldLogEventEndIndex"`
+				TooNewLogEventStartIndex *int `json:"tooNewLogEventStartIndex"`
+				ExpiredLogEventEndIndex  *int `json:"expiredLogEventEndIndex"`
+			} `json:"rejectedLogEventsInfo"`
+		}
+		err = cloudwatchCall("PutLogEvents", input, &result)
+		if err == nil {
+			cloudwatchSequenceToken = result.NextSequenceToken
+			if result.RejectedLogEvents != nil {
+				log.Println("Warning: CloudWatch Logs rejected events outside its time window")
+			}
+			return nil
+		}
+		cwErr, ok := err.(*cloudwatchError)
+		switch {
+		case ok && cwErr.Type == "InvalidSequenceTokenException":
+			cloudwatchSequenceToken = cwErr.ExpectedSequenceToken
+			continue
+		case ok && cwErr.Type == "DataAlreadyAcceptedException":
+			// A retry of a call which had in fact succeeded
+			cloudwatchSequenceToken = cwErr.ExpectedSequenceToken
+			return nil
+		case ok && cwErr.Type == "ResourceNotFoundException":
+			cloudwatchSequenceToken = ""
+			if createErr := createCloudwatchStream(); createErr != nil {
+				return createErr
+			}
+			continue
+		case ok && !cwErr.retryable():
+			return err
+		}
+		if attempt < cloudwatchAttempts {
+			logging.Clock.Sleep(backoff)
+			if backoff *= 2; backoff > cloudwatchMaxBackoff {
+				backoff = cloudwatchMaxBackoff
+			}
+		}
+	}
+	return err
+}
+
+// byTimestamp sorts events along with their timestamps
+type byTimestamp struct {
+	events     []json.RawMessage
+	timestamps []int64
+}
+
+func (b byTimestamp) Len() int           { return len(b.events) }
+func (b byTimestamp) Less(i, j int) bool { return b.timestamps[i] < b.timestamps[j] }
+func (b byTimestamp) Swap(i, j int) {
+	b.events[i], b.events[j] = b.events[j], b.events[i]
+	b.timestamps[i], b.timestamps[j] = b.timestamps[j], b.timestamps[i]
+}
+
+// CheckHealth makes sure we have credentials
+func (e cloudwatchEmitter) CheckHealth() error {
+	_, err := cloudwatchCredentials.Get()
+	return err
+}
+
+// Cleanup puts the last batch of events to the log stream
+func (e cloudwatchEmitter) Cleanup() error {
+	cloudwatchBatcher.Close()
+	return nil
+}
@@ -0,0 +1,157 @@
+package emitters
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// Event types, from winnt.h
+const (
+	eventlogErrorType       = 0x0001
+	eventlogWarningType     = 0x0002
+	eventlogInformationType = 0x0004
+)
+
+// ReportEvent's limit on each string, in UTF-16 code units
+const eventlogMaxString = 31839
+
+// The event IDs, by level, unless HABERDASHER_EVENTLOG_EVENT_IDS says
+// otherwise. Unleveled lines are "info".
+var eventlogDefaultIDs = map[string]uint32{
+	"trace": 1,
+	"debug": 1,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+	"fatal": 4,
+}
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+)
+
+type eventlogEmitter struct{}
+
+var eventlogLock sync.Mutex
+var eventlogHandle uintptr
+var eventlogIDs map[string]uint32
+
+func init() {
+	var emitter eventlogEmitter
+	logging.Register("eventlog", emitter)
+}
+
+// Setup registers HABERDASHER_EVENTLOG_SOURCE as the source of our events.
+// Sources which haven't been installed (with New-EventLog, say) still log to
+// the Application log, but Event Viewer complains it can't find their
+// message file.
+func (e eventlogEmitter) Setup() {
+	source, _ := config.Setting("HABERDASHER_EVENTLOG_SOURCE")
+	if source == "" {
+		source = "haberdasher"
+	}
+	eventlogIDs = make(map[string]uint32, len(eventlogDefaultIDs))
+	for level, id := range eventlogDefaultIDs {
+		eventlogIDs[level] = id
+	}
+	if fromEnv, exists := config.Setting("HABERDASHER_EVENTLOG_EVENT_IDS"); exists {
+		var overrides map[string]uint32
+		if err := json.Unmarshal([]byte(fromEnv), &overrides); err != nil {
+			log.Fatal("HABERDASHER_EVENTLOG_EVENT_IDS must be a JSON object of levels to event IDs, like {\"error\": 1003}")
+		}
+		for level, id := range overrides {
+			if _, known := eventlogDefaultIDs[level]; !known {
+				log.Fatal("HABERDASHER_EVENTLOG_EVENT_IDS: unknown level ", level)
+			}
+			if id > 0xffff {
+				log.Fatal("HABERDASHER_EVENTLOG_EVENT_IDS: event IDs must be from 0 to 65535")
+			}
+			eventlogIDs[level] = id
+		}
+	}
+
+	name, err := syscall.UTF16PtrFromString(source)
+	if err != nil {
+		log.Fatal("HABERDASHER_EVENTLOG_SOURCE: ", err)
+	}
+	eventlogLock.Lock()
+	defer eventlogLock.Unlock()
+	handle, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(name)))
+	if handle == 0 {
+		log.Fatal("Couldn't register the Event Log source ", source, ": ", err)
+	}
+	eventlogHandle = handle
+}
+
+// HandleLogMessage reports the log message as an event whose string is the
+// JSON document, with its type and event ID from the message's level
+func (e eventlogEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	level := logging.Severity(string(jsonBytes))
+	if m, ok := jsonSerializeable.(logging.Message); ok {
+		level = m.Level
+	}
+	id, known := eventlogIDs[level]
+	if !known {
+		level, id = "info", eventlogIDs["info"]
+	}
+	eventType := eventlogInformationType
+	switch level {
+	case "warn":
+		eventType = eventlogWarningType
+	case "error", "fatal":
+		eventType = eventlogErrorType
+	}
+
+	text := utf16.Encode([]rune(string(jsonBytes)))
+	if len(text) > eventlogMaxString {
+		text = text[:eventlogMaxString]
+		// Rather than leave half a surrogate pair
+		if last := text[len(text)-1]; last >= 0xd800 && last < 0xdc00 {
+			text = text[:len(text)-1]
+		}
+	}
+	text = append(text, 0)
+	eventStrings := [1]*uint16{&text[0]}
+
+	eventlogLock.Lock()
+	defer eventlogLock.Unlock()
+	if eventlogHandle == 0 {
+		return errors.New("the Event Log source isn't registered")
+	}
+	ok, _, err := procReportEvent.Call(eventlogHandle, uintptr(eventType), 0, uintptr(id), 0,
+		1, 0, uintptr(unsafe.Pointer(&eventStrings[0])), 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// Cleanup deregisters the event source
+func (e eventlogEmitter) Cleanup() error {
+	eventlogLock.Lock()
+	defer eventlogLock.Unlock()
+	if eventlogHandle == 0 {
+		return nil
+	}
+	ok, _, err := procDeregisterEventSource.Call(eventlogHandle)
+	eventlogHandle = 0
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
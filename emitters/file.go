@@ -0,0 +1,303 @@
+package emitters
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+const (
+	fileFlushInterval = time.Second
+	fileStampFormat   = "2006-01-02T15-04-05.000"
+)
+
+var filePath string
+var fileMaxBytes int64
+var fileMaxAge time.Duration
+var fileRetain int
+var fileCompress bool
+
+// The open file, its buffer, how much has been written to it, and the boundary
+// it's rotated at, all guarded by fileLock
+var fileLock sync.Mutex
+var fileHandle *os.File
+var fileBuffer *bufio.Writer
+var fileSize int64
+var fileRotateAt time.Time
+var fileRotated time.Time
+
+// Rotated files are compressed in the background, and their retention
+// enforced once they're done
+var fileRotations sync.WaitGroup
+var fileStop chan struct{}
+var fileFlusher sync.WaitGroup
+
+type fileEmitter struct{}
+
+func init() {
+	var emitter fileEmitter
+	logging.Register("file", emitter)
+}
+
+// Setup opens HABERDASHER_FILE_PATH to append messages to, and reads how it
+// should be rotated. Without HABERDASHER_FILE_MAX_AGE it rotates when the
+// child is signalled to rotate its own files, if it is.
+func (e fileEmitter) Setup() {
+	var exists bool
+	if filePath, exists = config.Setting("HABERDASHER_FILE_PATH"); !exists || filePath == "" {
+		log.Fatal("To use Haberdasher with files, HABERDASHER_FILE_PATH must be set to the file to write")
+	}
+	maxBytes, _ := config.IntSetting("HABERDASHER_FILE_MAX_BYTES", 1)
+	fileMaxBytes = int64(maxBytes)
+	fileMaxAge, _ = config.DurationSetting("HABERDASHER_FILE_MAX_AGE")
+	if fileMaxAge == 0 {
+		fileMaxAge, _ = config.DurationSetting("HABERDASHER_ROTATE_INTERVAL")
+	}
+	fileRetain, _ = config.IntSetting("HABERDASHER_FILE_RETAIN", 0)
+	fileCompress = config.FlagSetting("HABERDASHER_FILE_COMPRESS")
+
+	fileLock.Lock()
+	err := openFile()
+	fileLock.Unlock()
+	if err != nil {
+		log.Fatal("Couldn't open HABERDASHER_FILE_PATH: ", err)
+	}
+
+	fileStop = make(chan struct{})
+	fileFlusher.Add(1)
+	go flushFilePeriodically(fileStop)
+}
+
+// openFile opens the file for appending, carrying on from its current size.
+// The caller holds fileLock.
+func openFile() error {
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fileHandle, fileBuffer = f, bufio.NewWriter(f)
+	fileSize = info.Size()
+	if fileMaxAge > 0 {
+		fileRotateAt = logging.NextRotation(logging.Clock.Now(), fileMaxAge)
+	}
+	return nil
+}
+
+// dueForRotation reports whether the file has reached its rotation boundary.
+// An empty file isn't rotated, but waits for the next one. The caller holds
+// fileLock.
+func dueForRotation() bool {
+	if fileMaxAge <= 0 {
+		return false
+	}
+	now := logging.Clock.Now()
+	if now.Before(fileRotateAt) {
+		return false
+	}
+	if fileSize == 0 {
+		fileRotateAt = logging.NextRotation(now, fileMaxAge)
+		return false
+	}
+	return true
+}
+
+// closeFile flushes and closes the file. The caller holds fileLock.
+func closeFile() error {
+	if fileHandle == nil {
+		return nil
+	}
+	err := fileBuffer.Flush()
+	if syncErr := fileHandle.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := fileHandle.Close(); err == nil {
+		err = closeErr
+	}
+	fileHandle, fileBuffer = nil, nil
+	return err
+}
+
+// The buffer is flushed every second, so the file is never far behind, and
+// an idle file still rotates at its boundary
+func flushFilePeriodically(stop chan struct{}) {
+	defer fileFlusher.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-logging.Clock.After(fileFlushInterval):
+		}
+		fileLock.Lock()
+		if fileHandle != nil {
+			if err := fileBuffer.Flush(); err != nil {
+				log.Println("Error writing", filePath+":", err)
+			}
+			if dueForRotation() {
+				if err := rotateFile(); err != nil {
+					log.Println("Error rotating", filePath+":", err)
+				}
+			}
+		}
+		fileLock.Unlock()
+	}
+}
+
+// HandleLogMessage appends the message to the file as a line of JSON,
+// rotating the file first if the message would take it over its size or it's
+// reached its rotation boundary
+func (e fileEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	jsonBytes = append(jsonBytes, '\n')
+
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	if fileHandle == nil {
+		// A rotation failed to reopen the file; try again
+		if err := openFile(); err != nil {
+			return err
+		}
+	}
+	tooBig := fileSize > 0 && fileSize+int64(len(jsonBytes)) > fileMaxBytes
+	if tooBig || dueForRotation() {
+		if err := rotateFile(); err != nil {
+			return err
+		}
+	}
+	n, err := fileBuffer.Write(jsonBytes)
+	fileSize += int64(n)
+	return err
+}
+
+// rotateFile moves the current file aside, named for when it was rotated,
+// and opens a new one. The caller holds fileLock.
+func rotateFile() error {
+	if err := closeFile(); err != nil {
+		log.Println("Error closing", filePath, "to rotate it:", err)
+	}
+	// Rotated files are named to the millisecond, so rotating more often
+	// than that mustn't reuse a name
+	stamp := logging.Clock.Now().UTC().Truncate(time.Millisecond)
+	if !stamp.After(fileRotated) {
+		stamp = fileRotated.Add(time.Millisecond)
+	}
+	fileRotated = stamp
+	ext := filepath.Ext(filePath)
+	rotated := strings.TrimSuffix(filePath, ext) + "-" + stamp.Format(fileStampFormat) + ext
+	if err := os.Rename(filePath, rotated); err != nil {
+		openFile()
+		return err
+	}
+	if err := openFile(); err != nil {
+		return err
+	}
+
+	fileRotations.Add(1)
+	go func() {
+		defer fileRotations.Done()
+		if fileCompress {
+			if err := compressFile(rotated); err != nil {
+				log.Println("Error compressing", rotated+":", err)
+			}
+		}
+		pruneRotatedFiles()
+	}()
+	return nil
+}
+
+// compressFile gzips a rotated file, replacing it
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Rotations finishing at once take turns pruning
+var pruneLock sync.Mutex
+
+// pruneRotatedFiles removes all but the newest HABERDASHER_FILE_RETAIN
+// rotated files. Their timestamps sort in the order they were rotated.
+func pruneRotatedFiles() {
+	pruneLock.Lock()
+	defer pruneLock.Unlock()
+	ext := filepath.Ext(filePath)
+	prefix := strings.TrimSuffix(filePath, ext) + "-"
+	candidates, _ := filepath.Glob(prefix + "*")
+	var rotated []string
+	for _, candidate := range candidates {
+		stamp := strings.TrimSuffix(strings.TrimSuffix(candidate, ".gz"), ext)
+		if _, err := time.Parse(fileStampFormat, strings.TrimPrefix(stamp, prefix)); err == nil {
+			rotated = append(rotated, candidate)
+		}
+	}
+	sort.Slice(rotated, func(i, j int) bool {
+		return strings.TrimSuffix(rotated[i], ".gz") < strings.TrimSuffix(rotated[j], ".gz")
+	})
+	for len(rotated) > fileRetain {
+		if err := os.Remove(rotated[0]); err != nil {
+			log.Println("Error removing", rotated[0]+":", err)
+		}
+		rotated = rotated[1:]
+	}
+}
+
+// CheckHealth makes sure the file is open
+func (e fileEmitter) CheckHealth() error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	if fileHandle != nil {
+		return nil
+	}
+	return openFile()
+}
+
+// Cleanup flushes and closes the file, once any rotated files are compressed
+func (e fileEmitter) Cleanup() error {
+	close(fileStop)
+	fileFlusher.Wait()
+	fileLock.Lock()
+	err := closeFile()
+	fileLock.Unlock()
+	fileRotations.Wait()
+	return err
+}
@@ -0,0 +1,299 @@
+package emitters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/msgpack"
+	"github.com/RedHatInsights/haberdasher/tlsconfig"
+)
+
+const (
+	defaultFluentdTag = "haberdasher"
+	fluentdAttempts   = 3
+)
+
+var fluentdAddress string
+var fluentdTLS *tls.Config
+var fluentdTag string
+var fluentdSharedKey string
+var fluentdUsername, fluentdPassword string
+var fluentdHostname string
+var fluentdAck bool
+var fluentdAckTimeout time.Duration
+var fluentdBatcher *batch.Batcher
+
+// Batches are written one at a time on a connection we keep open, and after
+// an error, replace
+var fluentdLock sync.Mutex
+var fluentdConn net.Conn
+var fluentdDecoder *msgpack.Decoder
+
+type fluentdEmitter struct{}
+
+func init() {
+	var emitter fluentdEmitter
+	logging.Register("fluentd", emitter)
+}
+
+// Setup configures the Fluentd or Fluent Bit forward input to send to, from
+// HABERDASHER_FLUENTD_ADDRESS (host:port), and how to authenticate to it
+func (e fluentdEmitter) Setup() {
+	var exists bool
+	if fluentdAddress, exists = config.Setting("HABERDASHER_FLUENTD_ADDRESS"); !exists || fluentdAddress == "" {
+		log.Fatal("To use Haberdasher with Fluentd, HABERDASHER_FLUENTD_ADDRESS must be set to its forward input, like fluentd:24224")
+	}
+	if _, _, err := net.SplitHostPort(fluentdAddress); err != nil {
+		fluentdAddress = net.JoinHostPort(fluentdAddress, "24224")
+	}
+	var err error
+	if fluentdTLS, err = tlsconfig.FromEnv("HABERDASHER_FLUENTD"); err != nil {
+		log.Fatal("Invalid Fluentd TLS settings: ", err)
+	}
+
+	if fluentdTag, _ = config.Setting("HABERDASHER_FLUENTD_TAG"); fluentdTag == "" {
+		fluentdTag = defaultFluentdTag
+	}
+	fluentdSharedKey, _ = config.Setting("HABERDASHER_FLUENTD_SHARED_KEY")
+	fluentdUsername, _ = config.Setting("HABERDASHER_FLUENTD_USERNAME")
+	fluentdPassword, _ = config.Setting("HABERDASHER_FLUENTD_PASSWORD")
+	if fluentdUsername != "" && fluentdSharedKey == "" {
+		log.Fatal("HABERDASHER_FLUENTD_USERNAME needs HABERDASHER_FLUENTD_SHARED_KEY")
+	}
+	if fluentdHostname, _ = config.Setting("HABERDASHER_FLUENTD_HOSTNAME"); fluentdHostname == "" {
+		fluentdHostname, _ = os.Hostname()
+	}
+
+	fluentdAck = config.FlagSetting("HABERDASHER_FLUENTD_ACK")
+	fluentdAckTimeout, _ = config.DurationSetting("HABERDASHER_FLUENTD_ACK_TIMEOUT")
+
+	var limits batch.Limits
+	limits.MaxBytes, _ = config.IntSetting("HABERDASHER_FLUENTD_BATCH_BYTES", 1)
+	limits.MaxWait, _ = config.DurationSetting("HABERDASHER_FLUENTD_FLUSH_INTERVAL")
+
+	// Connecting now shows up a bad shared key straight away, but a server
+	// which is briefly down shouldn't stop startup
+	fluentdLock.Lock()
+	if err := fluentdConnect(); err != nil {
+		log.Println("Warning: couldn't connect to Fluentd:", err)
+	}
+	fluentdLock.Unlock()
+
+	fluentdBatcher = batch.New(limits, nil, writeFluentdBatch)
+}
+
+// fluentdConnect (re)connects, doing the shared key handshake if configured.
+// The caller holds fluentdLock.
+func fluentdConnect() error {
+	fluentdDisconnect()
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if fluentdTLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", fluentdAddress, fluentdTLS)
+	} else {
+		conn, err = dialer.Dial("tcp", fluentdAddress)
+	}
+	if err != nil {
+		return err
+	}
+	decoder := msgpack.NewDecoder(conn)
+	if fluentdSharedKey != "" {
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := fluentdHandshake(conn, decoder); err != nil {
+			conn.Close()
+			return err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	fluentdConn, fluentdDecoder = conn, decoder
+	return nil
+}
+
+func fluentdDisconnect() {
+	if fluentdConn != nil {
+		fluentdConn.Close()
+		fluentdConn, fluentdDecoder = nil, nil
+	}
+}
+
+// fluentdHandshake answers the server's HELO with a PING proving we know the
+// shared key (and password), and checks its PONG proves it knows it too
+func fluentdHandshake(conn net.Conn, decoder *msgpack.Decoder) error {
+	helo, err := decoder.Decode()
+	if err != nil {
+		return fmt.Errorf("reading HELO: %v", err)
+	}
+	fields, ok := helo.([]interface{})
+	if !ok || len(fields) < 2 || fields[0] != "HELO" {
+		return errors.New("expected HELO from the server")
+	}
+	options, _ := fields[1].(map[string]interface{})
+	nonce, _ := options["nonce"].(string)
+	authSalt, _ := options["auth"].(string)
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	sharedKeySalt := base64.StdEncoding.EncodeToString(salt)
+	passwordDigest := ""
+	if authSalt != "" {
+		passwordDigest = sha512Hex(authSalt, fluentdUsername, fluentdPassword)
+	}
+	ping, err := msgpack.Append(nil, []interface{}{
+		"PING",
+		fluentdHostname,
+		sharedKeySalt,
+		sha512Hex(sharedKeySalt, fluentdHostname, nonce, fluentdSharedKey),
+		fluentdUsername,
+		passwordDigest,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(ping); err != nil {
+		return err
+	}
+
+	pong, err := decoder.Decode()
+	if err != nil {
+		return fmt.Errorf("reading PONG: %v", err)
+	}
+	fields, ok = pong.([]interface{})
+	if !ok || len(fields) < 5 || fields[0] != "PONG" {
+		return errors.New("expected PONG from the server")
+	}
+	if authenticated, _ := fields[1].(bool); !authenticated {
+		return fmt.Errorf("the server refused us: %v", fields[2])
+	}
+	serverHostname, _ := fields[3].(string)
+	if fields[4] != sha512Hex(sharedKeySalt, serverHostname, nonce, fluentdSharedKey) {
+		return errors.New("the server doesn't know the shared key")
+	}
+	return nil
+}
+
+func sha512Hex(parts ...string) string {
+	h := sha512.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// HandleLogMessage queues the log message as a [time, record] entry for the
+// next batch
+func (e fluentdEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &record); err != nil {
+		return err
+	}
+	timestamp := logging.Clock.Now()
+	if stamp, ok := record["@timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			timestamp = parsed
+		}
+	}
+	entry, err := msgpack.Append(nil, []interface{}{msgpack.EventTime(timestamp), record})
+	if err != nil {
+		return err
+	}
+	return fluentdBatcher.Add(entry)
+}
+
+// writeFluentdBatch sends a batch in Forward mode, [tag, entries, options],
+// reconnecting and sending it again if that fails
+func writeFluentdBatch(items [][]byte) error {
+	chunk := make([]byte, 16)
+	rand.Read(chunk)
+	chunkID := base64.StdEncoding.EncodeToString(chunk)
+
+	message := msgpack.AppendArrayHeader(nil, 3)
+	message = msgpack.AppendString(message, fluentdTag)
+	message = msgpack.AppendArrayHeader(message, len(items))
+	message = append(message, bytes.Join(items, nil)...)
+	options := map[string]interface{}{"size": len(items)}
+	if fluentdAck {
+		options["chunk"] = chunkID
+	}
+	message, err := msgpack.Append(message, options)
+	if err != nil {
+		return err
+	}
+
+	fluentdLock.Lock()
+	defer fluentdLock.Unlock()
+	for attempt := 1; ; attempt++ {
+		if err = fluentdSend(message, chunkID); err == nil || attempt >= fluentdAttempts {
+			return err
+		}
+		fluentdDisconnect()
+		log.Println("Error sending to Fluentd, retrying:", err)
+		logging.Clock.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+// fluentdSend writes a message and, if acknowledgments are on, waits for the
+// server to acknowledge its chunk. The caller holds fluentdLock.
+func fluentdSend(message []byte, chunkID string) error {
+	if fluentdConn == nil {
+		if err := fluentdConnect(); err != nil {
+			return err
+		}
+	}
+	if _, err := fluentdConn.Write(message); err != nil {
+		return err
+	}
+	if !fluentdAck {
+		return nil
+	}
+	fluentdConn.SetReadDeadline(time.Now().Add(fluentdAckTimeout))
+	defer fluentdConn.SetReadDeadline(time.Time{})
+	response, err := fluentdDecoder.Decode()
+	if err != nil {
+		return fmt.Errorf("waiting for acknowledgment: %v", err)
+	}
+	if fields, ok := response.(map[string]interface{}); !ok || fields["ack"] != chunkID {
+		return errors.New("the server acknowledged a different chunk")
+	}
+	return nil
+}
+
+// CheckHealth makes sure we can connect to the server
+func (e fluentdEmitter) CheckHealth() error {
+	fluentdLock.Lock()
+	defer fluentdLock.Unlock()
+	if fluentdConn != nil {
+		return nil
+	}
+	return fluentdConnect()
+}
+
+// Cleanup sends whatever is still waiting to be batched, and disconnects
+func (e fluentdEmitter) Cleanup() error {
+	fluentdBatcher.Close()
+	fluentdLock.Lock()
+	defer fluentdLock.Unlock()
+	fluentdDisconnect()
+	return nil
+}
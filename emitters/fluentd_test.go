@@ -0,0 +1,138 @@
+package emitters
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/RedHatInsights/haberdasher/msgpack"
+)
+
+// A fakeFluentd is a forward input doing the server's side of the shared key
+// handshake, as Fluentd's in_forward does
+type fakeFluentd struct {
+	listener  net.Listener
+	sharedKey string
+	hostname  string
+	// refuse is the reason given for refusing the client, if it should be
+	refuse string
+	// forged answers with a PONG digest made without the shared key
+	forged bool
+	// pings receives the client's PING
+	pings chan []interface{}
+	// done receives the error the server's side ended with, if any
+	done chan error
+}
+
+func startFakeFluentd(t *testing.T, sharedKey string) *fakeFluentd {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return &fakeFluentd{listener: listener, sharedKey: sharedKey, hostname: "fluentd-0", pings: make(chan []interface{}, 1), done: make(chan error, 1)}
+}
+
+func (f *fakeFluentd) serve() {
+	f.done <- f.handshake()
+}
+
+func (f *fakeFluentd) handshake() error {
+	conn, err := f.listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	const nonce = "server-nonce"
+	helo, _ := msgpack.Append(nil, []interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": "", "keepalive": true}})
+	if _, err := conn.Write(helo); err != nil {
+		return err
+	}
+	decoded, err := msgpack.NewDecoder(conn).Decode()
+	if err != nil {
+		return err
+	}
+	ping, ok := decoded.([]interface{})
+	if !ok || len(ping) != 6 || ping[0] != "PING" {
+		return errors.New("expected PING")
+	}
+	f.pings <- ping
+	hostname, _ := ping[1].(string)
+	salt, _ := ping[2].(string)
+	authenticated := f.refuse == "" && ping[3] == sha512Hex(salt, hostname, nonce, f.sharedKey)
+	reason := f.refuse
+	if !authenticated && reason == "" {
+		reason = "shared key mismatch"
+	}
+	key := f.sharedKey
+	if f.forged {
+		key = "not the key"
+	}
+	pong, _ := msgpack.Append(nil, []interface{}{"PONG", authenticated, reason, f.hostname, sha512Hex(salt, f.hostname, nonce, key)})
+	_, err = conn.Write(pong)
+	return err
+}
+
+func withFluentd(t *testing.T, address string, sharedKey string) {
+	address0, key0, hostname0, username0, password0 := fluentdAddress, fluentdSharedKey, fluentdHostname, fluentdUsername, fluentdPassword
+	t.Cleanup(func() {
+		fluentdLock.Lock()
+		fluentdDisconnect()
+		fluentdLock.Unlock()
+		fluentdAddress, fluentdSharedKey, fluentdHostname, fluentdUsername, fluentdPassword = address0, key0, hostname0, username0, password0
+	})
+	fluentdAddress, fluentdSharedKey, fluentdHostname = address, sharedKey, "app-0"
+	fluentdUsername, fluentdPassword = "", ""
+}
+
+func TestFluentdHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		clientKey string
+		refuse    string
+		forged    bool
+		wantErr   string
+	}{
+		{"shared key", "secret", "", false, ""},
+		{"wrong shared key", "guess", "", false, "the server refused us: shared key mismatch"},
+		{"refused", "secret", "no room", false, "the server refused us: no room"},
+		{"server without the key", "secret", "", true, "the server doesn't know the shared key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startFakeFluentd(t, "secret")
+			server.refuse, server.forged = test.refuse, test.forged
+			go server.serve()
+			withFluentd(t, server.listener.Addr().String(), test.clientKey)
+
+			fluentdLock.Lock()
+			err := fluentdConnect()
+			connected := fluentdConn != nil
+			fluentdLock.Unlock()
+			if serverErr := <-server.done; serverErr != nil {
+				t.Fatalf("server: %v", serverErr)
+			}
+
+			ping := <-server.pings
+			if ping[1] != "app-0" {
+				t.Errorf("PING gave the hostname %v", ping[1])
+			}
+			if ping[4] != "" || ping[5] != "" {
+				t.Errorf("PING sent a username and password digest without a username: %v, %v", ping[4], ping[5])
+			}
+			if test.wantErr == "" {
+				if err != nil || !connected {
+					t.Errorf("handshake failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got %v, want %q", err, test.wantErr)
+			}
+			if connected {
+				t.Error("kept a connection which failed the handshake")
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package emitters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/gcp"
+	"github.com/RedHatInsights/haberdasher/kube"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// Cloud Logging allows 10MB in an entries.write call, and recommends no more
+// than 1000 entries in one
+const (
+	gclMaxBatchBytes = 5 * 1024 * 1024
+	gclMaxEntries    = 1000
+)
+
+const (
+	defaultGCLEndpoint = "https://logging.googleapis.com"
+	defaultGCLLogName  = "haberdasher"
+	gclScope           = "https://www.googleapis.com/auth/logging.write"
+)
+
+// Severities by the levels logging.Severity normalizes to
+var gclSeverities = map[string]string{
+	"trace": "DEBUG",
+	"debug": "DEBUG",
+	"info":  "INFO",
+	"warn":  "WARNING",
+	"error": "ERROR",
+	"fatal": "CRITICAL",
+}
+
+var gclLogName, gclURL string
+var gclResource gclMonitoredResource
+var gclTokens *gcp.TokenSource
+var gclClient *http.Client
+var gclBalancer *endpoints.Balancer
+var gclBatcher *batch.Batcher
+var gclAttempts int
+
+// Entries get insert IDs, so Cloud Logging can drop the duplicates a retry of
+// a write which had in fact succeeded would make
+var gclInsertPrefix string
+var gclInsertCount uint64
+
+type gclMonitoredResource struct {
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type gclEntry struct {
+	InsertID    string                `json:"insertId"`
+	Timestamp   string                `json:"timestamp"`
+	Severity    string                `json:"severity"`
+	Labels      map[string]string     `json:"labels,omitempty"`
+	Resource    *gclMonitoredResource `json:"resource,omitempty"`
+	JSONPayload json.RawMessage       `json:"jsonPayload"`
+}
+
+type gclEmitter struct{}
+
+func init() {
+	var emitter gclEmitter
+	logging.Register("gcl", emitter)
+}
+
+// Setup configures the project, log, and monitored resource to write to, from
+// HABERDASHER_GCL_PROJECT, HABERDASHER_GCL_LOG_NAME, and
+// HABERDASHER_GCL_RESOURCE_TYPE and HABERDASHER_GCL_RESOURCE_LABELS, working
+// out what it can from where we're running
+func (e gclEmitter) Setup() {
+	project, _ := config.Setting("HABERDASHER_GCL_PROJECT")
+	if project == "" {
+		var err error
+		if project, err = gcp.ProjectID(); err != nil || project == "" {
+			log.Fatal("To use Haberdasher with Cloud Logging, HABERDASHER_GCL_PROJECT or GOOGLE_CLOUD_PROJECT must be set, unless running on Google Cloud")
+		}
+	}
+	logName, _ := config.Setting("HABERDASHER_GCL_LOG_NAME")
+	if logName == "" {
+		logName = defaultGCLLogName
+	}
+	gclLogName = "projects/" + project + "/logs/" + url.PathEscape(logName)
+	gclResource = detectGCLResource(project)
+
+	endpoint, _ := config.Setting("HABERDASHER_GCL_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultGCLEndpoint
+	}
+	gclURL = strings.TrimSuffix(endpoint, "/") + "/v2/entries:write"
+	var err error
+	if gclBalancer, err = endpoints.New([]string{gclURL}, endpoints.RoundRobin, ""); err != nil {
+		log.Fatal("Invalid HABERDASHER_GCL_ENDPOINT: ", err)
+	}
+	gclTokens = gcp.NewTokenSource(gclScope)
+	gclClient = endpoints.NewClient("HABERDASHER_GCL")
+
+	limits := batch.Limits{MaxBytes: gclMaxBatchBytes, MaxItems: gclMaxEntries}
+	limits.MaxWait, _ = config.DurationSetting("HABERDASHER_GCL_FLUSH_INTERVAL")
+	gclAttempts, _ = config.IntSetting("HABERDASHER_GCL_ATTEMPTS", 1)
+
+	random := make([]byte, 8)
+	rand.Read(random)
+	gclInsertPrefix = hex.EncodeToString(random)
+	gclBatcher = batch.New(limits, nil, writeGCLBatch)
+}
+
+// detectGCLResource works out the monitored resource: the type from
+// HABERDASHER_GCL_RESOURCE_TYPE, or k8s_container in a Kubernetes cluster,
+// gce_instance on Compute Engine, and global anywhere else, with the labels
+// of HABERDASHER_GCL_RESOURCE_LABELS over those which can be detected
+func detectGCLResource(project string) gclMonitoredResource {
+	var overrides map[string]string
+	if fromEnv, exists := config.Setting("HABERDASHER_GCL_RESOURCE_LABELS"); exists {
+		if err := json.Unmarshal([]byte(fromEnv), &overrides); err != nil {
+			log.Fatal("HABERDASHER_GCL_RESOURCE_LABELS must be a JSON object of strings")
+		}
+	}
+	resource := gclMonitoredResource{Labels: map[string]string{"project_id": project}}
+	resource.Type, _ = config.Setting("HABERDASHER_GCL_RESOURCE_TYPE")
+	if resource.Type == "" {
+		switch {
+		case os.Getenv("KUBERNETES_SERVICE_HOST") != "":
+			resource.Type = "k8s_container"
+		case metadataAvailable():
+			resource.Type = "gce_instance"
+		default:
+			resource.Type = "global"
+		}
+	}
+	switch resource.Type {
+	case "k8s_container":
+		hostname, _ := os.Hostname()
+		resource.Labels["location"], _ = gcp.Metadata("instance/attributes/cluster-location")
+		resource.Labels["cluster_name"], _ = gcp.Metadata("instance/attributes/cluster-name")
+		resource.Labels["namespace_name"] = kube.Namespace()
+		resource.Labels["pod_name"] = hostname
+	case "gce_instance":
+		resource.Labels["instance_id"], _ = gcp.Metadata("instance/id")
+		zone, _ := gcp.Metadata("instance/zone")
+		resource.Labels["zone"] = zone[strings.LastIndexByte(zone, '/')+1:]
+	}
+	for k, v := range overrides {
+		resource.Labels[k] = v
+	}
+	return resource
+}
+
+func metadataAvailable() bool {
+	_, err := gcp.Metadata("instance/id")
+	return err == nil
+}
+
+// HandleLogMessage queues the log message as an entry for the next batch,
+// with its severity and timestamp, and the labels it was sent with
+func (e gclEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	var level string
+	var labels map[string]string
+	timestamp := logging.Clock.Now()
+	switch m := jsonSerializeable.(type) {
+	case logging.Message:
+		level, labels, timestamp = m.Level, m.Labels, m.Timestamp
+	case map[string]interface{}:
+		level = logging.Severity(string(jsonBytes))
+		if stamp, ok := m["@timestamp"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				timestamp = parsed
+			}
+		}
+		if fromMessage, ok := m["labels"].(map[string]interface{}); ok {
+			labels = make(map[string]string, len(fromMessage))
+			for k, v := range fromMessage {
+				if s, ok := v.(string); ok {
+					labels[k] = s
+				}
+			}
+		}
+	}
+	severity, known := gclSeverities[level]
+	if !known {
+		severity = "DEFAULT"
+	}
+	entry, err := json.Marshal(gclEntry{
+		InsertID:    fmt.Sprintf("%s-%d", gclInsertPrefix, atomic.AddUint64(&gclInsertCount, 1)),
+		Timestamp:   timestamp.UTC().Format(time.RFC3339Nano),
+		Severity:    severity,
+		Labels:      labels,
+		Resource:    gclEntryResource(labels),
+		JSONPayload: jsonBytes,
+	})
+	if err != nil {
+		return err
+	}
+	return gclBatcher.Add(entry)
+}
+
+// gclEntryResource gives an entry its own resource if it's from another
+// pod's container, as the messages collected in DaemonSet mode are, or nil
+// to use the batch's
+func gclEntryResource(labels map[string]string) *gclMonitoredResource {
+	if gclResource.Type != "k8s_container" || labels["kubernetes_pod_name"] == "" {
+		return nil
+	}
+	resource := gclMonitoredResource{Type: gclResource.Type, Labels: make(map[string]string, len(gclResource.Labels))}
+	for k, v := range gclResource.Labels {
+		resource.Labels[k] = v
+	}
+	resource.Labels["namespace_name"] = labels["kubernetes_namespace"]
+	resource.Labels["pod_name"] = labels["kubernetes_pod_name"]
+	resource.Labels["container_name"] = labels["kubernetes_container_name"]
+	return &resource
+}
+
+// writeGCLBatch sends a batch in one entries.write call. With partialSuccess,
+// an entry Cloud Logging rejects doesn't take the rest of the batch with it.
+func writeGCLBatch(items [][]byte) error {
+	entries := make([]json.RawMessage, len(items))
+	for i, item := range items {
+		entries[i] = item
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"logName":        gclLogName,
+		"resource":       gclResource,
+		"entries":        entries,
+		"partialSuccess": true,
+	})
+	if err != nil {
+		return err
+	}
+	return gclBalancer.Retry("", gclAttempts, func(url string) error {
+		token, err := gclTokens.Token()
+		if err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer "+token.AccessToken)
+		response, err := gclClient.Do(request)
+		if err != nil {
+			return err
+		}
+		return endpoints.CheckResponse(url, response)
+	})
+}
+
+// CheckHealth makes sure we can get an access token
+func (e gclEmitter) CheckHealth() error {
+	_, err := gclTokens.Token()
+	return err
+}
+
+// Cleanup writes the last batch of entries to Cloud Logging
+func (e gclEmitter) Cleanup() error {
+	gclBatcher.Close()
+	return nil
+}
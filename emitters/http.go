@@ -0,0 +1,127 @@
+package emitters
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/aws"
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+var httpBalancer *endpoints.Balancer
+var httpClient *http.Client
+var httpBatcher *batch.Batcher
+var httpHeaders map[string]string
+
+// Set when requests are signed with SigV4, for AWS endpoints like OpenSearch
+// Serverless and API Gateway
+var httpAWSService, httpAWSRegion string
+var httpAWSCredentials *aws.Chain
+
+type httpEmitter struct{}
+
+func init() {
+	var emitter httpEmitter
+	logging.Register("http", emitter)
+}
+
+// Setup configures where batches are POSTed, from HABERDASHER_HTTP_URL and
+// the other settings shared by HTTP emitters, and how they're made up
+func (e httpEmitter) Setup() {
+	httpBalancer = endpoints.FromEnv("HABERDASHER_HTTP")
+	httpClient = endpoints.NewClient("HABERDASHER_HTTP")
+
+	var limits batch.Limits
+	limits.MaxItems, _ = config.IntSetting("HABERDASHER_HTTP_BATCH_SIZE", 1)
+	limits.MaxBytes, _ = config.IntSetting("HABERDASHER_HTTP_BATCH_BYTES", 1)
+	limits.MaxWait, _ = config.DurationSetting("HABERDASHER_HTTP_FLUSH_INTERVAL")
+
+	httpHeaders = make(map[string]string)
+	if fromEnv, exists := config.Setting("HABERDASHER_HTTP_HEADERS"); exists {
+		if err := json.Unmarshal([]byte(fromEnv), &httpHeaders); err != nil {
+			log.Fatal("HABERDASHER_HTTP_HEADERS must be a JSON object of strings")
+		}
+	}
+	if token, exists := config.Setting("HABERDASHER_HTTP_BEARER_TOKEN"); exists {
+		httpHeaders["Authorization"] = "Bearer " + token
+	}
+	if httpAWSService, _ = config.Setting("HABERDASHER_HTTP_AWS_SERVICE"); httpAWSService != "" {
+		if _, exists := httpHeaders["Authorization"]; exists {
+			log.Fatal("HABERDASHER_HTTP_AWS_SERVICE can't be used with HABERDASHER_HTTP_BEARER_TOKEN, or an Authorization header")
+		}
+		if tokenURL, _ := config.Setting("HABERDASHER_HTTP_OAUTH_TOKEN_URL"); tokenURL != "" {
+			log.Fatal("HABERDASHER_HTTP_AWS_SERVICE can't be used with HABERDASHER_HTTP_OAUTH_TOKEN_URL")
+		}
+		if httpAWSRegion, _ = config.Setting("HABERDASHER_HTTP_AWS_REGION"); httpAWSRegion == "" {
+			httpAWSRegion = aws.Region()
+		}
+		if httpAWSRegion == "" {
+			log.Fatal("To sign requests for ", httpAWSService, ", HABERDASHER_HTTP_AWS_REGION or AWS_REGION must be set")
+		}
+		httpAWSCredentials = aws.NewChain(httpAWSRegion)
+	}
+
+	httpBatcher = batch.New(limits, nil, writeHTTPBatch)
+}
+
+// writeHTTPBatch POSTs a batch as a JSON array, splitting it up first if
+// endpoints are picked by hashing a label
+func writeHTTPBatch(items [][]byte) error {
+	var firstErr error
+	for key, group := range httpBalancer.Group(items) {
+		body := append([]byte{'['}, bytes.Join(group, []byte{','})...)
+		body = append(body, ']')
+		err := httpBalancer.Do(key, func(url string) error {
+			return postHTTPBatch(url, body)
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func postHTTPBatch(url string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range httpHeaders {
+		request.Header.Set(name, value)
+	}
+	if httpAWSCredentials != nil {
+		// Signed afresh for every endpoint, since the host is part of it
+		creds, err := httpAWSCredentials.Get()
+		if err != nil {
+			return err
+		}
+		aws.Sign(request, body, creds, httpAWSRegion, httpAWSService, time.Now())
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	return endpoints.CheckResponse(url, response)
+}
+
+// HandleLogMessage queues the log message for the next batch
+func (e httpEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	return httpBatcher.Add(jsonBytes)
+}
+
+// Cleanup POSTs the last batch to the endpoint
+func (e httpEmitter) Cleanup() error {
+	httpBatcher.Close()
+	return nil
+}
@@ -0,0 +1,276 @@
+package emitters
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+const defaultJournaldSocket = "/run/systemd/journal/socket"
+
+// Journal field names may be at most this long
+const journaldMaxFieldName = 64
+
+// The journal's socket is shared by every message, and redialled after a
+// failed write
+var journaldLock sync.Mutex
+var journaldConn *net.UnixConn
+var journaldAddress *net.UnixAddr
+var journaldIdentifier string
+var journaldFields map[string]string
+
+type journaldEmitter struct{}
+
+func init() {
+	var emitter journaldEmitter
+	logging.Register("journald", emitter)
+}
+
+// Setup reads the journal's socket from HABERDASHER_JOURNALD_SOCKET, the
+// SYSLOG_IDENTIFIER from HABERDASHER_JOURNALD_IDENTIFIER, and fields to add to
+// every entry from HABERDASHER_JOURNALD_FIELDS
+func (e journaldEmitter) Setup() {
+	socket, _ := config.Setting("HABERDASHER_JOURNALD_SOCKET")
+	if socket == "" {
+		socket = defaultJournaldSocket
+	}
+	journaldAddress = &net.UnixAddr{Name: socket, Net: "unixgram"}
+	journaldIdentifier, _ = config.Setting("HABERDASHER_JOURNALD_IDENTIFIER")
+	if journaldIdentifier == "" {
+		journaldIdentifier = "haberdasher"
+	}
+
+	journaldFields = nil
+	if fromEnv, exists := config.Setting("HABERDASHER_JOURNALD_FIELDS"); exists {
+		var fields map[string]string
+		if err := json.Unmarshal([]byte(fromEnv), &fields); err != nil {
+			log.Fatal("HABERDASHER_JOURNALD_FIELDS must be a JSON object of strings")
+		}
+		journaldFields = make(map[string]string, len(fields))
+		for name, value := range fields {
+			field := journaldFieldName(name)
+			if field == "" {
+				log.Fatal("HABERDASHER_JOURNALD_FIELDS: invalid field name ", name)
+			}
+			journaldFields[field] = value
+		}
+	}
+
+	journaldLock.Lock()
+	defer journaldLock.Unlock()
+	if err := journaldDial(); err != nil {
+		log.Fatal("Couldn't connect to the journal at ", socket, ": ", err)
+	}
+}
+
+// journaldDial (re)connects to the journal. The caller holds journaldLock.
+func journaldDial() error {
+	if journaldConn != nil {
+		journaldConn.Close()
+		journaldConn = nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, journaldAddress)
+	if err != nil {
+		return err
+	}
+	journaldConn = conn
+	return nil
+}
+
+// HandleLogMessage writes the log message to the journal as an entry whose
+// MESSAGE is the message's text, with its PRIORITY from its level, and its
+// other fields, such as labels, as fields of their own
+func (e journaldEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	entry := journaldEntry(jsonBytes)
+
+	journaldLock.Lock()
+	defer journaldLock.Unlock()
+	// journald restarting leaves our socket connected to nothing, so each
+	// message gets one retry on a fresh one
+	for attempt := 0; ; attempt++ {
+		if journaldConn == nil {
+			if err = journaldDial(); err != nil {
+				return err
+			}
+		}
+		if err = journaldWrite(entry); err == nil || attempt > 0 {
+			return err
+		}
+		journaldConn.Close()
+		journaldConn = nil
+	}
+}
+
+// journaldWrite sends an entry, passing it in a file descriptor instead if
+// it's too big for a datagram, as sd_journal_send does. The caller holds
+// journaldLock.
+func journaldWrite(entry []byte) error {
+	_, err := journaldConn.Write(entry)
+	if !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return err
+	}
+	file, err := ioutil.TempFile("/dev/shm", "haberdasher-journal-")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	os.Remove(file.Name())
+	if _, err := file.Write(entry); err != nil {
+		return err
+	}
+	// WriteMsgUnix refuses connected datagram sockets, so this is sendmsg(2)
+	raw, err := journaldConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(file.Fd()))
+	var sendErr error
+	if err := raw.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, rights, nil, 0)
+		return sendErr != syscall.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// journaldEntry encodes a message for the journal's native protocol. Nested
+// objects are flattened, so labels.app is LABELS_APP.
+func journaldEntry(jsonBytes []byte) []byte {
+	fields := map[string]string{}
+	var decoded map[string]interface{}
+	if json.Unmarshal(jsonBytes, &decoded) == nil {
+		journaldFlatten(fields, "", decoded)
+	}
+	message, ok := decoded["message"].(string)
+	if !ok {
+		// Structured lines without a message field are the message whole
+		message = string(jsonBytes)
+	}
+	delete(fields, "MESSAGE")
+	priority, known := syslogSeverities[logging.Severity(string(jsonBytes))]
+	if !known {
+		priority = 6
+	}
+
+	var entry bytes.Buffer
+	journaldAppend(&entry, "MESSAGE", message)
+	journaldAppend(&entry, "PRIORITY", fmt.Sprint(priority))
+	journaldAppend(&entry, "SYSLOG_IDENTIFIER", journaldIdentifier)
+	reserved := map[string]bool{"MESSAGE": true, "PRIORITY": true, "SYSLOG_IDENTIFIER": true}
+	for _, extra := range []map[string]string{journaldFields, fields} {
+		names := make([]string, 0, len(extra))
+		for name := range extra {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if reserved[name] {
+				continue
+			}
+			reserved[name] = true
+			journaldAppend(&entry, name, extra[name])
+		}
+	}
+	return entry.Bytes()
+}
+
+func journaldFlatten(fields map[string]string, prefix string, object map[string]interface{}) {
+	for key, value := range object {
+		name := journaldFieldName(prefix + key)
+		if name == "" {
+			continue
+		}
+		switch v := value.(type) {
+		case nil:
+		case string:
+			if v != "" {
+				fields[name] = v
+			}
+		case map[string]interface{}:
+			journaldFlatten(fields, prefix+key+"_", v)
+		case []interface{}:
+			if len(v) > 0 {
+				encoded, _ := json.Marshal(v)
+				fields[name] = string(encoded)
+			}
+		default:
+			encoded, _ := json.Marshal(v)
+			fields[name] = string(encoded)
+		}
+	}
+}
+
+// journaldFieldName makes a field name valid: upper case letters, digits,
+// and underscores, not starting with an underscore (those are trusted fields
+// journald sets itself) or a digit. It returns "" if nothing is left.
+func journaldFieldName(name string) string {
+	field := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+	field = strings.TrimLeft(field, "_0123456789")
+	if len(field) > journaldMaxFieldName {
+		field = field[:journaldMaxFieldName]
+	}
+	return field
+}
+
+// journaldAppend appends a field, as NAME=value, or for values with newlines,
+// the name, a newline, and the value's little-endian 64-bit length before it
+func journaldAppend(entry *bytes.Buffer, name string, value string) {
+	entry.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		entry.WriteByte('=')
+		entry.WriteString(value)
+		entry.WriteByte('\n')
+		return
+	}
+	entry.WriteByte('\n')
+	binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+	entry.WriteString(value)
+	entry.WriteByte('\n')
+}
+
+// CheckHealth makes sure the journal's socket is there
+func (e journaldEmitter) CheckHealth() error {
+	journaldLock.Lock()
+	defer journaldLock.Unlock()
+	if journaldConn != nil {
+		return nil
+	}
+	return journaldDial()
+}
+
+func (e journaldEmitter) Cleanup() error {
+	journaldLock.Lock()
+	defer journaldLock.Unlock()
+	if journaldConn == nil {
+		return nil
+	}
+	err := journaldConn.Close()
+	journaldConn = nil
+	return err
+}
@@ -3,15 +3,29 @@ package emitters
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"log"
-	"os"
+	"math"
 	"strings"
+	"time"
 
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/config"
 	"github.com/RedHatInsights/haberdasher/logging"
 	"github.com/segmentio/kafka-go"
 )
 
+var kafkaCompression = map[string]kafka.Compression{
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
 var producer *kafka.Writer
+var dialer *kafka.Dialer
+var batcher *batch.Batcher
+var brokers []string
 var topic string
 
 type kafkaEmitter struct{}
@@ -24,21 +38,59 @@ func init() {
 // If the Kafka emitter is activated, create a new Producer and spawn a
 // goroutine to note any errors.
 func (e kafkaEmitter) Setup() {
-	bootstrapServers, exists := os.LookupEnv("HABERDASHER_KAFKA_BOOTSTRAP")
+	bootstrapServers, exists := config.Setting("HABERDASHER_KAFKA_BOOTSTRAP")
 	if !exists {
 		log.Fatal("To use Haberdasher with Kafka, HABERDASHER_KAFKA_BOOTSTRAP must be set to your bootstrap servers")
 	}
 
-	topic, exists = os.LookupEnv("HABERDASHER_KAFKA_TOPIC")
+	topic, exists = config.Setting("HABERDASHER_KAFKA_TOPIC")
 	if !exists {
 		log.Fatal("To use Haberdasher with Kafka, HABERDASHER_KAFKA_TOPIC must be set to your logging topic")
 	}
 
+	// Brokers refuse record batches larger than message.max.bytes, which
+	// defaults to just over 1MB, so that's the default here too
+	limits := batch.Limits{MaxWait: time.Second}
+	limits.MaxBytes, _ = config.IntSetting("HABERDASHER_KAFKA_BATCH_BYTES", 1)
+	limits.TargetLatency, _ = config.DurationOrZeroSetting("HABERDASHER_KAFKA_TARGET_LATENCY", "to always use the largest batches")
+
+	var err error
+	if dialer, err = kafkaDialer(); err != nil {
+		log.Fatal("Invalid Kafka authentication settings: ", err)
+	}
+
+	var estimator *batch.Estimator
+	brokers = strings.Split(bootstrapServers, ",")
 	producer = kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  strings.Split(bootstrapServers, ","),
+		Brokers:  brokers,
 		Topic:    topic,
 		Balancer: &kafka.LeastBytes{},
+		Dialer:   dialer,
 	})
+	if name, exists := config.Setting("HABERDASHER_KAFKA_COMPRESSION"); exists && name != "none" {
+		compression, known := kafkaCompression[name]
+		if !known {
+			log.Fatal("HABERDASHER_KAFKA_COMPRESSION must be one of none, gzip, snappy, lz4, or zstd")
+		}
+		producer.Compression = compression
+		codec := compression.Codec()
+		estimator = batch.NewEstimator(func(w io.Writer) io.WriteCloser { return codec.NewWriter(w) })
+	}
+
+	// We do the batching, so the writer should send each of our batches as
+	// soon as it gets it. It still splits them up by partition.
+	producer.BatchSize = math.MaxInt32
+	producer.BatchBytes = math.MaxInt32
+	producer.BatchTimeout = time.Millisecond
+	batcher = batch.New(limits, estimator, writeKafkaBatch)
+}
+
+func writeKafkaBatch(items [][]byte) error {
+	messages := make([]kafka.Message, len(items))
+	for i, item := range items {
+		messages[i] = kafka.Message{Value: item}
+	}
+	return producer.WriteMessages(context.Background(), messages...)
 }
 
 // HandleLogMessage ships the log message to Kafka
@@ -48,12 +100,28 @@ func (e kafkaEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
 		// The calling function prints out the actual failed message, just need to pass here
 		log.Println("Error in message formatting. Skipping.")
 	} else {
-		err = producer.WriteMessages(
-			context.Background(),
-			kafka.Message{
-				Value: jsonBytes,
-			},
-		)
+		err = batcher.Add(jsonBytes)
+	}
+	return err
+}
+
+// CheckHealth connects to the first reachable bootstrap server and makes sure
+// it knows about our topic
+func (e kafkaEmitter) CheckHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var err error
+	for _, broker := range brokers {
+		var conn *kafka.Conn
+		conn, err = dialer.DialContext(ctx, "tcp", broker)
+		if err != nil {
+			continue
+		}
+		_, err = conn.ReadPartitions(topic)
+		conn.Close()
+		if err == nil {
+			return nil
+		}
 	}
 	return err
 }
@@ -61,5 +129,6 @@ func (e kafkaEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
 // We don't want any buffered messages to get lost if we shut down, so we wait
 // to allow it to exit.
 func (e kafkaEmitter) Cleanup() error {
+	batcher.Close()
 	return producer.Close()
 }
@@ -0,0 +1,76 @@
+package emitters
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/aws"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/fips"
+	"github.com/RedHatInsights/haberdasher/tlsconfig"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaDialer builds how we connect to the brokers: optionally over TLS, and
+// optionally authenticating with SASL. HABERDASHER_KAFKA_SASL_MECHANISM is
+// PLAIN, SCRAM-SHA-256, or SCRAM-SHA-512, with HABERDASHER_KAFKA_SASL_USERNAME
+// and HABERDASHER_KAFKA_SASL_PASSWORD, or AWS_MSK_IAM, for Amazon MSK's IAM
+// authentication in HABERDASHER_KAFKA_AWS_REGION, or AWS_REGION, with no
+// static credentials needed.
+func kafkaDialer() (*kafka.Dialer, error) {
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second, DualStack: true}
+
+	tlsConfig, err := tlsconfig.FromEnv("HABERDASHER_KAFKA")
+	if err != nil {
+		return nil, err
+	}
+	dialer.TLS = tlsConfig
+
+	name, exists := config.Setting("HABERDASHER_KAFKA_SASL_MECHANISM")
+	if !exists {
+		return dialer, nil
+	}
+	name = strings.ToUpper(name)
+	if name == "AWS_MSK_IAM" {
+		region, _ := config.Setting("HABERDASHER_KAFKA_AWS_REGION")
+		if region == "" {
+			region = aws.Region()
+		}
+		if region == "" {
+			return nil, fmt.Errorf("MSK IAM authentication needs HABERDASHER_KAFKA_AWS_REGION or AWS_REGION")
+		}
+		if dialer.TLS == nil {
+			// MSK only offers IAM authentication over TLS
+			dialer.TLS = fips.TLS(&tls.Config{})
+		}
+		dialer.SASLMechanism = newMSKIAM(region)
+		return dialer, nil
+	}
+	if name != "PLAIN" && name != "SCRAM-SHA-256" && name != "SCRAM-SHA-512" {
+		return nil, fmt.Errorf("HABERDASHER_KAFKA_SASL_MECHANISM must be PLAIN, SCRAM-SHA-256, SCRAM-SHA-512, or AWS_MSK_IAM")
+	}
+	username, _ := config.Setting("HABERDASHER_KAFKA_SASL_USERNAME")
+	password, _ := config.Setting("HABERDASHER_KAFKA_SASL_PASSWORD")
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("SASL needs HABERDASHER_KAFKA_SASL_USERNAME and HABERDASHER_KAFKA_SASL_PASSWORD")
+	}
+	var mechanism sasl.Mechanism
+	switch name {
+	case "PLAIN":
+		mechanism = plain.Mechanism{Username: username, Password: password}
+	case "SCRAM-SHA-256":
+		mechanism, err = scram.Mechanism(scram.SHA256, username, password)
+	case "SCRAM-SHA-512":
+		mechanism, err = scram.Mechanism(scram.SHA512, username, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dialer.SASLMechanism = mechanism
+	return dialer, nil
+}
@@ -0,0 +1,73 @@
+package emitters
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/aws"
+	"github.com/RedHatInsights/haberdasher/buildinfo"
+	"github.com/segmentio/kafka-go/sasl"
+)
+
+// How long each MSK IAM token is valid. It's only needed for the handshake,
+// so every connection signs a new one.
+const mskTokenExpiry = 15 * time.Minute
+
+// mskIAM authenticates to Amazon MSK with IAM, over SASL/OAUTHBEARER, with a
+// token which is a presigned kafka-cluster:Connect request for the cluster's
+// region, with credentials from the standard chain, such as IRSA's web
+// identity or the instance's role
+type mskIAM struct {
+	region      string
+	credentials *aws.Chain
+}
+
+func newMSKIAM(region string) mskIAM {
+	return mskIAM{region: region, credentials: aws.NewChain(region)}
+}
+
+func (m mskIAM) Name() string {
+	return "OAUTHBEARER"
+}
+
+func (m mskIAM) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	token, err := m.token(time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	// RFC 7628's initial client response, with no authorization identity
+	return m, []byte("n,,\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+// Next handles the broker's reply, which is empty if it accepted us and
+// describes the problem if it didn't
+func (m mskIAM) Next(ctx context.Context, challenge []byte) (bool, []byte, error) {
+	if len(challenge) > 0 {
+		return false, nil, fmt.Errorf("MSK IAM authentication failed: %s", challenge)
+	}
+	return true, nil, nil
+}
+
+// token presigns the request the brokers check against the role's
+// kafka-cluster permissions, encoded as they expect
+func (m mskIAM) token(now time.Time) (string, error) {
+	creds, err := m.credentials.Get()
+	if err != nil {
+		return "", err
+	}
+	connect := &url.URL{
+		Scheme:   "https",
+		Host:     "kafka." + m.region + ".amazonaws.com",
+		Path:     "/",
+		RawQuery: url.Values{"Action": {"kafka-cluster:Connect"}}.Encode(),
+	}
+	signed := aws.Presign(connect, creds, m.region, "kafka-cluster", mskTokenExpiry, now)
+	// The user agent isn't signed, but the brokers log it
+	query := signed.Query()
+	query.Set("User-Agent", "haberdasher/"+buildinfo.Get().Version)
+	signed.RawQuery = query.Encode()
+	return base64.RawURLEncoding.EncodeToString([]byte(signed.String())), nil
+}
@@ -0,0 +1,143 @@
+package emitters
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	reference "github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// The SHA-256 of an empty payload, which is what the token's GET signs
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func withAWSCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "session/token+1")
+}
+
+// decodeMSKToken returns the presigned URL in a token, without the user agent
+// added after signing
+func decodeMSKToken(t *testing.T, token string) (*url.URL, string) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		t.Fatalf("token %q isn't unpadded base64url: %v", token, err)
+	}
+	signed, err := url.Parse(string(decoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := signed.Query()
+	userAgent := query.Get("User-Agent")
+	query.Del("User-Agent")
+	signed.RawQuery = query.Encode()
+	return signed, userAgent
+}
+
+// The token is the kafka-cluster:Connect request the AWS SDK would presign,
+// as Amazon's aws-msk-iam-sasl-signer does
+func TestMSKIAMToken(t *testing.T) {
+	withAWSCredentials(t)
+	now := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	token, err := newMSKIAM("eu-west-1").token(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, userAgent := decodeMSKToken(t, token)
+	if !strings.HasPrefix(userAgent, "haberdasher/") {
+		t.Errorf("the token's user agent is %q", userAgent)
+	}
+
+	request, err := http.NewRequest(http.MethodGet, "https://kafka.eu-west-1.amazonaws.com/?Action=kafka-cluster%3AConnect&X-Amz-Expires=900", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := reference.Credentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		SessionToken:    "session/token+1",
+	}
+	presigned, _, err := v4.NewSigner().PresignHTTP(context.Background(), credentials, request, emptyPayloadHash, "kafka-cluster", "eu-west-1", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Scheme != want.Scheme || got.Host != want.Host || got.Path != want.Path {
+		t.Errorf("token for %s://%s%s, want %s://%s%s", got.Scheme, got.Host, got.Path, want.Scheme, want.Host, want.Path)
+	}
+	gotQuery, wantQuery := got.Query(), want.Query()
+	for key := range wantQuery {
+		if gotQuery.Get(key) != wantQuery.Get(key) {
+			t.Errorf("%s is %q, want %q", key, gotQuery.Get(key), wantQuery.Get(key))
+		}
+	}
+	for key := range gotQuery {
+		if _, expected := wantQuery[key]; !expected {
+			t.Errorf("the token has %s as well", key)
+		}
+	}
+}
+
+// Every connection presents a fresh token in RFC 7628's initial client
+// response, and the broker's reply is empty unless it refused us
+func TestMSKIAMHandshake(t *testing.T) {
+	withAWSCredentials(t)
+	m := newMSKIAM("eu-west-1")
+	if m.Name() != "OAUTHBEARER" {
+		t.Errorf("mechanism %q", m.Name())
+	}
+	state, response, err := m.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	initial := string(response)
+	if !strings.HasPrefix(initial, "n,,\x01auth=Bearer ") || !strings.HasSuffix(initial, "\x01\x01") {
+		t.Fatalf("initial response %q", initial)
+	}
+	signed, _ := decodeMSKToken(t, strings.TrimSuffix(strings.TrimPrefix(initial, "n,,\x01auth=Bearer "), "\x01\x01"))
+	if action := signed.Query().Get("Action"); action != "kafka-cluster:Connect" {
+		t.Errorf("the token is for %q", action)
+	}
+
+	if done, _, err := state.Next(context.Background(), nil); !done || err != nil {
+		t.Errorf("an empty reply gave %v, %v", done, err)
+	}
+	refusal := `{"status":"invalid_token"}`
+	if _, _, err := state.Next(context.Background(), []byte(refusal)); err == nil || !strings.Contains(err.Error(), refusal) {
+		t.Errorf("a refusal gave %v", err)
+	}
+}
+
+func TestKafkaDialerMSKIAM(t *testing.T) {
+	withAWSCredentials(t)
+	t.Setenv("HABERDASHER_KAFKA_SASL_MECHANISM", "aws_msk_iam")
+	for _, env := range []string{"HABERDASHER_KAFKA_AWS_REGION", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+	if _, err := kafkaDialer(); err == nil || !strings.Contains(err.Error(), "AWS_REGION") {
+		t.Errorf("without a region, returned %v", err)
+	}
+
+	t.Setenv("AWS_REGION", "ap-south-1")
+	dialer, err := kafkaDialer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := dialer.SASLMechanism.(mskIAM); !ok || m.region != "ap-south-1" {
+		t.Errorf("authenticating with %#v", dialer.SASLMechanism)
+	}
+	if dialer.TLS == nil {
+		t.Error("authenticating without TLS")
+	}
+}
@@ -0,0 +1,209 @@
+package emitters
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+var lokiBalancer *endpoints.Balancer
+var lokiClient *http.Client
+var lokiBatcher *batch.Batcher
+var lokiLabels map[string]string
+var lokiPromoted []string
+var lokiAttempts int
+var lokiTenant string
+
+// A lokiEntry is one line of a stream, as queued for the next batch. Hashing
+// endpoints by label hashes its stream labels.
+type lokiEntry struct {
+	Stream map[string]string `json:"labels"`
+	Value  [2]string         `json:"value"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiEmitter struct{}
+
+func init() {
+	var emitter lokiEmitter
+	logging.Register("loki", emitter)
+}
+
+// Setup configures where to push, from HABERDASHER_LOKI_URL (e.g.
+// http://loki:3100/loki/api/v1/push) and the other settings shared by HTTP
+// emitters, and which streams messages go to
+func (e lokiEmitter) Setup() {
+	lokiBalancer = endpoints.FromEnv("HABERDASHER_LOKI")
+	lokiClient = endpoints.NewClient("HABERDASHER_LOKI")
+
+	lokiLabels = map[string]string{"job": "haberdasher"}
+	if fromEnv, exists := config.Setting("HABERDASHER_LOKI_LABELS"); exists {
+		lokiLabels = nil
+		if err := json.Unmarshal([]byte(fromEnv), &lokiLabels); err != nil || len(lokiLabels) == 0 {
+			log.Fatal("HABERDASHER_LOKI_LABELS must be a JSON object of strings, with at least one label")
+		}
+	}
+	lokiPromoted = nil
+	promoted, _ := config.Setting("HABERDASHER_LOKI_STREAM_LABELS")
+	for _, label := range strings.Split(promoted, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			lokiPromoted = append(lokiPromoted, label)
+		}
+	}
+	lokiTenant, _ = config.Setting("HABERDASHER_LOKI_TENANT")
+
+	var limits batch.Limits
+	limits.MaxBytes, _ = config.IntSetting("HABERDASHER_LOKI_BATCH_BYTES", 1)
+	limits.MaxWait, _ = config.DurationSetting("HABERDASHER_LOKI_FLUSH_INTERVAL")
+	lokiAttempts, _ = config.IntSetting("HABERDASHER_LOKI_ATTEMPTS", 1)
+
+	lokiBatcher = batch.New(limits, nil, writeLokiBatch)
+}
+
+// HandleLogMessage queues the log message for the next push. Its stream is
+// the configured labels plus any promoted from the message's own labels.
+func (e lokiEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	timestamp := logging.Clock.Now()
+	var labels map[string]string
+	switch m := jsonSerializeable.(type) {
+	case logging.Message:
+		timestamp, labels = m.Timestamp, m.Labels
+	case map[string]interface{}:
+		if stamp, ok := m["@timestamp"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				timestamp = parsed
+			}
+		}
+		if fields, ok := m["labels"].(map[string]interface{}); ok {
+			labels = make(map[string]string, len(fields))
+			for k, v := range fields {
+				if s, ok := v.(string); ok {
+					labels[k] = s
+				}
+			}
+		}
+	}
+
+	stream := lokiLabels
+	if len(lokiPromoted) > 0 {
+		stream = make(map[string]string, len(lokiLabels)+len(lokiPromoted))
+		for k, v := range lokiLabels {
+			stream[k] = v
+		}
+		for _, label := range lokiPromoted {
+			if value, ok := labels[label]; ok && value != "" {
+				stream[label] = value
+			}
+		}
+	}
+
+	entry, err := json.Marshal(lokiEntry{
+		Stream: stream,
+		Value:  [2]string{strconv.FormatInt(timestamp.UnixNano(), 10), string(jsonBytes)},
+	})
+	if err != nil {
+		return err
+	}
+	return lokiBatcher.Add(entry)
+}
+
+// writeLokiBatch pushes a batch, grouping its lines into their streams
+func writeLokiBatch(items [][]byte) error {
+	var firstErr error
+	for key, group := range lokiBalancer.Group(items) {
+		body, err := lokiPushBody(group)
+		if err != nil {
+			return err
+		}
+		err = lokiBalancer.Retry(key, lokiAttempts, func(url string) error {
+			return postLokiBatch(url, body)
+		})
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func lokiPushBody(items [][]byte) ([]byte, error) {
+	streams := make(map[string]*lokiStream)
+	var order []string
+	for _, item := range items {
+		var entry lokiEntry
+		if err := json.Unmarshal(item, &entry); err != nil {
+			return nil, err
+		}
+		key := lokiStreamKey(entry.Stream)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: entry.Stream}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.Values = append(stream.Values, entry.Value)
+	}
+	push := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range order {
+		push.Streams = append(push.Streams, streams[key])
+	}
+	return json.Marshal(push)
+}
+
+// lokiStreamKey identifies a stream by its sorted labels
+func lokiStreamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var key strings.Builder
+	for _, k := range keys {
+		key.WriteString(k)
+		key.WriteByte(0)
+		key.WriteString(labels[k])
+		key.WriteByte(0)
+	}
+	return key.String()
+}
+
+func postLokiBatch(url string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if lokiTenant != "" {
+		request.Header.Set("X-Scope-OrgID", lokiTenant)
+	}
+	response, err := lokiClient.Do(request)
+	if err != nil {
+		return err
+	}
+	return endpoints.CheckResponse(url, response)
+}
+
+// Cleanup pushes whatever is still waiting to be batched
+func (e lokiEmitter) Cleanup() error {
+	lokiBatcher.Close()
+	return nil
+}
@@ -0,0 +1,184 @@
+package emitters
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// A fakeLoki answers pushes with the statuses it's given, the last of them
+// repeating, and keeps what was pushed
+type fakeLoki struct {
+	lock     sync.Mutex
+	statuses []int
+	pushes   []lokiPush
+	tenants  []string
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+func startFakeLoki(t *testing.T, statuses ...int) (*fakeLoki, string) {
+	loki := &fakeLoki{statuses: statuses}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loki.lock.Lock()
+		defer loki.lock.Unlock()
+		var push lokiPush
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&push) != nil {
+			http.Error(w, "not a JSON push", http.StatusBadRequest)
+			return
+		}
+		loki.pushes = append(loki.pushes, push)
+		loki.tenants = append(loki.tenants, r.Header.Get("X-Scope-OrgID"))
+		status := loki.statuses[0]
+		if len(loki.statuses) > 1 {
+			loki.statuses = loki.statuses[1:]
+		}
+		if status != http.StatusNoContent {
+			http.Error(w, "ingester unhappy", status)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return loki, server.URL + "/loki/api/v1/push"
+}
+
+// withLoki pushes to url, batching every n messages, retrying with a clock
+// which doesn't wait
+func withLoki(t *testing.T, url string, n int, promoted []string, tenant string) {
+	balancer0, client0, batcher0, labels0, promoted0, attempts0, tenant0 := lokiBalancer, lokiClient, lokiBatcher, lokiLabels, lokiPromoted, lokiAttempts, lokiTenant
+	t.Cleanup(func() {
+		lokiBatcher.Close()
+		lokiBalancer, lokiClient, lokiBatcher, lokiLabels, lokiPromoted, lokiAttempts, lokiTenant = balancer0, client0, batcher0, labels0, promoted0, attempts0, tenant0
+		log.SetOutput(os.Stderr)
+	})
+	log.SetOutput(ioutil.Discard)
+	var err error
+	if lokiBalancer, err = endpoints.New([]string{url}, endpoints.RoundRobin, ""); err != nil {
+		t.Fatal(err)
+	}
+	lokiBalancer.SetClock(noWait{clock.NewFake(time.Unix(0, 0))})
+	lokiClient = &http.Client{Timeout: 10 * time.Second}
+	lokiBatcher = batch.New(batch.Limits{MaxBytes: 1 << 20, MaxItems: n, MaxWait: time.Hour}, nil, writeLokiBatch)
+	lokiLabels = map[string]string{"job": "haberdasher"}
+	lokiPromoted, lokiAttempts, lokiTenant = promoted, 3, tenant
+}
+
+// noWait is a fake clock whose Sleep moves it on rather than waiting
+type noWait struct {
+	*clock.Fake
+}
+
+func (c noWait) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// handleAll hands the messages to an emitter at once, so they're batched
+// together
+func handleAll(emitter logging.Emitter, messages ...interface{}) []error {
+	errs := make([]error, len(messages))
+	var wait sync.WaitGroup
+	for i, message := range messages {
+		wait.Add(1)
+		go func(i int, message interface{}) {
+			defer wait.Done()
+			errs[i] = emitter.HandleLogMessage(message)
+		}(i, message)
+	}
+	wait.Wait()
+	return errs
+}
+
+// Lines are pushed to streams labelled with the job, plus the labels promoted
+// from each message
+func TestLokiPush(t *testing.T) {
+	loki, url := startFakeLoki(t, http.StatusNoContent)
+	withLoki(t, url, 3, []string{"tenant"}, "team-a")
+	stamp := time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)
+	messages := []interface{}{
+		logging.Message{Timestamp: stamp, Labels: map[string]string{"tenant": "acme"}, Message: "one"},
+		logging.Message{Timestamp: stamp.Add(time.Second), Labels: map[string]string{"tenant": "globex"}, Message: "two"},
+		map[string]interface{}{"@timestamp": stamp.Add(2 * time.Second).Format(time.RFC3339Nano), "labels": map[string]interface{}{"tenant": "acme"}, "message": "three"},
+	}
+	for _, err := range handleAll(lokiEmitter{}, messages...) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(loki.pushes) != 1 || loki.tenants[0] != "team-a" {
+		t.Fatalf("pushed %+v for tenants %q, want one push for team-a", loki.pushes, loki.tenants)
+	}
+	streams := make(map[string][]string)
+	for _, stream := range loki.pushes[0].Streams {
+		if stream.Stream["job"] != "haberdasher" || len(stream.Stream) != 2 {
+			t.Errorf("stream labelled %v", stream.Stream)
+		}
+		for _, value := range stream.Values {
+			var line map[string]interface{}
+			if err := json.Unmarshal([]byte(value[1]), &line); err != nil {
+				t.Fatal(err)
+			}
+			streams[stream.Stream["tenant"]] = append(streams[stream.Stream["tenant"]], value[0]+" "+line["message"].(string))
+		}
+	}
+	for _, values := range streams {
+		if len(values) > 1 && values[0] > values[1] {
+			values[0], values[1] = values[1], values[0]
+		}
+	}
+	want := map[string][]string{
+		"acme":   {"1614834367000000008 one", "1614834369000000008 three"},
+		"globex": {"1614834368000000008 two"},
+	}
+	if !reflect.DeepEqual(streams, want) {
+		t.Errorf("pushed streams %q, want %q", streams, want)
+	}
+}
+
+func TestLokiRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		wantPushes int
+		wantErr    string
+	}{
+		{"overloaded, then accepted", []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusNoContent}, 3, ""},
+		{"keeps failing", []int{http.StatusBadGateway}, 3, "502 Bad Gateway ingester unhappy"},
+		{"rejected", []int{http.StatusBadRequest}, 1, "400 Bad Request ingester unhappy"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loki, url := startFakeLoki(t, test.statuses...)
+			withLoki(t, url, 1, nil, "")
+			err := lokiEmitter{}.HandleLogMessage(logging.Message{Timestamp: time.Unix(1, 0), Message: "hi"})
+			if test.wantErr == "" && err != nil {
+				t.Errorf("returned %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.wantErr)
+			}
+			if len(loki.pushes) != test.wantPushes {
+				t.Errorf("pushed %d times, want %d", len(loki.pushes), test.wantPushes)
+			}
+			if loki.tenants[0] != "" {
+				t.Errorf("sent X-Scope-OrgID %q without a tenant", loki.tenants[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package emitters
+
+/*
+#include <os/log.h>
+#include <stdlib.h>
+
+// os_log_with_type is a macro which needs a literal format. Marking the
+// message public keeps it from being shown as <private>.
+static void haberdasher_os_log(os_log_t log, os_log_type_t type, const char *message) {
+	os_log_with_type(log, type, "%{public}s", message);
+}
+*/
+import "C"
+
+import (
+	"encoding/json"
+	"os"
+	"unsafe"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+const (
+	defaultOSLogSubsystem = "com.redhat.haberdasher"
+	defaultOSLogCategory  = "default"
+)
+
+// Our normalized levels as os_log types. Unleveled lines and warnings are
+// "default", since os_log has no warning type.
+var oslogTypes = map[string]C.os_log_type_t{
+	"trace": C.OS_LOG_TYPE_DEBUG,
+	"debug": C.OS_LOG_TYPE_DEBUG,
+	"info":  C.OS_LOG_TYPE_INFO,
+	"warn":  C.OS_LOG_TYPE_DEFAULT,
+	"error": C.OS_LOG_TYPE_ERROR,
+	"fatal": C.OS_LOG_TYPE_FAULT,
+}
+
+// Log objects live for the life of the process, so ours is never released
+var oslogHandle C.os_log_t
+
+type oslogEmitter struct{}
+
+func init() {
+	var emitter oslogEmitter
+	logging.Register("oslog", emitter)
+}
+
+// Setup creates the log object for HABERDASHER_OSLOG_SUBSYSTEM and
+// HABERDASHER_OSLOG_CATEGORY
+func (e oslogEmitter) Setup() {
+	subsystem, _ := config.Setting("HABERDASHER_OSLOG_SUBSYSTEM")
+	if subsystem == "" {
+		subsystem = defaultOSLogSubsystem
+	}
+	category, _ := config.Setting("HABERDASHER_OSLOG_CATEGORY")
+	if category == "" {
+		category = defaultOSLogCategory
+	}
+	cSubsystem, cCategory := C.CString(subsystem), C.CString(category)
+	defer C.free(unsafe.Pointer(cSubsystem))
+	defer C.free(unsafe.Pointer(cCategory))
+	oslogHandle = C.os_log_create(cSubsystem, cCategory)
+}
+
+// HandleLogMessage logs the JSON document, with its type from the message's
+// level
+func (e oslogEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	level := logging.Severity(string(jsonBytes))
+	if m, ok := jsonSerializeable.(logging.Message); ok {
+		level = m.Level
+	}
+	logType, known := oslogTypes[level]
+	if !known {
+		logType = C.OS_LOG_TYPE_DEFAULT
+	}
+	message := C.CString(string(jsonBytes))
+	defer C.free(unsafe.Pointer(message))
+	C.haberdasher_os_log(oslogHandle, logType, message)
+	return nil
+}
+
+// Cleanup does nothing, since os_log writes synchronously
+func (e oslogEmitter) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build darwin && !cgo
+// +build darwin,!cgo
+
+package emitters
+
+import (
+	"errors"
+	"log"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// os_log is only reachable through the C API, so without cgo the oslog
+// emitter can only say so
+type oslogEmitter struct{}
+
+func init() {
+	var emitter oslogEmitter
+	logging.Register("oslog", emitter)
+}
+
+func (e oslogEmitter) Setup() {
+	log.Fatal("The oslog emitter needs haberdasher built with cgo (CGO_ENABLED=1)")
+}
+
+func (e oslogEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	return errors.New("the oslog emitter needs cgo")
+}
+
+func (e oslogEmitter) Cleanup() error {
+	return nil
+}
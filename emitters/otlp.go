@@ -0,0 +1,584 @@
+package emitters
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/buildinfo"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/fips"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/protobuf"
+	"github.com/RedHatInsights/haberdasher/tlsconfig"
+)
+
+const (
+	otlpGRPC          = "grpc"
+	otlpHTTPProtobuf  = "http/protobuf"
+	otlpHTTPJSON      = "http/json"
+	otlpGRPCPath      = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+	otlpHTTPPath      = "/v1/logs"
+	defaultOTLPGRPC   = "http://localhost:4317"
+	defaultOTLPHTTP   = "http://localhost:4318"
+	otlpScopeName     = "haberdasher"
+	otlpUnknownSource = "unknown_service:haberdasher"
+)
+
+// Severity numbers and text by the levels logging.Severity normalizes to
+var otlpSeverities = map[string]struct {
+	number int
+	text   string
+}{
+	"trace": {1, "TRACE"},
+	"debug": {5, "DEBUG"},
+	"info":  {9, "INFO"},
+	"warn":  {13, "WARN"},
+	"error": {17, "ERROR"},
+	"fatal": {21, "FATAL"},
+}
+
+// gRPC status codes the OTLP specification says may succeed if retried
+var otlpRetryableCodes = map[string]bool{"1": true, "4": true, "8": true, "10": true, "11": true, "14": true, "15": true}
+
+var otlpProtocol, otlpURL string
+var otlpHeaders map[string]string
+var otlpGzip bool
+var otlpResource []otlpKeyValue
+var otlpClient *http.Client
+var otlpBalancer *endpoints.Balancer
+var otlpBatcher *batch.Batcher
+var otlpAttempts int
+
+// An otlpValue is an AnyValue, which holds one of its fields. Its JSON is
+// OTLP's JSON encoding, and appendProto its protobuf encoding.
+type otlpValue struct {
+	StringValue *string        `json:"stringValue,omitempty"`
+	BoolValue   *bool          `json:"boolValue,omitempty"`
+	IntValue    *otlpInt       `json:"intValue,omitempty"`
+	DoubleValue *float64       `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArray     `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKeyValues `json:"kvlistValue,omitempty"`
+}
+
+type otlpArray struct {
+	Values []otlpValue `json:"values"`
+}
+
+type otlpKeyValues struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// 64 bit integers are strings in OTLP's JSON
+type otlpInt int64
+
+func (i otlpInt) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatInt(int64(i), 10) + `"`), nil
+}
+
+type otlpRecord struct {
+	TimeUnixNano         otlpInt        `json:"timeUnixNano"`
+	ObservedTimeUnixNano otlpInt        `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 otlpValue      `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpEmitter struct{}
+
+func init() {
+	var emitter otlpEmitter
+	logging.Register("otlp", emitter)
+}
+
+// otelEnv reads one of the OTLP exporter's settings, preferring the
+// logs-specific OTEL_EXPORTER_OTLP_LOGS_<name> to OTEL_EXPORTER_OTLP_<name>
+func otelEnv(name string) string {
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_" + name); value != "" {
+		return value
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// Setup configures the exporter from the standard OTEL_* environment
+// variables, and batching from HABERDASHER_OTLP_*
+func (e otlpEmitter) Setup() {
+	if otlpProtocol = otelEnv("PROTOCOL"); otlpProtocol == "" {
+		otlpProtocol = otlpHTTPProtobuf
+	}
+	if otlpProtocol != otlpGRPC && otlpProtocol != otlpHTTPProtobuf && otlpProtocol != otlpHTTPJSON {
+		log.Fatal("OTEL_EXPORTER_OTLP_PROTOCOL must be grpc, http/protobuf, or http/json")
+	}
+	otlpURL = otlpEndpoint()
+	var err error
+	if otlpBalancer, err = endpoints.New([]string{otlpURL}, endpoints.RoundRobin, ""); err != nil {
+		log.Fatal("Invalid OTEL_EXPORTER_OTLP_ENDPOINT: ", err)
+	}
+	if otlpHeaders, err = parseOTELList(otelEnv("HEADERS")); err != nil {
+		log.Fatal("OTEL_EXPORTER_OTLP_HEADERS must be a comma separated list of key=value pairs")
+	}
+	otlpGzip = false
+	switch otelEnv("COMPRESSION") {
+	case "", "none":
+	case "gzip":
+		otlpGzip = true
+	default:
+		log.Fatal("OTEL_EXPORTER_OTLP_COMPRESSION must be gzip or none")
+	}
+	otlpResource = otlpResourceAttributes()
+
+	otlpClient = endpoints.NewClient("HABERDASHER_OTLP")
+	if timeout := otelEnv("TIMEOUT"); timeout != "" {
+		milliseconds, err := strconv.Atoi(timeout)
+		if err != nil || milliseconds <= 0 {
+			log.Fatal("OTEL_EXPORTER_OTLP_TIMEOUT must be a positive number of milliseconds")
+		}
+		otlpClient.Timeout = time.Duration(milliseconds) * time.Millisecond
+	}
+	if otlpProtocol == otlpGRPC {
+		otlpClient.Transport = otlpGRPCTransport(strings.HasPrefix(otlpURL, "https:"))
+	}
+
+	var limits batch.Limits
+	limits.MaxBytes, _ = config.IntSetting("HABERDASHER_OTLP_BATCH_BYTES", 1)
+	limits.MaxWait, _ = config.DurationSetting("HABERDASHER_OTLP_FLUSH_INTERVAL")
+	otlpAttempts, _ = config.IntSetting("HABERDASHER_OTLP_ATTEMPTS", 1)
+	otlpBatcher = batch.New(limits, nil, writeOTLPBatch)
+}
+
+// otlpEndpoint works out the URL to export to. The logs-specific endpoint is
+// used as it is, while /v1/logs is added to the general one for HTTP. gRPC
+// endpoints only need a host and port, and are insecure without a scheme only
+// if OTEL_EXPORTER_OTLP_INSECURE says so.
+func otlpEndpoint() string {
+	endpoint, specific := os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT"), true
+	if endpoint == "" {
+		endpoint, specific = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), false
+	}
+	if endpoint == "" {
+		endpoint = defaultOTLPHTTP
+		if otlpProtocol == otlpGRPC {
+			endpoint = defaultOTLPGRPC
+		}
+	}
+	if otlpProtocol != otlpGRPC {
+		if specific {
+			return endpoint
+		}
+		return strings.TrimSuffix(endpoint, "/") + otlpHTTPPath
+	}
+	if !strings.Contains(endpoint, "://") {
+		if strings.EqualFold(otelEnv("INSECURE"), "true") {
+			endpoint = "http://" + endpoint
+		} else {
+			endpoint = "https://" + endpoint
+		}
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		log.Fatal("OTEL_EXPORTER_OTLP_ENDPOINT must be a URL, like http://collector:4317")
+	}
+	return parsed.Scheme + "://" + parsed.Host + otlpGRPCPath
+}
+
+// otlpGRPCTransport speaks HTTP/2 for gRPC, without TLS too (h2c)
+func otlpGRPCTransport(secure bool) http.RoundTripper {
+	if secure {
+		tlsConfig, err := tlsconfig.FromEnv("HABERDASHER_OTLP")
+		if err != nil {
+			log.Fatal("Invalid HABERDASHER_OTLP TLS settings: ", err)
+		}
+		return &http2.Transport{TLSClientConfig: fips.TLS(tlsConfig)}
+	}
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, 30*time.Second)
+		},
+	}
+}
+
+// parseOTELList parses the key=value,key=value lists of OTEL_RESOURCE_ATTRIBUTES
+// and OTEL_EXPORTER_OTLP_HEADERS, whose values may be percent-encoded
+func parseOTELList(list string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		i := strings.IndexByte(pair, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%q isn't key=value", pair)
+		}
+		value, err := url.PathUnescape(strings.TrimSpace(pair[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		values[strings.TrimSpace(pair[:i])] = value
+	}
+	return values, nil
+}
+
+// otlpResourceAttributes describes where the logs come from, from
+// OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME, with host.name the hostname
+// unless they say otherwise
+func otlpResourceAttributes() []otlpKeyValue {
+	attributes, err := parseOTELList(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	if err != nil {
+		log.Fatal("OTEL_RESOURCE_ATTRIBUTES must be a comma separated list of key=value pairs")
+	}
+	if service := os.Getenv("OTEL_SERVICE_NAME"); service != "" {
+		attributes["service.name"] = service
+	} else if attributes["service.name"] == "" {
+		attributes["service.name"] = otlpUnknownSource
+	}
+	if attributes["host.name"] == "" {
+		attributes["host.name"], _ = os.Hostname()
+	}
+	fields := make(map[string]interface{}, len(attributes))
+	for k, v := range attributes {
+		fields[k] = v
+	}
+	return otlpKeyValueList(fields)
+}
+
+// otlpAnyValue converts a value decoded from JSON
+func otlpAnyValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case float64:
+		// JSON numbers are all floats once decoded, but most are integers
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			i := otlpInt(v)
+			return otlpValue{IntValue: &i}
+		}
+		return otlpValue{DoubleValue: &v}
+	case []interface{}:
+		array := &otlpArray{Values: make([]otlpValue, len(v))}
+		for i, item := range v {
+			array.Values[i] = otlpAnyValue(item)
+		}
+		return otlpValue{ArrayValue: array}
+	case map[string]interface{}:
+		return otlpValue{KvlistValue: &otlpKeyValues{Values: otlpKeyValueList(v)}}
+	}
+	return otlpValue{}
+}
+
+func otlpKeyValueList(fields map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]otlpKeyValue, len(keys))
+	for i, k := range keys {
+		list[i] = otlpKeyValue{Key: k, Value: otlpAnyValue(fields[k])}
+	}
+	return list
+}
+
+func (v otlpValue) appendProto(b []byte) []byte {
+	switch {
+	case v.StringValue != nil:
+		return protobuf.AppendStringField(b, 1, *v.StringValue)
+	case v.BoolValue != nil:
+		value := uint64(0)
+		if *v.BoolValue {
+			value = 1
+		}
+		return protobuf.AppendVarintField(b, 2, value)
+	case v.IntValue != nil:
+		return protobuf.AppendVarintField(b, 3, uint64(*v.IntValue))
+	case v.DoubleValue != nil:
+		return protobuf.AppendDoubleField(b, 4, *v.DoubleValue)
+	case v.ArrayValue != nil:
+		var array []byte
+		for _, item := range v.ArrayValue.Values {
+			array = protobuf.AppendBytesField(array, 1, item.appendProto(nil))
+		}
+		return protobuf.AppendBytesField(b, 5, array)
+	case v.KvlistValue != nil:
+		return protobuf.AppendBytesField(b, 6, appendOTLPKeyValues(nil, 1, v.KvlistValue.Values))
+	}
+	return b
+}
+
+func appendOTLPKeyValues(b []byte, field int, list []otlpKeyValue) []byte {
+	for _, kv := range list {
+		pair := protobuf.AppendStringField(nil, 1, kv.Key)
+		pair = protobuf.AppendBytesField(pair, 2, kv.Value.appendProto(nil))
+		b = protobuf.AppendBytesField(b, field, pair)
+	}
+	return b
+}
+
+func (r otlpRecord) appendProto(b []byte) []byte {
+	b = protobuf.AppendFixed64Field(b, 1, uint64(r.TimeUnixNano))
+	if r.SeverityNumber != 0 {
+		b = protobuf.AppendVarintField(b, 2, uint64(r.SeverityNumber))
+	}
+	if r.SeverityText != "" {
+		b = protobuf.AppendStringField(b, 3, r.SeverityText)
+	}
+	b = protobuf.AppendBytesField(b, 5, r.Body.appendProto(nil))
+	b = appendOTLPKeyValues(b, 6, r.Attributes)
+	return protobuf.AppendFixed64Field(b, 11, uint64(r.ObservedTimeUnixNano))
+}
+
+// HandleLogMessage queues the log message as a LogRecord for the next batch.
+// Its message is the body and the rest of its fields are attributes, or if it
+// has no message the whole of it is the body.
+func (e otlpEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(jsonBytes, &fields); err != nil {
+		return err
+	}
+	level := logging.Severity(string(jsonBytes))
+	observed := logging.Clock.Now()
+	timestamp := observed
+	if m, ok := jsonSerializeable.(logging.Message); ok {
+		level, timestamp = m.Level, m.Timestamp
+		if received, ok := m.Annotations.Time(logging.AnnotationReceived); ok {
+			observed = received
+		}
+	} else if stamp, ok := fields["@timestamp"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			timestamp = parsed
+		}
+	}
+
+	record := otlpRecord{TimeUnixNano: otlpInt(timestamp.UnixNano()), ObservedTimeUnixNano: otlpInt(observed.UnixNano())}
+	if severity, known := otlpSeverities[level]; known {
+		record.SeverityNumber, record.SeverityText = severity.number, severity.text
+	}
+	if message, ok := fields["message"].(string); ok {
+		// What's already in the record isn't repeated as an attribute
+		record.Body = otlpValue{StringValue: &message}
+		delete(fields, "message")
+		delete(fields, "@timestamp")
+		delete(fields, "log.level")
+		// Nor are empty labels and tags worth sending
+		for k, v := range fields {
+			if object, ok := v.(map[string]interface{}); ok && len(object) == 0 {
+				delete(fields, k)
+			} else if array, ok := v.([]interface{}); ok && len(array) == 0 {
+				delete(fields, k)
+			}
+		}
+		record.Attributes = otlpKeyValueList(fields)
+	} else {
+		record.Body = otlpAnyValue(fields)
+	}
+
+	var item []byte
+	if otlpProtocol == otlpHTTPJSON {
+		if item, err = json.Marshal(record); err != nil {
+			return err
+		}
+	} else {
+		item = record.appendProto(nil)
+	}
+	return otlpBatcher.Add(item)
+}
+
+// otlpRequest wraps a batch of LogRecords in an ExportLogsServiceRequest, with
+// the resource and scope they're from
+func otlpRequest(items [][]byte) ([]byte, error) {
+	scopeName, scopeVersion := otlpScopeName, buildinfo.Version
+	if otlpProtocol == otlpHTTPJSON {
+		resource, _ := json.Marshal(map[string]interface{}{"attributes": otlpResource})
+		scope, _ := json.Marshal(map[string]string{"name": scopeName, "version": scopeVersion})
+		return []byte(fmt.Sprintf(`{"resourceLogs":[{"resource":%s,"scopeLogs":[{"scope":%s,"logRecords":[%s]}]}]}`,
+			resource, scope, bytes.Join(items, []byte(",")))), nil
+	}
+	scope := protobuf.AppendStringField(nil, 1, scopeName)
+	scope = protobuf.AppendStringField(scope, 2, scopeVersion)
+	scopeLogs := protobuf.AppendBytesField(nil, 1, scope)
+	for _, item := range items {
+		scopeLogs = protobuf.AppendBytesField(scopeLogs, 2, item)
+	}
+	resourceLogs := protobuf.AppendBytesField(nil, 1, appendOTLPKeyValues(nil, 1, otlpResource))
+	resourceLogs = protobuf.AppendBytesField(resourceLogs, 2, scopeLogs)
+	return protobuf.AppendBytesField(nil, 1, resourceLogs), nil
+}
+
+// writeOTLPBatch exports a batch in one request, retrying while the collector
+// is unavailable or asks us to back off
+func writeOTLPBatch(items [][]byte) error {
+	body, err := otlpRequest(items)
+	if err != nil {
+		return err
+	}
+	if otlpGzip {
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		w.Write(body)
+		w.Close()
+		body = compressed.Bytes()
+	}
+	if otlpProtocol == otlpGRPC {
+		// A gRPC message is framed with whether it's compressed and its length
+		frame := make([]byte, 5, 5+len(body))
+		if otlpGzip {
+			frame[0] = 1
+		}
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+		body = append(frame, body...)
+	}
+	return otlpBalancer.Retry("", otlpAttempts, func(url string) error {
+		request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for k, v := range otlpHeaders {
+			request.Header.Set(k, v)
+		}
+		switch otlpProtocol {
+		case otlpGRPC:
+			request.Header.Set("Content-Type", "application/grpc")
+			request.Header.Set("TE", "trailers")
+			if otlpGzip {
+				request.Header.Set("Grpc-Encoding", "gzip")
+			}
+		case otlpHTTPJSON:
+			request.Header.Set("Content-Type", "application/json")
+		default:
+			request.Header.Set("Content-Type", "application/x-protobuf")
+		}
+		if otlpGzip && otlpProtocol != otlpGRPC {
+			request.Header.Set("Content-Encoding", "gzip")
+		}
+		response, err := otlpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK {
+			return endpoints.CheckResponse(url, response)
+		}
+		responseBody, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if otlpProtocol == otlpGRPC {
+			if responseBody, err = otlpGRPCResult(url, response, responseBody); err != nil {
+				return err
+			}
+		}
+		warnOTLPPartialSuccess(responseBody)
+		return nil
+	})
+}
+
+// otlpGRPCResult checks a gRPC call's status, in its trailers or its headers
+// if it failed without a response, and returns the response message. Failures
+// are StatusErrors, as 503s if the call may be retried, so Retry knows
+// whether to.
+func otlpGRPCResult(endpoint string, response *http.Response, body []byte) ([]byte, error) {
+	status := response.Trailer.Get("Grpc-Status")
+	message := response.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = response.Header.Get("Grpc-Status"), response.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		if unescaped, err := url.PathUnescape(message); err == nil {
+			message = unescaped
+		}
+		statusCode := http.StatusBadRequest
+		if otlpRetryableCodes[status] || status == "" {
+			statusCode = http.StatusServiceUnavailable
+		}
+		return nil, &endpoints.StatusError{URL: endpoint, StatusCode: statusCode, Status: "gRPC status " + status, Detail: message}
+	}
+	if len(body) < 5 || body[0] != 0 {
+		return nil, nil
+	}
+	return body[5:], nil
+}
+
+// warnOTLPPartialSuccess logs the collector rejecting some of a batch, which
+// ExportLogsServiceResponse reports as a partial success
+func warnOTLPPartialSuccess(body []byte) {
+	var rejected int64
+	var message string
+	if otlpProtocol == otlpHTTPJSON {
+		var response struct {
+			PartialSuccess struct {
+				RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+				ErrorMessage       string      `json:"errorMessage"`
+			} `json:"partialSuccess"`
+		}
+		if json.Unmarshal(body, &response) != nil {
+			return
+		}
+		rejected, _ = response.PartialSuccess.RejectedLogRecords.Int64()
+		message = response.PartialSuccess.ErrorMessage
+	} else {
+		fields, _ := protobuf.Fields(body)
+		for _, field := range fields {
+			if field.Number != 1 || field.WireType != protobuf.Bytes {
+				continue
+			}
+			partial, _ := protobuf.Fields(field.Data)
+			for _, f := range partial {
+				switch {
+				case f.Number == 1 && f.WireType == protobuf.Varint:
+					rejected = int64(f.Value)
+				case f.Number == 2 && f.WireType == protobuf.Bytes:
+					message = string(f.Data)
+				}
+			}
+		}
+	}
+	if rejected > 0 || message != "" {
+		log.Printf("Warning: the OTLP endpoint rejected %d log records: %s", rejected, message)
+	}
+}
+
+// CheckHealth reports whether the collector's endpoint is healthy
+func (e otlpEmitter) CheckHealth() error {
+	for _, endpoint := range otlpBalancer.Endpoints {
+		if !endpoint.Healthy() {
+			return fmt.Errorf("%s is failing", otlpURL)
+		}
+	}
+	return nil
+}
+
+// Cleanup exports the last batch of LogRecords to the collector
+func (e otlpEmitter) Cleanup() error {
+	otlpBatcher.Close()
+	return nil
+}
@@ -0,0 +1,261 @@
+package emitters
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/RedHatInsights/haberdasher/buildinfo"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+)
+
+// The LogRecord and AnyValue encodings, worked out by hand from
+// opentelemetry-proto's logs.proto and common.proto
+func TestOTLPRecordProto(t *testing.T) {
+	message, yes, half, one, minusOne := "hi", true, 0.5, otlpInt(1), otlpInt(-1)
+	record := otlpRecord{
+		TimeUnixNano:         1,
+		ObservedTimeUnixNano: 2,
+		SeverityNumber:       9,
+		SeverityText:         "INFO",
+		Body:                 otlpValue{StringValue: &message},
+		Attributes:           []otlpKeyValue{{Key: "n", Value: otlpValue{IntValue: &one}}},
+	}
+	want := []byte{
+		0x09, 1, 0, 0, 0, 0, 0, 0, 0, // time_unix_nano
+		0x10, 9, // severity_number
+		0x1a, 4, 'I', 'N', 'F', 'O', // severity_text
+		0x2a, 4, 0x0a, 2, 'h', 'i', // body
+		0x32, 7, 0x0a, 1, 'n', 0x12, 2, 0x18, 1, // attributes
+		0x59, 2, 0, 0, 0, 0, 0, 0, 0, // observed_time_unix_nano
+	}
+	if got := record.appendProto(nil); !bytes.Equal(got, want) {
+		t.Errorf("record encoded as\n% x\nwant\n% x", got, want)
+	}
+
+	tests := []struct {
+		name  string
+		value otlpValue
+		want  []byte
+	}{
+		{"empty string", otlpValue{StringValue: new(string)}, []byte{0x0a, 0}},
+		{"bool", otlpValue{BoolValue: &yes}, []byte{0x10, 1}},
+		{"negative int", otlpValue{IntValue: &minusOne}, []byte{0x18, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"double", otlpValue{DoubleValue: &half}, []byte{0x21, 0, 0, 0, 0, 0, 0, 0xe0, 0x3f}},
+		{"array", otlpValue{ArrayValue: &otlpArray{Values: []otlpValue{{BoolValue: &yes}, {IntValue: &one}}}},
+			[]byte{0x2a, 8, 0x0a, 2, 0x10, 1, 0x0a, 2, 0x18, 1}},
+		{"kvlist", otlpValue{KvlistValue: &otlpKeyValues{Values: []otlpKeyValue{{Key: "b", Value: otlpValue{BoolValue: &yes}}}}},
+			[]byte{0x32, 9, 0x0a, 7, 0x0a, 1, 'b', 0x12, 2, 0x10, 1}},
+		{"null", otlpValue{}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.value.appendProto(nil); !bytes.Equal(got, test.want) {
+				t.Errorf("encoded as % x, want % x", got, test.want)
+			}
+		})
+	}
+}
+
+// withOTLP points the emitter at url, speaking protocol, until the test ends
+func withOTLP(t *testing.T, protocol, url string, gzipped bool) {
+	protocol0, url0, headers0, gzip0, resource0, client0, balancer0, attempts0 := otlpProtocol, otlpURL, otlpHeaders, otlpGzip, otlpResource, otlpClient, otlpBalancer, otlpAttempts
+	t.Cleanup(func() {
+		otlpProtocol, otlpURL, otlpHeaders, otlpGzip, otlpResource, otlpClient, otlpBalancer, otlpAttempts = protocol0, url0, headers0, gzip0, resource0, client0, balancer0, attempts0
+	})
+	otlpProtocol, otlpURL, otlpHeaders, otlpGzip, otlpAttempts = protocol, url, nil, gzipped, 1
+	otlpResource = otlpKeyValueList(map[string]interface{}{"service.name": "checkout"})
+	otlpClient = &http.Client{Timeout: 10 * time.Second}
+	if protocol == otlpGRPC {
+		otlpClient.Transport = otlpGRPCTransport(false)
+	}
+	var err error
+	if otlpBalancer, err = endpoints.New([]string{url}, endpoints.RoundRobin, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// otlpTestBatch is two records, with every kind of value, and the request
+// the generated OTLP types say they should arrive as
+func otlpTestBatch() ([][]byte, *collogspb.ExportLogsServiceRequest) {
+	message, yes, half, one := "paid", true, 0.5, otlpInt(-1)
+	first := otlpRecord{
+		TimeUnixNano:         1600000000000000001,
+		ObservedTimeUnixNano: 1600000000000000002,
+		SeverityNumber:       9,
+		SeverityText:         "INFO",
+		Body:                 otlpValue{StringValue: &message},
+		Attributes: []otlpKeyValue{
+			{Key: "amount", Value: otlpValue{DoubleValue: &half}},
+			{Key: "items", Value: otlpValue{ArrayValue: &otlpArray{Values: []otlpValue{{IntValue: &one}, {BoolValue: &yes}}}}},
+			{Key: "labels", Value: otlpValue{KvlistValue: &otlpKeyValues{Values: []otlpKeyValue{{Key: "team", Value: otlpValue{StringValue: &message}}}}}},
+		},
+	}
+	second := otlpRecord{TimeUnixNano: 3, ObservedTimeUnixNano: 4, Body: otlpValue{KvlistValue: &otlpKeyValues{Values: []otlpKeyValue{{Key: "empty", Value: otlpValue{StringValue: new(string)}}}}}}
+
+	str := func(s string) *commonpb.AnyValue { return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}} }
+	want := &collogspb.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{Key: "service.name", Value: str("checkout")}}},
+		ScopeLogs: []*logspb.ScopeLogs{{
+			Scope: &commonpb.InstrumentationScope{Name: "haberdasher", Version: buildinfo.Version},
+			LogRecords: []*logspb.LogRecord{{
+				TimeUnixNano:         1600000000000000001,
+				ObservedTimeUnixNano: 1600000000000000002,
+				SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+				SeverityText:         "INFO",
+				Body:                 str("paid"),
+				Attributes: []*commonpb.KeyValue{
+					{Key: "amount", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: 0.5}}},
+					{Key: "items", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: []*commonpb.AnyValue{
+						{Value: &commonpb.AnyValue_IntValue{IntValue: -1}},
+						{Value: &commonpb.AnyValue_BoolValue{BoolValue: true}},
+					}}}}},
+					{Key: "labels", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: []*commonpb.KeyValue{{Key: "team", Value: str("paid")}}}}}},
+				},
+			}, {
+				TimeUnixNano:         3,
+				ObservedTimeUnixNano: 4,
+				Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: []*commonpb.KeyValue{{Key: "empty", Value: str("")}}}}},
+			}},
+		}},
+	}}}
+	return [][]byte{first.appendProto(nil), second.appendProto(nil)}, want
+}
+
+// A logsServer is a collector built from the reference gRPC and protobuf
+// libraries
+type logsServer struct {
+	collogspb.UnimplementedLogsServiceServer
+	requests chan *collogspb.ExportLogsServiceRequest
+	response *collogspb.ExportLogsServiceResponse
+	err      error
+}
+
+func (s *logsServer) Export(ctx context.Context, request *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	s.requests <- request
+	return s.response, s.err
+}
+
+func startLogsServer(t *testing.T) (*logsServer, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	logs := &logsServer{requests: make(chan *collogspb.ExportLogsServiceRequest, 1), response: &collogspb.ExportLogsServiceResponse{}}
+	collogspb.RegisterLogsServiceServer(server, logs)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return logs, "http://" + listener.Addr().String() + otlpGRPCPath
+}
+
+func TestOTLPGRPCExport(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		t.Run(fmt.Sprintf("gzip %v", gzipped), func(t *testing.T) {
+			server, url := startLogsServer(t)
+			withOTLP(t, otlpGRPC, url, gzipped)
+			items, want := otlpTestBatch()
+			if err := writeOTLPBatch(items); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-server.requests; !proto.Equal(got, want) {
+				t.Errorf("the collector received\n%v\nwant\n%v", prototext.Format(got), prototext.Format(want))
+			}
+		})
+	}
+}
+
+func TestOTLPGRPCStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		response   *collogspb.ExportLogsServiceResponse
+		wantStatus int
+		wantLog    string
+	}{
+		{"invalid", status.Error(codes.InvalidArgument, "bad record: 100%"), nil, http.StatusBadRequest, ""},
+		{"unavailable", status.Error(codes.Unavailable, "overloaded"), nil, http.StatusServiceUnavailable, ""},
+		{"partial success", nil, &collogspb.ExportLogsServiceResponse{PartialSuccess: &collogspb.ExportLogsPartialSuccess{RejectedLogRecords: 1, ErrorMessage: "too old"}},
+			0, "rejected 1 log records: too old"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, url := startLogsServer(t)
+			server.err, server.response = test.err, test.response
+			withOTLP(t, otlpGRPC, url, false)
+			var logged bytes.Buffer
+			log.SetOutput(&logged)
+			defer log.SetOutput(os.Stderr)
+
+			items, _ := otlpTestBatch()
+			err := writeOTLPBatch(items)
+			<-server.requests
+			if test.wantStatus == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if statusErr, ok := err.(*endpoints.StatusError); !ok || statusErr.StatusCode != test.wantStatus {
+				t.Fatalf("got %v, want a %d", err, test.wantStatus)
+			} else if want := status.Convert(test.err).Message(); statusErr.Detail != want {
+				t.Errorf("the error's detail is %q, want %q", statusErr.Detail, want)
+			}
+			if !strings.Contains(logged.String(), test.wantLog) {
+				t.Errorf("logged %q, want %q", logged.String(), test.wantLog)
+			}
+		})
+	}
+}
+
+func TestOTLPHTTPProtobufExport(t *testing.T) {
+	for _, gzipped := range []bool{false, true} {
+		t.Run(fmt.Sprintf("gzip %v", gzipped), func(t *testing.T) {
+			requests := make(chan *collogspb.ExportLogsServiceRequest, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body io.Reader = r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					body, _ = gzip.NewReader(r.Body)
+				}
+				data, _ := ioutil.ReadAll(body)
+				request := &collogspb.ExportLogsServiceRequest{}
+				if err := proto.Unmarshal(data, request); err != nil {
+					t.Errorf("the request doesn't decode: %v", err)
+				}
+				requests <- request
+				response, _ := proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+				w.Header().Set("Content-Type", "application/x-protobuf")
+				w.Write(response)
+			}))
+			defer server.Close()
+			withOTLP(t, otlpHTTPProtobuf, server.URL+otlpHTTPPath, gzipped)
+			items, want := otlpTestBatch()
+			if err := writeOTLPBatch(items); err != nil {
+				t.Fatal(err)
+			}
+			if got := <-requests; !proto.Equal(got, want) {
+				t.Errorf("the collector received\n%v\nwant\n%v", prototext.Format(got), prototext.Format(want))
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package emitters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+var splunkBalancer *endpoints.Balancer
+var splunkClient *http.Client
+var splunkBatcher *batch.Batcher
+var splunkToken string
+var splunkFields splunkMetadata
+var splunkAttempts int
+
+// With indexer acknowledgment, requests are sent on a channel, and a batch
+// only counts as delivered once the indexers say they've indexed it
+var splunkAcks *splunkAcker
+var splunkAckInterval time.Duration
+var splunkAckTimeout time.Duration
+
+// A splunkAcker polls for the acknowledgments of the requests accepted on its
+// channel, all of them at once in the background, so the batcher can go on to
+// the next batch meanwhile. A request not acknowledged in time is sent again.
+type splunkAcker struct {
+	channel string
+	lock    sync.Mutex
+	// By endpoint, then by ack ID
+	pending map[string]map[int64]*splunkPending
+	drain   chan struct{}
+	done    chan struct{}
+}
+
+// A splunkPending is a request the collector has accepted but hasn't yet said
+// is indexed
+type splunkPending struct {
+	key      string
+	body     []byte
+	events   int
+	sends    int
+	deadline time.Time
+}
+
+// splunkMetadata is what every event says about where it came from
+type splunkMetadata struct {
+	Host       string `json:"host,omitempty"`
+	Source     string `json:"source,omitempty"`
+	Sourcetype string `json:"sourcetype,omitempty"`
+	Index      string `json:"index,omitempty"`
+}
+
+type splunkEvent struct {
+	Time float64 `json:"time"`
+	splunkMetadata
+	Event json.RawMessage `json:"event"`
+}
+
+type splunkEmitter struct{}
+
+func init() {
+	var emitter splunkEmitter
+	logging.Register("splunk", emitter)
+}
+
+// Setup configures the HTTP Event Collector to send to, from
+// HABERDASHER_SPLUNK_URL (e.g. https://splunk:8088/services/collector/event)
+// and the other settings shared by HTTP emitters, and what events say
+func (e splunkEmitter) Setup() {
+	splunkBalancer = endpoints.FromEnv("HABERDASHER_SPLUNK")
+	splunkClient = endpoints.NewClient("HABERDASHER_SPLUNK")
+
+	var exists bool
+	if splunkToken, exists = config.Setting("HABERDASHER_SPLUNK_TOKEN"); !exists || splunkToken == "" {
+		log.Fatal("To use Haberdasher with Splunk, HABERDASHER_SPLUNK_TOKEN must be set to your HEC token")
+	}
+	splunkFields = splunkMetadata{}
+	splunkFields.Host, _ = config.Setting("HABERDASHER_SPLUNK_HOST")
+	splunkFields.Source, _ = config.Setting("HABERDASHER_SPLUNK_SOURCE")
+	splunkFields.Sourcetype, _ = config.Setting("HABERDASHER_SPLUNK_SOURCETYPE")
+	splunkFields.Index, _ = config.Setting("HABERDASHER_SPLUNK_INDEX")
+	if splunkFields.Host == "" {
+		splunkFields.Host, _ = os.Hostname()
+	}
+	if splunkFields.Sourcetype == "" {
+		splunkFields.Sourcetype = "_json"
+	}
+
+	var limits batch.Limits
+	limits.MaxBytes, _ = config.IntSetting("HABERDASHER_SPLUNK_BATCH_BYTES", 1)
+	limits.MaxWait, _ = config.DurationSetting("HABERDASHER_SPLUNK_FLUSH_INTERVAL")
+	splunkAttempts, _ = config.IntSetting("HABERDASHER_SPLUNK_ATTEMPTS", 1)
+
+	// Set up again after a panic, the acker carries on with its channel and
+	// the requests it's waiting on
+	if config.FlagSetting("HABERDASHER_SPLUNK_ACK") && splunkAcks == nil {
+		splunkAckInterval, _ = config.DurationSetting("HABERDASHER_SPLUNK_ACK_INTERVAL")
+		splunkAckTimeout, _ = config.DurationSetting("HABERDASHER_SPLUNK_ACK_TIMEOUT")
+		splunkAcks = &splunkAcker{
+			channel: newChannelID(),
+			pending: make(map[string]map[int64]*splunkPending),
+			drain:   make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		go splunkAcks.run()
+	}
+
+	splunkBatcher = batch.New(limits, nil, writeSplunkBatch)
+}
+
+// newChannelID makes a random (version 4) UUID to identify our channel
+func newChannelID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		log.Fatal("Couldn't generate a Splunk channel: ", err)
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// HandleLogMessage queues the log message as an event for the next batch
+func (e splunkEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	timestamp := logging.Clock.Now()
+	switch m := jsonSerializeable.(type) {
+	case logging.Message:
+		timestamp = m.Timestamp
+	case map[string]interface{}:
+		if stamp, ok := m["@timestamp"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				timestamp = parsed
+			}
+		}
+	}
+	event, err := json.Marshal(splunkEvent{
+		Time:           float64(timestamp.UnixNano()/int64(time.Millisecond)) / 1000,
+		splunkMetadata: splunkFields,
+		Event:          jsonBytes,
+	})
+	if err != nil {
+		return err
+	}
+	return splunkBatcher.Add(event)
+}
+
+// writeSplunkBatch sends a batch of events, which HEC takes one after another
+// in a single request
+func writeSplunkBatch(items [][]byte) error {
+	var firstErr error
+	for key, group := range splunkBalancer.Group(items) {
+		err := sendSplunkBatch(splunkAcks, key, bytes.Join(group, []byte{'\n'}), len(group), 1)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// sendSplunkBatch posts a group of events. With indexer acknowledgment, once
+// the collector has accepted them they're left to the acker to see indexed.
+func sendSplunkBatch(acks *splunkAcker, key string, body []byte, events, sends int) error {
+	return splunkBalancer.Retry(key, splunkAttempts, func(endpoint string) error {
+		if acks == nil {
+			_, err := postSplunkBatch(endpoint, body, "")
+			return err
+		}
+		ackID, err := postSplunkBatch(endpoint, body, acks.channel)
+		if err == nil {
+			acks.add(endpoint, ackID, &splunkPending{key: key, body: body, events: events, sends: sends})
+		}
+		return err
+	})
+}
+
+// postSplunkBatch sends a request, on the channel if there is one, returning
+// the ID to poll for its acknowledgment
+func postSplunkBatch(endpoint string, body []byte, channel string) (int64, error) {
+	request, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Splunk "+splunkToken)
+	if channel == "" {
+		response, err := splunkClient.Do(request)
+		if err != nil {
+			return 0, err
+		}
+		return 0, endpoints.CheckResponse(endpoint, response)
+	}
+
+	request.Header.Set("X-Splunk-Request-Channel", channel)
+	var accepted struct {
+		AckID *int64 `json:"ackId"`
+	}
+	if err := splunkRequest(request, &accepted); err != nil {
+		return 0, err
+	}
+	if accepted.AckID == nil {
+		return 0, fmt.Errorf("%s: indexer acknowledgment isn't enabled for this token", endpoint)
+	}
+	return *accepted.AckID, nil
+}
+
+// splunkAckURL is where to poll for acknowledgments of requests sent to an
+// event endpoint: its sibling ack endpoint, so whatever prefix it has, such as
+// a reverse proxy's, is kept. /services/collector/event/1.0 and
+// /services/collector both poll /services/collector/ack.
+func splunkAckURL(endpoint, channel string) (string, error) {
+	ackURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSuffix(strings.TrimSuffix(ackURL.Path, "/"), "/1.0")
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "/event"), "/raw")
+	ackURL.Path = path + "/ack"
+	ackURL.RawPath = ""
+	ackURL.RawQuery = url.Values{"channel": {channel}}.Encode()
+	return ackURL.String(), nil
+}
+
+func (a *splunkAcker) add(endpoint string, ackID int64, p *splunkPending) {
+	p.deadline = logging.Clock.Now().Add(splunkAckTimeout)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.pending[endpoint] == nil {
+		a.pending[endpoint] = make(map[int64]*splunkPending)
+	}
+	a.pending[endpoint][ackID] = p
+}
+
+// run polls every interval until it's drained. A request not acknowledged in
+// time is sent again, unless we're shutting down, when it's given up on.
+func (a *splunkAcker) run() {
+	defer close(a.done)
+	drain, draining := a.drain, false
+	for {
+		select {
+		case <-logging.Clock.After(splunkAckInterval):
+			a.poll()
+			for _, p := range a.expired() {
+				if draining {
+					a.fail(p, fmt.Errorf("still not acknowledged after %s when shutting down", splunkAckTimeout))
+				} else {
+					a.resend(p)
+				}
+			}
+		case <-drain:
+			draining, drain = true, nil
+		}
+		if draining && a.empty() {
+			return
+		}
+	}
+}
+
+// poll asks each endpoint about every request it's accepted which hasn't been
+// acknowledged yet
+func (a *splunkAcker) poll() {
+	a.lock.Lock()
+	waiting := make(map[string][]int64, len(a.pending))
+	for endpoint, requests := range a.pending {
+		for ackID := range requests {
+			waiting[endpoint] = append(waiting[endpoint], ackID)
+		}
+	}
+	a.lock.Unlock()
+
+	for endpoint, ackIDs := range waiting {
+		acked, err := a.query(endpoint, ackIDs)
+		if err != nil {
+			log.Println("Error checking Splunk acknowledgments:", err)
+			continue
+		}
+		a.lock.Lock()
+		for _, ackID := range acked {
+			delete(a.pending[endpoint], ackID)
+		}
+		if len(a.pending[endpoint]) == 0 {
+			delete(a.pending, endpoint)
+		}
+		a.lock.Unlock()
+	}
+}
+
+// query returns which of the requests are acknowledged
+func (a *splunkAcker) query(endpoint string, ackIDs []int64) ([]int64, error) {
+	ackURL, err := splunkAckURL(endpoint, a.channel)
+	if err != nil {
+		return nil, err
+	}
+	query, _ := json.Marshal(map[string][]int64{"acks": ackIDs})
+	request, err := http.NewRequest(http.MethodPost, ackURL, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Splunk "+splunkToken)
+	request.Header.Set("X-Splunk-Request-Channel", a.channel)
+	var status struct {
+		Acks map[string]bool `json:"acks"`
+	}
+	if err := splunkRequest(request, &status); err != nil {
+		return nil, err
+	}
+	var acked []int64
+	for _, ackID := range ackIDs {
+		if status.Acks[strconv.FormatInt(ackID, 10)] {
+			acked = append(acked, ackID)
+		}
+	}
+	return acked, nil
+}
+
+// expired takes out the requests whose acknowledgments are overdue
+func (a *splunkAcker) expired() []*splunkPending {
+	now := logging.Clock.Now()
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var overdue []*splunkPending
+	for endpoint, requests := range a.pending {
+		for ackID, p := range requests {
+			if now.After(p.deadline) {
+				overdue = append(overdue, p)
+				delete(requests, ackID)
+			}
+		}
+		if len(requests) == 0 {
+			delete(a.pending, endpoint)
+		}
+	}
+	return overdue
+}
+
+func (a *splunkAcker) resend(p *splunkPending) {
+	if p.sends >= splunkAttempts {
+		a.fail(p, fmt.Errorf("not acknowledged within %s, %d times", splunkAckTimeout, p.sends))
+		return
+	}
+	log.Println("Warning: Splunk didn't acknowledge", p.events, "events within", splunkAckTimeout.String()+", so sending them again")
+	if err := sendSplunkBatch(a, p.key, p.body, p.events, p.sends+1); err != nil {
+		a.fail(p, err)
+	}
+}
+
+func (a *splunkAcker) fail(p *splunkPending, err error) {
+	log.Println("Error: giving up on", p.events, "events sent to Splunk:", err)
+	logging.Failed(p.events)
+}
+
+func (a *splunkAcker) empty() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return len(a.pending) == 0
+}
+
+// close waits for the requests still unacknowledged to be acknowledged or
+// time out, and stops polling
+func (a *splunkAcker) close() {
+	close(a.drain)
+	<-a.done
+}
+
+// splunkRequest sends a request and decodes a successful response's JSON
+func splunkRequest(request *http.Request, into interface{}) error {
+	response, err := splunkClient.Do(request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return endpoints.CheckResponse(request.URL.String(), response)
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(into)
+}
+
+// Cleanup sends whatever is still waiting to be batched, and with indexer
+// acknowledgment waits for what's been sent to be acknowledged
+func (e splunkEmitter) Cleanup() error {
+	splunkBatcher.Close()
+	if splunkAcks != nil {
+		splunkAcks.close()
+		splunkAcks = nil
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package emitters
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/batch"
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/endpoints"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// A fakeHEC is an HTTP Event Collector which, with acknowledgment, hands out
+// ack IDs counting from 0, and only acknowledges those from indexedFrom on
+type fakeHEC struct {
+	lock        sync.Mutex
+	events      [][]splunkEvent
+	channels    []string
+	ack         bool
+	nextAckID   int64
+	indexedFrom int64
+	polls       int
+}
+
+func startFakeHEC(t *testing.T, ack bool, indexedFrom int64) (*fakeHEC, string) {
+	hec := &fakeHEC{ack: ack, indexedFrom: indexedFrom}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hec.lock.Lock()
+		defer hec.lock.Unlock()
+		if r.Header.Get("Authorization") != "Splunk secret" {
+			http.Error(w, `{"text":"Invalid token","code":4}`, http.StatusForbidden)
+			return
+		}
+		channel := r.Header.Get("X-Splunk-Request-Channel")
+		switch r.URL.Path {
+		case "/services/collector/event":
+			var events []splunkEvent
+			for scanner := bufio.NewScanner(r.Body); scanner.Scan(); {
+				var event splunkEvent
+				if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+					http.Error(w, `{"text":"Invalid data format","code":6}`, http.StatusBadRequest)
+					return
+				}
+				events = append(events, event)
+			}
+			hec.events = append(hec.events, events)
+			hec.channels = append(hec.channels, channel)
+			if !hec.ack {
+				w.Write([]byte(`{"text":"Success","code":0}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"text": "Success", "code": 0, "ackId": hec.nextAckID})
+			hec.nextAckID++
+		case "/services/collector/ack":
+			hec.polls++
+			var query struct {
+				Acks []int64 `json:"acks"`
+			}
+			if r.URL.Query().Get("channel") != channel || json.NewDecoder(r.Body).Decode(&query) != nil {
+				http.Error(w, `{"text":"Invalid data format","code":6}`, http.StatusBadRequest)
+				return
+			}
+			acks := make(map[string]bool)
+			for _, ackID := range query.Acks {
+				acks[strconv.FormatInt(ackID, 10)] = ackID >= hec.indexedFrom
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return hec, server.URL + "/services/collector/event"
+}
+
+// sent returns how many requests have been sent to the collector
+func (h *fakeHEC) sent() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return len(h.events)
+}
+
+// withSplunk sends to url, batching every n messages, retrying with a clock
+// which doesn't wait. With ack, acknowledgments are polled every 10ms and
+// given 50ms to arrive.
+func withSplunk(t *testing.T, url string, n int, attempts int, ack bool) *bytes.Buffer {
+	balancer0, client0, batcher0, token0, fields0, attempts0 := splunkBalancer, splunkClient, splunkBatcher, splunkToken, splunkFields, splunkAttempts
+	acks0, interval0, timeout0 := splunkAcks, splunkAckInterval, splunkAckTimeout
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() {
+		splunkEmitter{}.Cleanup()
+		splunkBalancer, splunkClient, splunkBatcher, splunkToken, splunkFields, splunkAttempts = balancer0, client0, batcher0, token0, fields0, attempts0
+		splunkAcks, splunkAckInterval, splunkAckTimeout = acks0, interval0, timeout0
+		log.SetOutput(os.Stderr)
+	})
+	var err error
+	if splunkBalancer, err = endpoints.New([]string{url}, endpoints.RoundRobin, ""); err != nil {
+		t.Fatal(err)
+	}
+	splunkBalancer.SetClock(noWait{clock.NewFake(time.Unix(0, 0))})
+	splunkClient = &http.Client{Timeout: 10 * time.Second}
+	splunkBatcher = batch.New(batch.Limits{MaxBytes: 1 << 20, MaxItems: n, MaxWait: time.Hour}, nil, writeSplunkBatch)
+	splunkToken, splunkAttempts = "secret", attempts
+	splunkFields = splunkMetadata{Host: "web-1", Sourcetype: "_json", Index: "main"}
+	splunkAcks = nil
+	if ack {
+		splunkAckInterval, splunkAckTimeout = 10*time.Millisecond, 50*time.Millisecond
+		splunkAcks = &splunkAcker{
+			channel: newChannelID(),
+			pending: make(map[string]map[int64]*splunkPending),
+			drain:   make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		go splunkAcks.run()
+	}
+	return &output
+}
+
+// Events carry their time in seconds, to the millisecond, and the configured
+// metadata, and a batch of them goes in one request
+func TestSplunkEvents(t *testing.T) {
+	hec, url := startFakeHEC(t, false, 0)
+	withSplunk(t, url, 2, 1, false)
+	stamp := time.Date(2021, 3, 4, 5, 6, 7, 891234567, time.UTC)
+	errs := handleAll(splunkEmitter{},
+		logging.Message{Timestamp: stamp, Message: "one"},
+		map[string]interface{}{"@timestamp": stamp.Add(time.Second).Format(time.RFC3339Nano), "message": "two"},
+	)
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(hec.events) != 1 || len(hec.events[0]) != 2 {
+		t.Fatalf("sent %+v, want one request with both events", hec.events)
+	}
+	if hec.channels[0] != "" {
+		t.Errorf("sent on channel %q without acknowledgment", hec.channels[0])
+	}
+	times := make(map[string]float64)
+	for _, event := range hec.events[0] {
+		if event.splunkMetadata != splunkFields {
+			t.Errorf("event says %+v, want %+v", event.splunkMetadata, splunkFields)
+		}
+		var message map[string]interface{}
+		if err := json.Unmarshal(event.Event, &message); err != nil {
+			t.Fatal(err)
+		}
+		times[message["message"].(string)] = event.Time
+	}
+	if want := map[string]float64{"one": 1614834367.891, "two": 1614834368.891}; !reflect.DeepEqual(times, want) {
+		t.Errorf("events timed %v, want %v", times, want)
+	}
+}
+
+func TestSplunkRejected(t *testing.T) {
+	hec, url := startFakeHEC(t, false, 0)
+	withSplunk(t, url, 1, 3, false)
+	splunkToken = "wrong"
+	err := splunkEmitter{}.HandleLogMessage(logging.Message{Message: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Errorf("returned %v, want the collector's refusal", err)
+	}
+	if hec.sent() != 0 {
+		t.Errorf("the collector took %d requests", hec.sent())
+	}
+}
+
+func TestSplunkAckURL(t *testing.T) {
+	for endpoint, want := range map[string]string{
+		"https://splunk:8088/services/collector/event":      "https://splunk:8088/services/collector/ack?channel=c",
+		"https://splunk:8088/services/collector/event/1.0":  "https://splunk:8088/services/collector/ack?channel=c",
+		"https://splunk:8088/services/collector":            "https://splunk:8088/services/collector/ack?channel=c",
+		"https://splunk:8088/services/collector/raw/":       "https://splunk:8088/services/collector/ack?channel=c",
+		"https://proxy/splunk/services/collector/event?x=1": "https://proxy/splunk/services/collector/ack?channel=c",
+	} {
+		if got, err := splunkAckURL(endpoint, "c"); err != nil || got != want {
+			t.Errorf("%s polls %s (%v), want %s", endpoint, got, err, want)
+		}
+	}
+}
+
+func TestSplunkChannelID(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if id := newChannelID(); !uuid.MatchString(id) {
+		t.Errorf("channel %q isn't a version 4 UUID", id)
+	}
+	if newChannelID() == newChannelID() {
+		t.Error("made the same channel twice")
+	}
+}
+
+// A request the indexers don't acknowledge in time is sent again, and once
+// it's out of attempts, given up on
+func TestSplunkAcknowledgment(t *testing.T) {
+	tests := []struct {
+		name        string
+		indexedFrom int64
+		attempts    int
+		wantSent    int
+		wantGivenUp bool
+	}{
+		{"acknowledged", 0, 3, 1, false},
+		{"acknowledged when sent again", 1, 3, 2, false},
+		{"never acknowledged", 100, 2, 2, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hec, url := startFakeHEC(t, true, test.indexedFrom)
+			output := withSplunk(t, url, 1, test.attempts, true)
+			if err := (splunkEmitter{}).HandleLogMessage(logging.Message{Message: "hi"}); err != nil {
+				t.Fatal(err)
+			}
+			for deadline := time.Now().Add(5 * time.Second); hec.sent() < test.wantSent || !splunkAcks.empty(); {
+				if time.Now().After(deadline) {
+					t.Fatalf("sent %d requests, with acknowledgments still pending", hec.sent())
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			splunkEmitter{}.Cleanup()
+			hec.lock.Lock()
+			defer hec.lock.Unlock()
+
+			if len(hec.events) != test.wantSent {
+				t.Errorf("sent %d requests, want %d", len(hec.events), test.wantSent)
+			}
+			for _, channel := range hec.channels {
+				if channel == "" || channel != hec.channels[0] {
+					t.Errorf("sent on channels %q, want one", hec.channels)
+					break
+				}
+			}
+			if givenUp := strings.Contains(output.String(), "giving up"); givenUp != test.wantGivenUp {
+				t.Errorf("gave up is %v, logging:\n%s", givenUp, output)
+			}
+		})
+	}
+}
+
+// Shutting down waits for outstanding acknowledgments
+func TestSplunkCleanupWaitsForAcks(t *testing.T) {
+	hec, url := startFakeHEC(t, true, 0)
+	withSplunk(t, url, 1, 1, true)
+	if err := (splunkEmitter{}).HandleLogMessage(logging.Message{Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	acks := splunkAcks
+	splunkEmitter{}.Cleanup()
+	hec.lock.Lock()
+	defer hec.lock.Unlock()
+	if !acks.empty() || hec.polls == 0 {
+		t.Errorf("shut down after %d polls, with acknowledgments pending", hec.polls)
+	}
+}
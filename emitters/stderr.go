@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"encoding/json"
 	"os"
+	"github.com/RedHatInsights/haberdasher/config"
 	"github.com/RedHatInsights/haberdasher/logging"
 )
 
@@ -19,7 +20,7 @@ func (e stderrEmitter) Setup() {}
 func (e stderrEmitter) HandleLogMessage(jsonSerializeable interface{}) (error) {
 	var jsonBytes []byte
 	var err error
-	prettyPrint := os.Getenv("HABERDASHER_STDERR_PRETTY")
+	prettyPrint, _ := config.Setting("HABERDASHER_STDERR_PRETTY")
 	if prettyPrint != "" {
 		jsonBytes, err = json.MarshalIndent(jsonSerializeable, "", "    ")
 	} else {
@@ -0,0 +1,31 @@
+package emitters
+
+import (
+	"encoding/json"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// stdoutJSONEmitter writes every message to our own stdout as a line of
+// JSON, for platforms whose node-level log collection reads containers'
+// output
+type stdoutJSONEmitter struct{}
+
+func init() {
+	var emitter stdoutJSONEmitter
+	logging.Register("stdout-json", emitter)
+}
+
+func (e stdoutJSONEmitter) Setup() {}
+
+func (e stdoutJSONEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	return logging.WriteStdout(string(jsonBytes))
+}
+
+func (e stdoutJSONEmitter) Cleanup() error {
+	return nil
+}
@@ -0,0 +1,244 @@
+package emitters
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/fips"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/tlsconfig"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Our normalized levels as syslog severities. Unleveled lines are "info".
+var syslogSeverities = map[string]int{
+	"trace": 7,
+	"debug": 7,
+	"info":  6,
+	"warn":  4,
+	"error": 3,
+	"fatal": 2,
+}
+
+type syslogEmitter struct{}
+
+// The collector's connection is shared by every message, and redialled after
+// a failed write
+var syslogLock sync.Mutex
+var syslogConn net.Conn
+var syslogWriteTimeout time.Duration
+var syslogNetwork string
+var syslogAddress string
+var syslogTLS *tls.Config
+var syslogFacility int
+var syslogAppName string
+var syslogHostname string
+
+func init() {
+	var emitter syslogEmitter
+	logging.Register("syslog", emitter)
+}
+
+// Setup reads where to send messages from HABERDASHER_SYSLOG_ADDRESS:
+// udp://host:port, tcp://host:port, or tls://host:port. TCP and TLS messages
+// are framed by octet counting (RFC 6587 and RFC 5425).
+func (e syslogEmitter) Setup() {
+	address, exists := config.Setting("HABERDASHER_SYSLOG_ADDRESS")
+	if !exists {
+		log.Fatal("To use Haberdasher with syslog, HABERDASHER_SYSLOG_ADDRESS must be set to your collector, like udp://rsyslog:514")
+	}
+	parts := strings.SplitN(address, "://", 2)
+	if len(parts) != 2 || (parts[0] != "udp" && parts[0] != "tcp" && parts[0] != "tls") {
+		log.Fatal("HABERDASHER_SYSLOG_ADDRESS must be udp://host:port, tcp://host:port, or tls://host:port")
+	}
+	syslogNetwork, syslogAddress = parts[0], parts[1]
+
+	var err error
+	if syslogTLS, err = tlsconfig.FromEnv("HABERDASHER_SYSLOG"); err != nil {
+		log.Fatal("Invalid syslog TLS settings: ", err)
+	}
+	if syslogNetwork == "tls" && syslogTLS == nil {
+		syslogTLS = fips.TLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	if syslogTLS != nil {
+		if syslogNetwork == "udp" {
+			log.Fatal("HABERDASHER_SYSLOG_ADDRESS: TLS needs tls://, not udp://")
+		}
+		syslogNetwork = "tls"
+	}
+
+	facility, _ := config.Setting("HABERDASHER_SYSLOG_FACILITY")
+	if facility == "" {
+		facility = "user"
+	}
+	var known bool
+	if syslogFacility, known = syslogFacilities[facility]; !known {
+		log.Fatal("HABERDASHER_SYSLOG_FACILITY must be a facility name, like user, daemon, or local0")
+	}
+
+	syslogAppName, _ = config.Setting("HABERDASHER_SYSLOG_APP_NAME")
+	if syslogAppName == "" {
+		syslogAppName = "haberdasher"
+	}
+	if syslogHostname, err = os.Hostname(); err != nil || syslogHostname == "" {
+		syslogHostname = "-"
+	}
+	syslogWriteTimeout, _ = config.DurationSetting("HABERDASHER_SYSLOG_WRITE_TIMEOUT")
+
+	// Connecting now means a bad address fails fast, but a collector which is
+	// briefly down shouldn't stop startup
+	syslogLock.Lock()
+	defer syslogLock.Unlock()
+	if err := syslogDial(); err != nil {
+		log.Println("Warning: couldn't connect to the syslog collector:", err)
+	}
+}
+
+// syslogDial (re)connects to the collector. The caller holds syslogLock.
+func syslogDial() error {
+	if syslogConn != nil {
+		syslogConn.Close()
+		syslogConn = nil
+	}
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if syslogNetwork == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", syslogAddress, syslogTLS)
+	} else {
+		conn, err = dialer.Dial(syslogNetwork, syslogAddress)
+	}
+	if err != nil {
+		return err
+	}
+	syslogConn = conn
+	return nil
+}
+
+// HandleLogMessage ships the log message to the collector as an RFC 5424
+// message whose body is the JSON document. A collector which stops reading
+// would hold up every message behind the lock, so a write which takes longer
+// than HABERDASHER_SYSLOG_WRITE_TIMEOUT fails, and the connection is dropped,
+// since part of the frame may have been sent; the next message reconnects.
+func (e syslogEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	frame := syslogFrame(jsonSerializeable, jsonBytes)
+
+	syslogLock.Lock()
+	defer syslogLock.Unlock()
+	// A stream the collector has closed often only fails on the next write,
+	// so each message gets one retry on a fresh connection
+	for attempt := 0; ; attempt++ {
+		if syslogConn == nil {
+			if err = syslogDial(); err != nil {
+				return err
+			}
+		}
+		syslogConn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		_, err = syslogConn.Write(frame)
+		if err == nil {
+			return nil
+		}
+		syslogConn.Close()
+		syslogConn = nil
+		if timeout, ok := err.(net.Error); attempt > 0 || (ok && timeout.Timeout()) {
+			return err
+		}
+	}
+}
+
+// syslogFrame formats a message, with its priority, timestamp and MSGID taken
+// from the message itself
+func syslogFrame(jsonSerializeable interface{}, jsonBytes []byte) []byte {
+	var level, action string
+	timestamp := logging.Clock.Now()
+	switch m := jsonSerializeable.(type) {
+	case logging.Message:
+		level, action, timestamp = m.Level, m.EventAction, m.Timestamp
+	case map[string]interface{}:
+		level = logging.Severity(string(jsonBytes))
+		action, _ = m["event.action"].(string)
+		if stamp, ok := m["@timestamp"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				timestamp = parsed
+			}
+		}
+	}
+	severity, known := syslogSeverities[level]
+	if !known {
+		severity = 6
+	}
+	msgID := "-"
+	if action != "" {
+		msgID = syslogField(action, 32)
+	}
+
+	message := fmt.Sprintf("<%d>1 %s %s %s - %s - %s",
+		syslogFacility*8+severity,
+		timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(syslogHostname, 255),
+		syslogField(syslogAppName, 48),
+		msgID,
+		jsonBytes)
+	if syslogNetwork == "udp" {
+		return []byte(message)
+	}
+	return []byte(fmt.Sprintf("%d %s", len(message), message))
+}
+
+// syslogField makes a header field valid: printable ASCII without spaces, and
+// no longer than RFC 5424 allows
+func syslogField(value string, maxLength int) string {
+	field := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+	return field
+}
+
+// CheckHealth makes sure the collector accepts connections. There's no way to
+// tell over UDP.
+func (e syslogEmitter) CheckHealth() error {
+	if syslogNetwork == "udp" {
+		return nil
+	}
+	syslogLock.Lock()
+	defer syslogLock.Unlock()
+	if syslogConn != nil {
+		return nil
+	}
+	return syslogDial()
+}
+
+func (e syslogEmitter) Cleanup() error {
+	syslogLock.Lock()
+	defer syslogLock.Unlock()
+	if syslogConn == nil {
+		return nil
+	}
+	err := syslogConn.Close()
+	syslogConn = nil
+	return err
+}
@@ -0,0 +1,118 @@
+package emitters
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+func withSyslog(t *testing.T, network string, facility string) {
+	network0, facility0, appName0, hostname0 := syslogNetwork, syslogFacility, syslogAppName, syslogHostname
+	t.Cleanup(func() {
+		syslogNetwork, syslogFacility, syslogAppName, syslogHostname = network0, facility0, appName0, hostname0
+	})
+	syslogNetwork, syslogFacility = network, syslogFacilities[facility]
+	syslogAppName, syslogHostname = "my app", "web-1"
+}
+
+func TestSyslogFrameHeader(t *testing.T) {
+	withSyslog(t, "udp", "local0")
+	m := logging.Message{
+		Timestamp:   time.Date(2020, 10, 29, 22, 41, 58, 20000000, time.FixedZone("EST", -5*60*60)),
+		Message:     "boom",
+		Level:       "error",
+		EventAction: "child exited",
+	}
+	jsonBytes, _ := json.Marshal(m)
+	got := string(syslogFrame(m, jsonBytes))
+	// Facility local0 (16) and severity error (3) make a priority of 131, the
+	// time is UTC, spaces in fields are replaced, and there's no PROCID or
+	// STRUCTURED-DATA
+	want := "<131>1 2020-10-30T03:41:58.020000Z web-1 my_app - child_exited - " + string(jsonBytes)
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	m.EventAction = ""
+	if got := string(syslogFrame(m, jsonBytes)); !strings.HasPrefix(got, "<131>1 2020-10-30T03:41:58.020000Z web-1 my_app - - - {") {
+		t.Errorf("a message without an action has no MSGID: %s", got)
+	}
+}
+
+func TestSyslogPriority(t *testing.T) {
+	tests := []struct {
+		facility string
+		level    string
+		want     string
+	}{
+		{"user", "info", "<14>"},
+		{"user", "", "<14>"},
+		{"user", "trace", "<15>"},
+		{"user", "debug", "<15>"},
+		{"user", "warn", "<12>"},
+		{"daemon", "error", "<27>"},
+		{"kern", "fatal", "<2>"},
+		{"local7", "debug", "<191>"},
+	}
+	for _, test := range tests {
+		withSyslog(t, "udp", test.facility)
+		m := logging.Message{Message: "hi", Level: test.level}
+		if got := string(syslogFrame(m, []byte("{}"))); !strings.HasPrefix(got, test.want+"1 ") {
+			t.Errorf("%s.%s: got %s, want priority %s", test.facility, test.level, got, test.want)
+		}
+	}
+
+	// Structured lines' severity comes from their level field
+	withSyslog(t, "udp", "user")
+	line := map[string]interface{}{"level": "warn", "message": "hi", "@timestamp": "2020-10-29T22:41:58Z"}
+	jsonBytes, _ := json.Marshal(line)
+	if got := string(syslogFrame(line, jsonBytes)); !strings.HasPrefix(got, "<12>1 2020-10-29T22:41:58.000000Z ") {
+		t.Errorf("a structured warning was framed as %s", got)
+	}
+}
+
+func TestSyslogOctetCounting(t *testing.T) {
+	m := logging.Message{Message: "héllo"}
+	jsonBytes, _ := json.Marshal(m)
+
+	withSyslog(t, "udp", "user")
+	datagram := string(syslogFrame(m, jsonBytes))
+	for _, network := range []string{"tcp", "tls"} {
+		withSyslog(t, network, "user")
+		// The count is of bytes, not characters
+		want := strconv.Itoa(len(datagram)) + " " + datagram
+		if got := string(syslogFrame(m, jsonBytes)); got != want {
+			t.Errorf("%s: got %q, want %q", network, got, want)
+		}
+	}
+}
+
+func TestSyslogWriteTimeout(t *testing.T) {
+	withSyslog(t, "tcp", "user")
+	timeout0 := syslogWriteTimeout
+	defer func() { syslogWriteTimeout = timeout0 }()
+	syslogWriteTimeout = 50 * time.Millisecond
+
+	// Nothing reads the other end of the pipe, like a collector which has hung
+	ours, theirs := net.Pipe()
+	defer theirs.Close()
+	syslogConn = ours
+	defer func() { syslogConn = nil }()
+
+	start := time.Now()
+	err := syslogEmitter{}.HandleLogMessage(logging.Message{Message: "hi"})
+	if timeout, ok := err.(net.Error); !ok || !timeout.Timeout() {
+		t.Fatalf("a hung collector gave %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the write took %s to time out", elapsed)
+	}
+	if syslogConn != nil {
+		t.Error("the connection wasn't dropped after the timeout")
+	}
+}
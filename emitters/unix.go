@@ -0,0 +1,135 @@
+package emitters
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// The agent's socket is shared by every message, and redialled after a
+// failed write
+var unixLock sync.Mutex
+var unixConn net.Conn
+var unixPath string
+var unixNetwork string
+var unixLengthPrefixed bool
+
+type unixEmitter struct{}
+
+func init() {
+	var emitter unixEmitter
+	logging.Register("unix", emitter)
+}
+
+// Setup reads the socket to write to from HABERDASHER_UNIX_PATH, whether it's
+// a stream or datagram socket from HABERDASHER_UNIX_TYPE, and how messages on
+// a stream are framed from HABERDASHER_UNIX_FRAMING: newline delimited, or
+// each preceded by its length as a 4-byte big-endian integer. Every datagram
+// is one message.
+func (e unixEmitter) Setup() {
+	var exists bool
+	if unixPath, exists = config.Setting("HABERDASHER_UNIX_PATH"); !exists || unixPath == "" {
+		log.Fatal("To use Haberdasher with a Unix socket, HABERDASHER_UNIX_PATH must be set to the socket's path")
+	}
+	switch socketType, _ := config.Setting("HABERDASHER_UNIX_TYPE"); socketType {
+	case "", "stream":
+		unixNetwork = "unix"
+	case "datagram":
+		unixNetwork = "unixgram"
+	default:
+		log.Fatal("HABERDASHER_UNIX_TYPE must be stream or datagram")
+	}
+	switch framing, _ := config.Setting("HABERDASHER_UNIX_FRAMING"); framing {
+	case "", "newline":
+		unixLengthPrefixed = false
+	case "length":
+		unixLengthPrefixed = true
+	default:
+		log.Fatal("HABERDASHER_UNIX_FRAMING must be newline or length")
+	}
+
+	// Connecting now means a bad path fails fast, but an agent which is
+	// briefly down shouldn't stop startup
+	unixLock.Lock()
+	defer unixLock.Unlock()
+	if err := unixDial(); err != nil {
+		log.Println("Warning: couldn't connect to", unixPath+":", err)
+	}
+}
+
+// unixDial (re)connects to the socket. The caller holds unixLock.
+func unixDial() error {
+	if unixConn != nil {
+		unixConn.Close()
+		unixConn = nil
+	}
+	conn, err := net.DialTimeout(unixNetwork, unixPath, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	unixConn = conn
+	return nil
+}
+
+// HandleLogMessage writes the JSON document to the socket
+func (e unixEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	jsonBytes, err := json.Marshal(jsonSerializeable)
+	if err != nil {
+		return err
+	}
+	var frame []byte
+	switch {
+	case unixNetwork == "unixgram":
+		frame = jsonBytes
+	case unixLengthPrefixed:
+		frame = make([]byte, 4, 4+len(jsonBytes))
+		binary.BigEndian.PutUint32(frame, uint32(len(jsonBytes)))
+		frame = append(frame, jsonBytes...)
+	default:
+		frame = append(jsonBytes, '\n')
+	}
+
+	unixLock.Lock()
+	defer unixLock.Unlock()
+	// An agent restarting leaves our connection to nothing, which often only
+	// fails on the next write, so each message gets one retry on a fresh one
+	for attempt := 0; ; attempt++ {
+		if unixConn == nil {
+			if err = unixDial(); err != nil {
+				return err
+			}
+		}
+		if _, err = unixConn.Write(frame); err == nil || attempt > 0 {
+			return err
+		}
+		unixConn.Close()
+		unixConn = nil
+	}
+}
+
+// CheckHealth makes sure the agent accepts connections
+func (e unixEmitter) CheckHealth() error {
+	unixLock.Lock()
+	defer unixLock.Unlock()
+	if unixConn != nil {
+		return nil
+	}
+	return unixDial()
+}
+
+func (e unixEmitter) Cleanup() error {
+	unixLock.Lock()
+	defer unixLock.Unlock()
+	if unixConn == nil {
+		return nil
+	}
+	err := unixConn.Close()
+	unixConn = nil
+	return err
+}
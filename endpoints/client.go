@@ -0,0 +1,72 @@
+package endpoints
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/fips"
+	"github.com/RedHatInsights/haberdasher/tlsconfig"
+)
+
+// NewClient returns the HTTP client an emitter should send with. Connections
+// are kept alive and reused between batches, and HTTP/2 is used with servers
+// which offer it over TLS, so a busy emitter isn't doing a TLS handshake per
+// request. It's configured from <prefix>_MAX_CONNS, the most connections to
+// open to each endpoint, <prefix>_IDLE_TIMEOUT, how long an unused connection
+// is kept open, and <prefix>_TIMEOUT, how long a request may take.
+//
+// TLS is configured by tlsconfig.FromEnv, so <prefix>_CA_CERT and friends
+// work as they do for the other emitters.
+//
+// If <prefix>_UNIX_SOCKET is set, every connection goes to that Unix domain
+// socket instead, whatever host the URL names, for node-local agents which
+// accept HTTP over a socket.
+//
+// If <prefix>_OAUTH_TOKEN_URL is set, every request carries an access token
+// from an OAuth2 client credentials grant, for endpoints behind an ingress
+// which checks them.
+func NewClient(prefix string) *http.Client {
+	maxConns, _ := config.IntSetting(prefix+"_MAX_CONNS", 1)
+	idleTimeout, _ := config.DurationSetting(prefix + "_IDLE_TIMEOUT")
+	timeout, _ := config.DurationSetting(prefix + "_TIMEOUT")
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxConnsPerHost:       maxConns,
+		MaxIdleConns:          maxConns * 4,
+		MaxIdleConnsPerHost:   maxConns,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	tlsConfig, err := tlsconfig.FromEnv(prefix)
+	if err != nil {
+		log.Fatal("Invalid ", prefix, " TLS settings: ", err)
+	}
+	// Plain https:// endpoints are restricted in FIPS mode too
+	tlsConfig = fips.TLS(tlsConfig)
+	transport.TLSClientConfig = tlsConfig
+	tokens := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if socket, exists := config.Setting(prefix + "_UNIX_SOCKET"); exists {
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	}
+	return &http.Client{
+		Transport: oauthFromEnv(prefix, transport, tokens),
+		Timeout:   timeout,
+	}
+}
@@ -0,0 +1,277 @@
+// Package endpoints spreads an HTTP-based emitter's requests over several
+// equivalent endpoints, such as regional gateways in front of one backend,
+// keeping track of which of them are healthy.
+package endpoints
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// How long an endpoint is avoided after failing, doubling with each
+// consecutive failure
+const (
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// Strategies for picking the next endpoint
+const (
+	RoundRobin   = "round-robin"
+	LeastPending = "least-pending"
+	Hash         = "hash"
+)
+
+// An Endpoint is one URL requests can go to
+type Endpoint struct {
+	URL string
+
+	clock     clock.Clock
+	pending   int32
+	lock      sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+// Pending returns how many requests to the endpoint haven't finished yet
+func (e *Endpoint) Pending() int {
+	return int(atomic.LoadInt32(&e.pending))
+}
+
+// Healthy reports whether the endpoint is in use, rather than being avoided
+// after failing
+func (e *Endpoint) Healthy() bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.clock.Now().After(e.downUntil)
+}
+
+func (e *Endpoint) record(err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if err == nil {
+		e.failures = 0
+		e.downUntil = time.Time{}
+		return
+	}
+	backoff := minBackoff << uint(e.failures)
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+	e.failures++
+	e.downUntil = e.clock.Now().Add(backoff)
+	log.Println("Endpoint", e.URL, "failed, avoiding it for", backoff.String()+":", err)
+}
+
+// A Balancer picks which endpoint each request goes to. Failed endpoints are
+// avoided for a while, unless they've all failed, so as long as one endpoint
+// is working requests keep flowing.
+type Balancer struct {
+	Endpoints []*Endpoint
+	strategy  string
+	hashLabel string
+	next      uint32
+	clock     clock.Clock
+}
+
+// New returns a Balancer for a list of URLs, on the real clock, using a strategy of RoundRobin,
+// LeastPending, or Hash. With Hash, messages are sent to an endpoint chosen by
+// the value of their hashLabel label, so all the messages with the same value
+// land on the same endpoint while it's healthy.
+func New(urls []string, strategy string, hashLabel string) (*Balancer, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("no endpoints")
+	}
+	switch strategy {
+	case RoundRobin, LeastPending:
+	case Hash:
+		if hashLabel == "" {
+			return nil, errors.New("hashing needs a label to hash")
+		}
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+	b := &Balancer{strategy: strategy, hashLabel: hashLabel, clock: clock.Real}
+	for _, url := range urls {
+		b.Endpoints = append(b.Endpoints, &Endpoint{URL: url, clock: clock.Real})
+	}
+	return b, nil
+}
+
+// SetClock times endpoints' recovery from failures, and retries, by another
+// clock
+func (b *Balancer) SetClock(c clock.Clock) {
+	b.clock = c
+	for _, endpoint := range b.Endpoints {
+		endpoint.lock.Lock()
+		endpoint.clock = c
+		endpoint.lock.Unlock()
+	}
+}
+
+// FromEnv configures a Balancer for an emitter from <prefix>_URL, a comma
+// separated list of endpoints, <prefix>_BALANCE, the strategy, which defaults
+// to round-robin, and for hashing <prefix>_HASH_LABEL. It exits if they're
+// missing or invalid.
+func FromEnv(prefix string) *Balancer {
+	urls, exists := config.Setting(prefix + "_URL")
+	if !exists || urls == "" {
+		log.Fatal(prefix + "_URL must be set to the endpoint, or a comma separated list of endpoints, to send to")
+	}
+	strategy := RoundRobin
+	if fromEnv, exists := config.Setting(prefix + "_BALANCE"); exists {
+		strategy = fromEnv
+	}
+	var list []string
+	for _, url := range strings.Split(urls, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			list = append(list, url)
+		}
+	}
+	hashLabel, _ := config.Setting(prefix + "_HASH_LABEL")
+	b, err := New(list, strategy, hashLabel)
+	if err != nil {
+		log.Fatal("Invalid ", prefix, " endpoints: ", err)
+	}
+	return b
+}
+
+// Group splits a batch of serialized messages by the key they hash to. Unless
+// the Balancer hashes, they're all in one group with an empty key.
+func (b *Balancer) Group(items [][]byte) map[string][][]byte {
+	if b.strategy != Hash {
+		return map[string][][]byte{"": items}
+	}
+	groups := make(map[string][][]byte)
+	for _, item := range items {
+		key := labelValue(item, b.hashLabel)
+		groups[key] = append(groups[key], item)
+	}
+	return groups
+}
+
+// labelValue finds a label in a serialized message, or failing that a top
+// level field of the same name
+func labelValue(item []byte, label string) string {
+	var decoded map[string]interface{}
+	if json.Unmarshal(item, &decoded) != nil {
+		return ""
+	}
+	if labels, ok := decoded["labels"].(map[string]interface{}); ok {
+		if value, ok := labels[label]; ok {
+			return fmt.Sprint(value)
+		}
+	}
+	if value, ok := decoded[label]; ok {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// Do runs a request against an endpoint, recording how it went. If it fails
+// and there are other healthy endpoints, it's retried on them. The key, from
+// Group, only matters when hashing.
+func (b *Balancer) Do(key string, request func(url string) error) error {
+	tried := make(map[*Endpoint]bool)
+	var err error
+	for len(tried) < len(b.Endpoints) {
+		endpoint := b.pick(key, tried)
+		if endpoint == nil {
+			break
+		}
+		tried[endpoint] = true
+		atomic.AddInt32(&endpoint.pending, 1)
+		err = request(endpoint.URL)
+		atomic.AddInt32(&endpoint.pending, -1)
+		endpoint.record(err)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// pick chooses a healthy endpoint which hasn't been tried yet. The first time
+// round, if none are healthy, it chooses the one which will recover soonest.
+func (b *Balancer) pick(key string, tried map[*Endpoint]bool) *Endpoint {
+	var candidates []*Endpoint
+	for _, endpoint := range b.Endpoints {
+		if !tried[endpoint] && endpoint.Healthy() {
+			candidates = append(candidates, endpoint)
+		}
+	}
+	if len(candidates) == 0 {
+		if len(tried) > 0 {
+			return nil
+		}
+		return b.soonestRecovered()
+	}
+
+	switch b.strategy {
+	case Hash:
+		return highestRandomWeight(key, candidates)
+	case LeastPending:
+		best := candidates[0]
+		for _, endpoint := range candidates[1:] {
+			if endpoint.Pending() < best.Pending() {
+				best = endpoint
+			}
+		}
+		return best
+	}
+	return candidates[int(atomic.AddUint32(&b.next, 1)-1)%len(candidates)]
+}
+
+// highestRandomWeight picks the endpoint scoring highest for a key. When an
+// endpoint fails, only its keys move elsewhere, and they come back when it
+// recovers.
+func highestRandomWeight(key string, candidates []*Endpoint) *Endpoint {
+	var best *Endpoint
+	var bestScore uint64
+	for _, endpoint := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(endpoint.URL))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		if score := mix(h.Sum64()); best == nil || score > bestScore {
+			best, bestScore = endpoint, score
+		}
+	}
+	return best
+}
+
+// mix is MurmurHash3's finalizer. FNV's high bits barely depend on the last
+// bytes hashed, so without it the scores for similar URLs and keys compare
+// the same way more often than not, and the keys pile up on one endpoint.
+func mix(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func (b *Balancer) soonestRecovered() *Endpoint {
+	var soonest *Endpoint
+	var soonestAt time.Time
+	for _, endpoint := range b.Endpoints {
+		endpoint.lock.Lock()
+		downUntil := endpoint.downUntil
+		endpoint.lock.Unlock()
+		if soonest == nil || downUntil.Before(soonestAt) {
+			soonest, soonestAt = endpoint, downUntil
+		}
+	}
+	return soonest
+}
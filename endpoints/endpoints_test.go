@@ -0,0 +1,268 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+func newBalancer(t *testing.T, strategy string, hashLabel string, urls ...string) (*Balancer, *clock.Fake) {
+	b, err := New(urls, strategy, hashLabel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := clock.NewFake(time.Unix(0, 0))
+	b.SetClock(fake)
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return b, fake
+}
+
+// failing records the URLs requests go to, failing the ones to down
+func failing(got *[]string, down ...string) func(url string) error {
+	return func(url string) error {
+		*got = append(*got, url)
+		for _, d := range down {
+			if url == d {
+				return errors.New(url + " is down")
+			}
+		}
+		return nil
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		urls     []string
+		strategy string
+		want     string
+	}{
+		{"no endpoints", nil, RoundRobin, "no endpoints"},
+		{"unknown strategy", []string{"a"}, "random", `unknown load balancing strategy "random"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(test.urls, test.strategy, "")
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("New returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	b, _ := newBalancer(t, RoundRobin, "", "a", "b", "c")
+	var got []string
+	for i := 0; i < 6; i++ {
+		if err := b.Do("", failing(&got)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"a", "b", "c", "a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests went to %q, want %q", got, want)
+	}
+}
+
+// A request which fails is retried on another endpoint, and the failed one
+// is left alone for a second, then two, doubling with each failure
+func TestFailedEndpointIsAvoided(t *testing.T) {
+	b, fake := newBalancer(t, RoundRobin, "", "a", "b", "c")
+	var got []string
+	for i := 0; i < 2; i++ {
+		if err := b.Do("", failing(&got, "b")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"a", "b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("requests went to %q, want %q", got, want)
+	}
+
+	got = nil
+	for i := 0; i < 4; i++ {
+		b.Do("", failing(&got, "b"))
+	}
+	if strings.Contains(strings.Join(got, ""), "b") {
+		t.Errorf("requests went to %q while b was failing", got)
+	}
+
+	fake.Advance(time.Second + time.Nanosecond)
+	if !b.Endpoints[1].Healthy() {
+		t.Fatal("b is still avoided after a second")
+	}
+	got = nil
+	for i := 0; i < 3; i++ {
+		b.Do("", failing(&got, "b"))
+	}
+	if !strings.Contains(strings.Join(got, ""), "b") {
+		t.Errorf("requests went to %q once b had recovered", got)
+	}
+
+	fake.Advance(time.Second + time.Nanosecond)
+	if b.Endpoints[1].Healthy() {
+		t.Error("b is used again a second after failing twice")
+	}
+	fake.Advance(time.Second)
+	if !b.Endpoints[1].Healthy() {
+		t.Error("b is still avoided two seconds after failing twice")
+	}
+}
+
+// When every endpoint is being avoided, requests go to the one which will
+// recover soonest rather than nowhere
+func TestAllEndpointsFailed(t *testing.T) {
+	b, fake := newBalancer(t, RoundRobin, "", "a", "b")
+	var got []string
+	b.Do("", failing(&got, "a"))
+	fake.Advance(500 * time.Millisecond)
+	if err := b.Do("", failing(&got, "b")); err == nil || err.Error() != "b is down" {
+		t.Fatalf("Do returned %v, want b's error", err)
+	}
+
+	got = nil
+	if err := b.Do("", failing(&got, "a", "b")); err == nil {
+		t.Error("Do succeeded with every endpoint down")
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests went to %q, want %q", got, want)
+	}
+}
+
+func TestLeastPending(t *testing.T) {
+	b, _ := newBalancer(t, LeastPending, "", "a", "b")
+	started, release := make(chan bool), make(chan bool)
+	done := make(chan error)
+	go func() {
+		done <- b.Do("", func(url string) error {
+			started <- true
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		if err := b.Do("", failing(&got)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests went to %q while a was busy, want %q", got, want)
+	}
+	if b.Endpoints[0].Pending() != 0 {
+		t.Errorf("a has %d requests pending after they finished", b.Endpoints[0].Pending())
+	}
+}
+
+func TestHashNeedsALabel(t *testing.T) {
+	if _, err := New([]string{"a"}, Hash, ""); err == nil || !strings.Contains(err.Error(), "needs a label") {
+		t.Errorf("New returned %v, want an error about the label", err)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	b, _ := newBalancer(t, Hash, "tenant", "a")
+	items := [][]byte{
+		[]byte(`{"message":"one","labels":{"tenant":"acme"}}`),
+		[]byte(`{"message":"two","tenant":"acme"}`),
+		[]byte(`{"message":"three","labels":{"tenant":7}}`),
+		[]byte(`{"message":"four"}`),
+		[]byte(`not JSON`),
+	}
+	got := b.Group(items)
+	want := map[string][][]byte{
+		"acme": {items[0], items[1]},
+		"7":    {items[2]},
+		"":     {items[3], items[4]},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("grouped into %q, want %q", got, want)
+	}
+
+	roundRobin, _ := newBalancer(t, RoundRobin, "", "a")
+	if got := roundRobin.Group(items); len(got) != 1 || len(got[""]) != len(items) {
+		t.Errorf("without hashing, grouped into %q", got)
+	}
+}
+
+// Each key sticks to one endpoint. When that endpoint fails only its keys
+// move, and they move back once it recovers.
+func TestHashIsSticky(t *testing.T) {
+	b, fake := newBalancer(t, Hash, "tenant", "a", "b", "c")
+	keys := make([]string, 30)
+	for i := range keys {
+		keys[i] = fmt.Sprint("tenant-", i)
+	}
+	route := func(down ...string) map[string]string {
+		routes := make(map[string]string)
+		for _, key := range keys {
+			var got []string
+			if err := b.Do(key, failing(&got, down...)); err != nil {
+				t.Fatal(err)
+			}
+			routes[key] = got[len(got)-1]
+		}
+		return routes
+	}
+
+	before := route()
+	if again := route(); !reflect.DeepEqual(again, before) {
+		t.Fatalf("keys moved between batches: %v, then %v", before, again)
+	}
+	used := make(map[string]bool)
+	for _, url := range before {
+		used[url] = true
+	}
+	if len(used) != 3 {
+		t.Fatalf("%d keys only went to %v", len(keys), used)
+	}
+
+	route("b")
+	during := route()
+	for _, key := range keys {
+		switch {
+		case during[key] == "b":
+			t.Errorf("%s went to b while it was failing", key)
+		case before[key] != "b" && during[key] != before[key]:
+			t.Errorf("%s moved from %s to %s when b failed", key, before[key], during[key])
+		}
+	}
+
+	fake.Advance(time.Second + time.Nanosecond)
+	if after := route(); !reflect.DeepEqual(after, before) {
+		t.Errorf("once b recovered keys went to %v, want %v", after, before)
+	}
+}
+
+// Keys are shared out about evenly, even between URLs which differ only by a
+// character
+func TestHashSpreadsKeys(t *testing.T) {
+	urls := []string{"https://gw-1.example.com", "https://gw-2.example.com", "https://gw-3.example.com"}
+	b, _ := newBalancer(t, Hash, "tenant", urls...)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		b.Do(fmt.Sprint("tenant-", i), func(url string) error {
+			counts[url]++
+			return nil
+		})
+	}
+	for _, url := range urls {
+		if counts[url] < 850 || counts[url] > 1150 {
+			t.Errorf("3000 keys were shared out %v", counts)
+			break
+		}
+	}
+}
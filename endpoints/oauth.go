@@ -0,0 +1,178 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// Tokens are refreshed this long before they expire, or at three quarters of
+// their lifetime if that's sooner, so a request made with one doesn't arrive
+// after it has
+const oauthRefreshWindow = time.Minute
+
+// An oauthTransport authenticates every request with an access token from an
+// OAuth2 client credentials grant, caching the token until shortly before it
+// expires
+type oauthTransport struct {
+	base http.RoundTripper
+
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	// basic sends the client's credentials with HTTP Basic authentication,
+	// as RFC 6749 prefers, rather than in the form
+	basic  bool
+	client *http.Client
+
+	lock    sync.Mutex
+	token   string
+	refresh time.Time
+}
+
+// oauthFromEnv wraps base in an oauthTransport if <prefix>_OAUTH_TOKEN_URL is
+// set, configured from it, <prefix>_OAUTH_CLIENT_ID,
+// <prefix>_OAUTH_CLIENT_SECRET, <prefix>_OAUTH_SCOPES, and
+// <prefix>_OAUTH_CLIENT_AUTH. Tokens are fetched through tokens, which has the
+// emitter's TLS settings, but not its Unix socket.
+func oauthFromEnv(prefix string, base, tokens http.RoundTripper) http.RoundTripper {
+	tokenURL, exists := config.Setting(prefix + "_OAUTH_TOKEN_URL")
+	if !exists {
+		return base
+	}
+	if parsed, err := url.Parse(tokenURL); err != nil || parsed.Host == "" {
+		log.Fatal(prefix, "_OAUTH_TOKEN_URL must be an absolute URL")
+	}
+	t := &oauthTransport{
+		base:     base,
+		tokenURL: tokenURL,
+		basic:    true,
+		client:   &http.Client{Transport: tokens, Timeout: 10 * time.Second},
+	}
+	t.clientID, _ = config.Setting(prefix + "_OAUTH_CLIENT_ID")
+	t.clientSecret, _ = config.Setting(prefix + "_OAUTH_CLIENT_SECRET")
+	if t.clientID == "" {
+		log.Fatal(prefix, "_OAUTH_CLIENT_ID is required with ", prefix, "_OAUTH_TOKEN_URL")
+	}
+	scopes, _ := config.Setting(prefix + "_OAUTH_SCOPES")
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			t.scopes = append(t.scopes, scope)
+		}
+	}
+	switch auth, _ := config.Setting(prefix + "_OAUTH_CLIENT_AUTH"); auth {
+	case "", "basic":
+	case "post":
+		t.basic = false
+	default:
+		log.Fatal(prefix, "_OAUTH_CLIENT_AUTH must be basic or post")
+	}
+	return t
+}
+
+// RoundTrip sends the request with a bearer token. If the server rejects the
+// token before it was due to expire, perhaps because it was revoked, a new one
+// is fetched and the request sent once more.
+func (t *oauthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	token, err := t.get(false)
+	if err != nil {
+		return nil, err
+	}
+	response, err := t.base.RoundTrip(withToken(request, token))
+	if err != nil || response.StatusCode != http.StatusUnauthorized || request.GetBody == nil {
+		return response, err
+	}
+	body, err := request.GetBody()
+	if err != nil {
+		return response, nil
+	}
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	if token, err = t.get(true); err != nil {
+		return nil, err
+	}
+	retry := withToken(request, token)
+	retry.Body = body
+	return t.base.RoundTrip(retry)
+}
+
+func withToken(request *http.Request, token string) *http.Request {
+	authenticated := request.Clone(request.Context())
+	authenticated.Header.Set("Authorization", "Bearer "+token)
+	return authenticated
+}
+
+// get returns the cached token while it's fresh, unless renew says it was
+// rejected
+func (t *oauthTransport) get(renew bool) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !renew && t.token != "" && (t.refresh.IsZero() || time.Now().Before(t.refresh)) {
+		return t.token, nil
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(t.scopes) > 0 {
+		form.Set("scope", strings.Join(t.scopes, " "))
+	}
+	if !t.basic {
+		form.Set("client_id", t.clientID)
+		form.Set("client_secret", t.clientSecret)
+	}
+	request, err := http.NewRequest(http.MethodPost, t.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("OAuth token: %v", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if t.basic {
+		request.SetBasicAuth(url.QueryEscape(t.clientID), url.QueryEscape(t.clientSecret))
+	}
+	fetched := time.Now()
+	response, err := t.client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("OAuth token: %v", err)
+	}
+	defer response.Body.Close()
+	var reply struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err := json.Unmarshal(body, &reply); err != nil && response.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("OAuth token: %s: %v", t.tokenURL, err)
+	}
+	if response.StatusCode != http.StatusOK || reply.AccessToken == "" {
+		detail := reply.Error
+		if reply.ErrorDescription != "" {
+			detail += ": " + reply.ErrorDescription
+		}
+		if detail == "" {
+			detail = strings.TrimSpace(string(body))
+		}
+		return "", fmt.Errorf("OAuth token: %s: %s %s", t.tokenURL, response.Status, detail)
+	}
+	t.token = reply.AccessToken
+	// A token without a lifetime is used until it's rejected
+	t.refresh = time.Time{}
+	if reply.ExpiresIn > 0 {
+		lifetime := time.Duration(reply.ExpiresIn) * time.Second
+		window := lifetime / 4
+		if window > oauthRefreshWindow {
+			window = oauthRefreshWindow
+		}
+		t.refresh = fetched.Add(lifetime - window)
+	}
+	return t.token, nil
+}
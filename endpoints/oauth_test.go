@@ -0,0 +1,247 @@
+package endpoints
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeIssuer hands out access-1, access-2, and so on, each lasting
+// expiresIn seconds, to the client my client with the secret s&cret
+type fakeIssuer struct {
+	lock      sync.Mutex
+	expiresIn int
+	issued    int
+	forms     []url.Values
+	basic     []bool
+}
+
+func startFakeIssuer(t *testing.T, expiresIn int) (*fakeIssuer, string) {
+	issuer := &fakeIssuer{expiresIn: expiresIn}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issuer.lock.Lock()
+		defer issuer.lock.Unlock()
+		r.ParseForm()
+		issuer.forms = append(issuer.forms, r.PostForm)
+		id, secret, basic := r.BasicAuth()
+		issuer.basic = append(issuer.basic, basic)
+		if basic {
+			id, _ = url.QueryUnescape(id)
+			secret, _ = url.QueryUnescape(secret)
+		} else {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("grant_type") != "client_credentials" || id != "my client" || secret != "s&cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"unknown client"}`)
+			return
+		}
+		issuer.issued++
+		fmt.Fprintf(w, `{"access_token":"access-%d","token_type":"Bearer","expires_in":%d}`, issuer.issued, issuer.expiresIn)
+	}))
+	t.Cleanup(server.Close)
+	return issuer, server.URL + "/token"
+}
+
+// startResource starts a server which records the bearer tokens it's sent,
+// rejecting the ones in revoked
+func startResource(t *testing.T, revoked ...string) (*[]string, string) {
+	var lock sync.Mutex
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		tokens = append(tokens, token)
+		for _, rejected := range revoked {
+			if token == rejected {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		if body, _ := ioutil.ReadAll(r.Body); string(body) != "payload" {
+			http.Error(w, "sent "+string(body), http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &tokens, server.URL
+}
+
+func newOAuthTransport(tokenURL string, basic bool, scopes ...string) *oauthTransport {
+	return &oauthTransport{
+		base:         http.DefaultTransport,
+		tokenURL:     tokenURL,
+		clientID:     "my client",
+		clientSecret: "s&cret",
+		scopes:       scopes,
+		basic:        basic,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func post(transport http.RoundTripper, url string) error {
+	response, err := (&http.Client{Transport: transport}).Post(url, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		return err
+	}
+	return CheckResponse(url, response)
+}
+
+func TestOAuthClientAuthentication(t *testing.T) {
+	for _, basic := range []bool{true, false} {
+		issuer, tokenURL := startFakeIssuer(t, 3600)
+		tokens, resource := startResource(t)
+		if err := post(newOAuthTransport(tokenURL, basic, "logs.write", "metrics"), resource); err != nil {
+			t.Fatal(err)
+		}
+		if issuer.basic[0] != basic {
+			t.Errorf("with basic %v, the client authenticated with HTTP Basic %v", basic, issuer.basic[0])
+		}
+		if _, inForm := issuer.forms[0]["client_secret"]; inForm == basic {
+			t.Errorf("with basic %v, the form was %v", basic, issuer.forms[0])
+		}
+		if scope := issuer.forms[0].Get("scope"); scope != "logs.write metrics" {
+			t.Errorf("asked for scope %q", scope)
+		}
+		if len(*tokens) != 1 || (*tokens)[0] != "access-1" {
+			t.Errorf("sent tokens %q, want access-1", *tokens)
+		}
+	}
+}
+
+// A token is used until it's nearly expired
+func TestOAuthCachesTokens(t *testing.T) {
+	issuer, tokenURL := startFakeIssuer(t, 3600)
+	tokens, resource := startResource(t)
+	transport := newOAuthTransport(tokenURL, true)
+	for i := 0; i < 3; i++ {
+		if err := post(transport, resource); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if issuer.issued != 1 {
+		t.Errorf("fetched %d tokens for three requests", issuer.issued)
+	}
+	if until := time.Until(transport.refresh); until < 58*time.Minute || until > 59*time.Minute {
+		t.Errorf("an hour's token is refreshed in %s, want a minute early", until)
+	}
+
+	transport.refresh = time.Now().Add(-time.Second)
+	if err := post(transport, resource); err != nil {
+		t.Fatal(err)
+	}
+	if want := "access-1 access-1 access-1 access-2"; strings.Join(*tokens, " ") != want {
+		t.Errorf("sent tokens %q, want %s", *tokens, want)
+	}
+}
+
+func TestOAuthRefreshWindow(t *testing.T) {
+	tests := []struct {
+		expiresIn int
+		// How long before it expires a token is replaced
+		want time.Duration
+	}{
+		{3600, time.Minute},
+		{120, 30 * time.Second},
+		{0, 0},
+	}
+	for _, test := range tests {
+		_, tokenURL := startFakeIssuer(t, test.expiresIn)
+		transport := newOAuthTransport(tokenURL, true)
+		before := time.Now()
+		if _, err := transport.get(false); err != nil {
+			t.Fatal(err)
+		}
+		if test.expiresIn == 0 {
+			if !transport.refresh.IsZero() {
+				t.Errorf("a token without a lifetime is refreshed at %s", transport.refresh)
+			}
+			continue
+		}
+		expires := before.Add(time.Duration(test.expiresIn) * time.Second)
+		if early := expires.Sub(transport.refresh); early > test.want || early < test.want-time.Second {
+			t.Errorf("a token lasting %ds is replaced %s early, want %s", test.expiresIn, early, test.want)
+		}
+	}
+}
+
+// A token rejected before it was due to expire is replaced, and the request
+// sent again with its body
+func TestOAuthRejectedToken(t *testing.T) {
+	issuer, tokenURL := startFakeIssuer(t, 3600)
+	tokens, resource := startResource(t, "access-1")
+	if err := post(newOAuthTransport(tokenURL, true), resource); err != nil {
+		t.Fatal(err)
+	}
+	if want := "access-1 access-2"; strings.Join(*tokens, " ") != want || issuer.issued != 2 {
+		t.Errorf("sent tokens %q, want %s", *tokens, want)
+	}
+
+	tokens, resource = startResource(t, "access-3", "access-4")
+	err := post(newOAuthTransport(tokenURL, true), resource)
+	if status, ok := err.(*StatusError); !ok || status.StatusCode != http.StatusUnauthorized {
+		t.Errorf("returned %v, want the second rejection", err)
+	}
+	if len(*tokens) != 2 {
+		t.Errorf("sent tokens %q, want two tries", *tokens)
+	}
+}
+
+func TestOAuthTokenErrors(t *testing.T) {
+	_, tokenURL := startFakeIssuer(t, 3600)
+	wrongSecret := newOAuthTransport(tokenURL, true)
+	wrongSecret.clientSecret = "guess"
+
+	notJSON := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>sign in</html>")
+	}))
+	defer notJSON.Close()
+	noToken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"token_type":"Bearer"}`)
+	}))
+	defer noToken.Close()
+
+	tests := []struct {
+		name      string
+		transport *oauthTransport
+		want      string
+	}{
+		{"wrong secret", wrongSecret, "401 Unauthorized invalid_client: unknown client"},
+		{"not JSON", newOAuthTransport(notJSON.URL, true), "invalid character"},
+		{"no token", newOAuthTransport(noToken.URL, true), `200 OK {"token_type":"Bearer"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.transport.get(false)
+			if err == nil || !strings.HasPrefix(err.Error(), "OAuth token: ") || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestOAuthFromEnv(t *testing.T) {
+	base := http.DefaultTransport
+	if got := oauthFromEnv("HABERDASHER_TEST", base, base); got != base {
+		t.Errorf("without a token URL, wrapped the transport in %T", got)
+	}
+
+	t.Setenv("HABERDASHER_TEST_OAUTH_TOKEN_URL", "https://sso.example.com/token")
+	t.Setenv("HABERDASHER_TEST_OAUTH_CLIENT_ID", "my client")
+	t.Setenv("HABERDASHER_TEST_OAUTH_SCOPES", " logs.write, ,metrics")
+	t.Setenv("HABERDASHER_TEST_OAUTH_CLIENT_AUTH", "post")
+	transport, ok := oauthFromEnv("HABERDASHER_TEST", base, base).(*oauthTransport)
+	if !ok {
+		t.Fatal("with a token URL, didn't authenticate")
+	}
+	if transport.basic || strings.Join(transport.scopes, " ") != "logs.write metrics" {
+		t.Errorf("configured basic %v, scopes %q", transport.basic, transport.scopes)
+	}
+}
@@ -0,0 +1,93 @@
+package endpoints
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The most a Retry-After header can make us wait before retrying
+const maxRetryAfter = 5 * time.Minute
+
+// A StatusError is a response which wasn't a success
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Detail     string
+	// RetryAfter is how long the server asked us to wait, if it did
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %s", e.URL, e.Status)
+	}
+	return fmt.Sprintf("%s: %s %s", e.URL, e.Status, e.Detail)
+}
+
+// Retryable reports whether the request may succeed if it's sent again: the
+// server is overloaded (429) or failed (5xx)
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// CheckResponse drains and closes a response's body, so the connection can be
+// reused, and returns a StatusError unless it was a success
+func CheckResponse(url string, response *http.Response) error {
+	defer response.Body.Close()
+	detail, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return nil
+	}
+	err := &StatusError{
+		URL:        url,
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Detail:     string(bytes.TrimSpace(detail)),
+	}
+	if header := response.Header.Get("Retry-After"); header != "" {
+		if seconds, parseErr := strconv.Atoi(header); parseErr == nil && seconds >= 0 {
+			err.RetryAfter = time.Duration(seconds) * time.Second
+		} else if at, parseErr := http.ParseTime(header); parseErr == nil {
+			err.RetryAfter = time.Until(at)
+		}
+		if err.RetryAfter > maxRetryAfter {
+			err.RetryAfter = maxRetryAfter
+		}
+	}
+	return err
+}
+
+// Retry is Do, repeated up to attempts times in all while the request fails
+// with a retryable status or can't reach the server. Between attempts it waits
+// as long as the server's Retry-After header asks, or else backs off from a
+// second, doubling each time.
+func (b *Balancer) Retry(key string, attempts int, request func(url string) error) error {
+	backoff := minBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = b.Do(key, request)
+		if err == nil || attempt >= attempts {
+			return err
+		}
+		wait := backoff
+		if status, ok := err.(*StatusError); ok {
+			if !status.Retryable() {
+				return err
+			}
+			if status.RetryAfter > 0 {
+				wait = status.RetryAfter
+			}
+		}
+		b.clock.Sleep(wait)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package endpoints
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+// A sleeper is a fake clock which notes how long it's asked to sleep, then
+// moves on without waiting
+type sleeper struct {
+	*clock.Fake
+	slept []time.Duration
+}
+
+func (s *sleeper) Sleep(d time.Duration) {
+	s.slept = append(s.slept, d)
+	s.Advance(d)
+}
+
+func TestRetry(t *testing.T) {
+	unavailable := &StatusError{StatusCode: 503, Status: "503 Service Unavailable"}
+	badRequest := &StatusError{StatusCode: 400, Status: "400 Bad Request"}
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		attempts int
+		// The last result repeats
+		results   []error
+		wantCalls int
+		wantSlept []time.Duration
+		wantErr   error
+	}{
+		{"success", 3, []error{nil}, 1, nil, nil},
+		{"succeeds on the third attempt", 5, []error{unavailable, refused, nil}, 3, []time.Duration{time.Second, 2 * time.Second}, nil},
+		{"gives up", 3, []error{refused}, 3, []time.Duration{time.Second, 2 * time.Second}, refused},
+		{"backs off no further than 30s", 8, []error{unavailable}, 8, []time.Duration{
+			time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second,
+		}, unavailable},
+		{"Retry-After", 2, []error{&StatusError{StatusCode: 429, RetryAfter: 7 * time.Second}, nil}, 2, []time.Duration{7 * time.Second}, nil},
+		{"not retryable", 3, []error{badRequest}, 1, nil, badRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, _ := newBalancer(t, RoundRobin, "", "a")
+			s := &sleeper{Fake: clock.NewFake(time.Unix(0, 0))}
+			b.SetClock(s)
+			calls := 0
+			err := b.Retry("", test.attempts, func(string) error {
+				result := test.results[len(test.results)-1]
+				if calls < len(test.results) {
+					result = test.results[calls]
+				}
+				calls++
+				return result
+			})
+			if err != test.wantErr {
+				t.Errorf("Retry returned %v, want %v", err, test.wantErr)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("made %d attempts, want %d", calls, test.wantCalls)
+			}
+			if !reflect.DeepEqual(s.slept, test.wantSlept) {
+				t.Errorf("slept %v, want %v", s.slept, test.wantSlept)
+			}
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name           string
+		code           int
+		retryAfter     string
+		body           string
+		wantErr        string
+		wantRetryable  bool
+		wantRetryAfter time.Duration
+	}{
+		{"success", 204, "", "", "", false, 0},
+		{"bad request", 400, "", "  no such stream\n", "http://a: 400 Bad Request no such stream", false, 0},
+		{"overloaded", 429, "3", "", "http://a: 429 Too Many Requests", true, 3 * time.Second},
+		{"failed", 502, "", "", "http://a: 502 Bad Gateway", true, 0},
+		{"Retry-After capped", 503, "3600", "", "http://a: 503 Service Unavailable", true, 5 * time.Minute},
+		{"unparseable Retry-After", 503, "soon", "", "http://a: 503 Service Unavailable", true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := &http.Response{
+				StatusCode: test.code,
+				Status:     fmt.Sprint(test.code, " ", http.StatusText(test.code)),
+				Header:     make(http.Header),
+				Body:       ioutil.NopCloser(strings.NewReader(test.body)),
+			}
+			if test.retryAfter != "" {
+				response.Header.Set("Retry-After", test.retryAfter)
+			}
+			err := CheckResponse("http://a", response)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("returned %v", err)
+				}
+				return
+			}
+			status, ok := err.(*StatusError)
+			if !ok {
+				t.Fatalf("returned %v, want a StatusError", err)
+			}
+			if status.Error() != test.wantErr {
+				t.Errorf("returned %q, want %q", status, test.wantErr)
+			}
+			if status.Retryable() != test.wantRetryable {
+				t.Errorf("retryable is %v", status.Retryable())
+			}
+			if status.RetryAfter != test.wantRetryAfter {
+				t.Errorf("retry after %v, want %v", status.RetryAfter, test.wantRetryAfter)
+			}
+		})
+	}
+}
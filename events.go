@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// routeEvents sends haberdasher's own events, like startup-mode and summary,
+// to HABERDASHER_EVENTS_EMITTER if it's set, rather than mixing them in with
+// the child's logs. Setting HABERDASHER_EVENTS_SELF_LOG sends our log output
+// there too.
+func routeEvents() {
+	name, exists := config.Setting("HABERDASHER_EVENTS_EMITTER")
+	if !exists {
+		if config.FlagSetting("HABERDASHER_EVENTS_SELF_LOG") {
+			log.Fatal("HABERDASHER_EVENTS_SELF_LOG needs HABERDASHER_EVENTS_EMITTER")
+		}
+		return
+	}
+	emitter, err := logging.Lookup(name)
+	if err != nil {
+		log.Fatal("Invalid HABERDASHER_EVENTS_EMITTER: ", err)
+	}
+	setupLock.Lock()
+	setUp(emitter)
+	setupLock.Unlock()
+	logging.RouteEvents(emitter)
+	if config.FlagSetting("HABERDASHER_EVENTS_SELF_LOG") {
+		logging.CaptureSelfLog()
+	}
+}
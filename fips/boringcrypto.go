@@ -0,0 +1,10 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package fips
+
+// Importing fipsonly makes crypto/tls refuse anything else, whatever a
+// configuration asks for
+import _ "crypto/tls/fipsonly"
+
+const boringCrypto = true
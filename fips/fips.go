@@ -0,0 +1,83 @@
+// Package fips restricts Haberdasher to FIPS 140 approved cryptography, for
+// deployments which require it. FIPS mode is on when HABERDASHER_FIPS is set,
+// in builds with BoringCrypto, and when Go's own FIPS 140 module is enabled.
+package fips
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Enabled reports whether FIPS mode is on
+func Enabled() bool {
+	return required() || boringCrypto || goFIPS()
+}
+
+// Mode describes why FIPS mode is on, or returns "" if it isn't
+func Mode() string {
+	switch {
+	case boringCrypto:
+		return "BoringCrypto"
+	case goFIPS():
+		return "Go FIPS 140 module"
+	case required():
+		return "HABERDASHER_FIPS"
+	}
+	return ""
+}
+
+// HABERDASHER_FIPS is read when it's needed, since the command line and
+// configuration file may set it after we're initialized. It has no default,
+// and config depends on this package, so it's read from the environment.
+func required() bool {
+	return os.Getenv("HABERDASHER_FIPS") != ""
+}
+
+// Check returns an error if FIPS mode is on, for a feature which needs
+// cryptography that isn't approved
+func Check(feature string) error {
+	if !Enabled() {
+		return nil
+	}
+	return fmt.Errorf("%s isn't FIPS approved, and FIPS mode is on (%s)", feature, Mode())
+}
+
+// The approved TLS 1.2 cipher suites, all ECDHE with AES-GCM
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var curves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// TLS restricts a TLS configuration to approved versions, cipher suites, and
+// curves, if FIPS mode is on, and returns it. A nil configuration, for Go's
+// defaults, gets a restricted one of its own.
+//
+// TLS 1.3 is left out, since Go doesn't let its cipher suites, which include
+// ChaCha20-Poly1305, be chosen.
+func TLS(config *tls.Config) *tls.Config {
+	if !Enabled() {
+		return config
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config.MinVersion = tls.VersionTLS12
+	config.MaxVersion = tls.VersionTLS12
+	config.CipherSuites = cipherSuites
+	config.CurvePreferences = curves
+	return config
+}
+
+// RestrictDefaultTransport restricts the TLS of http.DefaultTransport, which
+// the cloud credential providers use, if FIPS mode is on
+func RestrictDefaultTransport() {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok && Enabled() {
+		transport.TLSClientConfig = TLS(transport.TLSClientConfig)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build go1.24
+// +build go1.24
+
+package fips
+
+import "crypto/fips140"
+
+// goFIPS reports whether Go's FIPS 140 module is enabled, as it is with
+// GODEBUG=fips140=on
+func goFIPS() bool {
+	return fips140.Enabled()
+}
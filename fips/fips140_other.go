@@ -0,0 +1,9 @@
+//go:build !go1.24
+// +build !go1.24
+
+package fips
+
+// Go's FIPS 140 module is new in Go 1.24
+func goFIPS() bool {
+	return false
+}
@@ -0,0 +1,141 @@
+package fips
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// withFIPS turns FIPS mode on or off with HABERDASHER_FIPS, skipping the test
+// if the build has it on regardless
+func withFIPS(t *testing.T, on bool) {
+	if boringCrypto || goFIPS() {
+		t.Skip("FIPS mode is always on in this build")
+	}
+	t.Setenv("HABERDASHER_FIPS", "1")
+	if !on {
+		os.Unsetenv("HABERDASHER_FIPS")
+	}
+}
+
+// withDefaultTransport swaps http.DefaultTransport for a copy trusting
+// server, putting it back afterwards
+func withDefaultTransport(t *testing.T, server *httptest.Server) *http.Transport {
+	original := http.DefaultTransport
+	t.Cleanup(func() { http.DefaultTransport = original })
+	transport := original.(*http.Transport).Clone()
+	transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	http.DefaultTransport = transport
+	return transport
+}
+
+func startTLSServer(t *testing.T, config func(*tls.Config)) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(tls.VersionName(r.TLS.Version) + " " + tls.CipherSuiteName(r.TLS.CipherSuite)))
+	}))
+	// Refused handshakes aren't worth logging
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.TLS = &tls.Config{}
+	config(server.TLS)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOff(t *testing.T) {
+	withFIPS(t, false)
+	if Enabled() || Mode() != "" {
+		t.Errorf("enabled %v, in mode %q", Enabled(), Mode())
+	}
+	if err := Check("MD5"); err != nil {
+		t.Error(err)
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS13}
+	if got := TLS(config); got != config || got.MaxVersion != 0 || got.CipherSuites != nil {
+		t.Errorf("changed the configuration to %+v", got)
+	}
+	if TLS(nil) != nil {
+		t.Error("replaced Go's default configuration")
+	}
+
+	server := startTLSServer(t, func(*tls.Config) {})
+	transport := withDefaultTransport(t, server)
+	RestrictDefaultTransport()
+	if transport.TLSClientConfig.MaxVersion != 0 || transport.TLSClientConfig.CipherSuites != nil {
+		t.Errorf("restricted the default transport to %+v", transport.TLSClientConfig)
+	}
+}
+
+func TestOn(t *testing.T) {
+	withFIPS(t, true)
+	if !Enabled() || Mode() != "HABERDASHER_FIPS" {
+		t.Errorf("enabled %v, in mode %q", Enabled(), Mode())
+	}
+	if err := Check("MD5"); err == nil || !strings.Contains(err.Error(), "MD5 isn't FIPS approved") {
+		t.Errorf("Check returned %v", err)
+	}
+	for _, config := range []*tls.Config{nil, {MinVersion: tls.VersionTLS13, ServerName: "kept"}} {
+		got := TLS(config)
+		if got.MinVersion != tls.VersionTLS12 || got.MaxVersion != tls.VersionTLS12 || len(got.CipherSuites) != 4 || len(got.CurvePreferences) != 3 {
+			t.Errorf("restricted the configuration to %+v", got)
+		}
+		if config != nil && got.ServerName != "kept" {
+			t.Error("lost the rest of the configuration")
+		}
+	}
+}
+
+// Once the default transport is restricted, it only connects with TLS 1.2
+// and an approved cipher suite, still trusting what it did before
+func TestRestrictDefaultTransport(t *testing.T) {
+	withFIPS(t, true)
+	tests := []struct {
+		name   string
+		server func(*tls.Config)
+		// want is the connection's version and cipher suite, or "" if it
+		// shouldn't connect at all
+		want string
+	}{
+		{"anything", func(*tls.Config) {}, "TLS 1.2 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		{"AES-256", func(c *tls.Config) {
+			c.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}
+		}, "TLS 1.2 TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+		{"TLS 1.3 only", func(c *tls.Config) { c.MinVersion = tls.VersionTLS13 }, ""},
+		{"ChaCha20 only", func(c *tls.Config) {
+			c.MaxVersion = tls.VersionTLS12
+			c.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}
+		}, ""},
+		{"X25519 only", func(c *tls.Config) { c.CurvePreferences = []tls.CurveID{tls.X25519} }, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startTLSServer(t, test.server)
+			withDefaultTransport(t, server)
+			RestrictDefaultTransport()
+			response, err := http.Get(server.URL)
+			if test.want == "" {
+				if err == nil {
+					response.Body.Close()
+					t.Error("connected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			got, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("connected with %s, want %s", got, test.want)
+			}
+		})
+	}
+}
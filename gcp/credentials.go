@@ -0,0 +1,256 @@
+// Package gcp finds Google Cloud credentials the way the Google client
+// libraries do, and exchanges them for OAuth2 access tokens, for the emitters
+// which talk to Google Cloud APIs directly.
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tokens are refreshed this long before they expire, so a request made with
+// one doesn't arrive after it has
+const refreshWindow = 5 * time.Minute
+
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// A Token is an OAuth2 access token and when it expires
+type Token struct {
+	AccessToken string
+	Expires     time.Time
+	Source      string
+}
+
+// A TokenSource gets access tokens from the first of:
+//
+//  1. the credentials file GOOGLE_APPLICATION_CREDENTIALS names, a service
+//     account key or a user's credentials
+//  2. gcloud's application default credentials,
+//     ~/.config/gcloud/application_default_credentials.json
+//  3. the GCE or GKE metadata server, for the instance's or workload
+//     identity's service account
+//
+// and caches them until shortly before they expire.
+type TokenSource struct {
+	client *http.Client
+	scopes []string
+
+	lock   sync.Mutex
+	cached *Token
+}
+
+// credentialsFile is a service account key or authorized user's credentials,
+// whichever its type says
+type credentialsFile struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+	QuotaProject string `json:"quota_project_id"`
+}
+
+// NewTokenSource returns a TokenSource for tokens with the given scopes
+func NewTokenSource(scopes ...string) *TokenSource {
+	return &TokenSource{client: &http.Client{Timeout: 10 * time.Second}, scopes: scopes}
+}
+
+// Token returns an access token, from the cache while it's fresh
+func (s *TokenSource) Token() (Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.cached != nil && time.Until(s.cached.Expires) > refreshWindow {
+		return *s.cached, nil
+	}
+	file, path, err := findCredentialsFile()
+	if err != nil {
+		return Token{}, err
+	}
+	var token *Token
+	switch {
+	case file == nil:
+		token, err = s.fromMetadata()
+	case file.Type == "service_account":
+		token, err = s.fromServiceAccount(file)
+	case file.Type == "authorized_user":
+		token, err = s.fromAuthorizedUser(file)
+	default:
+		err = fmt.Errorf("%s: unsupported credentials type %q", path, file.Type)
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	s.cached = token
+	return *token, nil
+}
+
+// ProjectID returns the project to use: GOOGLE_CLOUD_PROJECT, the service
+// account key's project, or the metadata server's
+func ProjectID() (string, error) {
+	if project := os.Getenv("GOOGLE_CLOUD_PROJECT"); project != "" {
+		return project, nil
+	}
+	if file, _, err := findCredentialsFile(); err != nil {
+		return "", err
+	} else if file != nil {
+		if file.ProjectID != "" {
+			return file.ProjectID, nil
+		}
+		if file.QuotaProject != "" {
+			return file.QuotaProject, nil
+		}
+	}
+	return Metadata("project/project-id")
+}
+
+// findCredentialsFile reads the credentials file, returning nil if there
+// isn't one
+func findCredentialsFile() (*credentialsFile, string, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, "", nil
+		}
+		path = filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, path, fmt.Errorf("Google credentials: %v", err)
+	}
+	var file credentialsFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, path, fmt.Errorf("Google credentials: %s: %v", path, err)
+	}
+	if file.TokenURI == "" {
+		file.TokenURI = defaultTokenURI
+	}
+	return &file, path, nil
+}
+
+// fromServiceAccount exchanges a JWT signed with the service account's key
+// for a token
+func (s *TokenSource) fromServiceAccount(file *credentialsFile) (*Token, error) {
+	block, _ := pem.Decode([]byte(file.PrivateKey))
+	if block == nil {
+		return nil, errors.New("service account: the private key isn't PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("service account: %v", err)
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("service account: the private key isn't RSA")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": file.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   file.ClientEmail,
+		"scope": strings.Join(s.scopes, " "),
+		"aud":   file.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("service account: %v", err)
+	}
+	token, err := s.exchange(file.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("service account: %v", err)
+	}
+	token.Source = "service account " + file.ClientEmail
+	return token, nil
+}
+
+// fromAuthorizedUser exchanges a user's refresh token for a token
+func (s *TokenSource) fromAuthorizedUser(file *credentialsFile) (*Token, error) {
+	token, err := s.exchange(file.TokenURI, url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {file.ClientID},
+		"client_secret": {file.ClientSecret},
+		"refresh_token": {file.RefreshToken},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("authorized user: %v", err)
+	}
+	token.Source = "authorized user"
+	return token, nil
+}
+
+func (s *TokenSource) exchange(tokenURI string, form url.Values) (*Token, error) {
+	response, err := s.client.PostForm(tokenURI, form)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return parseToken(body)
+}
+
+func (s *TokenSource) fromMetadata() (*Token, error) {
+	path := "instance/service-accounts/default/token"
+	if len(s.scopes) > 0 {
+		path += "?scopes=" + url.QueryEscape(strings.Join(s.scopes, ","))
+	}
+	body, err := Metadata(path)
+	if err != nil {
+		return nil, fmt.Errorf("no Google credentials found: %v", err)
+	}
+	token, err := parseToken([]byte(body))
+	if err != nil {
+		return nil, fmt.Errorf("metadata server: %v", err)
+	}
+	token.Source = "metadata server"
+	return token, nil
+}
+
+func parseToken(body []byte) (*Token, error) {
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	if result.AccessToken == "" {
+		return nil, errors.New("no access token in the response")
+	}
+	return &Token{AccessToken: result.AccessToken, Expires: time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)}, nil
+}
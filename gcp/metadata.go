@@ -0,0 +1,49 @@
+package gcp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultMetadataHost = "metadata.google.internal"
+
+// Off Google Cloud there's no metadata server, so don't wait long to find out
+var metadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// ErrNoMetadata is returned for metadata the server doesn't have
+var ErrNoMetadata = errors.New("not in the metadata")
+
+// Metadata fetches path, like "project/project-id", from the GCE or GKE
+// metadata server, at GCE_METADATA_HOST if that's set
+func Metadata(path string) (string, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	request, err := http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+	response, err := metadataClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 1<<20))
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return "", ErrNoMetadata
+	case response.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("metadata server: %s", response.Status)
+	case response.Header.Get("Metadata-Flavor") != "Google":
+		return "", errors.New("metadata server: not a Google metadata server")
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/RedHatInsights/haberdasher/admin"
+	"github.com/RedHatInsights/haberdasher/buildinfo"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// An inventory is what's live in this process: where lines come from, the
+// rules they pass through, and where they go, each with a hash of its
+// settings. Fleet tooling compares them across pods to audit which policies
+// are actually in force. Hash covers the whole inventory, so pods with the
+// same one run the same build configured alike.
+type inventory struct {
+	Version  string             `json:"version"`
+	Commit   string             `json:"commit"`
+	Inputs   []inventoryInput   `json:"inputs"`
+	Filters  []inventoryFilter  `json:"filters"`
+	Emitters []inventoryEmitter `json:"emitters"`
+	Hash     string             `json:"hash"`
+}
+
+type inventoryInput struct {
+	Type   string `json:"type"`
+	Path   string `json:"path,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+type inventoryFilter struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Emitter string `json:"emitter,omitempty"`
+	Hash    string `json:"hash"`
+}
+
+type inventoryEmitter struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// takeInventory lists what's live now
+func takeInventory(child *supervisor) inventory {
+	info := buildinfo.Get()
+	inv := inventory{
+		Version:  info.Version,
+		Commit:   info.Commit,
+		Inputs:   []inventoryInput{},
+		Filters:  []inventoryFilter{},
+		Emitters: []inventoryEmitter{},
+	}
+
+	if child.piped {
+		inv.Inputs = append(inv.Inputs, inventoryInput{Type: "stdin"})
+	}
+	if len(child.argv) > 0 {
+		if child.rawTee != nil {
+			inv.Inputs = append(inv.Inputs, inventoryInput{Type: "raw-tee"})
+		} else {
+			inv.Inputs = append(inv.Inputs, inventoryInput{Type: "stderr"})
+		}
+		if child.captureStdout {
+			inv.Inputs = append(inv.Inputs, inventoryInput{Type: "stdout"})
+		}
+	}
+	if paths, exists := config.Setting("HABERDASHER_TAIL_FILES"); exists {
+		format, _ := config.Setting("HABERDASHER_TAIL_FORMAT")
+		for _, path := range strings.Split(paths, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				inv.Inputs = append(inv.Inputs, inventoryInput{Type: "file", Path: path, Format: format})
+			}
+		}
+	}
+	if dir, exists := config.Setting("HABERDASHER_PODS_DIR"); exists {
+		inv.Inputs = append(inv.Inputs, inventoryInput{Type: "pods", Path: dir})
+	}
+
+	for _, redaction := range logging.Redactions() {
+		inv.Filters = append(inv.Filters, inventoryFilter{Type: "redaction", Name: redaction.Name, Hash: config.Hash(redaction)})
+	}
+	child.policyLock.RLock()
+	for _, source := range child.virtual {
+		inv.Filters = append(inv.Filters, inventoryFilter{
+			Type:    "virtual-source",
+			Name:    source.Name,
+			Emitter: source.Emitter,
+			Hash:    config.Hash(source),
+		})
+	}
+	child.policyLock.RUnlock()
+
+	setupLock.Lock()
+	for name, emitter := range logging.Emitters {
+		if setupEmitters[emitter] {
+			inv.Emitters = append(inv.Emitters, inventoryEmitter{Name: name, Hash: config.SectionHash(name)})
+		}
+	}
+	setupLock.Unlock()
+	sort.Slice(inv.Emitters, func(i, j int) bool { return inv.Emitters[i].Name < inv.Emitters[j].Name })
+
+	inv.Hash = config.Hash(inv)
+	return inv
+}
+
+// emitInventory sends the inventory as an inventory event, with the document
+// itself in the haberdasher_inventory label
+func emitInventory(child *supervisor, emitter logging.Emitter) {
+	inv := takeInventory(child)
+	document, err := json.Marshal(inv)
+	if err != nil {
+		log.Println("Error encoding the inventory:", err)
+		return
+	}
+	text := fmt.Sprintf("Inventory: %d inputs, %d filters, and %d emitters live (%s)",
+		len(inv.Inputs), len(inv.Filters), len(inv.Emitters), inv.Hash)
+	log.Println(text)
+	m := logging.NewEvent("inventory", text)
+	m.AddLabel("haberdasher_inventory", string(document))
+	m.AddLabel("haberdasher_inventory_hash", inv.Hash)
+	if routed := logging.RoutedEvents(); routed != nil {
+		emitter = routed
+	}
+	if err := emitter.HandleLogMessage(m); err != nil {
+		log.Println("Error emitting the inventory:", err)
+	}
+}
+
+// handleInventory serves the inventory on the admin listener at /inventory
+func handleInventory(child *supervisor) {
+	admin.Handle("/inventory", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(takeInventory(child))
+	}))
+}
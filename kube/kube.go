@@ -0,0 +1,142 @@
+package kube
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/fips"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ErrNotFound is returned when the requested object doesn't exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when an update's resourceVersion is no longer the
+// object's, because someone else has updated it since it was read, or a
+// create's object already exists
+var ErrConflict = errors.New("conflict")
+
+// A Client talks to the Kubernetes API server using the pod's service account.
+// It only knows enough of the API for haberdasher's needs.
+type Client struct {
+	host       string
+	httpClient *http.Client
+}
+
+// InCluster creates a Client from the environment Kubernetes provides to every
+// pod, or returns an error if we aren't running in a cluster.
+func InCluster() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster")
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("unable to parse the service account CA")
+	}
+	return &Client{
+		host: "https://" + net.JoinHostPort(host, port),
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: fips.TLS(&tls.Config{RootCAs: pool})},
+		},
+	}, nil
+}
+
+// Namespace returns the namespace this pod is running in
+func Namespace() string {
+	namespace, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "default"
+	}
+	return string(bytes.TrimSpace(namespace))
+}
+
+// Do sends a request to the API server, encoding body and decoding the
+// response into result when they aren't nil.
+func (c *Client) Do(method string, path string, body interface{}, result interface{}) error {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.host+path, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	// Projected service account tokens are rotated, so read it every time
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return ErrConflict
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(respBody))
+	}
+	if result != nil {
+		return json.Unmarshal(respBody, result)
+	}
+	return nil
+}
+
+// ObjectMeta is the part of every object's metadata haberdasher cares about
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	UID             string            `json:"uid,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+// A Pod is the little of a pod that haberdasher needs
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+}
+
+// GetPod fetches a pod by namespace and name
+func (c *Client) GetPod(namespace string, name string) (*Pod, error) {
+	var pod Pod
+	if err := c.Do("GET", fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name), nil, &pod); err != nil {
+		return nil, err
+	}
+	return &pod, nil
+}
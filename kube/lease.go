@@ -0,0 +1,121 @@
+package kube
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// A Lease is a coordination.k8s.io/v1 Lease, used for leader election
+type Lease struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+func leasePath(namespace string, name string) string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", namespace, name)
+}
+
+// An Elector competes with other replicas for a Lease. Whoever holds the
+// Lease and keeps renewing it is the leader; if the leader stops renewing for
+// the lease duration, another replica takes over.
+type Elector struct {
+	client    *Client
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	leader    int32
+}
+
+// NewElector creates an Elector for the named Lease. The identity must be
+// unique among the replicas, the pod name is a good choice.
+func (c *Client) NewElector(namespace string, name string, identity string, duration time.Duration) *Elector {
+	return &Elector{client: c, namespace: namespace, name: name, identity: identity, duration: duration}
+}
+
+// IsLeader reports whether we held the Lease as of the last attempt to
+// acquire or renew it
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Run tries to acquire or renew the Lease a few times per lease duration,
+// forever
+func (e *Elector) Run() {
+	for {
+		leader, err := e.tryAcquire(logging.Clock.Now())
+		if err != nil {
+			log.Println("Leader election for", e.name, "failed:", err)
+		}
+		var flag int32
+		if leader {
+			flag = 1
+		}
+		if previous := atomic.SwapInt32(&e.leader, flag); previous != flag {
+			if leader {
+				log.Println("Became leader for", e.name)
+			} else {
+				log.Println("No longer leader for", e.name)
+			}
+		}
+		logging.Clock.Sleep(e.duration / 3)
+	}
+}
+
+func (e *Elector) tryAcquire(now time.Time) (bool, error) {
+	path := leasePath(e.namespace, e.name)
+	var lease Lease
+	err := e.client.Do("GET", path, nil, &lease)
+	if err == ErrNotFound {
+		lease = Lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		lease.Metadata = ObjectMeta{Name: e.name, Namespace: e.namespace}
+		e.claim(&lease, now)
+		createPath := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.namespace)
+		if err := e.client.Do("POST", createPath, lease, nil); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if lease.Spec.HolderIdentity != e.identity {
+		renewed, err := time.Parse(microTimeFormat, lease.Spec.RenewTime)
+		duration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+		if err == nil && lease.Spec.HolderIdentity != "" && now.Before(renewed.Add(duration)) {
+			return false, nil
+		}
+		lease.Spec.LeaseTransitions++
+	}
+	e.claim(&lease, now)
+	// The resourceVersion makes this fail with a conflict if anyone else
+	// updated the Lease since we read it
+	if err := e.client.Do("PUT", path, lease, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (e *Elector) claim(lease *Lease, now time.Time) {
+	if lease.Spec.HolderIdentity != e.identity || lease.Spec.AcquireTime == "" {
+		lease.Spec.AcquireTime = now.UTC().Format(microTimeFormat)
+	}
+	lease.Spec.HolderIdentity = e.identity
+	lease.Spec.LeaseDurationSeconds = int(e.duration / time.Second)
+	lease.Spec.RenewTime = now.UTC().Format(microTimeFormat)
+}
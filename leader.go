@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/kube"
+)
+
+const leaseDuration = 15 * time.Second
+
+// When several replicas tail the same shared source, such as a file on an NFS
+// volume, every one of them would ship the same lines. If
+// HABERDASHER_LEADER_ELECTION names a Kubernetes Lease, the replicas compete
+// for it and only the holder ships from shared sources. Returns a function
+// reporting whether this replica should currently ship; without leader
+// election it always should.
+func startLeaderElection() func() bool {
+	leaseName, exists := config.Setting("HABERDASHER_LEADER_ELECTION")
+	if !exists {
+		return func() bool { return true }
+	}
+	client, err := kube.InCluster()
+	if err != nil {
+		log.Fatal("HABERDASHER_LEADER_ELECTION requires the Kubernetes API: ", err)
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		log.Fatal("Unable to determine an identity for leader election: ", err)
+	}
+	log.Println("Competing for leadership of", leaseName, "as", identity)
+	elector := client.NewElector(kube.Namespace(), leaseName, identity, leaseDuration)
+	go elector.Run()
+	return elector.IsLeader
+}
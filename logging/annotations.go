@@ -0,0 +1,70 @@
+package logging
+
+import "time"
+
+// Annotations are hints one stage of the pipeline leaves for the stages after
+// it, like that a message has already been redacted, or which tenant it
+// belongs to. Unlike labels they're never shipped. Only wrapped Messages
+// carry them; structured lines are passed along as they are.
+type Annotations map[string]interface{}
+
+// Annotations haberdasher's own stages set and understand
+const (
+	// AnnotationRedacted is true if a redaction rewrote the line
+	AnnotationRedacted = "redacted"
+	// AnnotationSampled is true if the message was kept by sampling, so
+	// counts downstream should be scaled up
+	AnnotationSampled = "sampled"
+	// AnnotationTenant is the tenant the message belongs to, for emitters
+	// which ship each tenant's messages separately
+	AnnotationTenant = "tenant"
+	// AnnotationReceived is when haberdasher read the line, which differs from
+	// its timestamp if that was parsed from the line itself
+	AnnotationReceived = "received"
+)
+
+// Annotate sets an annotation on the message without touching any other
+// message's, as labels are by AddLabel
+func (m *Message) Annotate(key string, value interface{}) {
+	annotations := make(Annotations, len(m.Annotations)+1)
+	for k, v := range m.Annotations {
+		annotations[k] = v
+	}
+	annotations[key] = value
+	m.Annotations = annotations
+}
+
+// AnnotationsOf returns a message's annotations, or nil for a structured line
+func AnnotationsOf(jsonSerializeable interface{}) Annotations {
+	switch m := jsonSerializeable.(type) {
+	case Message:
+		return m.Annotations
+	case *Message:
+		return m.Annotations
+	}
+	return nil
+}
+
+// String returns a string annotation, and whether it's set and a string
+func (a Annotations) String(key string) (string, bool) {
+	value, ok := a[key].(string)
+	return value, ok
+}
+
+// Int returns an integer annotation, and whether it's set and an integer
+func (a Annotations) Int(key string) (int, bool) {
+	value, ok := a[key].(int)
+	return value, ok
+}
+
+// Time returns a time annotation, and whether it's set and a time
+func (a Annotations) Time(key string) (time.Time, bool) {
+	value, ok := a[key].(time.Time)
+	return value, ok
+}
+
+// Flag reports whether a boolean annotation is set and true
+func (a Annotations) Flag(key string) bool {
+	value, _ := a[key].(bool)
+	return value
+}
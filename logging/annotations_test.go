@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnnotationHelpers(t *testing.T) {
+	received := time.Date(2020, 10, 29, 22, 41, 58, 0, time.UTC)
+	var m Message
+	m.Annotate(AnnotationTenant, "acme")
+	m.Annotate("attempt", 3)
+	m.Annotate(AnnotationReceived, received)
+	m.Annotate(AnnotationSampled, true)
+	a := AnnotationsOf(m)
+
+	if tenant, ok := a.String(AnnotationTenant); !ok || tenant != "acme" {
+		t.Errorf("String(tenant) = %q, %v", tenant, ok)
+	}
+	if _, ok := a.String("attempt"); ok {
+		t.Error("String took an integer annotation")
+	}
+	if attempt, ok := a.Int("attempt"); !ok || attempt != 3 {
+		t.Errorf("Int(attempt) = %d, %v", attempt, ok)
+	}
+	if _, ok := a.Int(AnnotationTenant); ok {
+		t.Error("Int took a string annotation")
+	}
+	if got, ok := a.Time(AnnotationReceived); !ok || !got.Equal(received) {
+		t.Errorf("Time(received) = %v, %v", got, ok)
+	}
+	if _, ok := a.Time("missing"); ok {
+		t.Error("Time found a missing annotation")
+	}
+	if !a.Flag(AnnotationSampled) || a.Flag(AnnotationRedacted) || a.Flag(AnnotationTenant) {
+		t.Error("Flag only reports annotations which are set and true")
+	}
+	if AnnotationsOf(map[string]interface{}{"message": "structured"}) != nil {
+		t.Error("a structured line has annotations")
+	}
+}
+
+func TestAnnotateCopies(t *testing.T) {
+	var first Message
+	first.Annotate(AnnotationTenant, "acme")
+	second := first
+	second.Annotate(AnnotationTenant, "globex")
+	if tenant, _ := first.Annotations.String(AnnotationTenant); tenant != "acme" {
+		t.Errorf("annotating a copy changed the original's tenant to %q", tenant)
+	}
+}
+
+func TestEmitAtAnnotations(t *testing.T) {
+	defer SetRedactions(nil)
+	if err := SetRedactions([]Redaction{{Name: "password", Match: `password=\S+`}}); err != nil {
+		t.Fatal(err)
+	}
+	readAt := time.Date(2020, 10, 29, 22, 41, 58, 0, time.UTC)
+	emitter := &recordingEmitter{}
+	EmitAt(emitter, Source{}, readAt.Add(-time.Hour), readAt, "login password=hunter2")
+	EmitAt(emitter, Source{}, readAt.Add(-time.Hour), readAt, "logout")
+
+	for i, redacted := range []bool{true, false} {
+		m, ok := emitter.messages[i].(Message)
+		if !ok {
+			t.Fatalf("message %d is a %T", i, emitter.messages[i])
+		}
+		if got, _ := m.Annotations.Time(AnnotationReceived); !got.Equal(readAt) {
+			t.Errorf("message %d was received at %v, want when it was read, %v", i, got, readAt)
+		}
+		if m.Annotations.Flag(AnnotationRedacted) != redacted {
+			t.Errorf("message %d: redacted is %v, want %v", i, !redacted, redacted)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// ANSI escape sequences: control sequences, like colours and cursor movement,
+// operating system commands, like setting the title or a hyperlink, and the
+// two byte escapes
+var ansiSequence = regexp.MustCompile(`\x1b(\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[()][0-9A-Za-z]|[@-Z\\-_])`)
+
+// StripANSI removes ANSI escape sequences from s
+func StripANSI(s string) string {
+	if !strings.ContainsRune(s, '\x1b') {
+		return s
+	}
+	return ansiSequence.ReplaceAllString(s, "")
+}
+
+// An ansiStrippedEmitter removes the escape sequences of applications which
+// colour their output from messages, so they don't pollute indexes downstream
+type ansiStrippedEmitter struct {
+	Emitter
+}
+
+func (a *ansiStrippedEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	switch m := jsonSerializeable.(type) {
+	case Message:
+		m.Message = StripANSI(m.Message)
+		jsonSerializeable = m
+	case *Message:
+		stripped := *m
+		stripped.Message = StripANSI(stripped.Message)
+		jsonSerializeable = stripped
+	case map[string]interface{}:
+		// Structured lines are shared with any other emitters
+		stripped := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			if s, isString := v.(string); isString {
+				v = StripANSI(s)
+			}
+			stripped[k] = v
+		}
+		jsonSerializeable = stripped
+	}
+	return a.Emitter.HandleLogMessage(jsonSerializeable)
+}
+
+func (a *ansiStrippedEmitter) CheckHealth() error {
+	return CheckHealth(a.Emitter)
+}
+
+// stripANSI wraps an emitter in a stage removing escape sequences as
+// HABERDASHER_STRIP_ANSI says: auto (the default) for every emitter but
+// stderr, whose output is most likely read on a terminal, on for every
+// emitter, or off
+func stripANSI(emitterType string, emitter Emitter) Emitter {
+	switch mode, _ := config.Setting("HABERDASHER_STRIP_ANSI"); mode {
+	case "", "auto":
+		if emitterType == "stderr" {
+			return emitter
+		}
+	case "on":
+	case "off":
+		return emitter
+	default:
+		log.Fatal("HABERDASHER_STRIP_ANSI must be auto, on, or off")
+	}
+	return &ansiStrippedEmitter{Emitter: emitter}
+}
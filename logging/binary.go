@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Only this much of a binary line is kept in its hexdump
+const maxHexdumpBytes = 256
+
+var binaryWarningLock sync.Mutex
+var binaryWarnedAt = make(map[string]time.Time)
+
+// looksBinary decides whether a line is accidental binary output rather than
+// text: invalid UTF-8, or more than one in ten characters being control
+// characters (other than whitespace and the escapes used for colors)
+func looksBinary(logMessage string) bool {
+	if !utf8.ValidString(logMessage) {
+		return true
+	}
+	control, total := 0, 0
+	for _, r := range logMessage {
+		total++
+		if unicode.IsControl(r) && r != '\t' && r != '\r' && r != '\n' && r != '\x1b' {
+			control++
+		}
+	}
+	return control*10 > total
+}
+
+// hexdump turns a binary line into a bounded, printable record, so a stray
+// binary write doesn't turn into megabytes of mojibake downstream
+func hexdump(logMessage string) string {
+	data := []byte(logMessage)
+	summary := fmt.Sprintf("binary data (%d bytes)", len(data))
+	if len(data) > maxHexdumpBytes {
+		data = data[:maxHexdumpBytes]
+		summary += fmt.Sprintf(", first %d bytes", maxHexdumpBytes)
+	}
+	return summary + ":\n" + hex.Dump(data)
+}
+
+// warnBinary notes on the console that a source is producing binary data, at
+// most once a minute per source
+func warnBinary(source Source) {
+	binaryWarningLock.Lock()
+	defer binaryWarningLock.Unlock()
+	name := source.Name()
+	if Clock.Since(binaryWarnedAt[name]) < time.Minute {
+		return
+	}
+	binaryWarnedAt[name] = Clock.Now()
+	log.Println("Warning: binary data received from", name+"; shipping it as hexdumps")
+}
@@ -0,0 +1,34 @@
+package logging
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// Common log prefixes which carry the file and line that logged the message.
+// Each has the file name as its first group and the line as its second.
+var callerPatterns = []*regexp.Regexp{
+	// glog and klog, from Go and C++:
+	//   I1029 22:41:58.020345   12345 server.go:123] message
+	regexp.MustCompile(`^[IWEF]\d{4} \d{2}:\d{2}:\d{2}\.\d+\s+\d+ ([^ :\]]+):(\d+)\] `),
+	// zap's console encoder:
+	//   2020-10-29T22:41:58.020Z	INFO	pkg/server.go:123	message
+	regexp.MustCompile(`^\S+\t[A-Za-z]+\t([^\t:]+):(\d+)\t`),
+	// Go's standard log package with Lshortfile or Llongfile:
+	//   2020/10/29 22:41:58 server.go:123: message
+	regexp.MustCompile(`^(?:\d{4}/\d{2}/\d{2} )?(?:\d{2}:\d{2}:\d{2}(?:\.\d+)? )?([\w./-]+\.go):(\d+): `),
+}
+
+// parseCaller finds the source location in a log line's prefix, if it has one
+func parseCaller(logMessage string) (string, int, bool) {
+	for _, pattern := range callerPatterns {
+		if match := pattern.FindStringSubmatch(logMessage); match != nil {
+			line, err := strconv.Atoi(match[2])
+			if err != nil {
+				continue
+			}
+			return match[1], line, true
+		}
+	}
+	return "", 0, false
+}
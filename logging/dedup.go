@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+// A Deduplicator catches applications which write every line to both stdout
+// and stderr. Each line is held for a short window, and if the same line
+// arrives from the other stream within it, only one copy is passed on, tagged
+// with both streams. This delays every line by up to the window.
+type Deduplicator struct {
+	window time.Duration
+	emit   func(source Source, logMessage string)
+
+	lock    sync.Mutex
+	pending map[string]*pendingLine
+}
+
+type pendingLine struct {
+	source Source
+	timer  clock.Timer
+}
+
+// NewDeduplicator creates a Deduplicator which hands lines on to emit
+func NewDeduplicator(window time.Duration, emit func(source Source, logMessage string)) *Deduplicator {
+	return &Deduplicator{window: window, emit: emit, pending: make(map[string]*pendingLine)}
+}
+
+// Add takes a line from one of the streams
+func (d *Deduplicator) Add(source Source, logMessage string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if p, ok := d.pending[logMessage]; ok {
+		delete(d.pending, logMessage)
+		if p.timer.Stop() {
+			if p.source.Stream != source.Stream {
+				merged := source
+				merged.Stream = mergeStreams(p.source.Stream, source.Stream)
+				go d.emit(merged, logMessage)
+				return
+			}
+			// The same line twice on one stream isn't a duplicate we handle
+			go d.emit(p.source, logMessage)
+		}
+	}
+
+	p := &pendingLine{source: source}
+	p.timer = Clock.AfterFunc(d.window, func() {
+		d.lock.Lock()
+		if d.pending[logMessage] == p {
+			delete(d.pending, logMessage)
+		}
+		d.lock.Unlock()
+		d.emit(p.source, logMessage)
+	})
+	d.pending[logMessage] = p
+}
+
+// Flush passes on every line still waiting for its twin, for when the streams
+// have closed
+func (d *Deduplicator) Flush() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for logMessage, p := range d.pending {
+		if p.timer.Stop() {
+			d.emit(p.source, logMessage)
+		}
+		delete(d.pending, logMessage)
+	}
+}
+
+func mergeStreams(a string, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + "," + b
+}
@@ -4,9 +4,15 @@ import (
 	"encoding/json"
 	"log"
 	"time"
-	"os"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/config"
 )
 
+// Clock is what every timer in the package runs on. Tests can swap it for a
+// fake before emitting anything.
+var Clock clock.Clock = clock.Real
+
 var defaultTags []string
 var defaultLabels map[string]string
 const defaultEcsVersion = "1.5.0"
@@ -16,22 +22,24 @@ const defaultEcsVersion = "1.5.0"
 // HABERDASHER_TAGS and HABERDASHER_LABELS contain serialized JSON values for
 // the tags and labels to go in such messages. They are optional.
 func init() {
-	tagsFromEnv, exists := os.LookupEnv("HABERDASHER_TAGS")
-	if !exists {
-		tagsFromEnv = "[]"
-	}
-	labelsFromEnv, exists := os.LookupEnv("HABERDASHER_LABELS")
-	if !exists {
-		labelsFromEnv = "{}"
-	}
-	err := json.Unmarshal([]byte(tagsFromEnv), &defaultTags)
-	if err != nil {
-		log.Fatal("HABERDASHER_TAGS must be a JSON array of strings")
-	}
-	err = json.Unmarshal([]byte(labelsFromEnv), &defaultLabels)
-	if err != nil {
-		log.Fatal("HABERDASHER_LABELS must be a JSON object of strings")
-	}
+	config.OnLoad(func() {
+		tagsFromEnv, exists := config.Setting("HABERDASHER_TAGS")
+		if !exists {
+			tagsFromEnv = "[]"
+		}
+		labelsFromEnv, exists := config.Setting("HABERDASHER_LABELS")
+		if !exists {
+			labelsFromEnv = "{}"
+		}
+		err := json.Unmarshal([]byte(tagsFromEnv), &defaultTags)
+		if err != nil {
+			log.Fatal("HABERDASHER_TAGS must be a JSON array of strings")
+		}
+		err = json.Unmarshal([]byte(labelsFromEnv), &defaultLabels)
+		if err != nil {
+			log.Fatal("HABERDASHER_LABELS must be a JSON object of strings")
+		}
+	})
 }
 
 // An Emitter defines how to ship a log message to a log service.
@@ -41,6 +49,21 @@ type Emitter interface {
 	Cleanup() (error)
 }
 
+// A HealthChecker is an Emitter which can check that its backend is reachable.
+// Emitters which can't, like stderr, are always considered healthy.
+type HealthChecker interface {
+	CheckHealth() error
+}
+
+// CheckHealth reports whether the emitter's backend is reachable, if it knows
+// how to check
+func CheckHealth(emitter Emitter) error {
+	if checker, ok := emitter.(HealthChecker); ok {
+		return checker.CheckHealth()
+	}
+	return nil
+}
+
 // A Message is a structured log message - only used if the log message we
 // consume from the subprocess is not already structured
 type Message struct {
@@ -49,14 +72,99 @@ type Message struct {
 	Labels map[string]string `json:"labels"`
 	Tags []string `json:"tags"`
 	Message string `json:"message"`
+	Level string `json:"log.level,omitempty"`
+	FilePath string `json:"log.file.path,omitempty"`
+	Stream string `json:"stream,omitempty"`
+	Dataset string `json:"event.dataset,omitempty"`
+	OriginFile string `json:"log.origin.file.name,omitempty"`
+	OriginLine int `json:"log.origin.file.line,omitempty"`
+	EventAction string `json:"event.action,omitempty"`
+	// Annotations are for the pipeline's own use, and aren't shipped
+	Annotations Annotations `json:"-"`
+}
+
+// A Source describes where a log line was captured from. The zero value is the
+// wrapped command's stderr.
+type Source struct {
+	// Path is set when the line was read from a log file
+	Path string
+	// Stream is set when we know which stream the line was originally written
+	// to, e.g. from a container runtime's log file
+	Stream string
+	// Dataset names the virtual source the line was classified into, if any
+	Dataset string
+	// Labels are added to the default labels of wrapped messages
+	Labels map[string]string
+}
+
+// Name identifies the source in configuration: its virtual source, the path of
+// a file, or the stream it was read from
+func (s Source) Name() string {
+	if s.Dataset != "" {
+		return s.Dataset
+	}
+	if s.Path != "" {
+		return s.Path
+	}
+	if s.Stream != "" {
+		return s.Stream
+	}
+	return "stderr"
+}
+
+// NewMessage wraps an unstructured log line in a Message carrying the default
+// tags and labels
+func NewMessage(logMessage string) Message {
+	return Message{
+		ECSVersion: defaultEcsVersion,
+		Timestamp: Clock.Now(),
+		Labels: defaultLabels,
+		Tags: defaultTags,
+		Message: logMessage,
+	}
+}
+
+// AddLabel sets a label on the message without touching the default labels
+// shared by every other message
+func (m *Message) AddLabel(key string, value string) {
+	labels := make(map[string]string, len(m.Labels)+1)
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	labels[key] = value
+	m.Labels = labels
+}
+
+// EmitEvent ships a message about something haberdasher itself noticed, as
+// opposed to something the wrapped command logged. The action names the kind
+// of event so it's easy to find downstream. It goes to emitter, unless
+// events are routed elsewhere.
+func EmitEvent(emitter Emitter, action string, message string) {
+	SendEvent(emitter, NewEvent(action, message))
+}
+
+// SendEvent is EmitEvent for an event built with NewEvent, so it can carry
+// labels of its own
+func SendEvent(emitter Emitter, event Message) {
+	console.Println(event.Message)
+	if routed := RoutedEvents(); routed != nil {
+		emitter = routed
+	}
+	if err := emitter.HandleLogMessage(event); err != nil {
+		console.Println("Error emitting event:", event.Message, err)
+	}
 }
 
 // Emitters is the registry of Emitter implementers
 var Emitters = make(map[string]Emitter)
 
-// Register will make note of new types of Emitters
+// Register will make note of new types of Emitters. The stages wrapping the
+// emitter read their settings, so they're built again once the configuration
+// file is loaded.
 func Register(emitterType string, emitter Emitter) {
-	Emitters[emitterType] = emitter
+	config.OnLoad(func() {
+		Emitters[emitterType] = guard(emitterType, stripANSI(emitterType, throttle(emitterType, sandbox(emitterType, emitter))))
+	})
 }
 
 // Emit is launched as a goroutine for individual log lines to be sent
@@ -64,16 +172,98 @@ func Register(emitterType string, emitter Emitter) {
 // If that succeeds, meaning it's already a structured object, we pass it along
 // unmodified. If not, we wrap it in a basic ECS structure.
 func Emit(emitter Emitter, logMessage string) {
-	// If the emitted message is JSON, pass it along unmodified
+	EmitFrom(emitter, Source{}, logMessage)
+}
+
+// EmitFrom is Emit for lines captured somewhere other than the wrapped
+// command's stderr. Wrapped messages record where they came from.
+func EmitFrom(emitter Emitter, source Source, logMessage string) {
+	now := Clock.Now()
+	EmitAt(emitter, source, now, now, logMessage)
+}
+
+// EmitAt is EmitFrom for lines whose original timestamp is known, such as
+// those read back from a container runtime's log files, or which were read
+// some time before they're emitted, such as those which waited in a queue.
+// readAt is when haberdasher read the line.
+func EmitAt(emitter Emitter, source Source, timestamp, readAt time.Time, logMessage string) {
+	received(logMessage)
+	redacted := Redact(logMessage)
+	wasRedacted := redacted != logMessage
+	logMessage = redacted
+	// If the emitted message is JSON, pass it along as HABERDASHER_JSON says
 	var decodedJSON map[string]interface{}
 	if err := json.Unmarshal([]byte(logMessage), &decodedJSON); err != nil {
-		m := Message{defaultEcsVersion, time.Now(), defaultLabels, defaultTags, logMessage}
+		m := wrapMessage(source, timestamp, logMessage)
+		m.Annotate(AnnotationReceived, readAt)
+		if wasRedacted {
+			m.Annotate(AnnotationRedacted, true)
+		}
+		if expired(emitter, source, m.Timestamp) {
+			messagesDropped.Inc()
+			return
+		}
 		if err := emitter.HandleLogMessage(m); err != nil {
+			messagesFailed.Inc()
 			log.Println("Error emitting message:", logMessage, err)
 		}
 	} else {
+		decodedJSON = structured(source, timestamp, decodedJSON)
+		if stamp, ok := decodedJSON["@timestamp"].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, stamp); err == nil && expired(emitter, source, parsed) {
+				messagesDropped.Inc()
+				return
+			}
+		}
 		if err := emitter.HandleLogMessage(decodedJSON); err != nil {
+			messagesFailed.Inc()
 			log.Println("Error emitting message:", logMessage, err)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// Preview returns what EmitAt would ship for a line, without shipping it: the
+// Message wrapping an unstructured line, or a structured line's decoded
+// object, after redaction
+func Preview(source Source, timestamp time.Time, logMessage string) interface{} {
+	logMessage = Redact(logMessage)
+	var decodedJSON map[string]interface{}
+	if err := json.Unmarshal([]byte(logMessage), &decodedJSON); err != nil {
+		return wrapMessage(source, timestamp, logMessage)
+	}
+	return structured(source, timestamp, decodedJSON)
+}
+
+// wrapMessage builds the Message for an unstructured line, with everything we
+// can work out about it
+func wrapMessage(source Source, timestamp time.Time, logMessage string) Message {
+	m := NewMessage(logMessage)
+	if looksBinary(logMessage) {
+		warnBinary(source)
+		m.Message = hexdump(logMessage)
+		m.EventAction = "binary-data"
+	}
+	m.Level = Severity(logMessage)
+	m.Timestamp = timestamp
+	if parsed, ok := parseTimestamp(source, logMessage); ok {
+		m.Timestamp = parsed
+	}
+	m.FilePath = source.Path
+	m.Stream = source.Stream
+	m.Dataset = source.Dataset
+	if file, line, ok := parseCaller(logMessage); ok {
+		m.OriginFile = file
+		m.OriginLine = line
+	}
+	if len(source.Labels) > 0 {
+		labels := make(map[string]string, len(m.Labels)+len(source.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+		for k, v := range source.Labels {
+			labels[k] = v
+		}
+		m.Labels = labels
+	}
+	return m
+}
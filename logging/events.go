@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// How many of our own log lines can wait to be shipped before more are
+// dropped, rather than logging blocking on a slow emitter
+const selfLogBuffer = 1000
+
+// Events are printed to stderr through their own logger, so capturing our
+// log output doesn't ship them twice
+var console = log.New(os.Stderr, "", log.LstdFlags)
+
+// Where haberdasher's own events go, if not to the emitter they're about,
+// with the labels and dataset which set them apart from the child's logs
+var eventsLock sync.RWMutex
+var eventsEmitter Emitter
+var eventLabels map[string]string
+var eventDataset string
+
+// Our own log output, when it's being captured
+var selfLog chan string
+var selfLogDone chan struct{}
+var delivering int32
+
+// HABERDASHER_EVENTS_LABELS is a JSON object of labels for haberdasher's own
+// events and log lines, added to HABERDASHER_LABELS, and
+// HABERDASHER_EVENTS_DATASET is their event.dataset
+func init() {
+	config.OnLoad(func() {
+		if labelsFromEnv, exists := config.Setting("HABERDASHER_EVENTS_LABELS"); exists {
+			var labels map[string]string
+			if err := json.Unmarshal([]byte(labelsFromEnv), &labels); err != nil {
+				log.Fatal("HABERDASHER_EVENTS_LABELS must be a JSON object of strings")
+			}
+			eventLabels = make(map[string]string, len(defaultLabels)+len(labels))
+			for k, v := range defaultLabels {
+				eventLabels[k] = v
+			}
+			for k, v := range labels {
+				eventLabels[k] = v
+			}
+		}
+		eventDataset, _ = config.Setting("HABERDASHER_EVENTS_DATASET")
+	})
+}
+
+// RouteEvents sends every event to emitter, rather than the emitter each is
+// about
+func RouteEvents(emitter Emitter) {
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	eventsEmitter = emitter
+}
+
+// RoutedEvents returns the emitter events are routed to, or nil if they
+// aren't
+func RoutedEvents() Emitter {
+	eventsLock.RLock()
+	defer eventsLock.RUnlock()
+	return eventsEmitter
+}
+
+// NewEvent is a Message about something haberdasher itself noticed, with
+// the events' labels and dataset
+func NewEvent(action string, message string) Message {
+	m := NewMessage(message)
+	m.EventAction = action
+	if eventLabels != nil {
+		m.Labels = eventLabels
+	}
+	m.Dataset = eventDataset
+	return m
+}
+
+// CaptureSelfLog ships haberdasher's own log output as self-log events to
+// the emitter events are routed to, as well as writing it to stderr. Lines
+// logged while one is being shipped aren't shipped themselves, so an emitter
+// logging its own failures can't feed on them.
+func CaptureSelfLog() {
+	selfLog = make(chan string, selfLogBuffer)
+	selfLogDone = make(chan struct{})
+	go shipSelfLog(selfLog, selfLogDone)
+	log.SetOutput(selfLogWriter{})
+}
+
+// StopSelfLog stops capturing our log output, once what's been captured is
+// shipped
+func StopSelfLog() {
+	if selfLog == nil {
+		return
+	}
+	log.SetOutput(os.Stderr)
+	close(selfLog)
+	<-selfLogDone
+	selfLog = nil
+}
+
+type selfLogWriter struct{}
+
+func (selfLogWriter) Write(p []byte) (int, error) {
+	n, err := os.Stderr.Write(p)
+	if atomic.LoadInt32(&delivering) != 0 {
+		return n, err
+	}
+	line := string(p)
+	// Drop the date and time the standard flags put first
+	if log.Flags() == log.LstdFlags && len(line) > 20 {
+		line = line[20:]
+	}
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	select {
+	case selfLog <- line:
+	default:
+	}
+	return n, err
+}
+
+func shipSelfLog(lines chan string, done chan struct{}) {
+	defer close(done)
+	for line := range lines {
+		emitter := RoutedEvents()
+		if emitter == nil {
+			continue
+		}
+		m := NewEvent("self-log", line)
+		m.Level = Severity(line)
+		atomic.StoreInt32(&delivering, 1)
+		emitter.HandleLogMessage(m)
+		atomic.StoreInt32(&delivering, 0)
+	}
+}
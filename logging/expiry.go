@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+const expirySummaryInterval = time.Minute
+
+var maxAge time.Duration
+var expiredMessages = metrics.NewCounter("haberdasher_messages_expired_total", "Messages dropped for being older than HABERDASHER_MAX_AGE.")
+
+// expiredCounts tallies what's been dropped since the last summary, per
+// emitter and source
+type expiredCount struct {
+	count  int
+	oldest time.Time
+}
+
+var expiredLock sync.Mutex
+var expiredCounts = make(map[Emitter]map[string]*expiredCount)
+var expirySummaries sync.Once
+
+// Late data is often worse than no data: a dashboard showing a burst of day-old
+// messages as if they'd just happened is misleading. If HABERDASHER_MAX_AGE is
+// set (e.g. "24h"), messages whose timestamp is older than that, such as lines
+// read back from a file after a long outage, are dropped. Each minute a summary
+// event reports how many were dropped, so the gap is explained.
+func init() {
+	config.OnLoad(func() {
+		maxAge, _ = config.DurationSetting("HABERDASHER_MAX_AGE")
+	})
+}
+
+// expired reports whether a message is too old to ship, and if so counts it
+// towards the next summary
+func expired(emitter Emitter, source Source, timestamp time.Time) bool {
+	if maxAge == 0 || timestamp.IsZero() || Clock.Since(timestamp) <= maxAge {
+		return false
+	}
+	expiredMessages.Inc()
+	expirySummaries.Do(func() { go summarizeExpired() })
+
+	expiredLock.Lock()
+	defer expiredLock.Unlock()
+	bySource, ok := expiredCounts[emitter]
+	if !ok {
+		bySource = make(map[string]*expiredCount)
+		expiredCounts[emitter] = bySource
+	}
+	count, ok := bySource[source.Name()]
+	if !ok {
+		count = &expiredCount{oldest: timestamp}
+		bySource[source.Name()] = count
+	}
+	count.count++
+	if timestamp.Before(count.oldest) {
+		count.oldest = timestamp
+	}
+	return true
+}
+
+func summarizeExpired() {
+	for {
+		Clock.Sleep(expirySummaryInterval)
+		expiredLock.Lock()
+		counts := expiredCounts
+		expiredCounts = make(map[Emitter]map[string]*expiredCount)
+		expiredLock.Unlock()
+
+		for emitter, bySource := range counts {
+			for source, count := range bySource {
+				EmitEvent(emitter, "messages-expired", fmt.Sprintf(
+					"Dropped %d messages from %s older than %s, the oldest from %s",
+					count.count, source, maxAge, count.oldest.Format(time.RFC3339)))
+			}
+		}
+	}
+}
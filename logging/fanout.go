@@ -0,0 +1,165 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many messages a fanout holds for a member which is behind, before it
+// drops that member's copies
+const fanoutQueueSize = 1000
+
+// A Fanout delivers every message to several emitters at once. Each has its
+// own queue and goroutine delivering from it, so one failing or hanging
+// backend doesn't stop the others getting their copy, or hold up the caller.
+type Fanout struct {
+	names    []string
+	emitters []Emitter
+	lanes    []*fanoutLane
+}
+
+// A fanoutLane queues a fanout's messages for one of its members
+type fanoutLane struct {
+	name    string
+	emitter Emitter
+	queue   chan interface{}
+
+	lock    sync.Mutex
+	pending int
+	// Closed whenever nothing is pending
+	idle chan struct{}
+}
+
+var errLaneFull = errors.New("too far behind, dropped")
+
+// NewFanout returns an emitter delivering to each of the named emitters
+func NewFanout(names []string, emitters []Emitter) *Fanout {
+	f := &Fanout{names: names, emitters: emitters}
+	for i, emitter := range emitters {
+		idle := make(chan struct{})
+		close(idle)
+		lane := &fanoutLane{name: names[i], emitter: emitter, queue: make(chan interface{}, fanoutQueueSize), idle: idle}
+		f.lanes = append(f.lanes, lane)
+		go lane.run()
+	}
+	return f
+}
+
+// Members returns the emitters messages are delivered to
+func (f *Fanout) Members() []Emitter {
+	return f.emitters
+}
+
+// Setup sets up every emitter
+func (f *Fanout) Setup() {
+	for _, emitter := range f.emitters {
+		emitter.Setup()
+	}
+}
+
+// HandleLogMessage queues the message for every emitter, reporting any too
+// far behind to take it. Failures to deliver it are logged and counted as
+// they happen.
+func (f *Fanout) HandleLogMessage(jsonSerializeable interface{}) error {
+	errs := make([]error, len(f.lanes))
+	for i, lane := range f.lanes {
+		errs[i] = lane.push(jsonSerializeable)
+	}
+	return f.failures(errs)
+}
+
+// Drain waits until every member has been handed what's queued for it, or
+// timeout fires, reporting whether they all were
+func (f *Fanout) Drain(timeout <-chan time.Time) bool {
+	for _, lane := range f.lanes {
+		select {
+		case <-lane.idleSignal():
+		case <-timeout:
+			return false
+		}
+	}
+	return true
+}
+
+// Cleanup cleans up every emitter
+func (f *Fanout) Cleanup() error {
+	return f.each(func(emitter Emitter) error {
+		return emitter.Cleanup()
+	})
+}
+
+// CheckHealth is healthy only if every emitter is
+func (f *Fanout) CheckHealth() error {
+	return f.each(CheckHealth)
+}
+
+func (f *Fanout) each(do func(emitter Emitter) error) error {
+	errs := make([]error, len(f.emitters))
+	var wg sync.WaitGroup
+	wg.Add(len(f.emitters))
+	for i, emitter := range f.emitters {
+		go func(i int, emitter Emitter) {
+			defer wg.Done()
+			errs[i] = do(emitter)
+		}(i, emitter)
+	}
+	wg.Wait()
+	return f.failures(errs)
+}
+
+// failures combines the members' errors, logging which failed if any didn't
+func (f *Fanout) failures(errs []error) error {
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", f.names[i], err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	if len(failed) < len(f.emitters) {
+		log.Println("Partial delivery failure;", strings.Join(failed, "; "))
+	}
+	return fmt.Errorf("%s", strings.Join(failed, "; "))
+}
+
+func (l *fanoutLane) push(message interface{}) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	select {
+	case l.queue <- message:
+	default:
+		return errLaneFull
+	}
+	l.pending++
+	if l.pending == 1 {
+		l.idle = make(chan struct{})
+	}
+	return nil
+}
+
+func (l *fanoutLane) run() {
+	for message := range l.queue {
+		if err := l.emitter.HandleLogMessage(message); err != nil {
+			messagesFailed.Inc()
+			log.Println("Error emitting message to", l.name+":", err)
+		}
+		l.lock.Lock()
+		l.pending--
+		if l.pending == 0 {
+			close(l.idle)
+		}
+		l.lock.Unlock()
+	}
+}
+
+func (l *fanoutLane) idleSignal() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.idle
+}
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingEmitter struct {
+	lock     sync.Mutex
+	messages []interface{}
+}
+
+func (r *recordingEmitter) Setup() {}
+
+func (r *recordingEmitter) HandleLogMessage(message interface{}) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func (r *recordingEmitter) Cleanup() error { return nil }
+
+func (r *recordingEmitter) received() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.messages)
+}
+
+// A hangingEmitter never returns from HandleLogMessage, like a backend which
+// has stopped answering
+type hangingEmitter struct {
+	hang chan struct{}
+}
+
+func (h hangingEmitter) Setup()                             {}
+func (h hangingEmitter) HandleLogMessage(interface{}) error { <-h.hang; return nil }
+func (h hangingEmitter) Cleanup() error                     { return nil }
+
+func TestFanoutHangingMember(t *testing.T) {
+	hanging := hangingEmitter{make(chan struct{})}
+	defer close(hanging.hang)
+	healthy := &recordingEmitter{}
+	f := NewFanout([]string{"hanging", "healthy"}, []Emitter{hanging, healthy})
+
+	delivered := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			if err := f.HandleLogMessage(i); err != nil {
+				t.Errorf("message %d: %v", i, err)
+			}
+		}
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleLogMessage waited for the hanging member")
+	}
+
+	if f.Drain(time.After(100 * time.Millisecond)) {
+		t.Error("drained a member which hasn't taken its messages")
+	}
+	if got := healthy.received(); got != 10 {
+		t.Errorf("the healthy member received %d messages, want 10", got)
+	}
+
+	// Once the hanging member's queue is full, its copies are dropped, and the
+	// healthy member still gets its own
+	var err error
+	sent := 10
+	for err == nil && sent < 10+2*fanoutQueueSize {
+		err = f.HandleLogMessage(sent)
+		sent++
+	}
+	if err == nil || !strings.Contains(err.Error(), "hanging") || strings.Contains(err.Error(), "healthy") {
+		t.Errorf("a full queue reported %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); healthy.received() < sent && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if got := healthy.received(); got != sent {
+		t.Errorf("the healthy member received %d messages, want %d", got, sent)
+	}
+}
@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+// An Interleaver puts the lines of several streams back in the order they
+// were written in, as a terminal would have shown them, as best it can tell
+// from when each was read from its pipe. A line is held until every other
+// stream has a later one, or for up to the window, in case one read before it
+// is still being scanned. This delays lines by up to the window.
+type Interleaver struct {
+	window time.Duration
+	emit   func(source Source, read time.Time, logMessage string)
+
+	lock    sync.Mutex
+	streams []string
+	reads   map[string]*int64
+	pending map[string][]interleavedLine
+	timer   clock.Timer
+}
+
+type interleavedLine struct {
+	source     Source
+	read       time.Time
+	logMessage string
+}
+
+// NewInterleaver creates an Interleaver which hands lines on to emit, in
+// order, with when they were read
+func NewInterleaver(window time.Duration, emit func(source Source, read time.Time, logMessage string)) *Interleaver {
+	return &Interleaver{
+		window:  window,
+		emit:    emit,
+		reads:   make(map[string]*int64),
+		pending: make(map[string][]interleavedLine),
+	}
+}
+
+// Watch wraps the reader of a stream, noting when every read from it returns.
+// Every stream must be watched before any line is added.
+func (i *Interleaver) Watch(stream string, reader io.Reader) io.Reader {
+	last := new(int64)
+	i.streams = append(i.streams, stream)
+	i.reads[stream] = last
+	return &stampedReader{reader: reader, last: last}
+}
+
+// Add takes a line of one of the streams, which is taken to have been read by
+// the stream's latest read
+func (i *Interleaver) Add(source Source, logMessage string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	read := Clock.Now()
+	if last, watched := i.reads[source.Stream]; watched {
+		if stamp := atomic.LoadInt64(last); stamp != 0 {
+			read = time.Unix(0, stamp)
+		}
+	}
+	// A stream's lines can't have been read before the ones ahead of them
+	queue := i.pending[source.Stream]
+	if len(queue) > 0 && read.Before(queue[len(queue)-1].read) {
+		read = queue[len(queue)-1].read
+	}
+	i.pending[source.Stream] = append(queue, interleavedLine{source: source, read: read, logMessage: logMessage})
+	i.release(false)
+}
+
+// Flush passes on every line still held, for when the streams have closed
+func (i *Interleaver) Flush() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if i.timer != nil {
+		i.timer.Stop()
+		i.timer = nil
+	}
+	i.release(true)
+}
+
+// release passes on lines, earliest read first, while that's known to be
+// their place: every stream has a line waiting, or the earliest has waited
+// out the window. With all, every line is passed on. The caller holds i.lock.
+func (i *Interleaver) release(all bool) {
+	for {
+		next, waiting := "", false
+		var earliest time.Time
+		for _, stream := range i.streams {
+			queue := i.pending[stream]
+			if len(queue) == 0 {
+				waiting = true
+				continue
+			}
+			if next == "" || queue[0].read.Before(earliest) {
+				next, earliest = stream, queue[0].read
+			}
+		}
+		if next == "" {
+			return
+		}
+		if wait := i.window - Clock.Since(earliest); waiting && !all && wait > 0 {
+			i.schedule(wait)
+			return
+		}
+		line := i.pending[next][0]
+		i.pending[next] = i.pending[next][1:]
+		i.emit(line.source, line.read, line.logMessage)
+	}
+}
+
+// schedule releases lines again after wait, when the earliest has waited out
+// the window. The caller holds i.lock.
+func (i *Interleaver) schedule(wait time.Duration) {
+	if i.timer != nil {
+		i.timer.Stop()
+	}
+	i.timer = Clock.AfterFunc(wait, func() {
+		i.lock.Lock()
+		defer i.lock.Unlock()
+		i.release(false)
+	})
+}
+
+// A stampedReader notes when its latest read returned anything
+type stampedReader struct {
+	reader io.Reader
+	last   *int64
+}
+
+func (r *stampedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(r.last, Clock.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+// MaxLineBytes is the longest line NewScanner reads whole
+var MaxLineBytes int
+
+var linesTruncated = metrics.NewCounter("haberdasher_lines_truncated_total", "Lines cut short for exceeding HABERDASHER_MAX_LINE_BYTES.")
+
+// HABERDASHER_MAX_LINE_BYTES is the longest line read from the child before
+// it's cut short
+func init() {
+	config.OnLoad(func() {
+		MaxLineBytes, _ = config.IntSetting("HABERDASHER_MAX_LINE_BYTES", 1)
+	})
+}
+
+// NewScanner returns a scanner of the lines of r. A line longer than
+// MaxLineBytes is cut short, ending in a marker saying so, and the rest of it
+// skipped, where a bufio.Scanner would stop scanning altogether.
+func NewScanner(r io.Reader) *bufio.Scanner {
+	max := MaxLineBytes
+	scanner := bufio.NewScanner(r)
+	initial := 4096
+	if max+1 < initial {
+		initial = max + 1
+	}
+	scanner.Buffer(make([]byte, initial), max+1)
+	skipping := false
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if skipping {
+			newline := bytes.IndexByte(data, '\n')
+			if newline < 0 {
+				return len(data), nil, nil
+			}
+			skipping = false
+			return newline + 1, nil, nil
+		}
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if err != nil || len(token) <= max && (advance > 0 || len(data) <= max) {
+			return advance, token, err
+		}
+		// Cut the line short, and skip the rest of it
+		truncated := TruncateLine(data, max)
+		if advance > 0 {
+			return advance, truncated, nil
+		}
+		skipping = true
+		return len(data), truncated, nil
+	})
+	return scanner
+}
+
+// TruncateLine cuts a line longer than max bytes short at a character
+// boundary, ending it in a marker saying so, and counts it
+func TruncateLine(line []byte, max int) []byte {
+	cut := max
+	for cut > 0 && cut > max-utf8.UTFMax && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	linesTruncated.Inc()
+	return append(line[:cut:cut], fmt.Sprintf(" [truncated: line exceeded %d bytes]", max)...)
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Lookup resolves an emitter's name, or a comma separated list of names to
+// fan messages out to, like kafka,stderr. An unknown name is an error which
+// lists the registered emitters, rather than a nil Emitter.
+func Lookup(name string) (Emitter, error) {
+	names := strings.Split(name, ",")
+	var emitters []Emitter
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+		emitter, ok := Emitters[names[i]]
+		if !ok {
+			return nil, fmt.Errorf("unknown emitter %q; the registered emitters are %s", names[i], strings.Join(Names(), ", "))
+		}
+		emitters = append(emitters, emitter)
+	}
+	if len(emitters) == 1 {
+		return emitters[0], nil
+	}
+	return NewFanout(names, emitters), nil
+}
+
+// Names returns the names of the registered emitters, sorted
+func Names() []string {
+	names := make([]string, 0, len(Emitters))
+	for name := range Emitters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,231 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A queued line waiting to be shipped
+type queued struct {
+	source   Source
+	received time.Time
+	line     string
+}
+
+// A Queue sits between the streams we capture and the emitters, so a slow
+// backend builds up a backlog instead of an unbounded number of goroutines.
+// It has two lanes: error and fatal lines go in the priority lane, which the
+// workers always drain first, so when recovering from an outage the most
+// important messages reach the backend ahead of a backlog of chatter.
+//
+// Both lanes are bounded. When one is full, Push blocks, which in turn stops
+// us reading from the child's pipe.
+type Queue struct {
+	priority chan queued
+	normal   chan queued
+	handle   func(source Source, received time.Time, line string)
+	workers  sync.WaitGroup
+
+	// Held for reading by every Push, so Close doesn't close the lanes under
+	// one. Lines pushed once closed are dropped.
+	lock   sync.RWMutex
+	closed bool
+	// Set once Close has given up on the backlog, which is then dropped
+	abandoned int32
+
+	stallThreshold time.Duration
+	onStall        func(stalled time.Duration)
+
+	shedding int32
+	shed     uint64
+	// Lines logged below this rank in severityRanks are dropped
+	minimum int
+
+	// For spotting a stalled pipeline: lines pushed and handled, and when a
+	// line was last handled, in Unix nanoseconds
+	pushed      uint64
+	handled     uint64
+	lastHandled int64
+}
+
+// NewQueue starts a Queue with the given capacity per lane, and the given
+// number of workers calling handle. Emitters which wait for the backend to
+// acknowledge each write rely on there being many concurrent writes to batch
+// together, so use plenty of workers.
+func NewQueue(size int, workers int, handle func(source Source, received time.Time, line string)) *Queue {
+	q := &Queue{
+		priority: make(chan queued, size),
+		normal:   make(chan queued, size),
+		handle:   handle,
+	}
+	q.lastHandled = Clock.Now().UnixNano()
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// ReportStalls arranges for report to be called whenever Push blocks for at
+// least threshold because a lane is full. It must be called before the first
+// Push.
+func (q *Queue) ReportStalls(threshold time.Duration, report func(stalled time.Duration)) {
+	q.stallThreshold = threshold
+	q.onStall = report
+}
+
+// ShedDebug turns dropping of trace and debug lines on or off, returning how
+// many have been dropped since it was last called
+func (q *Queue) ShedDebug(on bool) uint64 {
+	var flag int32
+	if on {
+		flag = 1
+	}
+	atomic.StoreInt32(&q.shedding, flag)
+	return atomic.SwapUint64(&q.shed, 0)
+}
+
+// The order of the levels Severity normalizes to
+var severityRanks = map[string]int{"trace": 0, "debug": 1, "info": 2, "warn": 3, "error": 4, "fatal": 5}
+
+// DropBelow drops lines logged below level, one of those Severity returns.
+// Lines whose level can't be told are always kept. It must be called before
+// the first Push.
+func (q *Queue) DropBelow(level string) error {
+	rank, known := severityRanks[level]
+	if !known {
+		return fmt.Errorf("unknown level %q, rather than trace, debug, info, warn, error, or fatal", level)
+	}
+	q.minimum = rank
+	return nil
+}
+
+// Push adds a line to the lane its severity belongs in
+func (q *Queue) Push(source Source, line string) {
+	q.PushAt(source, Clock.Now(), line)
+}
+
+// PushAt is Push for a line received earlier than it's queued
+func (q *Queue) PushAt(source Source, received time.Time, line string) {
+	q.lock.RLock()
+	defer q.lock.RUnlock()
+	if q.closed {
+		Drop(line)
+		return
+	}
+	item := queued{source: source, received: received, line: line}
+	lane := q.normal
+	severity := Severity(line)
+	if rank, known := severityRanks[severity]; known && rank < q.minimum {
+		Drop(line)
+		return
+	}
+	switch severity {
+	case "error", "fatal":
+		lane = q.priority
+	case "trace", "debug":
+		if atomic.LoadInt32(&q.shedding) != 0 {
+			atomic.AddUint64(&q.shed, 1)
+			Drop(line)
+			return
+		}
+	}
+	atomic.AddUint64(&q.pushed, 1)
+	select {
+	case lane <- item:
+		return
+	default:
+	}
+
+	start := Clock.Now()
+	lane <- item
+	if stalled := Clock.Since(start); q.onStall != nil && stalled >= q.stallThreshold {
+		q.onStall(stalled)
+	}
+}
+
+// Progress reports how many lines are waiting or being handled, including
+// any a full lane is blocking, and when a line was last handled
+func (q *Queue) Progress() (pending uint64, lastHandled time.Time) {
+	handled := atomic.LoadUint64(&q.handled)
+	return atomic.LoadUint64(&q.pushed) - handled, time.Unix(0, atomic.LoadInt64(&q.lastHandled))
+}
+
+// Close stops accepting lines and waits for the backlog to be handled. Lines
+// pushed afterwards, by a reader that hasn't noticed we're shutting down, are
+// dropped.
+func (q *Queue) Close() {
+	q.CloseBy(nil)
+}
+
+// CloseBy is Close, giving up on the backlog when timeout fires, reporting
+// whether it was all handled. Lines still waiting then are dropped; those
+// being handled are left to finish on their own.
+func (q *Queue) CloseBy(timeout <-chan time.Time) bool {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.priority)
+		close(q.normal)
+	}
+	q.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-timeout:
+		atomic.StoreInt32(&q.abandoned, 1)
+		return false
+	}
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+	priority, normal := q.priority, q.normal
+	for priority != nil || normal != nil {
+		// Anything in the priority lane goes first
+		select {
+		case item, ok := <-priority:
+			if !ok {
+				priority = nil
+				continue
+			}
+			q.process(item)
+			continue
+		default:
+		}
+		select {
+		case item, ok := <-priority:
+			if !ok {
+				priority = nil
+				continue
+			}
+			q.process(item)
+		case item, ok := <-normal:
+			if !ok {
+				normal = nil
+				continue
+			}
+			q.process(item)
+		}
+	}
+}
+
+// process handles one line, recovering from a panic so the worker carries on
+// with the next
+func (q *Queue) process(item queued) {
+	if atomic.LoadInt32(&q.abandoned) != 0 {
+		Drop(item.line)
+		atomic.AddUint64(&q.handled, 1)
+		return
+	}
+	Recover("the queue", func() { q.handle(item.source, item.received, item.line) })
+	atomic.StoreInt64(&q.lastHandled, Clock.Now().UnixNano())
+	atomic.AddUint64(&q.handled, 1)
+}
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueCloseBy(t *testing.T) {
+	started, release := make(chan struct{}, 3), make(chan struct{})
+	var handled int32
+	q := NewQueue(10, 1, func(source Source, received time.Time, line string) {
+		started <- struct{}{}
+		<-release
+		atomic.AddInt32(&handled, 1)
+	})
+	for _, line := range []string{"one", "two", "three"} {
+		q.Push(Source{}, line)
+	}
+	<-started
+
+	// The backend is hung, so we give up on the backlog
+	timeout := make(chan time.Time)
+	close(timeout)
+	if q.CloseBy(timeout) {
+		t.Fatal("closed with the worker stuck on a line")
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for pending, _ := q.Progress(); pending > 0; pending, _ = q.Progress() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d lines still pending", pending)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The line being handled is finished, and the rest are dropped
+	if got := atomic.LoadInt32(&handled); got != 1 {
+		t.Errorf("handled %d lines after giving up, want 1", got)
+	}
+
+	// With nothing left, closing again doesn't wait
+	if !q.CloseBy(nil) {
+		t.Error("closing an idle queue gave up")
+	}
+}
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+var panicsRecovered = metrics.NewCounter("haberdasher_panics_total", "Panics recovered from in pipeline stages and emitters.")
+
+// Recover runs f, recovering from any panic in it. The panic is logged with
+// its stack, counted, and returned as an error, so the stage it happened in
+// can carry on with its next piece of work rather than taking the whole
+// sidecar, and with it the child's logs, down.
+func Recover(stage string, f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicsRecovered.Inc()
+			log.Printf("Recovered from a panic in %s: %v\n%s", stage, r, debug.Stack())
+			err = fmt.Errorf("%s panicked: %v", stage, r)
+		}
+	}()
+	f()
+	return nil
+}
+
+// A guardedEmitter recovers from panics in an emitter, failing the message
+// which caused it and restarting the emitter, since whatever panicked may
+// have left it in a bad state
+type guardedEmitter struct {
+	Emitter
+	name string
+
+	// Calls hold the lock for reading, so a restart waits for them to finish.
+	// Each restart starts a new generation, so several calls panicking at
+	// once only restart the emitter once.
+	lock       sync.RWMutex
+	generation int
+
+	// Set while announcing a restart, so if events go to this emitter and it
+	// panics again, it doesn't announce restarts forever
+	announcing int32
+}
+
+func guard(emitterType string, emitter Emitter) Emitter {
+	return &guardedEmitter{Emitter: emitter, name: emitterType}
+}
+
+func (g *guardedEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	g.lock.RLock()
+	generation := g.generation
+	var handleErr error
+	err := Recover("the "+g.name+" emitter", func() { handleErr = g.Emitter.HandleLogMessage(jsonSerializeable) })
+	g.lock.RUnlock()
+	if err != nil {
+		if g.restart(generation) && atomic.CompareAndSwapInt32(&g.announcing, 0, 1) {
+			EmitEvent(g, "emitter-restarted", fmt.Sprintf("Restarted the %s emitter after it panicked: %v", g.name, err))
+			atomic.StoreInt32(&g.announcing, 0)
+		}
+		return err
+	}
+	return handleErr
+}
+
+func (g *guardedEmitter) CheckHealth() error {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	var healthErr error
+	if err := Recover("the "+g.name+" emitter's health check", func() { healthErr = CheckHealth(g.Emitter) }); err != nil {
+		return err
+	}
+	return healthErr
+}
+
+func (g *guardedEmitter) Cleanup() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	var cleanupErr error
+	if err := Recover("the "+g.name+" emitter's cleanup", func() { cleanupErr = g.Emitter.Cleanup() }); err != nil {
+		return err
+	}
+	return cleanupErr
+}
+
+// restart cleans the emitter up and sets it up again, unless it's already
+// been restarted since generation, returning whether it did
+func (g *guardedEmitter) restart(generation int) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.generation != generation {
+		return false
+	}
+	g.generation++
+	Recover("the "+g.name+" emitter's cleanup", func() {
+		if err := g.Emitter.Cleanup(); err != nil {
+			log.Println("Error cleaning up the", g.name, "emitter:", err)
+		}
+	})
+	return Recover("the "+g.name+" emitter's setup", g.Emitter.Setup) == nil
+}
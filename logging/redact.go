@@ -0,0 +1,112 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// A Redaction rewrites whatever matches a pattern in every line before it's
+// shipped, so secrets and personal data never leave the pod
+type Redaction struct {
+	Name    string `json:"name"`
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+
+	pattern *regexp.Regexp
+}
+
+const defaultRedactionReplacement = "[REDACTED]"
+
+var redactionsLock sync.RWMutex
+var redactions []Redaction
+
+// HABERDASHER_REDACTIONS is a JSON array of redactions, each with a name, a
+// regex, and optionally what to replace matches with (by default
+// "[REDACTED]"; $1 and the like expand to submatches). They're applied in
+// order. Policy fragments can add more with SetRedactions. A misspelled key
+// is an error rather than ignored, since a redaction missing its pattern
+// would otherwise fail silently.
+func init() {
+	config.OnLoad(func() {
+		redactionsFromEnv, exists := config.Setting("HABERDASHER_REDACTIONS")
+		if !exists {
+			return
+		}
+		var rules []Redaction
+		decoder := json.NewDecoder(bytes.NewReader([]byte(redactionsFromEnv)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rules); err != nil {
+			log.Fatal("HABERDASHER_REDACTIONS must be a JSON array of redactions: ", err)
+		}
+		if err := SetRedactions(rules); err != nil {
+			log.Fatal("HABERDASHER_REDACTIONS: ", err)
+		}
+	})
+}
+
+// Redactions returns a copy of the redactions currently applied
+func Redactions() []Redaction {
+	redactionsLock.RLock()
+	defer redactionsLock.RUnlock()
+	return append([]Redaction(nil), redactions...)
+}
+
+// SetRedactions replaces the redactions applied to every line. If any of them
+// is invalid, the current ones are kept.
+func SetRedactions(rules []Redaction) error {
+	compiled := make([]Redaction, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("every redaction needs a name")
+		}
+		// The empty pattern matches between every character
+		if rule.Match == "" {
+			return fmt.Errorf("%s: the redaction has no match pattern", rule.Name)
+		}
+		var err error
+		if rule.pattern, err = regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("%s: %v", rule.Name, err)
+		}
+		if rule.Replace == "" {
+			rule.Replace = defaultRedactionReplacement
+		}
+		compiled[i] = rule
+	}
+	redactionsLock.Lock()
+	redactions = compiled
+	redactionsLock.Unlock()
+	return nil
+}
+
+// Redact applies the redactions to a line
+func Redact(line string) string {
+	redactionsLock.RLock()
+	rules := redactions
+	redactionsLock.RUnlock()
+	for _, rule := range rules {
+		line = rule.pattern.ReplaceAllString(line, rule.Replace)
+	}
+	return line
+}
+
+// RedactExplained is Redact, also returning the names of the redactions which
+// rewrote the line, in the order they were applied
+func RedactExplained(line string) (string, []string) {
+	redactionsLock.RLock()
+	rules := redactions
+	redactionsLock.RUnlock()
+	var matched []string
+	for _, rule := range rules {
+		if rewritten := rule.pattern.ReplaceAllString(line, rule.Replace); rewritten != line {
+			matched = append(matched, rule.Name)
+			line = rewritten
+		}
+	}
+	return line, matched
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	defer SetRedactions(nil)
+	err := SetRedactions([]Redaction{
+		{Name: "card", Match: `[0-9]{16}`},
+		{Name: "email", Match: `([a-z]+)@example\.com`, Replace: "$1@[domain]"},
+		{Name: "domain", Match: `\[domain\]`, Replace: "[hidden]"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line    string
+		want    string
+		matched []string
+	}{
+		{"paid with 4111111111111111", "paid with [REDACTED]", []string{"card"}},
+		// Each redaction sees what the ones before it left
+		{"mail ann@example.com, card 4111111111111111", "mail ann@[hidden], card [REDACTED]", []string{"card", "email", "domain"}},
+		{"nothing to see", "nothing to see", nil},
+	}
+	for _, test := range tests {
+		if got := Redact(test.line); got != test.want {
+			t.Errorf("Redact(%q) = %q, want %q", test.line, got, test.want)
+		}
+		got, matched := RedactExplained(test.line)
+		if got != test.want || !reflect.DeepEqual(matched, test.matched) {
+			t.Errorf("RedactExplained(%q) = %q, %q, want %q, %q", test.line, got, matched, test.want, test.matched)
+		}
+	}
+	if names := Redactions(); len(names) != 3 || names[0].Replace != "[REDACTED]" {
+		t.Errorf("Redactions() = %+v", names)
+	}
+}
+
+// An invalid set of redactions is refused whole, keeping the ones in force
+func TestSetRedactionsRejects(t *testing.T) {
+	defer SetRedactions(nil)
+	if err := SetRedactions([]Redaction{{Name: "card", Match: `[0-9]{16}`}}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		rules []Redaction
+		want  string
+	}{
+		{"no name", []Redaction{{Match: "x"}}, "every redaction needs a name"},
+		{"no pattern", []Redaction{{Name: "typo", Replace: "x"}}, "typo: the redaction has no match pattern"},
+		{"bad pattern", []Redaction{{Name: "ok", Match: "ok"}, {Name: "broken", Match: "(unclosed"}}, "broken: error parsing regexp"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := SetRedactions(test.rules); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+			if got := Redact("4111111111111111 ok"); got != "[REDACTED] ok" {
+				t.Errorf("after a refused update, redacted to %q", got)
+			}
+		})
+	}
+}
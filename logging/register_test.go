@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// The stages wrapping an emitter take their settings from the configuration
+// file, which is only read after the emitters have registered
+func TestRegisterReadsConfigurationFile(t *testing.T) {
+	variables := []string{
+		"HABERDASHER_STRIP_ANSI",
+		"HABERDASHER_CONFIGURED_BANDWIDTH",
+		"HABERDASHER_CONFIGURED_MAX_GOROUTINES",
+		"HABERDASHER_CONFIGURED_MAX_INFLIGHT_BYTES",
+	}
+	for _, variable := range variables {
+		if _, set := os.LookupEnv(variable); set {
+			t.Skipf("%s is set", variable)
+		}
+	}
+	t.Cleanup(func() {
+		for _, variable := range variables {
+			os.Unsetenv(variable)
+		}
+		delete(Emitters, "configured")
+	})
+
+	Register("configured", &recordingEmitter{})
+	if _, stripped := Emitters["configured"].(*guardedEmitter).Emitter.(*ansiStrippedEmitter); !stripped {
+		t.Fatal("escape sequences aren't stripped by default")
+	}
+
+	path := filepath.Join(t.TempDir(), "haberdasher.yaml")
+	file := `strip_ansi: "off"
+environment:
+  HABERDASHER_CONFIGURED_BANDWIDTH: "1000"
+  HABERDASHER_CONFIGURED_MAX_GOROUTINES: "50"
+  HABERDASHER_CONFIGURED_MAX_INFLIGHT_BYTES: "4096"
+`
+	if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Load([]string{"haberdasher", "--config", path}); err != nil {
+		t.Fatal(err)
+	}
+
+	throttled, isThrottled := Emitters["configured"].(*guardedEmitter).Emitter.(*throttledEmitter)
+	if !isThrottled {
+		t.Fatalf("strip_ansi: off and the bandwidth from the file gave %T", Emitters["configured"].(*guardedEmitter).Emitter)
+	}
+	if throttled.bucket.rate != 1000 {
+		t.Errorf("bandwidth %v, want 1000", throttled.bucket.rate)
+	}
+	sandboxed, isSandboxed := throttled.Emitter.(*sandboxedEmitter)
+	if !isSandboxed {
+		t.Fatalf("the budgets from the file gave %T", throttled.Emitter)
+	}
+	if sandboxed.maxGoroutines != 50 || sandboxed.maxBytes != 4096 {
+		t.Errorf("budgets of %d goroutines and %d bytes, want 50 and 4096", sandboxed.maxGoroutines, sandboxed.maxBytes)
+	}
+}
@@ -0,0 +1,11 @@
+package logging
+
+import "time"
+
+// NextRotation returns the next wall-clock boundary of the interval after now,
+// so an hourly rotation lands on the hour rather than an hour after startup.
+// The file emitter rotates its file, and the child is signalled to rotate its
+// own, on the same boundaries.
+func NextRotation(now time.Time, interval time.Duration) time.Time {
+	return now.Truncate(interval).Add(interval)
+}
@@ -0,0 +1,31 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRotation(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, "2020-10-29T"+clock+"Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	tests := []struct {
+		now      string
+		interval time.Duration
+		want     string
+	}{
+		{"22:41:58", time.Hour, "23:00:00"},
+		{"22:00:00", time.Hour, "23:00:00"},
+		{"22:41:58", 15 * time.Minute, "22:45:00"},
+		{"22:44:59", 15 * time.Minute, "22:45:00"},
+	}
+	for _, test := range tests {
+		if got := NextRotation(at(test.now), test.interval); !got.Equal(at(test.want)) {
+			t.Errorf("NextRotation(%s, %s) = %s, want %s", test.now, test.interval, got.Format("15:04:05"), test.want)
+		}
+	}
+}
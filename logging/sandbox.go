@@ -0,0 +1,281 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+// How often emitters are checked against their budgets
+const budgetInterval = 10 * time.Second
+
+// How long a restart waits for calls into the emitter to finish
+const budgetDrainTimeout = 10 * time.Second
+
+// A restart can't stop goroutines which have truly leaked, so an emitter isn't
+// restarted again for a while, rather than over and over
+const budgetCooldown = time.Minute
+
+// Goroutines started by an emitter carry this profiler label, so they can be
+// counted even once they've leaked
+const emitterLabel = "haberdasher_emitter"
+
+// A sandboxedEmitter holds an emitter to budgets on how many goroutines it
+// starts and the size of the messages in calls into it, restarting it when it
+// goes over, so a leaky connector can't take down the whole sidecar. Go can't
+// tell how much of the heap is an emitter's, so the size of its in-flight
+// messages stands in for its memory.
+type sandboxedEmitter struct {
+	Emitter
+	name          string
+	maxGoroutines int
+	maxBytes      int
+
+	lock       sync.Mutex
+	idle       *sync.Cond
+	restarting bool
+	inflight   int
+	heldBytes  int
+	restarted  time.Time
+	monitored  bool
+
+	goroutines *metrics.Gauge
+	held       *metrics.Gauge
+	restarts   *metrics.Counter
+}
+
+var sandboxes []*sandboxedEmitter
+var sandboxesLock sync.Mutex
+var budgetMonitor sync.Once
+
+// sandbox wraps an emitter in budgets if HABERDASHER_<NAME>_MAX_GOROUTINES or
+// HABERDASHER_<NAME>_MAX_INFLIGHT_BYTES is set
+func sandbox(emitterType string, emitter Emitter) Emitter {
+	prefix := "HABERDASHER_" + strings.ToUpper(strings.Replace(emitterType, "-", "_", -1))
+	maxGoroutines, limitGoroutines := config.IntSetting(prefix+"_MAX_GOROUTINES", 1)
+	maxBytes, limitBytes := config.IntSetting(prefix+"_MAX_INFLIGHT_BYTES", 1)
+	if !limitGoroutines && !limitBytes {
+		return emitter
+	}
+	metricName := strings.Replace(emitterType, "-", "_", -1)
+	s := &sandboxedEmitter{
+		Emitter:       emitter,
+		name:          emitterType,
+		maxGoroutines: maxGoroutines,
+		maxBytes:      maxBytes,
+		goroutines:    metrics.NewGauge("haberdasher_emitter_"+metricName+"_goroutines", "Goroutines run by the "+emitterType+" emitter."),
+		held:          metrics.NewGauge("haberdasher_emitter_"+metricName+"_inflight_bytes", "Approximate JSON bytes of the messages in calls into the "+emitterType+" emitter."),
+		restarts:      metrics.NewCounter("haberdasher_emitter_"+metricName+"_restarts_total", "Restarts of the "+emitterType+" emitter for exceeding its budgets."),
+	}
+	s.idle = sync.NewCond(&s.lock)
+	return s
+}
+
+// Setup sets the emitter up with its goroutines labelled, and starts checking
+// it against its budgets. It's set up again when restarted after a panic.
+func (s *sandboxedEmitter) Setup() {
+	s.labelled(s.Emitter.Setup)
+	sandboxesLock.Lock()
+	if !s.monitored {
+		s.monitored = true
+		sandboxes = append(sandboxes, s)
+	}
+	sandboxesLock.Unlock()
+	budgetMonitor.Do(func() { go monitorBudgets() })
+}
+
+func (s *sandboxedEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	size := 0
+	if s.maxBytes > 0 {
+		size = messageSize(jsonSerializeable)
+	}
+	s.lock.Lock()
+	for s.restarting {
+		s.idle.Wait()
+	}
+	s.inflight++
+	s.heldBytes += size
+	s.lock.Unlock()
+
+	var err error
+	s.labelled(func() { err = s.Emitter.HandleLogMessage(jsonSerializeable) })
+
+	s.lock.Lock()
+	s.inflight--
+	s.heldBytes -= size
+	if s.inflight == 0 {
+		s.idle.Broadcast()
+	}
+	s.lock.Unlock()
+	return err
+}
+
+// messageSize approximates a message's JSON size from the lengths of its
+// strings, without serializing it again
+func messageSize(jsonSerializeable interface{}) int {
+	switch v := jsonSerializeable.(type) {
+	case Message:
+		// The field names, punctuation, and timestamp come to about this
+		size := 100 + len(v.Message) + len(v.Level) + len(v.FilePath) + len(v.Stream) + len(v.Dataset) + len(v.OriginFile) + len(v.EventAction)
+		for key, value := range v.Labels {
+			size += len(key) + len(value) + 6
+		}
+		for _, tag := range v.Tags {
+			size += len(tag) + 3
+		}
+		return size
+	case *Message:
+		return messageSize(*v)
+	case map[string]interface{}:
+		size := 2
+		for key, value := range v {
+			size += len(key) + 4 + messageSize(value)
+		}
+		return size
+	case []interface{}:
+		size := 2
+		for _, value := range v {
+			size += messageSize(value) + 1
+		}
+		return size
+	case string:
+		return len(v) + 2
+	}
+	// Numbers, booleans, and null
+	return 8
+}
+
+func (s *sandboxedEmitter) CheckHealth() error {
+	return CheckHealth(s.Emitter)
+}
+
+// labelled runs f with the emitter's profiler label, which any goroutines it
+// starts inherit
+func (s *sandboxedEmitter) labelled(f func()) {
+	pprof.Do(context.Background(), pprof.Labels(emitterLabel, s.name), func(context.Context) { f() })
+}
+
+// check compares the emitter with its budgets, restarting it if it's over
+func (s *sandboxedEmitter) check(goroutines int) {
+	s.lock.Lock()
+	held := s.heldBytes
+	s.lock.Unlock()
+	s.goroutines.Set(float64(goroutines))
+	s.held.Set(float64(held))
+
+	var over string
+	switch {
+	case s.maxGoroutines > 0 && goroutines > s.maxGoroutines:
+		over = fmt.Sprintf("is running %d goroutines, over its budget of %d", goroutines, s.maxGoroutines)
+	case s.maxBytes > 0 && held > s.maxBytes:
+		over = fmt.Sprintf("has %d bytes of messages in flight, over its budget of %d", held, s.maxBytes)
+	default:
+		return
+	}
+	if !s.restarted.IsZero() && Clock.Since(s.restarted) < budgetCooldown {
+		log.Println("Warning: the", s.name, "emitter", over)
+		return
+	}
+	s.restart(over)
+}
+
+// restart holds new messages back, waits for the ones being handled to
+// finish, and then cleans the emitter up and sets it up again. If they don't
+// finish in time, the emitter is left as it is, since cleaning it up under
+// them could lose or corrupt their messages.
+func (s *sandboxedEmitter) restart(reason string) {
+	deadline := Clock.Now().Add(budgetDrainTimeout)
+	s.lock.Lock()
+	s.restarting = true
+	for s.inflight > 0 && Clock.Now().Before(deadline) {
+		s.lock.Unlock()
+		Clock.Sleep(50 * time.Millisecond)
+		s.lock.Lock()
+	}
+	stuck := s.inflight
+	drained := stuck == 0
+	if drained {
+		if err := s.Emitter.Cleanup(); err != nil {
+			log.Println("Error cleaning up the", s.name, "emitter:", err)
+		}
+		s.labelled(s.Emitter.Setup)
+		s.restarts.Inc()
+	}
+	s.restarted = Clock.Now()
+	s.restarting = false
+	s.idle.Broadcast()
+	s.lock.Unlock()
+
+	if drained {
+		EmitEvent(s, "emitter-restarted", fmt.Sprintf("Restarted the %s emitter, which %s", s.name, reason))
+	} else {
+		EmitEvent(s, "emitter-over-budget", fmt.Sprintf("The %s emitter %s, but couldn't be restarted with %d messages still being handled", s.name, reason, stuck))
+	}
+}
+
+func monitorBudgets() {
+	for {
+		Clock.Sleep(budgetInterval)
+		counts := labelledGoroutines()
+		sandboxesLock.Lock()
+		current := append([]*sandboxedEmitter(nil), sandboxes...)
+		sandboxesLock.Unlock()
+		for _, s := range current {
+			s.check(counts[s.name])
+		}
+	}
+}
+
+// The frame on the stack of a goroutine calling into an emitter, which carries
+// the emitter's label only for the length of the call
+const labelledFrame = "logging.(*sandboxedEmitter).labelled"
+
+// labelledGoroutines counts the running goroutines carrying each emitter's
+// label, from the goroutine profile. The goroutines calling into the emitter,
+// such as queue workers waiting on a slow backend, carry it too while they're
+// inside labelled, and aren't counted: only the goroutines the emitter started
+// are.
+func labelledGoroutines() map[string]int {
+	var profile bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&profile, 1)
+	counts := make(map[string]int)
+	marker := `"` + emitterLabel + `":"`
+	stack, name, calling := 0, "", false
+	record := func() {
+		if name != "" && !calling {
+			counts[name] += stack
+		}
+		stack, name, calling = 0, "", false
+	}
+	scanner := bufio.NewScanner(&profile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == "@" {
+			record()
+			stack, _ = strconv.Atoi(fields[0])
+			continue
+		}
+		if strings.HasPrefix(line, "# labels: ") {
+			if i := strings.Index(line, marker); i >= 0 {
+				name = line[i+len(marker):]
+				if end := strings.IndexByte(name, '"'); end >= 0 {
+					name = name[:end]
+				}
+			}
+		} else if strings.HasPrefix(line, "#") && strings.Contains(line, labelledFrame) {
+			calling = true
+		}
+	}
+	record()
+	return counts
+}
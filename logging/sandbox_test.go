@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// messageSize only has to be close enough to budget by
+func TestMessageSize(t *testing.T) {
+	messages := []interface{}{
+		Message{ECSVersion: defaultEcsVersion, Timestamp: time.Now(), Labels: map[string]string{}, Tags: []string{}, Message: "hi"},
+		Message{ECSVersion: defaultEcsVersion, Timestamp: time.Now(), Labels: map[string]string{"app": "checkout", "team": "payments"}, Tags: []string{"web"}, Message: strings.Repeat("x", 2000), Level: "error", FilePath: "/var/log/app.log"},
+		map[string]interface{}{"message": strings.Repeat("y", 500), "level": "info", "attempt": 3.0, "ok": true, "tags": []interface{}{"a", "b"}, "nested": map[string]interface{}{"k": "v"}},
+	}
+	for i, m := range messages {
+		jsonBytes, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, want := messageSize(m), len(jsonBytes)
+		if got < want/2 || got > want*2 {
+			t.Errorf("message %d: estimated %d bytes, but it's %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// The versions of the envelope wrapped messages are shipped in. Version 1 was
+// the bare ECS envelope: ecs.version, @timestamp, labels, tags, and message.
+// Version 2 adds the rest of Message's fields, and haberdasher.schema to say
+// which version it is. Fields may be added to a version, but never renamed or
+// removed; that takes a new one.
+const (
+	SchemaV1      = 1
+	SchemaV2      = 2
+	currentSchema = SchemaV2
+)
+
+// Schema is the envelope version wrapped messages are shipped in
+var Schema = currentSchema
+
+// HABERDASHER_SCHEMA ships an earlier version of the envelope, while
+// downstream parsers are migrated to the current one
+func init() {
+	config.OnLoad(func() {
+		fromEnv, _ := config.Setting("HABERDASHER_SCHEMA")
+		version, err := strconv.Atoi(fromEnv)
+		if err != nil || version < SchemaV1 || version > currentSchema {
+			log.Fatalf("HABERDASHER_SCHEMA must be a version from %d to %d", SchemaV1, currentSchema)
+		}
+		Schema = version
+	})
+}
+
+type messageV1 struct {
+	ECSVersion string            `json:"ecs.version"`
+	Timestamp  time.Time         `json:"@timestamp"`
+	Labels     map[string]string `json:"labels"`
+	Tags       []string          `json:"tags"`
+	Message    string            `json:"message"`
+}
+
+// Marshalling an alias of Message doesn't come back here
+type messageFields Message
+
+type messageV2 struct {
+	Schema int `json:"haberdasher.schema"`
+	messageFields
+}
+
+// MarshalJSON writes the message in the envelope version Schema says
+func (m Message) MarshalJSON() ([]byte, error) {
+	if Schema == SchemaV1 {
+		return json.Marshal(messageV1{
+			ECSVersion: m.ECSVersion,
+			Timestamp:  m.Timestamp,
+			Labels:     m.Labels,
+			Tags:       m.Tags,
+			Message:    m.Message,
+		})
+	}
+	return json.Marshal(messageV2{Schema: SchemaV2, messageFields: messageFields(m)})
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// The envelopes are a contract with downstream parsers: a change to either of
+// these is a change to the wire format, and needs a new version instead
+func TestMessageEnvelopes(t *testing.T) {
+	defer func(schema int) { Schema = schema }(Schema)
+	m := Message{
+		ECSVersion:  defaultEcsVersion,
+		Timestamp:   time.Date(2020, 10, 29, 22, 41, 58, 20000000, time.UTC),
+		Labels:      map[string]string{"app": "checkout"},
+		Tags:        []string{"web"},
+		Message:     "E1029 22:41:58.020345 server.go:42] connection refused",
+		Level:       "error",
+		FilePath:    "/var/log/app.log",
+		Stream:      "stderr",
+		Dataset:     "access",
+		OriginFile:  "server.go",
+		OriginLine:  42,
+		EventAction: "binary-data",
+		Annotations: Annotations{AnnotationTenant: "acme"},
+	}
+	tests := []struct {
+		schema int
+		want   string
+	}{
+		{SchemaV1, `{"ecs.version":"1.5.0","@timestamp":"2020-10-29T22:41:58.02Z","labels":{"app":"checkout"},"tags":["web"],"message":"E1029 22:41:58.020345 server.go:42] connection refused"}`},
+		{SchemaV2, `{"haberdasher.schema":2,"ecs.version":"1.5.0","@timestamp":"2020-10-29T22:41:58.02Z","labels":{"app":"checkout"},"tags":["web"],"message":"E1029 22:41:58.020345 server.go:42] connection refused","log.level":"error","log.file.path":"/var/log/app.log","stream":"stderr","event.dataset":"access","log.origin.file.name":"server.go","log.origin.file.line":42,"event.action":"binary-data"}`},
+	}
+	for _, test := range tests {
+		Schema = test.schema
+		got, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("version %d:\n got %s\nwant %s", test.schema, got, test.want)
+		}
+	}
+
+	// Version 2 leaves out the optional fields which aren't set
+	Schema = SchemaV2
+	got, err := json.Marshal(Message{ECSVersion: defaultEcsVersion, Timestamp: m.Timestamp, Labels: map[string]string{}, Tags: []string{}, Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"haberdasher.schema":2,"ecs.version":"1.5.0","@timestamp":"2020-10-29T22:41:58.02Z","labels":{},"tags":[],"message":"hello"}`
+	if string(got) != want {
+		t.Errorf("a bare message:\n got %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Only the start of a line is searched for its level, where loggers put it
+var severityWindow = 80
+
+var glogSeverity = regexp.MustCompile(`^([IWEF])\d{4} `)
+var severityWord = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|CRITICAL|SEVERE|FATAL|PANIC)\b|\blevel=(\w+)`)
+
+var severityNames = map[string]string{
+	"trace":    "trace",
+	"debug":    "debug",
+	"dbg":      "debug",
+	"info":     "info",
+	"notice":   "info",
+	"i":        "info",
+	"warn":     "warn",
+	"warning":  "warn",
+	"w":        "warn",
+	"error":    "error",
+	"err":      "error",
+	"e":        "error",
+	"critical": "fatal",
+	"crit":     "fatal",
+	"severe":   "fatal",
+	"fatal":    "fatal",
+	"panic":    "fatal",
+	"f":        "fatal",
+}
+
+// Severity works out the level a line was logged at, normalized to one of
+// trace, debug, info, warn, error, or fatal. It understands JSON lines with a
+// level field, glog prefixes, and level words like "ERROR" or "level=error"
+// near the start of the line. It returns "" if it can't tell.
+func Severity(logMessage string) string {
+	if strings.HasPrefix(logMessage, "{") {
+		var fields struct {
+			Level    string `json:"level"`
+			LogLevel string `json:"log.level"`
+			Severity string `json:"severity"`
+		}
+		if json.Unmarshal([]byte(logMessage), &fields) == nil {
+			for _, level := range []string{fields.LogLevel, fields.Level, fields.Severity} {
+				if normalized, ok := severityNames[strings.ToLower(level)]; ok {
+					return normalized
+				}
+			}
+			return ""
+		}
+	}
+	// A coloured level word is still a level word
+	logMessage = StripANSI(logMessage)
+	if match := glogSeverity.FindStringSubmatch(logMessage); match != nil {
+		return severityNames[strings.ToLower(match[1])]
+	}
+	prefix := logMessage
+	if len(prefix) > severityWindow {
+		prefix = prefix[:severityWindow]
+	}
+	if match := severityWord.FindStringSubmatch(prefix); match != nil {
+		return severityNames[strings.ToLower(match[1]+match[2])]
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package logging
+
+import "github.com/RedHatInsights/haberdasher/metrics"
+
+var linesReceived = metrics.NewCounter("haberdasher_lines_total", "Lines received to be shipped.")
+var bytesReceived = metrics.NewCounter("haberdasher_bytes_total", "Bytes of lines received to be shipped.")
+var messagesDropped = metrics.NewCounter("haberdasher_messages_dropped_total", "Lines deliberately not shipped: expired, shed, or routed nowhere.")
+var messagesFailed = metrics.NewCounter("haberdasher_messages_failed_total", "Messages the emitter failed to ship.")
+
+// Stats totals what has been shipped so far
+type Stats struct {
+	Lines   uint64
+	Bytes   uint64
+	Dropped uint64
+	Failed  uint64
+}
+
+// CurrentStats returns the totals since we started
+func CurrentStats() Stats {
+	return Stats{
+		Lines:   linesReceived.Value(),
+		Bytes:   bytesReceived.Value(),
+		Dropped: messagesDropped.Value(),
+		Failed:  messagesFailed.Value(),
+	}
+}
+
+func received(line string) {
+	linesReceived.Inc()
+	bytesReceived.Add(uint64(len(line)))
+}
+
+// Drop accounts for a line which is deliberately not being shipped
+func Drop(line string) {
+	received(line)
+	messagesDropped.Inc()
+}
+
+// Failed accounts for messages an emitter gave up on after HandleLogMessage
+// had returned, such as those it was waiting to see acknowledged
+func Failed(messages int) {
+	messagesFailed.Add(uint64(messages))
+}
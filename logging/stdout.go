@@ -0,0 +1,19 @@
+package logging
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+var stdoutLock sync.Mutex
+
+// WriteStdout writes a line to our stdout in one piece, so the records of the
+// stdout-json emitter and the child's lines mirrored there don't tear each
+// other
+func WriteStdout(line string) error {
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	_, err := io.WriteString(os.Stdout, line+"\n")
+	return err
+}
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// MergeStructured is set when lines which are JSON objects are merged over
+// the envelope unstructured lines are wrapped in, rather than shipped as they
+// are
+var MergeStructured bool
+
+// HABERDASHER_JSON says how lines which are JSON objects are shipped:
+// passthrough (the default) ships them as they are, and merge adds our own
+// metadata to them
+func init() {
+	config.OnLoad(func() {
+		switch mode, _ := config.Setting("HABERDASHER_JSON"); mode {
+		case "", "passthrough":
+		case "merge":
+			MergeStructured = true
+		default:
+			log.Fatal("HABERDASHER_JSON must be passthrough or merge")
+		}
+	})
+}
+
+// structured returns what's shipped for a line decoded as a JSON object. When
+// merging, its fields win over the envelope's, except that labels are the
+// default labels and the source's with the line's own added.
+func structured(source Source, timestamp time.Time, decoded map[string]interface{}) map[string]interface{} {
+	if !MergeStructured {
+		return decoded
+	}
+	m := NewMessage("")
+	m.Timestamp = timestamp
+	m.FilePath = source.Path
+	m.Stream = source.Stream
+	m.Dataset = source.Dataset
+	envelope, err := json.Marshal(m)
+	var merged map[string]interface{}
+	if err == nil {
+		err = json.Unmarshal(envelope, &merged)
+	}
+	if err != nil {
+		return decoded
+	}
+	delete(merged, "message")
+	labels := make(map[string]interface{}, len(m.Labels)+len(source.Labels))
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	for k, v := range source.Labels {
+		labels[k] = v
+	}
+	merged["labels"] = labels
+	for k, v := range decoded {
+		if own, isObject := v.(map[string]interface{}); k == "labels" && isObject {
+			for label, value := range own {
+				labels[label] = value
+			}
+			continue
+		}
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// A bucket is a token bucket of bytes: it fills at rate bytes a second, up to
+// burst bytes
+type bucket struct {
+	rate   float64
+	burst  float64
+	lock   sync.Mutex
+	tokens float64
+	filled time.Time
+}
+
+// take waits until n bytes may be sent. A message bigger than the burst is
+// let through once the bucket is full, and the bucket goes into debt for it.
+func (b *bucket) take(n int) {
+	b.lock.Lock()
+	now := Clock.Now()
+	b.tokens += now.Sub(b.filled).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.filled = now
+	need := float64(n)
+	if need > b.burst {
+		need = b.burst
+	}
+	var wait time.Duration
+	if b.tokens < need {
+		wait = time.Duration((need - b.tokens) / b.rate * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	b.lock.Unlock()
+	Clock.Sleep(wait)
+}
+
+// A throttledEmitter caps how fast an emitter sends, so log shipping can't
+// saturate a constrained link
+type throttledEmitter struct {
+	Emitter
+	bucket *bucket
+}
+
+func (t *throttledEmitter) HandleLogMessage(jsonSerializeable interface{}) error {
+	if jsonBytes, err := json.Marshal(jsonSerializeable); err == nil {
+		t.bucket.take(len(jsonBytes))
+	}
+	return t.Emitter.HandleLogMessage(jsonSerializeable)
+}
+
+func (t *throttledEmitter) CheckHealth() error {
+	return CheckHealth(t.Emitter)
+}
+
+// throttle wraps an emitter in a bandwidth cap if HABERDASHER_<NAME>_BANDWIDTH
+// is set to a number of bytes a second. HABERDASHER_<NAME>_BANDWIDTH_BURST is
+// how many bytes can be sent at once after a quiet spell, by default a
+// second's worth.
+func throttle(emitterType string, emitter Emitter) Emitter {
+	prefix := "HABERDASHER_" + strings.ToUpper(strings.Replace(emitterType, "-", "_", -1)) + "_BANDWIDTH"
+	rate, exists := config.IntSetting(prefix, 1)
+	if !exists {
+		return emitter
+	}
+	burst, exists := config.IntSetting(prefix+"_BURST", 1)
+	if !exists {
+		burst = rate
+	}
+	return &throttledEmitter{
+		Emitter: emitter,
+		bucket:  &bucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), filled: Clock.Now()},
+	}
+}
@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+var parseTimestamps bool
+var assumedZone = time.Local
+var assumedZoneOverrides = make(map[string]*time.Location)
+
+// Timestamps found at the start of common log formats, optionally in square
+// brackets. The first group is the time and the second, if present, the zone.
+var timestampPatterns = []struct {
+	pattern *regexp.Regexp
+	layout  string
+}{
+	// 2020-10-29T22:41:58.020Z, [2020-10-29 22:41:58,020], 2020-10-29 22:41:58+02:00
+	{regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)(Z|[+-]\d{2}:?\d{2})?\]?\s`), "2006-01-02 15:04:05.999999999"},
+	// Go's log package: 2020/10/29 22:41:58.020345
+	{regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?)()\s`), "2006/01/02 15:04:05.999999999"},
+	// glog and klog, which leave out the year: I1029 22:41:58.020345
+	{regexp.MustCompile(`^[IWEF](\d{4} \d{2}:\d{2}:\d{2}\.\d+)()\s`), "0102 15:04:05.999999999"},
+}
+
+// Setting HABERDASHER_PARSE_TIMESTAMPS takes the @timestamp of wrapped messages
+// from the start of the log line, when there's a recognizable time there,
+// rather than from when we received it. Legacy apps often print local times
+// with no zone, which are assumed to be in HABERDASHER_ASSUME_TZ (an IANA zone
+// like "America/New_York", defaulting to our local zone). Sources in other
+// zones can be overridden with HABERDASHER_ASSUME_TZ_OVERRIDES, a JSON object
+// mapping source names (a tailed file's path, or "stderr") to zones.
+func init() {
+	config.OnLoad(func() {
+		parseTimestamps = config.FlagSetting("HABERDASHER_PARSE_TIMESTAMPS")
+		if zone, exists := config.Setting("HABERDASHER_ASSUME_TZ"); exists {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				log.Fatal("HABERDASHER_ASSUME_TZ must be a time zone name: ", err)
+			}
+			assumedZone = loc
+		}
+		overridesFromEnv, exists := config.Setting("HABERDASHER_ASSUME_TZ_OVERRIDES")
+		if !exists {
+			return
+		}
+		var overrides map[string]string
+		if err := json.Unmarshal([]byte(overridesFromEnv), &overrides); err != nil {
+			log.Fatal("HABERDASHER_ASSUME_TZ_OVERRIDES must be a JSON object of sources to time zone names")
+		}
+		for source, zone := range overrides {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				log.Fatal("HABERDASHER_ASSUME_TZ_OVERRIDES: ", err)
+			}
+			assumedZoneOverrides[source] = loc
+		}
+	})
+}
+
+// parseTimestamp finds the time a log line was written from its prefix, if
+// timestamp parsing is enabled and it has one
+func parseTimestamp(source Source, logMessage string) (time.Time, bool) {
+	if !parseTimestamps {
+		return time.Time{}, false
+	}
+	loc, overridden := assumedZoneOverrides[source.Name()]
+	if !overridden {
+		loc = assumedZone
+	}
+	for _, p := range timestampPatterns {
+		match := p.pattern.FindStringSubmatch(logMessage)
+		if match == nil {
+			continue
+		}
+		value := strings.Replace(strings.Replace(match[1], ",", ".", 1), "T", " ", 1)
+		layout := p.layout
+		if zone := match[2]; zone != "" {
+			value += strings.Replace(zone, ":", "", 1)
+			layout += "Z0700"
+		}
+		parsed, err := time.ParseInLocation(layout, value, loc)
+		if err != nil {
+			continue
+		}
+		if parsed.Year() == 0 {
+			// Pick the year which puts the timestamp closest to now, so
+			// December's logs read in January land in the right year
+			now := Clock.Now().In(loc)
+			parsed = parsed.AddDate(now.Year(), 0, 0)
+			if parsed.Sub(now) > 180*24*time.Hour {
+				parsed = parsed.AddDate(-1, 0, 0)
+			}
+		}
+		return parsed.UTC(), true
+	}
+	return time.Time{}, false
+}
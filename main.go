@@ -1,23 +1,33 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/RedHatInsights/haberdasher/admin"
+	"github.com/RedHatInsights/haberdasher/buildinfo"
+	"github.com/RedHatInsights/haberdasher/config"
 	_ "github.com/RedHatInsights/haberdasher/emitters"
+	"github.com/RedHatInsights/haberdasher/fips"
 	"github.com/RedHatInsights/haberdasher/logging"
-	reaper "github.com/ramr/go-reaper"
+	"github.com/RedHatInsights/haberdasher/multiline"
 )
 
 // If running as PID1, we need to actively catch and handle any shutdown signals
 // So with this handler, we pass the signal along to the subprocess we spawned
-// and allow our emitters' buffers to flush before exiting
-func signalHandler(pid *int, emitter logging.Emitter, signalChan chan os.Signal) {
+// and allow our emitters' buffers to flush before exiting. The rest of the
+// child's output is read and shipped first: main shuts down once it's exited
+// and its pipes have closed, and we only step in if that takes too long.
+func signalHandler(child *supervisor, mode processMode, signalChan chan os.Signal) {
 	var signalToSendChild syscall.Signal = syscall.SIGHUP
+	waiting := false
 	for {
 		signalReceived := <-signalChan
 		log.Println("Signal received:", signalReceived)
@@ -28,66 +38,244 @@ func signalHandler(pid *int, emitter logging.Emitter, signalChan chan os.Signal)
 			signalToSendChild = syscall.SIGINT
 		case syscall.SIGTERM:
 			signalToSendChild = syscall.SIGTERM
-		case syscall.SIGKILL:
-			signalToSendChild = syscall.SIGKILL
 		}
-		log.Println("Sending signal to", *pid)
-		syscall.Kill(*pid, signalToSendChild)
-		log.Println("Trigering emitter shutdown")
-		if err := emitter.Cleanup(); err != nil {
-			log.Println("Error cleaning up emitter:", err)
+		// We're on our way out, so the child isn't coming back
+		child.stopRestarting()
+		if child.Pid() <= 0 {
+			// Nothing to wait for: we're only tailing files or reading stdin,
+			// or the child is waiting to be restarted
+			shutdown(child, mode, child.exitCode())
 		}
-		os.Exit(0)
+		writeTerminationReason(mode.terminationReason(signalReceived))
+		child.Signal(signalToSendChild)
+		if !waiting {
+			waiting = true
+			go awaitShutdown(child, mode, signalReceived.(syscall.Signal))
+		}
+	}
+}
+
+// awaitShutdown gives the child until it's killed to exit, when we wait for
+// it, and then its output HABERDASHER_PIPE_DRAIN_TIMEOUT to be read. If main
+// still hasn't shut down by then, we do it ourselves with what's been read.
+func awaitShutdown(child *supervisor, mode processMode, received syscall.Signal) {
+	mode.awaitChild(child)
+	deadline := logging.Clock.Now().Add(mode.pipeDrainTimeout)
+	for child.Pid() > 0 && logging.Clock.Now().Before(deadline) {
+		logging.Clock.Sleep(50 * time.Millisecond)
+	}
+	if child.Pid() <= 0 {
+		// main is shutting down
+		return
 	}
+	log.Println("Gave up waiting for the child after", mode.pipeDrainTimeout)
+	// A child which has exited, but whose output is still being read, exited
+	// with its own code. One which hasn't been collected yet goes down with
+	// us, so we exit as though the signal had killed us.
+	if code, collected := child.collectedExitCode(); collected {
+		shutdown(child, mode, code)
+	}
+	shutdown(child, mode, 128+int(received))
+}
+
+var shutdownOnce sync.Once
+
+// shutdown ships what's left and exits with code. Everything else shipping
+// lines is stopped first, so nothing reaches the queue or the emitters after
+// they've closed: then queued lines are sent, the checkpoints and summary
+// written, and the emitters flushed.
+func shutdown(child *supervisor, mode processMode, code int) {
+	shutdownOnce.Do(func() {
+		producers.halt()
+		if child.recorder != nil {
+			child.recorder.Close()
+		}
+		mode.closeQueue(child.queue)
+		flushCheckpoints()
+		logging.StopSelfLog()
+		emitSummary(child)
+		log.Println("Trigering emitter shutdown")
+		cleanupEmitters(mode)
+		os.Exit(code)
+	})
 }
 
 func main() {
+	if filepath.Base(os.Args[0]) == childHelper {
+		execChild(os.Args[1:])
+	}
+	// Our flags, and any --config file, go into the environment before
+	// anything else reads it
+	if err := config.Load(os.Args); err != nil {
+		log.Fatal(err)
+	}
+	cli := config.CommandLine()
+	info := buildinfo.Get()
+	if cli.Help {
+		fmt.Print(config.Usage())
+		os.Exit(0)
+	}
+	if cli.Version {
+		fmt.Printf("haberdasher %s (commit %s, built %s with %s for %s)\n", info.Version, info.Commit, info.Date, info.GoVersion, info.Platform)
+		os.Exit(0)
+	}
 	log.Println("Initializing haberdasher.")
+	log.Println("Version", info.Version, "commit", info.Commit, "built for", info.Platform)
+	if fips.Enabled() {
+		log.Println("FIPS mode is on:", fips.Mode())
+		fips.RestrictDefaultTransport()
+	}
+	args := cli.Args
+	subcommand := cli.Subcommand()
 
-	// Generate the emitter first so we can hand it over to the signal handler
-	emitterName, exists := os.LookupEnv("HABERDASHER_EMITTER")
-	if !exists {
-		emitterName = "stderr"
+	// `haberdasher config-schema` describes the configuration for editors and
+	// policy checks
+	if subcommand == "config-schema" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(config.Schema()); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
 	}
+
+	// `haberdasher test-rules [--config fragment.yaml] < sample.log` shows
+	// which rules each line of a sample matches, without shipping anything
+	if subcommand == "test-rules" {
+		os.Exit(testRules(args[1:], os.Stdin, os.Stdout))
+	}
+
+	// Generate the emitter first so we can hand it over to the signal handler
+	emitterName, _ := config.Setting("HABERDASHER_EMITTER")
 	log.Println("Configured emitter:", emitterName)
-	emitter := logging.Emitters[emitterName]
+	emitter, err := logging.Lookup(emitterName)
+	if err != nil {
+		log.Fatal("Invalid HABERDASHER_EMITTER: ", err)
+	}
+	virtualSources := loadPolicies()
 
-	// Reap any zombie children - see: https://github.com/ramr/go-reaper/
-	go reaper.Reap()
-	// Until we start the subprocess, populate the pid variable with something,
-	// in case the signal handler gets fired before we've started it
-	subcmdPid := -1
+	// `haberdasher selftest` smoke-tests the emitter pipeline instead of
+	// wrapping a command
+	if subcommand == "selftest" {
+		setUp(emitter)
+		os.Exit(selftest(emitter))
+	}
+
+	// `haberdasher replay-raw <recording> [speed]` feeds a raw recording
+	// through the pipeline instead of wrapping a command
+	replaying := subcommand == "replay-raw"
+	// `myapp 2>&1 | haberdasher --stdin` ships what's piped to us instead
+	piping := cli.Stdin
+	var argv []string
+	if !replaying && !piping {
+		if argv, err = childArgv(args); err != nil {
+			log.Fatal("Unable to parse the command: ", err)
+		}
+	}
+	child := &supervisor{argv: argv, emitter: emitter, echo: !echoesToConsole(emitter), piped: piping}
+	child.stopping = make(chan struct{})
+	child.configured = make(map[string]bool)
+	for _, name := range config.Variables() {
+		child.configured[name] = true
+	}
+	child.queue = newQueue(emitter, child.emit)
+	child.dedupWindow, _ = config.DurationOrZeroSetting("HABERDASHER_DEDUP_WINDOW", "not to look for duplicates")
+	// To spot lines written to both streams, stdout has to be captured too
+	child.captureStdout = child.dedupWindow > 0
+	if child.multiline, err = multiline.FromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	if child.recordLimits, err = multiline.LimitsFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	child.recordLimits.Clock = logging.Clock
+	child.restart = restartPolicyFromEnv()
+	if streams, exists := config.Setting("HABERDASHER_STREAMS"); exists {
+		stdout, err := parseStreams(streams)
+		if err != nil {
+			log.Fatal("HABERDASHER_STREAMS: ", err)
+		}
+		child.captureStdout = child.captureStdout || stdout
+	}
+	if child.interleave, _ = config.DurationOrZeroSetting("HABERDASHER_INTERLEAVE", "not to interleave"); child.interleave > 0 {
+		switch {
+		case child.dedupWindow > 0:
+			log.Fatal("HABERDASHER_INTERLEAVE can't be used with HABERDASHER_DEDUP_WINDOW")
+		case !child.captureStdout:
+			log.Fatal("HABERDASHER_INTERLEAVE needs stdout in HABERDASHER_STREAMS")
+		}
+	}
+	if destination, exists := config.Setting("HABERDASHER_RAW_TEE"); exists {
+		if child.captureStdout {
+			log.Fatal("HABERDASHER_RAW_TEE can't be used with HABERDASHER_DEDUP_WINDOW, or stdout in HABERDASHER_STREAMS")
+		}
+		child.rawTee = openRawTee(destination)
+	}
+	child.pty = config.FlagSetting("HABERDASHER_PTY")
+	child.pipeBuffer, _ = config.IntSetting("HABERDASHER_PIPE_BUFFER", 1)
+	if replaying {
+		os.Exit(replay(child, virtualSources, args[1:]))
+	}
+	if path, exists := config.Setting("HABERDASHER_RECORD_RAW"); exists {
+		child.recorder = openRawRecording(path)
+	}
+
+	// Without a child there's nothing to reap
+	reaping := false
+	if !piping {
+		reaping = startReaper()
+	}
+	mode := detectMode(reaping)
+	child.pipeDrainTimeout = mode.pipeDrainTimeout
 	// Spawn a handler for any termination signals
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGKILL)
-	go signalHandler(&subcmdPid, emitter, signalChan)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
+	go signalHandler(child, mode, signalChan)
+	if !piping {
+		go forwardSignals(child)
+	}
 
 	// If our selected emitter requires any initialization, do it
-	emitter.Setup()
-
-	subcmdBin := os.Args[1]
-	subcmdArgs := os.Args[2:len(os.Args)]
-	subcmd := exec.Command(subcmdBin, subcmdArgs...)
-	// pass through stdout, but capture stderr
-	subcmd.Stdout = os.Stdout
-	subcmdErr, err := subcmd.StderrPipe()
-	if err != nil {
-		log.Fatal(err)
+	setUp(emitter)
+	routeEvents()
+	if !piping {
+		mode.announce(emitter)
 	}
-	scanner := bufio.NewScanner(subcmdErr)
+	child.virtual = loadVirtualSources(emitter, virtualSources)
+	handlePolicyReloads(child, emitter)
+	child.readiness = newReadinessGate()
+	handleChildAPI(child)
+	handleInventory(child)
+	admin.Start()
+	startCanary(emitter)
+	startPressureMonitor(emitter, child.queue)
+	startWatchdog(emitter, child.queue)
+	shouldShip := startLeaderElection()
+	loadCheckpoints(shouldShip)
+	tailing := startFileTails(emitter, shouldShip)
+	collecting := startPodCollector(emitter)
+	emitInventory(child, emitter)
 
-	if err := subcmd.Start(); err != nil {
-		log.Fatal(err)
+	// With no command to wrap, we're only collecting log files
+	if len(argv) == 0 && !piping {
+		if !tailing && !collecting {
+			log.Fatal("Usage: haberdasher [flags] [--] <command> [args...]")
+		}
+		select {}
 	}
-	subcmdPid = subcmd.Process.Pid
 
-	for scanner.Scan() {
-		go func() {
-			logging.Emit(emitter, scanner.Text())
-			// Still want to send logs to console with non-console emitters
-			if emitterName != "stderr" {
-				log.Println(scanner.Text())
-			}
-		}()
+	if piping {
+		go child.readiness.run(emitter)
+		child.readStdin()
+	} else {
+		// Without a tee, the child's stdout must be mirrored whole lines at
+		// a time to share ours with the stdout-json emitter
+		child.lineStdout = child.rawTee == nil && writesStdout()
+		startRotationSignals(child)
+		startDescendantWatch(emitter, child)
+		startThresholds(emitter, child)
+		go child.readiness.run(emitter)
+		child.run()
 	}
+	shutdown(child, mode, child.exitCode())
 }
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// The same image can be a container's entrypoint, where Haberdasher is PID 1,
+// or a sidecar sharing the pod's PID namespace with the real init. As PID 1,
+// once we exit the kernel kills everything else in the namespace, so there's
+// no need to wait for the child. As anything else the child would be
+// reparented and outlive us, so on shutdown we wait for it, killing it if it
+// doesn't exit within HABERDASHER_KILL_TIMEOUT.
+//
+// If we're told the pod's terminationGracePeriodSeconds with
+// HABERDASHER_GRACE_PERIOD, we budget within it: a share is kept back for
+// draining the emitter, and the rest is how long the child gets before it's
+// killed, whether or not we're PID 1, so everything is done before the
+// kubelet kills us.
+type processMode struct {
+	pid1         bool
+	reaping      bool
+	killTimeout  time.Duration
+	gracePeriod  time.Duration
+	drainTimeout time.Duration
+	// How long the child's output is still read for once it's exited, see
+	// supervisor.drain
+	pipeDrainTimeout time.Duration
+}
+
+// The share of the grace period kept back for draining the emitter
+const (
+	minDrainTimeout = time.Second
+	maxDrainTimeout = 10 * time.Second
+)
+
+func detectMode(reaping bool) processMode {
+	mode := processMode{pid1: os.Getpid() == 1, reaping: reaping}
+	if fromEnv, exists := config.Setting("HABERDASHER_GRACE_PERIOD"); exists {
+		mode.gracePeriod = parseGracePeriod(fromEnv)
+		mode.drainTimeout = mode.gracePeriod / 4
+		if mode.drainTimeout < minDrainTimeout {
+			mode.drainTimeout = minDrainTimeout
+		} else if mode.drainTimeout > maxDrainTimeout {
+			mode.drainTimeout = maxDrainTimeout
+		}
+		if mode.drainTimeout >= mode.gracePeriod {
+			mode.drainTimeout = mode.gracePeriod / 2
+		}
+		mode.killTimeout = mode.gracePeriod - mode.drainTimeout
+	}
+	// Without a grace period to budget within, or when they're set, these come
+	// from the settings
+	_, killSet := os.LookupEnv("HABERDASHER_KILL_TIMEOUT")
+	if killSet || mode.gracePeriod == 0 {
+		mode.killTimeout, _ = config.DurationSetting("HABERDASHER_KILL_TIMEOUT")
+	}
+	if killSet && mode.gracePeriod > 0 && mode.killTimeout+mode.drainTimeout > mode.gracePeriod {
+		log.Println("Warning: HABERDASHER_KILL_TIMEOUT leaves less than", mode.drainTimeout, "of the grace period to drain the emitter")
+	}
+	if _, exists := os.LookupEnv("HABERDASHER_PIPE_DRAIN_TIMEOUT"); exists || mode.gracePeriod == 0 {
+		mode.pipeDrainTimeout, _ = config.DurationOrZeroSetting("HABERDASHER_PIPE_DRAIN_TIMEOUT", "not to wait")
+	} else {
+		// It comes out of the share kept back for draining
+		mode.pipeDrainTimeout = mode.drainTimeout / 2
+	}
+	return mode
+}
+
+// parseGracePeriod accepts whole seconds, as Kubernetes gives them, or a
+// duration
+func parseGracePeriod(fromEnv string) time.Duration {
+	if seconds, err := strconv.Atoi(fromEnv); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	grace, err := time.ParseDuration(fromEnv)
+	if err != nil || grace <= 0 {
+		log.Fatal("HABERDASHER_GRACE_PERIOD must be a positive number of seconds, like 30")
+	}
+	return grace
+}
+
+// waitsForChild reports whether we'll wait for the child on shutdown
+func (m processMode) waitsForChild() bool {
+	return !m.pid1 || m.gracePeriod > 0
+}
+
+// announce emits an event describing the mode we chose
+func (m processMode) announce(emitter logging.Emitter) {
+	message := "Running as PID 1: the child is stopped with us"
+	if m.waitsForChild() {
+		message = fmt.Sprintf("Running as PID %d: on shutdown the child gets %s to exit before it's killed", os.Getpid(), m.killTimeout)
+	}
+	if m.gracePeriod > 0 {
+		message += fmt.Sprintf(", leaving %s of the %s grace period to drain", m.drainTimeout, m.gracePeriod)
+	}
+	if m.reaping {
+		message += ", reaping zombies"
+	} else {
+		message += ", not reaping zombies"
+	}
+	logging.EmitEvent(emitter, "startup-mode", message)
+}
+
+// terminationReason describes a shutdown caused by a signal. The child only
+// has a deadline when we'll kill it.
+func (m processMode) terminationReason(received os.Signal) terminationReason {
+	reason := terminationReason{Reason: "signal", Signal: received.String(), Received: logging.Clock.Now().UTC()}
+	if m.waitsForChild() {
+		deadline := reason.Received.Add(m.killTimeout)
+		reason.Deadline = &deadline
+	}
+	return reason
+}
+
+// awaitChild makes sure the child doesn't outlive us once it's been signalled
+func (m processMode) awaitChild(child *supervisor) {
+	if !m.waitsForChild() {
+		return
+	}
+	deadline := logging.Clock.Now().Add(m.killTimeout)
+	for child.Pid() > 0 {
+		if logging.Clock.Now().After(deadline) {
+			child.Signal(syscall.SIGKILL)
+			return
+		}
+		logging.Clock.Sleep(50 * time.Millisecond)
+	}
+}
+
+// drain waits, as long as cleanup would, for a fanout to hand its members
+// what it's queued for them
+func (m processMode) drain(fanout *logging.Fanout) {
+	var timeout <-chan time.Time
+	if m.gracePeriod > 0 {
+		timeout = logging.Clock.After(m.drainTimeout)
+	}
+	if !fanout.Drain(timeout) {
+		log.Println("Gave up draining the fanout after", m.drainTimeout)
+	}
+}
+
+// closeQueue ships the queue's backlog, giving up within the drain budget, as
+// drain and cleanup do, on a backend too slow to take it
+func (m processMode) closeQueue(queue *logging.Queue) {
+	var timeout <-chan time.Time
+	if m.gracePeriod > 0 {
+		timeout = logging.Clock.After(m.drainTimeout)
+	}
+	if !queue.CloseBy(timeout) {
+		pending, _ := queue.Progress()
+		log.Println("Gave up shipping the queue after", m.drainTimeout, "dropping", pending, "lines")
+	}
+}
+
+// cleanup flushes the emitter, giving up once the drain budget is spent so
+// we're gone before the grace period is
+func (m processMode) cleanup(emitter logging.Emitter) {
+	done := make(chan error, 1)
+	go func() { done <- emitter.Cleanup() }()
+	var timeout <-chan time.Time
+	if m.gracePeriod > 0 {
+		timeout = logging.Clock.After(m.drainTimeout)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Println("Error cleaning up emitter:", err)
+		}
+	case <-timeout:
+		log.Println("Gave up draining the emitter after", m.drainTimeout)
+	}
+}
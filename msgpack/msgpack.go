@@ -0,0 +1,354 @@
+// Package msgpack is just enough MessagePack for the Fluentd forward
+// protocol: encoding log records, and decoding the server's handshake and
+// acknowledgments.
+//
+// Records are appended to a batch's buffer as they arrive, already in the
+// shape decoded JSON has, which a general encoder would reflect over for
+// every value. The tests check both directions against vmihailenco/msgpack
+// at every width.
+package msgpack
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// Raw is already encoded MessagePack, appended as it is
+type Raw []byte
+
+// EventTime is a timestamp with nanoseconds, Fluentd's extension type 0
+type EventTime time.Time
+
+// Append encodes a value onto b. It handles nil, booleans, numbers, strings,
+// byte slices, slices and maps of those (as decoded from JSON), Raw, and
+// EventTime.
+func Append(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int:
+		return AppendInt(b, int64(v)), nil
+	case int64:
+		return AppendInt(b, v), nil
+	case uint32:
+		return AppendInt(b, int64(v)), nil
+	case float64:
+		// JSON numbers are all floats once decoded, but most are integers
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return AppendInt(b, int64(v)), nil
+		}
+		b = append(b, 0xcb)
+		return appendUint64(b, math.Float64bits(v)), nil
+	case string:
+		return AppendString(b, v), nil
+	case []byte:
+		return appendBinary(b, v), nil
+	case Raw:
+		return append(b, v...), nil
+	case EventTime:
+		t := time.Time(v)
+		b = append(b, 0xd7, 0x00)
+		b = appendUint32(b, uint32(t.Unix()))
+		return appendUint32(b, uint32(t.Nanosecond())), nil
+	case []interface{}:
+		b = AppendArrayHeader(b, len(v))
+		var err error
+		for _, item := range v {
+			if b, err = Append(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case []string:
+		b = AppendArrayHeader(b, len(v))
+		for _, item := range v {
+			b = AppendString(b, item)
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = AppendMapHeader(b, len(v))
+		var err error
+		for _, k := range sortedKeys(v) {
+			b = AppendString(b, k)
+			if b, err = Append(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		b = AppendMapHeader(b, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b = AppendString(b, k)
+			b = AppendString(b, v[k])
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: can't encode %T", v)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// AppendInt encodes an integer in the fewest bytes
+func AppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		return append(b, byte(v))
+	case v < 0 && v >= -32:
+		return append(b, byte(v))
+	case v >= 0 && v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v >= 0 && v <= math.MaxUint16:
+		return append(append(b, 0xcd), byte(v>>8), byte(v))
+	case v >= 0 && v <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(v))
+	case v >= 0:
+		return appendUint64(append(b, 0xcf), uint64(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return append(append(b, 0xd1), byte(v>>8), byte(v))
+	case v >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(v))
+	}
+	return appendUint64(append(b, 0xd3), uint64(v))
+}
+
+// AppendString encodes a string
+func AppendString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendBinary(b []byte, v []byte) []byte {
+	switch n := len(v); {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, v...)
+}
+
+// AppendArrayHeader starts an array of n items, which should follow
+func AppendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(b, 0xdd), uint32(n))
+}
+
+// AppendMapHeader starts a map of n pairs, which should follow
+func AppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	}
+	return appendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+// A Decoder reads MessagePack values one after another
+type Decoder struct {
+	r *bufio.Reader
+}
+
+// NewDecoder reads values from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next value. Maps are map[string]interface{}, arrays are
+// []interface{}, strings and binary are strings, integers are int64, and
+// extension types are skipped as nil.
+func (d *Decoder) Decode() (interface{}, error) {
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tag <= 0x7f:
+		return int64(tag), nil
+	case tag >= 0xe0:
+		return int64(int8(tag)), nil
+	case tag&0xf0 == 0x80:
+		return d.decodeMap(int(tag & 0x0f))
+	case tag&0xf0 == 0x90:
+		return d.decodeArray(int(tag & 0x0f))
+	case tag&0xe0 == 0xa0:
+		return d.readString(int(tag & 0x1f))
+	}
+	switch tag {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		n, err := d.readUint(1)
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(int(n))
+	case 0xc5, 0xda:
+		n, err := d.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(int(n))
+	case 0xc6, 0xdb:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.readString(int(n))
+	case 0xca:
+		bits, err := d.readUint(4)
+		return float64(math.Float32frombits(uint32(bits))), err
+	case 0xcb:
+		bits, err := d.readUint(8)
+		return math.Float64frombits(bits), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.readUint(1 << (tag - 0xcc))
+		return int64(v), err
+	case 0xd0:
+		v, err := d.readUint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := d.readUint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := d.readUint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := d.readUint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return nil, d.skip(1 + 1<<(tag-0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readUint(1 << (tag - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return nil, d.skip(int(n) + 1)
+	case 0xdc:
+		n, err := d.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n))
+	case 0xdd:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(int(n))
+	case 0xde:
+		n, err := d.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n))
+	case 0xdf:
+		n, err := d.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(int(n))
+	}
+	return nil, fmt.Errorf("msgpack: unknown type 0x%02x", tag)
+}
+
+func (d *Decoder) readUint(size int) (uint64, error) {
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(d.r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+func (d *Decoder) readString(n int) (string, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(d.r, buf)
+	return string(buf), err
+}
+
+func (d *Decoder) skip(n int) error {
+	_, err := d.r.Discard(n)
+	return err
+}
+
+func (d *Decoder) decodeArray(n int) (interface{}, error) {
+	items := make([]interface{}, n)
+	for i := range items {
+		var err error
+		if items[i], err = d.Decode(); err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+func (d *Decoder) decodeMap(n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.Decode()
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, errors.New("msgpack: only string map keys are supported")
+		}
+		if m[k], err = d.Decode(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,308 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	reference "github.com/vmihailenco/msgpack/v5"
+)
+
+// The expected encodings are from the MessagePack specification's formats,
+// at the edges of each width
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  []byte
+	}{
+		{"nil", nil, []byte{0xc0}},
+		{"false", false, []byte{0xc2}},
+		{"true", true, []byte{0xc3}},
+
+		{"positive fixint 0", 0, []byte{0x00}},
+		{"positive fixint 127", 127, []byte{0x7f}},
+		{"negative fixint -1", -1, []byte{0xff}},
+		{"negative fixint -32", -32, []byte{0xe0}},
+		{"uint 8 128", 128, []byte{0xcc, 0x80}},
+		{"uint 8 255", 255, []byte{0xcc, 0xff}},
+		{"uint 16 256", 256, []byte{0xcd, 0x01, 0x00}},
+		{"uint 16 65535", 65535, []byte{0xcd, 0xff, 0xff}},
+		{"uint 32 65536", 65536, []byte{0xce, 0x00, 0x01, 0x00, 0x00}},
+		{"uint 32 max", int64(4294967295), []byte{0xce, 0xff, 0xff, 0xff, 0xff}},
+		{"uint 64", int64(4294967296), []byte{0xcf, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		{"int 8 -33", -33, []byte{0xd0, 0xdf}},
+		{"int 8 -128", -128, []byte{0xd0, 0x80}},
+		{"int 16 -129", -129, []byte{0xd1, 0xff, 0x7f}},
+		{"int 16 -32768", -32768, []byte{0xd1, 0x80, 0x00}},
+		{"int 32 -32769", -32769, []byte{0xd2, 0xff, 0xff, 0x7f, 0xff}},
+		{"int 32 min", int64(-2147483648), []byte{0xd2, 0x80, 0x00, 0x00, 0x00}},
+		{"int 64", int64(-2147483649), []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xff, 0xff}},
+		{"uint32", uint32(200), []byte{0xcc, 0xc8}},
+
+		{"whole float as an integer", 42.0, []byte{0x2a}},
+		{"negative whole float as an integer", -300.0, []byte{0xd1, 0xfe, 0xd4}},
+		{"float 64", 0.5, []byte{0xcb, 0x3f, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"float 64 too big to be exact", float64(1 << 53), []byte{0xcb, 0x43, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}},
+
+		{"empty fixstr", "", []byte{0xa0}},
+		{"fixstr", "hi", []byte{0xa2, 'h', 'i'}},
+		{"bin 8", []byte{1, 2}, []byte{0xc4, 0x02, 0x01, 0x02}},
+		{"raw", Raw{0x93, 0x01, 0x02, 0x03}, []byte{0x93, 0x01, 0x02, 0x03}},
+		{"event time", EventTime(time.Unix(1, 2)), []byte{0xd7, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02}},
+
+		{"empty fixarray", []interface{}{}, []byte{0x90}},
+		{"fixarray", []interface{}{1, "a", nil}, []byte{0x93, 0x01, 0xa1, 'a', 0xc0}},
+		{"string fixarray", []string{"a", "b"}, []byte{0x92, 0xa1, 'a', 0xa1, 'b'}},
+		{"empty fixmap", map[string]interface{}{}, []byte{0x80}},
+		// Keys are sorted, so the encoding is stable
+		{"fixmap", map[string]interface{}{"b": true, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0xc3}},
+		{"string fixmap", map[string]string{"b": "2", "a": "1"}, []byte{0x82, 0xa1, 'a', 0xa1, '1', 0xa1, 'b', 0xa1, '2'}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Append(nil, test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("encoded as % x, want % x", got, test.want)
+			}
+		})
+	}
+}
+
+// Longer values are checked by their headers, and that the payload follows
+func TestAppendHeaders(t *testing.T) {
+	items := func(n int) []interface{} { return make([]interface{}, n) }
+	pairs := func(n int) map[string]interface{} {
+		m := make(map[string]interface{}, n)
+		for i := 0; i < n; i++ {
+			m[fmt.Sprintf("k%d", i)] = nil
+		}
+		return m
+	}
+	tests := []struct {
+		name   string
+		value  interface{}
+		header []byte
+		length int
+	}{
+		{"fixstr 31", strings.Repeat("x", 31), []byte{0xbf}, 1 + 31},
+		{"str 8", strings.Repeat("x", 32), []byte{0xd9, 0x20}, 2 + 32},
+		{"str 8 255", strings.Repeat("x", 255), []byte{0xd9, 0xff}, 2 + 255},
+		{"str 16", strings.Repeat("x", 256), []byte{0xda, 0x01, 0x00}, 3 + 256},
+		{"str 32", strings.Repeat("x", 65536), []byte{0xdb, 0x00, 0x01, 0x00, 0x00}, 5 + 65536},
+		{"bin 16", make([]byte, 256), []byte{0xc5, 0x01, 0x00}, 3 + 256},
+		{"bin 32", make([]byte, 65536), []byte{0xc6, 0x00, 0x01, 0x00, 0x00}, 5 + 65536},
+		{"fixarray 15", items(15), []byte{0x9f}, 1 + 15},
+		{"array 16", items(16), []byte{0xdc, 0x00, 0x10}, 3 + 16},
+		{"array 32", items(65536), []byte{0xdd, 0x00, 0x01, 0x00, 0x00}, 5 + 65536},
+		{"fixmap 15", pairs(15), []byte{0x8f}, -1},
+		{"map 16", pairs(16), []byte{0xde, 0x00, 0x10}, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Append(nil, test.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(got, test.header) {
+				t.Errorf("starts % x, want % x", got[:len(test.header)], test.header)
+			}
+			if test.length >= 0 && len(got) != test.length {
+				t.Errorf("encoded in %d bytes, want %d", len(got), test.length)
+			}
+		})
+	}
+	if header := AppendMapHeader(nil, 65536); !bytes.Equal(header, []byte{0xdf, 0x00, 0x01, 0x00, 0x00}) {
+		t.Errorf("map 32 header % x", header)
+	}
+}
+
+func TestAppendUnsupported(t *testing.T) {
+	if _, err := Append(nil, struct{}{}); err == nil {
+		t.Error("encoded a struct")
+	}
+	if _, err := Append(nil, []interface{}{1, struct{}{}}); err == nil {
+		t.Error("encoded an array holding a struct")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(127), int64(-1), int64(-32), int64(-33), int64(255), int64(65535), int64(65536),
+		int64(4294967296), int64(-129), int64(-32769), int64(-2147483649),
+		0.5,
+		"", "hi", strings.Repeat("x", 32), strings.Repeat("x", 256),
+		[]interface{}{int64(1), "a", nil},
+		map[string]interface{}{"HELO": []interface{}{map[string]interface{}{"nonce": "abc"}}},
+	}
+	var encoded []byte
+	for _, value := range values {
+		var err error
+		if encoded, err = Append(encoded, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Extension types, like EventTime, are skipped as nil
+	encoded, _ = Append(encoded, EventTime(time.Unix(1, 2)))
+	values = append(values, nil)
+	// Binary decodes as a string
+	encoded, _ = Append(encoded, []byte("bin"))
+	values = append(values, "bin")
+
+	decoder := NewDecoder(bytes.NewReader(encoded))
+	for i, want := range values {
+		got, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("value %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("value %d decoded as %#v, want %#v", i, got, want)
+		}
+	}
+	if _, err := decoder.Decode(); err == nil {
+		t.Error("decoded past the end")
+	}
+}
+
+// normalize makes what the reference implementation decodes comparable with
+// what Append was given: integers are int64, and binary is strings. Nested
+// values are decoded with their own widths.
+func normalize(v interface{}) interface{} {
+	switch value := reflect.ValueOf(v); value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	}
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalize(item)
+		}
+	}
+	return v
+}
+
+// short describes a value, which may be very long, for a test failure
+func short(v interface{}) string {
+	s := fmt.Sprintf("%#v", v)
+	if len(s) > 100 {
+		return s[:100] + "..."
+	}
+	return s
+}
+
+// What Append encodes, vmihailenco/msgpack decodes, at every width
+func TestAppendDecodesWithReference(t *testing.T) {
+	many := make(map[string]interface{})
+	for i := 0; i < 20; i++ {
+		many[fmt.Sprint("key", i)] = int64(i)
+	}
+	values := []interface{}{
+		nil, true, false,
+		int64(0), int64(127), int64(128), int64(255), int64(256), int64(65535), int64(65536), int64(4294967295), int64(4294967296), int64(math.MaxInt64),
+		int64(-1), int64(-32), int64(-33), int64(-128), int64(-129), int64(-32768), int64(-32769), int64(-2147483648), int64(-2147483649), int64(math.MinInt64),
+		0.5, -1e300,
+		"", strings.Repeat("x", 31), strings.Repeat("x", 32), strings.Repeat("x", 255), strings.Repeat("x", 256), strings.Repeat("x", 65536),
+		[]interface{}{}, make([]interface{}, 15), make([]interface{}, 16), make([]interface{}, 65536),
+		map[string]interface{}{}, many,
+		[]interface{}{"tag", int64(1600000000), map[string]interface{}{"message": "hi", "labels": map[string]interface{}{"app": "x"}}},
+	}
+	for i, value := range values {
+		encoded, err := Append(nil, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoder := reference.NewDecoder(bytes.NewReader(encoded))
+		got, err := decoder.DecodeInterfaceLoose()
+		if err != nil {
+			t.Fatalf("value %d: the reference implementation can't decode % .32x: %v", i, encoded, err)
+		}
+		if got = normalize(got); !reflect.DeepEqual(got, value) {
+			t.Errorf("value %d: the reference implementation decoded %s, want %s", i, short(got), short(value))
+		}
+	}
+
+	// EventTime is Fluentd's extension type 0: seconds and nanoseconds
+	encoded, _ := Append(nil, EventTime(time.Unix(1600000000, 123456789)))
+	decoder := reference.NewDecoder(bytes.NewReader(encoded))
+	id, length, err := decoder.DecodeExtHeader()
+	if err != nil || id != 0 || length != 8 {
+		t.Fatalf("EventTime has extension %d of %d bytes (%v), want 0 of 8", id, length, err)
+	}
+	var data [8]byte
+	if err := decoder.ReadFull(data[:]); err != nil {
+		t.Fatal(err)
+	}
+	if seconds, nanoseconds := binary.BigEndian.Uint32(data[:4]), binary.BigEndian.Uint32(data[4:]); seconds != 1600000000 || nanoseconds != 123456789 {
+		t.Errorf("EventTime encoded %d.%09d", seconds, nanoseconds)
+	}
+}
+
+// Decode reads what vmihailenco/msgpack encodes, at every width
+func TestDecodeReference(t *testing.T) {
+	var encoded bytes.Buffer
+	encoder := reference.NewEncoder(&encoded)
+	var want []interface{}
+	encode := func(value interface{}, encode func() error) {
+		if err := encode(); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, value)
+	}
+	encode(nil, encoder.EncodeNil)
+	encode(true, func() error { return encoder.EncodeBool(true) })
+	encode(int64(200), func() error { return encoder.EncodeUint8(200) })
+	encode(int64(60000), func() error { return encoder.EncodeUint16(60000) })
+	encode(int64(4000000000), func() error { return encoder.EncodeUint32(4000000000) })
+	encode(int64(1<<40), func() error { return encoder.EncodeUint64(1 << 40) })
+	encode(int64(-100), func() error { return encoder.EncodeInt8(-100) })
+	encode(int64(-30000), func() error { return encoder.EncodeInt16(-30000) })
+	encode(int64(-2000000000), func() error { return encoder.EncodeInt32(-2000000000) })
+	encode(int64(-1<<40), func() error { return encoder.EncodeInt64(-1 << 40) })
+	encode(float64(0.5), func() error { return encoder.EncodeFloat32(0.5) })
+	encode(-0.1, func() error { return encoder.EncodeFloat64(-0.1) })
+	for _, n := range []int{0, 31, 32, 255, 256, 65536} {
+		s := strings.Repeat("x", n)
+		encode(s, func() error { return encoder.EncodeString(s) })
+		encode(s, func() error { return encoder.EncodeBytes([]byte(s)) })
+	}
+	array := make([]interface{}, 70000)
+	for i := range array {
+		array[i] = int64(i % 100)
+	}
+	encode(array, func() error { return encoder.Encode(array) })
+	many := make(map[string]interface{})
+	for i := 0; i < 70000; i++ {
+		many[fmt.Sprint(i)] = "v"
+	}
+	encode(many, func() error { return encoder.Encode(many) })
+	// Fluentd's acknowledgment
+	encode(map[string]interface{}{"ack": "chunk-id"}, func() error { return encoder.Encode(map[string]string{"ack": "chunk-id"}) })
+
+	decoder := NewDecoder(&encoded)
+	for i, value := range want {
+		got, err := decoder.Decode()
+		if err != nil {
+			t.Fatalf("value %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, value) {
+			t.Errorf("value %d decoded as %s, want %s", i, short(got), short(value))
+		}
+	}
+}
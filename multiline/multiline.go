@@ -0,0 +1,265 @@
+// Package multiline reassembles records, like stack traces, which the child
+// writes over several lines, so they're shipped as one message.
+package multiline
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// A Rule decides whether a line continues the record made of the lines so far
+type Rule func(record []string, line string) bool
+
+// Indented continues a record with every line indented by at least width
+// columns, as the frames of most stack traces are, each tab counting as
+// tabWidth. Output which legitimately indents lines a little, like YAML, can
+// be left alone by requiring more.
+func Indented(width int, tabWidth int) Rule {
+	return func(record []string, line string) bool {
+		columns := 0
+		for i := 0; i < len(line) && columns < width; i++ {
+			switch line[i] {
+			case ' ':
+				columns++
+			case '\t':
+				if tabWidth == 0 {
+					return false
+				}
+				columns += tabWidth
+			default:
+				return false
+			}
+		}
+		return columns >= width
+	}
+}
+
+// Backslash continues a record with the line after one ending in an
+// unescaped backslash, as shells and C macros do
+func Backslash(record []string, line string) bool {
+	previous := record[len(record)-1]
+	trailing := len(previous) - len(strings.TrimRight(previous, "\\"))
+	return trailing%2 == 1
+}
+
+// Any continues a record whenever one of rules does
+func Any(rules ...Rule) Rule {
+	return func(record []string, line string) bool {
+		for _, rule := range rules {
+			if rule(record, line) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FromEnv reads the rule for the splitter profile HABERDASHER_SPLITTER names,
+// see Profiles. It returns nil if lines shouldn't be joined.
+func FromEnv() (Rule, error) {
+	splitter, _ := config.Setting("HABERDASHER_SPLITTER")
+	if splitter == "" || splitter == "legacy" {
+		return legacyFromEnv()
+	}
+	rule, known := Profiles[splitter]
+	if !known && splitter != "patterns" {
+		return nil, fmt.Errorf("HABERDASHER_SPLITTER must be one of %s", strings.Join(profileNames(), ", "))
+	}
+	// Settings which would be silently ignored are more likely a mistake
+	for _, name := range []string{"HABERDASHER_MULTILINE", "HABERDASHER_MULTILINE_INDENT", "HABERDASHER_MULTILINE_TAB_WIDTH"} {
+		if _, exists := os.LookupEnv(name); exists {
+			return nil, fmt.Errorf("%s only applies to the legacy splitter, not %s", name, splitter)
+		}
+	}
+	if splitter == "patterns" {
+		return patternsFromEnv()
+	}
+	if _, exists := config.Setting("HABERDASHER_MULTILINE_PATTERNS"); exists {
+		return nil, fmt.Errorf("HABERDASHER_MULTILINE_PATTERNS only applies to the patterns splitter, not %s", splitter)
+	}
+	return rule, nil
+}
+
+// legacyFromEnv reads the rule for HABERDASHER_MULTILINE: off (the default),
+// or a comma separated list of indent and backslash.
+// HABERDASHER_MULTILINE_INDENT is how many columns of indentation continue a
+// record, by default 1, and HABERDASHER_MULTILINE_TAB_WIDTH how many a tab
+// counts as, by default 8; 0 means tabs never do.
+func legacyFromEnv() (Rule, error) {
+	if _, exists := config.Setting("HABERDASHER_MULTILINE_PATTERNS"); exists {
+		return nil, fmt.Errorf("HABERDASHER_MULTILINE_PATTERNS only applies to the patterns splitter, not legacy")
+	}
+	mode, _ := config.Setting("HABERDASHER_MULTILINE")
+	if mode == "" || mode == "off" {
+		return nil, nil
+	}
+	width, err := intFromEnv("HABERDASHER_MULTILINE_INDENT", 1)
+	if err != nil {
+		return nil, err
+	}
+	tabWidth, err := intFromEnv("HABERDASHER_MULTILINE_TAB_WIDTH", 0)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	for _, name := range strings.Split(mode, ",") {
+		switch strings.TrimSpace(name) {
+		case "indent":
+			rules = append(rules, Indented(width, tabWidth))
+		case "backslash":
+			rules = append(rules, Backslash)
+		default:
+			return nil, fmt.Errorf("HABERDASHER_MULTILINE: unknown rule %q, it must be off, or a list of indent and backslash", name)
+		}
+	}
+	if len(rules) == 1 {
+		return rules[0], nil
+	}
+	return Any(rules...), nil
+}
+
+// intFromEnv reads a whole number setting, or its default from the schema
+func intFromEnv(name string, min int) (int, error) {
+	fromEnv, _ := config.Setting(name)
+	n, err := strconv.Atoi(fromEnv)
+	if err != nil || n < min {
+		return 0, fmt.Errorf("%s must be a whole number, at least %d", name, min)
+	}
+	return n, nil
+}
+
+// Limits cap how big a record can get, so a child which indents everything
+// can't build one without bound, and how long it waits for its next line, so
+// a child which writes slowly doesn't have its stack traces held back until
+// its next, unrelated, line. Zero means no cap.
+type Limits struct {
+	Lines int
+	Bytes int
+	Hold  time.Duration
+
+	// Clock times the hold. Nil means the real clock.
+	Clock clock.Clock
+}
+
+// LimitsFromEnv reads the caps from HABERDASHER_RECORD_MAX_LINES,
+// HABERDASHER_RECORD_MAX_BYTES, and HABERDASHER_RECORD_HOLD, any of which can
+// be 0 for none
+func LimitsFromEnv() (Limits, error) {
+	var limits Limits
+	var err error
+	if limits.Lines, err = intFromEnv("HABERDASHER_RECORD_MAX_LINES", 0); err != nil {
+		return limits, err
+	}
+	if limits.Bytes, err = intFromEnv("HABERDASHER_RECORD_MAX_BYTES", 0); err != nil {
+		return limits, err
+	}
+	hold, _ := config.Setting("HABERDASHER_RECORD_HOLD")
+	if limits.Hold, err = time.ParseDuration(hold); err != nil || limits.Hold < 0 {
+		return limits, fmt.Errorf("HABERDASHER_RECORD_HOLD must be a duration, like 500ms, or 0 to wait for the next line")
+	}
+	return limits, nil
+}
+
+// An Assembler joins the lines of one stream into records. Each stream has
+// its own, though records held too long are completed from another
+// goroutine.
+type Assembler struct {
+	rule   Rule
+	limits Limits
+	emit   func(record string)
+
+	lock  sync.Mutex
+	lines []string
+	size  int
+	// Counts lines added, so a hold timer firing late can tell another line
+	// has arrived since it was set
+	added uint64
+	timer clock.Timer
+}
+
+// New creates an Assembler which hands each complete record to emit, its
+// lines joined by newlines. A record which would outgrow limits is completed
+// early, ending in a line saying so, and the line which didn't fit starts
+// the next one. One with no new line for limits.Hold is completed as it is.
+func New(rule Rule, limits Limits, emit func(record string)) *Assembler {
+	if limits.Clock == nil {
+		limits.Clock = clock.Real
+	}
+	return &Assembler{rule: rule, limits: limits, emit: emit}
+}
+
+// Add takes the stream's next line. A line which doesn't continue the pending
+// record completes it, and starts the next one.
+func (a *Assembler) Add(line string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.added++
+	defer a.hold()
+	if len(a.lines) > 0 && a.rule(a.lines, line) {
+		var marker string
+		switch {
+		case a.limits.Lines > 0 && len(a.lines) >= a.limits.Lines:
+			marker = fmt.Sprintf("[truncated: record exceeded %d lines]", a.limits.Lines)
+		case a.limits.Bytes > 0 && a.size+1+len(line) > a.limits.Bytes:
+			marker = fmt.Sprintf("[truncated: record exceeded %d bytes]", a.limits.Bytes)
+		default:
+			a.lines = append(a.lines, line)
+			a.size += 1 + len(line)
+			return
+		}
+		a.lines = append(a.lines, marker)
+	}
+	a.flush()
+	a.lines = append(a.lines, line)
+	a.size = len(line)
+}
+
+// hold (re)starts the timer completing the pending record if no line follows
+// it in time. The caller holds a.lock.
+func (a *Assembler) hold() {
+	if a.limits.Hold <= 0 {
+		return
+	}
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+	added := a.added
+	a.timer = a.limits.Clock.AfterFunc(a.limits.Hold, func() {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		if a.added == added {
+			a.flush()
+		}
+	})
+}
+
+// Flush emits the pending record, if there is one, without waiting for a line
+// to complete it. Streams must be flushed when they close, or their last
+// record, often the stack trace of a crash, is lost.
+func (a *Assembler) Flush() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.flush()
+}
+
+// flush is Flush for a caller holding a.lock
+func (a *Assembler) flush() {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	if len(a.lines) == 0 {
+		return
+	}
+	record := strings.Join(a.lines, "\n")
+	a.lines = a.lines[:0]
+	a.size = 0
+	a.emit(record)
+}
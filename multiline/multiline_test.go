@@ -0,0 +1,166 @@
+package multiline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+func TestAssembler(t *testing.T) {
+	var records []string
+	a := New(Indented(2, 4), Limits{Lines: 3}, func(record string) { records = append(records, record) })
+	for _, line := range []string{"panic: boom", "  frame 1", "\tframe 2", "next", "long", "  1", "  2", "  3"} {
+		a.Add(line)
+	}
+	a.Flush()
+	want := []string{
+		"panic: boom\n  frame 1\n\tframe 2",
+		"next",
+		"long\n  1\n  2\n[truncated: record exceeded 3 lines]",
+		"  3",
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records %q, want %q", records, want)
+	}
+}
+
+// A record is completed once no line has followed it for the hold, and not
+// before
+func TestAssemblerHold(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	records := make(chan string, 1)
+	a := New(Indented(2, 4), Limits{Hold: time.Second, Clock: fake}, func(record string) { records <- record })
+
+	a.Add("panic: boom")
+	fake.Advance(900 * time.Millisecond)
+	a.Add("  frame 1")
+	fake.Advance(900 * time.Millisecond)
+	select {
+	case record := <-records:
+		t.Fatalf("%q completed before its hold", record)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	fake.Advance(100 * time.Millisecond)
+	select {
+	case record := <-records:
+		if record != "panic: boom\n  frame 1" {
+			t.Errorf("record %q", record)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the record wasn't completed after its hold")
+	}
+	if waiting := fake.Waiters(); waiting != 0 {
+		t.Errorf("%d timers still waiting", waiting)
+	}
+}
+
+// assemble joins lines into records by rule
+func assemble(rule Rule, lines ...string) []string {
+	var records []string
+	a := New(rule, Limits{}, func(record string) { records = append(records, record) })
+	for _, line := range lines {
+		a.Add(line)
+	}
+	a.Flush()
+	return records
+}
+
+func TestIndented(t *testing.T) {
+	tests := []struct {
+		width, tabWidth int
+		line            string
+		want            bool
+	}{
+		{1, 8, " x", true},
+		{1, 8, "x", false},
+		{1, 8, "", false},
+		{4, 8, "   x", false},
+		{4, 8, "    x", true},
+		{4, 8, "\tx", true},
+		{4, 2, "\tx", false},
+		{4, 2, "\t\tx", true},
+		{4, 2, " \t x", true},
+		{1, 0, "\tx", false},
+		{1, 0, " \tx", true},
+	}
+	for _, test := range tests {
+		if got := Indented(test.width, test.tabWidth)([]string{"record"}, test.line); got != test.want {
+			t.Errorf("Indented(%d, %d) continued with %q: %v, want %v", test.width, test.tabWidth, test.line, got, test.want)
+		}
+	}
+}
+
+func TestBackslash(t *testing.T) {
+	got := assemble(Backslash, `./configure \`, `  --prefix=/usr \`, `  --quiet`, `echo C:\\`, `done`, `#define X \\\`, `  1`)
+	want := []string{"./configure \\\n  --prefix=/usr \\\n  --quiet", `echo C:\\`, "done", "#define X \\\\\\\n  1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestLegacyFromEnv(t *testing.T) {
+	lines := []string{"make \\", "all", "panic: boom", "  frame", "\tframe", "next"}
+	tests := []struct {
+		env  map[string]string
+		want []string
+	}{
+		{nil, nil},
+		{map[string]string{"HABERDASHER_MULTILINE": "off"}, nil},
+		{map[string]string{"HABERDASHER_MULTILINE": "indent"},
+			[]string{"make \\", "all", "panic: boom\n  frame\n\tframe", "next"}},
+		{map[string]string{"HABERDASHER_MULTILINE": "indent", "HABERDASHER_MULTILINE_INDENT": "4"},
+			[]string{"make \\", "all", "panic: boom", "  frame\n\tframe", "next"}},
+		{map[string]string{"HABERDASHER_MULTILINE": "indent", "HABERDASHER_MULTILINE_TAB_WIDTH": "0"},
+			[]string{"make \\", "all", "panic: boom\n  frame", "\tframe", "next"}},
+		{map[string]string{"HABERDASHER_MULTILINE": "backslash"},
+			[]string{"make \\\nall", "panic: boom", "  frame", "\tframe", "next"}},
+		{map[string]string{"HABERDASHER_MULTILINE": "indent, backslash"},
+			[]string{"make \\\nall", "panic: boom\n  frame\n\tframe", "next"}},
+	}
+	for _, test := range tests {
+		t.Run(test.env["HABERDASHER_MULTILINE"], func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			rule, err := FromEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want == nil {
+				if rule != nil {
+					t.Error("lines are joined")
+				}
+				return
+			}
+			if got := assemble(rule, lines...); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("records %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestLegacyFromEnvErrors(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"HABERDASHER_MULTILINE": "indent,stack"}, `unknown rule "stack"`},
+		{map[string]string{"HABERDASHER_MULTILINE": "indent", "HABERDASHER_MULTILINE_INDENT": "0"}, "HABERDASHER_MULTILINE_INDENT must be a whole number, at least 1"},
+		{map[string]string{"HABERDASHER_MULTILINE": "indent", "HABERDASHER_MULTILINE_TAB_WIDTH": "-1"}, "HABERDASHER_MULTILINE_TAB_WIDTH must be a whole number, at least 0"},
+		{map[string]string{"HABERDASHER_MULTILINE": "indent", "HABERDASHER_MULTILINE_TAB_WIDTH": "tab"}, "HABERDASHER_MULTILINE_TAB_WIDTH must be a whole number"},
+	}
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package multiline
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// A Pattern joins records by regex: a record whose first line matches Start
+// is continued by every line matching Continue. Without Continue, it's
+// continued by every line until the next one matching Start; without Start,
+// any record is continued by lines matching Continue. A Pattern can instead
+// name one of the Profiles, so one can be combined with patterns of its own.
+type Pattern struct {
+	Start    string `json:"start,omitempty"`
+	Continue string `json:"continue,omitempty"`
+	Profile  string `json:"profile,omitempty"`
+}
+
+// Patterns returns a rule continuing a record whenever one of patterns does
+func Patterns(patterns []Pattern) (Rule, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("there must be at least one pattern")
+	}
+	rules := make([]Rule, len(patterns))
+	for i, pattern := range patterns {
+		rule, err := pattern.rule()
+		if err != nil {
+			return nil, fmt.Errorf("pattern %d: %v", i+1, err)
+		}
+		rules[i] = rule
+	}
+	if len(rules) == 1 {
+		return rules[0], nil
+	}
+	return Any(rules...), nil
+}
+
+func (p Pattern) rule() (Rule, error) {
+	if p.Profile != "" {
+		if p.Start != "" || p.Continue != "" {
+			return nil, fmt.Errorf("a profile can't have a start or continue as well")
+		}
+		rule, known := Profiles[p.Profile]
+		if !known {
+			return nil, fmt.Errorf("unknown profile %q", p.Profile)
+		}
+		if rule == nil {
+			return nil, fmt.Errorf("the %s profile never joins lines", p.Profile)
+		}
+		return rule, nil
+	}
+	if p.Start == "" && p.Continue == "" {
+		return nil, fmt.Errorf("needs a start, a continue, or a profile")
+	}
+	var start, continuation *regexp.Regexp
+	var err error
+	if p.Start != "" {
+		if start, err = regexp.Compile(p.Start); err != nil {
+			return nil, fmt.Errorf("start: %v", err)
+		}
+	}
+	if p.Continue != "" {
+		if continuation, err = regexp.Compile(p.Continue); err != nil {
+			return nil, fmt.Errorf("continue: %v", err)
+		}
+	}
+	return func(record []string, line string) bool {
+		if start != nil && !start.MatchString(record[0]) {
+			return false
+		}
+		if continuation != nil {
+			return continuation.MatchString(line)
+		}
+		return !start.MatchString(line)
+	}, nil
+}
+
+// patternsFromEnv reads the rule for HABERDASHER_MULTILINE_PATTERNS, a JSON
+// array of patterns
+func patternsFromEnv() (Rule, error) {
+	patternsFromEnv, exists := config.Setting("HABERDASHER_MULTILINE_PATTERNS")
+	if !exists {
+		return nil, fmt.Errorf("the patterns splitter needs HABERDASHER_MULTILINE_PATTERNS")
+	}
+	var patterns []Pattern
+	if err := json.Unmarshal([]byte(patternsFromEnv), &patterns); err != nil {
+		return nil, fmt.Errorf("HABERDASHER_MULTILINE_PATTERNS must be a JSON array of patterns: %v", err)
+	}
+	rule, err := Patterns(patterns)
+	if err != nil {
+		return nil, fmt.Errorf("HABERDASHER_MULTILINE_PATTERNS: %v", err)
+	}
+	return rule, nil
+}
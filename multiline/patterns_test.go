@@ -0,0 +1,127 @@
+package multiline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGo(t *testing.T) {
+	lines := []string{
+		"listening on :8080",
+		"panic: runtime error: invalid memory address or nil pointer dereference",
+		"[signal SIGSEGV: segmentation violation code=0x1 addr=0x0 pc=0x4a2b3c]",
+		"",
+		"goroutine 1 [running]:",
+		"main.handle(0x0)",
+		"\t/src/main.go:12 +0x1c",
+		"main.main()",
+		"\t/src/main.go:20 +0x25",
+		"exit status 2",
+		"fatal error: all goroutines are asleep - deadlock!",
+		"",
+		"goroutine 1 [chan receive]:",
+		"main.main()",
+		"\t/src/main.go:8 +0x2d",
+		"restarting",
+		"  indented, but not after a panic",
+	}
+	want := []string{
+		"listening on :8080",
+		strings.Join(lines[1:9], "\n"),
+		"exit status 2",
+		strings.Join(lines[10:15], "\n"),
+		"restarting",
+		"  indented, but not after a panic",
+	}
+	if got := assemble(Go, lines...); !reflect.DeepEqual(got, want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	lines := []string{
+		"2024-05-01 ERROR failed",
+		"details: timeout",
+		"retrying",
+		"2024-05-01 INFO ok",
+		"> quoted",
+		"> more",
+		"Caused by: x",
+		"\tat Frame",
+	}
+	tests := []struct {
+		name     string
+		patterns []Pattern
+		want     []string
+	}{
+		{"start only", []Pattern{{Start: `^\d{4}-`}}, []string{
+			"2024-05-01 ERROR failed\ndetails: timeout\nretrying",
+			"2024-05-01 INFO ok\n> quoted\n> more\nCaused by: x\n\tat Frame",
+		}},
+		{"start and continue", []Pattern{{Start: `ERROR`, Continue: `^details:`}}, []string{
+			"2024-05-01 ERROR failed\ndetails: timeout", "retrying", "2024-05-01 INFO ok", "> quoted", "> more", "Caused by: x", "\tat Frame",
+		}},
+		{"continue only", []Pattern{{Continue: `^> `}}, []string{
+			"2024-05-01 ERROR failed", "details: timeout", "retrying", "2024-05-01 INFO ok\n> quoted\n> more", "Caused by: x", "\tat Frame",
+		}},
+		{"with a profile", []Pattern{{Continue: `^> `}, {Profile: "java"}}, []string{
+			"2024-05-01 ERROR failed", "details: timeout", "retrying", "2024-05-01 INFO ok\n> quoted\n> more\nCaused by: x\n\tat Frame",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rule, err := Patterns(test.patterns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := assemble(rule, lines...); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("records %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPatternsFromEnv(t *testing.T) {
+	t.Setenv("HABERDASHER_SPLITTER", "patterns")
+	t.Setenv("HABERDASHER_MULTILINE_PATTERNS", `[{"profile": "go"}, {"start": "^BEGIN", "continue": "^  "}]`)
+	rule, err := FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := assemble(rule, "BEGIN", "  body", "panic: boom", "", "goroutine 1 [running]:", "end")
+	want := []string{"BEGIN\n  body", "panic: boom\n\ngoroutine 1 [running]:", "end"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestPatternsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"none", map[string]string{"HABERDASHER_SPLITTER": "patterns"}, "the patterns splitter needs HABERDASHER_MULTILINE_PATTERNS"},
+		{"empty", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `[]`}, "there must be at least one pattern"},
+		{"not an array", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `{"start": "x"}`}, "must be a JSON array of patterns"},
+		{"nothing to match", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `[{"start": "x"}, {}]`}, "pattern 2: needs a start, a continue, or a profile"},
+		{"bad start", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `[{"start": "("}]`}, "pattern 1: start: error parsing regexp"},
+		{"bad continue", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `[{"continue": "["}]`}, "pattern 1: continue: error parsing regexp"},
+		{"unknown profile", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `[{"profile": "ruby"}]`}, `unknown profile "ruby"`},
+		{"raw profile", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `[{"profile": "raw"}]`}, "the raw profile never joins lines"},
+		{"profile and start", map[string]string{"HABERDASHER_SPLITTER": "patterns", "HABERDASHER_MULTILINE_PATTERNS": `[{"profile": "go", "start": "x"}]`}, "a profile can't have a start or continue as well"},
+		{"with a profile", map[string]string{"HABERDASHER_SPLITTER": "go", "HABERDASHER_MULTILINE_PATTERNS": `[{"start": "x"}]`}, "HABERDASHER_MULTILINE_PATTERNS only applies to the patterns splitter, not go"},
+		{"with legacy", map[string]string{"HABERDASHER_MULTILINE_PATTERNS": `[{"start": "x"}]`}, "HABERDASHER_MULTILINE_PATTERNS only applies to the patterns splitter, not legacy"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package multiline
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Profiles are the splitters HABERDASHER_SPLITTER can name, each suited to
+// one kind of application, besides legacy: whatever HABERDASHER_MULTILINE
+// says, exactly as before there were profiles, and patterns: whatever
+// HABERDASHER_MULTILINE_PATTERNS says. A nil rule never joins lines.
+var Profiles = map[string]Rule{
+	"raw":    nil,
+	"python": Python,
+	"java":   Java,
+	"go":     Go,
+	"json":   JSON,
+}
+
+func profileNames() []string {
+	names := []string{"legacy", "patterns"}
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names[2:])
+	return names
+}
+
+const pythonTraceback = "Traceback (most recent call last):"
+
+var indented = Indented(1, 8)
+
+// Python joins tracebacks: the frames and source lines indented under
+// "Traceback (most recent call last):", the exception ending it, and chained
+// exceptions' tracebacks with the lines introducing them
+func Python(record []string, line string) bool {
+	if indented(record, line) {
+		return true
+	}
+	inTraceback := false
+	for _, l := range record {
+		if strings.HasPrefix(l, pythonTraceback) {
+			inTraceback = true
+			break
+		}
+	}
+	if !inTraceback {
+		return false
+	}
+	previous := record[len(record)-1]
+	switch {
+	case line == pythonTraceback,
+		strings.HasPrefix(line, "During handling of the above exception"),
+		strings.HasPrefix(line, "The above exception was the direct cause"):
+		return previous == ""
+	case line == "":
+		// Chained exceptions are separated by blank lines
+		return previous != "" && previous != pythonTraceback && !indented(record, previous)
+	}
+	// The exception itself follows the last frame's source line
+	return indented(record, previous)
+}
+
+// Java joins stack traces: the indented "at" frames and "... 3 more" lines,
+// and the "Caused by:" lines of their causes
+func Java(record []string, line string) bool {
+	return indented(record, line) || strings.HasPrefix(line, "Caused by: ")
+}
+
+// The lines of a Go panic's goroutine dump: blank lines, goroutine headers,
+// functions with their arguments, the indented files and lines calling them,
+// and the like
+var goPanicLine = regexp.MustCompile(`^(\s|goroutine \d+ \[|created by |\[signal |runtime stack:|\.\.\.|$)|^[^\s(]+\(.*\)$`)
+
+// Go joins panics and fatal errors: the message, and the dump of goroutines'
+// stacks after it
+func Go(record []string, line string) bool {
+	first := record[0]
+	if !strings.HasPrefix(first, "panic: ") && !strings.HasPrefix(first, "fatal error: ") {
+		return false
+	}
+	return goPanicLine.MatchString(line)
+}
+
+// JSON joins pretty-printed JSON documents, continuing a record which starts
+// with { or [ until its brackets balance
+func JSON(record []string, line string) bool {
+	first := strings.TrimSpace(record[0])
+	if !strings.HasPrefix(first, "{") && !strings.HasPrefix(first, "[") {
+		return false
+	}
+	depth := 0
+	inString, escaped := false, false
+	for _, l := range record {
+		for i := 0; i < len(l); i++ {
+			c := l[i]
+			switch {
+			case escaped:
+				escaped = false
+			case inString && c == '\\':
+				escaped = true
+			case c == '"':
+				inString = !inString
+			case inString:
+			case c == '{' || c == '[':
+				depth++
+			case c == '}' || c == ']':
+				depth--
+			}
+		}
+	}
+	return depth > 0
+}
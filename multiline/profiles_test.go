@@ -0,0 +1,108 @@
+package multiline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPython(t *testing.T) {
+	lines := []string{
+		"Starting worker",
+		"Traceback (most recent call last):",
+		`  File "app.py", line 3, in <module>`,
+		"    load()",
+		"KeyError: 'name'",
+		"",
+		"During handling of the above exception, another exception occurred:",
+		"",
+		"Traceback (most recent call last):",
+		`  File "app.py", line 5, in <module>`,
+		"    fail()",
+		"RuntimeError: giving up",
+		"Worker exited",
+		"",
+	}
+	want := []string{
+		"Starting worker",
+		strings.Join(lines[1:12], "\n"),
+		"Worker exited",
+		"",
+	}
+	if got := assemble(Python, lines...); !reflect.DeepEqual(got, want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestJava(t *testing.T) {
+	lines := []string{
+		"Exception in thread \"main\" java.lang.IllegalStateException: closed",
+		"\tat com.example.Pool.get(Pool.java:42)",
+		"\tat com.example.Main.main(Main.java:7)",
+		"Caused by: java.io.IOException: broken pipe",
+		"\tat com.example.Conn.write(Conn.java:88)",
+		"\t... 2 more",
+		"INFO shutting down",
+	}
+	want := []string{strings.Join(lines[:6], "\n"), "INFO shutting down"}
+	if got := assemble(Java, lines...); !reflect.DeepEqual(got, want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	lines := []string{
+		`{"level": "info", "msg": "one line"}`,
+		`{`,
+		`  "msg": "a } in a string, and \" an escaped quote {",`,
+		`  "items": [`,
+		`    {"id": 1}`,
+		`  ]`,
+		`}`,
+		`[1,`,
+		` 2]`,
+		`plain text`,
+	}
+	want := []string{lines[0], strings.Join(lines[1:7], "\n"), "[1,\n 2]", "plain text"}
+	if got := assemble(JSON, lines...); !reflect.DeepEqual(got, want) {
+		t.Errorf("records %q, want %q", got, want)
+	}
+}
+
+func TestFromEnvProfiles(t *testing.T) {
+	for _, name := range []string{"python", "java", "go", "json"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("HABERDASHER_SPLITTER", name)
+			if rule, err := FromEnv(); err != nil || rule == nil {
+				t.Errorf("FromEnv() = %v, %v", rule, err)
+			}
+		})
+	}
+	t.Run("raw", func(t *testing.T) {
+		t.Setenv("HABERDASHER_SPLITTER", "raw")
+		if rule, err := FromEnv(); err != nil || rule != nil {
+			t.Errorf("FromEnv() = %v, %v, want lines never joined", rule, err)
+		}
+	})
+}
+
+func TestFromEnvProfileErrors(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"HABERDASHER_SPLITTER": "ruby"}, "HABERDASHER_SPLITTER must be one of legacy, patterns, go, java, json, python, raw"},
+		{map[string]string{"HABERDASHER_SPLITTER": "java", "HABERDASHER_MULTILINE": "indent"}, "HABERDASHER_MULTILINE only applies to the legacy splitter, not java"},
+		{map[string]string{"HABERDASHER_SPLITTER": "python", "HABERDASHER_MULTILINE_TAB_WIDTH": "4"}, "HABERDASHER_MULTILINE_TAB_WIDTH only applies to the legacy splitter"},
+	}
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Not in the syscall package
+const (
+	fSetPipeSize = 1031
+	fGetPipeSize = 1032
+)
+
+// setPipeSize asks the kernel to grow a pipe's buffer, returning the size it
+// actually got. Unprivileged processes can't go past fs.pipe-max-size.
+func setPipeSize(pipe interface{}, size int) (int, error) {
+	file, ok := pipe.(*os.File)
+	if !ok {
+		return 0, errors.New("not a pipe")
+	}
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var got uintptr
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		if _, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, fSetPipeSize, uintptr(size)); errno != 0 {
+			return
+		}
+		got, _, errno = syscall.Syscall(syscall.SYS_FCNTL, fd, fGetPipeSize, 0)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(got), nil
+}
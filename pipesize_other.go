@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+func setPipeSize(pipe interface{}, size int) (int, error) {
+	return 0, errors.New("resizing pipes is only supported on Linux")
+}
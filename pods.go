@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/kube"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/tail"
+)
+
+const podScanInterval = 10 * time.Second
+
+// In DaemonSet mode haberdasher collects the logs of every pod on the node
+// instead of wrapping a command. HABERDASHER_PODS_DIR points at the kubelet's
+// pod log directory (usually /var/log/pods), which is laid out as
+// <namespace>_<pod>_<uid>/<container>/<restart>.log in the CRI format.
+//
+// Every message is labelled with its namespace, pod and container, and with
+// the pod's own labels if HABERDASHER_PODS_METADATA is set and the service
+// account may get pods. HABERDASHER_PODS_ROUTES is a JSON object mapping
+// namespaces to the emitter their logs should go to, or to "drop"; namespaces
+// without a route use the configured emitter. Returns whether DaemonSet mode
+// is enabled.
+func startPodCollector(emitter logging.Emitter) bool {
+	dir, exists := config.Setting("HABERDASHER_PODS_DIR")
+	if !exists {
+		return false
+	}
+
+	routes := make(map[string]logging.Emitter)
+	routesFromEnv, exists := config.Setting("HABERDASHER_PODS_ROUTES")
+	if !exists {
+		routesFromEnv = "{}"
+	}
+	var routeNames map[string]string
+	if err := json.Unmarshal([]byte(routesFromEnv), &routeNames); err != nil {
+		log.Fatal("HABERDASHER_PODS_ROUTES must be a JSON object of namespaces to emitter names")
+	}
+	for namespace, name := range routeNames {
+		routed, err := routeTo(name)
+		if err != nil {
+			log.Fatal("HABERDASHER_PODS_ROUTES: ", err)
+		}
+		routes[namespace] = routed
+	}
+
+	var client *kube.Client
+	if config.FlagSetting("HABERDASHER_PODS_METADATA") {
+		var err error
+		if client, err = kube.InCluster(); err != nil {
+			log.Fatal("HABERDASHER_PODS_METADATA requires the Kubernetes API: ", err)
+		}
+	}
+
+	log.Println("Collecting pod logs from", dir)
+	collector := &podCollector{
+		dir:       dir,
+		emitter:   emitter,
+		routes:    routes,
+		client:    client,
+		following: make(map[string]bool),
+	}
+	producers.start(collector.run)
+	return true
+}
+
+type podCollector struct {
+	dir     string
+	emitter logging.Emitter
+	routes  map[string]logging.Emitter
+	client  *kube.Client
+
+	lock      sync.Mutex
+	following map[string]bool
+}
+
+func (c *podCollector) run() {
+	// Files which already exist when we start are followed from their end;
+	// anything that shows up afterwards is new and read from the start
+	fromStart := false
+	for {
+		paths, err := filepath.Glob(filepath.Join(c.dir, "*", "*", "*.log"))
+		if err != nil {
+			log.Println("Error scanning for pod logs:", err)
+		}
+		for _, path := range paths {
+			c.lock.Lock()
+			known := c.following[path]
+			c.following[path] = true
+			c.lock.Unlock()
+			if !known {
+				path, fromStart := path, fromStart
+				producers.start(func() { c.follow(path, fromStart) })
+			}
+		}
+		fromStart = true
+		if !producers.sleep(podScanInterval) {
+			return
+		}
+	}
+}
+
+func (c *podCollector) follow(path string, fromStart bool) {
+	rel, _ := filepath.Rel(c.dir, path)
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	podParts := strings.SplitN(parts[0], "_", 3)
+	if len(parts) != 3 || len(podParts) != 3 {
+		log.Println("Skipping unrecognized pod log path:", path)
+		return
+	}
+	namespace, pod, container := podParts[0], podParts[1], parts[1]
+
+	emitter, routed := c.routes[namespace]
+	if !routed {
+		emitter = c.emitter
+	}
+	if emitter == nil {
+		// Dropped namespaces stay in the following set so they aren't rescanned
+		return
+	}
+	defer func() {
+		c.lock.Lock()
+		delete(c.following, path)
+		c.lock.Unlock()
+	}()
+
+	labels := map[string]string{
+		"kubernetes_namespace":      namespace,
+		"kubernetes_pod_name":       pod,
+		"kubernetes_pod_uid":        podParts[2],
+		"kubernetes_container_name": container,
+	}
+	if c.client != nil {
+		if meta, err := c.client.GetPod(namespace, pod); err != nil {
+			log.Println("Unable to look up pod", namespace+"/"+pod+":", err)
+		} else {
+			labels["kubernetes_node_name"] = meta.Spec.NodeName
+			for k, v := range meta.Metadata.Labels {
+				labels["kubernetes_pod_label_"+labelKey(k)] = v
+			}
+		}
+	}
+
+	decoder, _ := tail.NewDecoder("cri")
+	opts := tailOptions()
+	opts.FromStart = fromStart
+	opts.StopWhenRemoved = true
+	tail.Follow(path, opts, func(record string) {
+		line, complete, err := decoder.Decode(record)
+		if err != nil {
+			log.Println("Error decoding", path+":", err)
+			return
+		}
+		if complete {
+			source := logging.Source{Path: path, Stream: line.Stream, Labels: labels}
+			logging.EmitAt(emitter, source, line.Time, logging.Clock.Now(), line.Log)
+		}
+	})
+}
+
+// ECS label keys can't contain dots, and Kubernetes label keys are full of
+// them (and slashes)
+func labelKey(key string) string {
+	return strings.NewReplacer(".", "_", "/", "_", "-", "_").Replace(key)
+}
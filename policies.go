@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/signature"
+)
+
+// A policyFragment is one file of a policy bundle: redactions and virtual
+// sources layered over the ones configured in the environment
+type policyFragment struct {
+	Redactions     []logging.Redaction `json:"redactions"`
+	VirtualSources []*virtualSource    `json:"virtual_sources"`
+}
+
+// The redactions configured in the environment, which policy fragments are
+// merged over each time they're read
+var baseRedactions []logging.Redaction
+var baseRedactionsOnce sync.Once
+
+// HABERDASHER_POLICY_DIR names a directory of policy fragments, *.json files
+// such as a ConfigMap of org-wide rules mounted by the platform. They're merged
+// over HABERDASHER_REDACTIONS and HABERDASHER_VIRTUAL_SOURCES in lexical order
+// of their file names, conf.d style: a rule replaces any earlier rule of the
+// same name where it stood, and new rules are appended. If
+// HABERDASHER_SIGNING_KEY is set, every fragment must be signed with it.
+func loadPolicies() []*virtualSource {
+	redactions, sources, err := readPolicies()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := logging.SetRedactions(redactions); err != nil {
+		log.Fatal("HABERDASHER_POLICY_DIR: ", err)
+	}
+	return sources
+}
+
+// readPolicies reads and merges the redactions and virtual sources, without
+// applying them
+func readPolicies() ([]logging.Redaction, []*virtualSource, error) {
+	baseRedactionsOnce.Do(func() { baseRedactions = logging.Redactions() })
+	redactions := append([]logging.Redaction(nil), baseRedactions...)
+
+	var sources []*virtualSource
+	if sourcesFromEnv, exists := config.Setting("HABERDASHER_VIRTUAL_SOURCES"); exists {
+		if err := json.Unmarshal([]byte(sourcesFromEnv), &sources); err != nil {
+			return nil, nil, fmt.Errorf("HABERDASHER_VIRTUAL_SOURCES must be a JSON array of virtual sources: %v", err)
+		}
+	}
+
+	dir, exists := config.Setting("HABERDASHER_POLICY_DIR")
+	if !exists {
+		return redactions, sources, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("HABERDASHER_POLICY_DIR: %v", err)
+	}
+	sort.Strings(paths)
+	key, err := signature.FromEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("HABERDASHER_SIGNING_KEY: %v", err)
+	}
+
+	for _, path := range paths {
+		var contents []byte
+		if key != nil {
+			// What was verified is what's parsed, even if the file is
+			// replaced in between, as ConfigMap updates do
+			if contents, err = key.ReadVerified(path); err != nil {
+				return nil, nil, fmt.Errorf("HABERDASHER_POLICY_DIR: %s: %v", path, err)
+			}
+		} else if contents, err = ioutil.ReadFile(path); err != nil {
+			return nil, nil, fmt.Errorf("HABERDASHER_POLICY_DIR: %v", err)
+		}
+		fragment, err := decodeFragment(contents)
+		if err != nil {
+			return nil, nil, fmt.Errorf("HABERDASHER_POLICY_DIR: %s: %v", path, err)
+		}
+		for _, redaction := range fragment.Redactions {
+			redactions = mergeRedaction(redactions, redaction)
+		}
+		for _, source := range fragment.VirtualSources {
+			sources = mergeVirtualSource(sources, source)
+		}
+		log.Println("Loaded policy fragment:", path)
+	}
+	return redactions, sources, nil
+}
+
+// decodeFragment parses a policy fragment, refusing keys it doesn't know, so a
+// typo fails when it's loaded instead of leaving a rule half configured
+func decodeFragment(contents []byte) (policyFragment, error) {
+	var fragment policyFragment
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&fragment)
+	return fragment, err
+}
+
+func mergeRedaction(rules []logging.Redaction, rule logging.Redaction) []logging.Redaction {
+	for i := range rules {
+		if rules[i].Name == rule.Name {
+			rules[i] = rule
+			return rules
+		}
+	}
+	return append(rules, rule)
+}
+
+func mergeVirtualSource(sources []*virtualSource, source *virtualSource) []*virtualSource {
+	for i := range sources {
+		if sources[i].Name == source.Name {
+			sources[i] = source
+			return sources
+		}
+	}
+	return append(sources, source)
+}
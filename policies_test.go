@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// withPolicies makes base the redactions configured in the environment, and
+// writes the fragments, by file name, to HABERDASHER_POLICY_DIR
+func withPolicies(t *testing.T, base []logging.Redaction, fragments map[string]string) string {
+	if err := logging.SetRedactions(base); err != nil {
+		t.Fatal(err)
+	}
+	baseRedactionsOnce = sync.Once{}
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		logging.SetRedactions(nil)
+		baseRedactionsOnce = sync.Once{}
+		log.SetOutput(os.Stderr)
+	})
+	dir := t.TempDir()
+	for name, contents := range fragments {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("HABERDASHER_POLICY_DIR", dir)
+	return dir
+}
+
+func redactionNames(redactions []logging.Redaction) string {
+	var names []string
+	for _, r := range redactions {
+		names = append(names, r.Name+"="+r.Replace)
+	}
+	return strings.Join(names, " ")
+}
+
+func sourceNames(sources []*virtualSource) string {
+	var names []string
+	for _, s := range sources {
+		names = append(names, s.Name+"="+s.Emitter)
+	}
+	return strings.Join(names, " ")
+}
+
+// Fragments are merged in order of their names: a rule replaces an earlier
+// one of the same name where it stood, and new ones are appended
+func TestReadPoliciesMerges(t *testing.T) {
+	withPolicies(t, []logging.Redaction{
+		{Name: "card", Match: "[0-9]{16}", Replace: "[card]"},
+		{Name: "email", Match: "@example.com", Replace: "[env]"},
+	}, map[string]string{
+		"20-team.json": `{"redactions": [{"name": "token", "match": "tok_[a-z]+", "replace": "[team]"}],
+			"virtual_sources": [{"name": "access", "match": "GET", "emitter": "stderr"}]}`,
+		"10-org.json": `{"redactions": [{"name": "email", "match": "@example.com", "replace": "[org]"}, {"name": "token", "match": "tok_", "replace": "[org]"}],
+			"virtual_sources": [{"name": "health", "match": "/healthz", "emitter": "drop"}]}`,
+		"notes.txt": "not a fragment",
+	})
+	t.Setenv("HABERDASHER_VIRTUAL_SOURCES", `[{"name": "access", "match": "GET"}]`)
+
+	for i := 0; i < 2; i++ {
+		redactions, sources, err := readPolicies()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Applying them doesn't make them the base the next read merges over
+		if err := logging.SetRedactions(redactions); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := redactionNames(redactions), "card=[card] email=[org] token=[team]"; got != want {
+			t.Errorf("read %d: merged redactions %s, want %s", i, got, want)
+		}
+		if got, want := sourceNames(sources), "access=stderr health=drop"; got != want {
+			t.Errorf("read %d: merged virtual sources %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestReadPoliciesRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+		want     string
+	}{
+		{"a misspelled key", `{"redactions": [{"name": "card", "match": "[0-9]{16}", "replacement": "[card]"}]}`, `unknown field "replacement"`},
+		{"not JSON", `redactions: []`, "invalid character"},
+		{"the wrong shape", `{"redactions": {"name": "card"}}`, "cannot unmarshal"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := withPolicies(t, nil, map[string]string{"10-org.json": `{}`, "20-team.json": test.fragment})
+			_, _, err := readPolicies()
+			if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "20-team.json")) || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error about 20-team.json mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+// With a signing key, every fragment has to be signed with it
+func TestReadPoliciesSigned(t *testing.T) {
+	signed, err := ioutil.ReadFile(filepath.Join("signature", "testdata", "cosign", "policy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ioutil.ReadFile(filepath.Join("signature", "testdata", "cosign", "policy.json.sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := withPolicies(t, nil, map[string]string{"10-org.json": string(signed), "10-org.json.sig": string(sig)})
+	t.Setenv("HABERDASHER_SIGNING_KEY", filepath.Join("signature", "testdata", "cosign.pub"))
+	redactions, _, err := readPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redactionNames(redactions); got != "card=[card]" {
+		t.Errorf("read redactions %s", got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "20-team.json"), []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readPolicies(); err == nil || !strings.Contains(err.Error(), "20-team.json") {
+		t.Errorf("an unsigned fragment gave %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+// Linux reports CPU time in clock ticks, which are almost always 100 a second
+const clockTicks = 100
+
+var memoryUsage = metrics.NewGauge("haberdasher_memory_bytes", "Resident memory used by Haberdasher itself.")
+var cpuUsage = metrics.NewGauge("haberdasher_cpu_cores", "CPU used by Haberdasher itself, in cores, over the last sample.")
+
+// startPressureMonitor watches Haberdasher's own memory and CPU use and, when
+// either passes HABERDASHER_PRESSURE_MEMORY (bytes) or HABERDASHER_PRESSURE_CPU
+// (cores), stops shipping debug and trace lines until it recovers. Events
+// mark where shedding started and stopped, so downstream consumers can tell a
+// gap in debug output from the application going quiet.
+func startPressureMonitor(emitter logging.Emitter, queue *logging.Queue) {
+	var cpuLimit float64
+	memoryLimit, memorySet := config.IntSetting("HABERDASHER_PRESSURE_MEMORY", 1)
+	cpuFromEnv, cpuSet := config.Setting("HABERDASHER_PRESSURE_CPU")
+	if cpuSet {
+		var err error
+		if cpuLimit, err = strconv.ParseFloat(cpuFromEnv, 64); err != nil || cpuLimit <= 0 {
+			log.Fatal("HABERDASHER_PRESSURE_CPU must be a positive number of cores, like 0.5")
+		}
+	}
+	if !memorySet && !cpuSet {
+		return
+	}
+	interval, _ := config.DurationSetting("HABERDASHER_PRESSURE_INTERVAL")
+
+	producers.start(func() {
+		shedding := false
+		lastCPU, lastSampled := cpuSeconds("self"), logging.Clock.Now()
+		for producers.sleep(interval) {
+			memory := residentBytes("self")
+			cpu := cpuSeconds("self")
+			cores := (cpu - lastCPU) / logging.Clock.Since(lastSampled).Seconds()
+			lastCPU, lastSampled = cpu, logging.Clock.Now()
+			memoryUsage.Set(float64(memory))
+			cpuUsage.Set(cores)
+
+			var reasons []string
+			if memorySet && memory > memoryLimit {
+				reasons = append(reasons, fmt.Sprintf("memory pressure (%d bytes used, limit %d)", memory, memoryLimit))
+			}
+			if cpuSet && cores > cpuLimit {
+				reasons = append(reasons, fmt.Sprintf("CPU pressure (%.2f cores used, limit %.2f)", cores, cpuLimit))
+			}
+
+			under := len(reasons) > 0
+			if under == shedding {
+				continue
+			}
+			shed := queue.ShedDebug(under)
+			shedding = under
+			if under {
+				logging.EmitEvent(emitter, "resource-pressure", "Shedding debug messages due to "+strings.Join(reasons, " and "))
+			} else {
+				logging.EmitEvent(emitter, "resource-pressure-relieved", fmt.Sprintf("Resource pressure relieved, shipping debug messages again after shedding %d", shed))
+			}
+		}
+	})
+}
+
+// residentBytes returns a process's resident set size from
+// /proc/<process>/statm, where process is a pid, or self for our own
+func residentBytes(process string) int {
+	statm, err := ioutil.ReadFile("/proc/" + process + "/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, _ := strconv.Atoi(fields[1])
+	return pages * os.Getpagesize()
+}
+
+// cpuSeconds returns the user and system CPU time a process has used, from
+// /proc/<process>/stat
+func cpuSeconds(process string) float64 {
+	stat, err := ioutil.ReadFile("/proc/" + process + "/stat")
+	if err != nil {
+		return 0
+	}
+	// The command name is in parentheses and may contain spaces
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	if len(fields) < 13 {
+		return 0
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	return (utime + stime) / clockTicks
+}
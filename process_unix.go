@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// newProcessGroup has the child lead a process group of its own, so
+// forwardSignals can reach it and whatever it starts
+func newProcessGroup(subcmd *exec.Cmd) {
+	subcmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func kill(pid int, sig syscall.Signal) error {
+	return syscall.Kill(pid, sig)
+}
+
+func killGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig)
+}
+
+// pipeIdentity returns what /proc/<pid>/fd/N links to for the other end of the
+// pipe, e.g. "pipe:[1234]"
+func pipeIdentity(pipe interface{}) string {
+	f, ok := pipe.(*os.File)
+	if !ok {
+		return ""
+	}
+	// Not Fd(), which would put the pipe in blocking mode, and then closing
+	// it wouldn't interrupt a read, see drain
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("pipe:[%d]", stat.Ino)
+}
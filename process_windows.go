@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Windows has no process groups to speak of, so signals only reach the child
+func newProcessGroup(subcmd *exec.Cmd) {}
+
+// kill can only kill on Windows, so the signals asking a process to stop do
+// that, and any others are an error
+func kill(pid int, sig syscall.Signal) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	switch sig {
+	case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL:
+		return process.Kill()
+	}
+	return fmt.Errorf("%v can't be sent on Windows", sig)
+}
+
+func killGroup(pid int, sig syscall.Signal) error {
+	return kill(pid, sig)
+}
+
+// pipeIdentity returns nothing, since there's no /proc to compare it with
+func pipeIdentity(pipe interface{}) string {
+	return ""
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// How long shutdown waits for the producers to finish what they're shipping
+const producerStopTimeout = 5 * time.Second
+
+// Besides the child's pipes, which go through the queue, lines and events are
+// shipped straight to the emitters by the file and pod followers and the
+// periodic checks (canary, thresholds, watchdog and so on). A line handed to
+// an emitter after its Cleanup is lost, or worse, restarts it, so shutdown
+// stops all of them, and waits for whatever they're in the middle of
+// shipping, before the queue is closed and the emitters cleaned up.
+type producerGroup struct {
+	lock    sync.Mutex
+	stop    chan struct{}
+	stopped bool
+	running sync.WaitGroup
+}
+
+var producers = &producerGroup{stop: make(chan struct{})}
+
+// start runs f in its own goroutine, which shutdown waits for. f should return
+// soon after stopping is closed. Once shutdown has begun, f isn't run at all.
+func (p *producerGroup) start(f func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.stopped {
+		return
+	}
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		f()
+	}()
+}
+
+// stopping is closed once shutdown has begun
+func (p *producerGroup) stopping() <-chan struct{} {
+	return p.stop
+}
+
+// sleep waits for d, returning false instead if shutdown begins first
+func (p *producerGroup) sleep(d time.Duration) bool {
+	select {
+	case <-logging.Clock.After(d):
+		return true
+	case <-p.stop:
+		return false
+	}
+}
+
+// halt tells the producers to stop, and waits up to producerStopTimeout for
+// them to return
+func (p *producerGroup) halt() {
+	p.lock.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.stop)
+	}
+	p.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-logging.Clock.After(producerStopTimeout):
+		log.Println("Gave up waiting for the file followers and periodic checks to stop after", producerStopTimeout)
+	}
+}
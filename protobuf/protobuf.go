@@ -0,0 +1,120 @@
+// Package protobuf is just enough of the Protocol Buffers wire format for
+// OTLP: appending fields to a message, and reading the fields of a response.
+//
+// The OTLP emitter builds one request message and reads one field of the
+// response, so the code generated from opentelemetry-proto, and the runtime
+// and gRPC libraries it needs, would be most of the binary for that. The
+// tests check this package against google.golang.org/protobuf's protowire,
+// and the emitter's tests check what it sends with the generated OTLP types
+// and a gRPC server.
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Wire types
+const (
+	Varint  = 0
+	Fixed64 = 1
+	Bytes   = 2
+	Fixed32 = 5
+)
+
+var errTruncated = errors.New("protobuf: truncated message")
+
+// AppendVarint appends v as a base 128 varint
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendTag appends a field's number and wire type
+func AppendTag(b []byte, field int, wireType int) []byte {
+	return AppendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// AppendVarintField appends an integer, boolean, or enum field
+func AppendVarintField(b []byte, field int, v uint64) []byte {
+	return AppendVarint(AppendTag(b, field, Varint), v)
+}
+
+// AppendFixed64Field appends a fixed64 field
+func AppendFixed64Field(b []byte, field int, v uint64) []byte {
+	b = AppendTag(b, field, Fixed64)
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// AppendDoubleField appends a double field
+func AppendDoubleField(b []byte, field int, v float64) []byte {
+	return AppendFixed64Field(b, field, math.Float64bits(v))
+}
+
+// AppendBytesField appends a bytes field, or an embedded message already
+// encoded
+func AppendBytesField(b []byte, field int, v []byte) []byte {
+	b = AppendVarint(AppendTag(b, field, Bytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendStringField appends a string field
+func AppendStringField(b []byte, field int, v string) []byte {
+	b = AppendVarint(AppendTag(b, field, Bytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// A Field is one field read from a message. Varint and fixed fields are in
+// Value; bytes fields, strings, and embedded messages are in Data.
+type Field struct {
+	Number   int
+	WireType int
+	Value    uint64
+	Data     []byte
+}
+
+// Fields reads every field of a message, in order
+func Fields(b []byte) ([]Field, error) {
+	var fields []Field
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errTruncated
+		}
+		b = b[n:]
+		f := Field{Number: int(tag >> 3), WireType: int(tag & 7)}
+		switch f.WireType {
+		case Varint:
+			if f.Value, n = binary.Uvarint(b); n <= 0 {
+				return nil, errTruncated
+			}
+			b = b[n:]
+		case Fixed64:
+			if len(b) < 8 {
+				return nil, errTruncated
+			}
+			f.Value, b = binary.LittleEndian.Uint64(b), b[8:]
+		case Fixed32:
+			if len(b) < 4 {
+				return nil, errTruncated
+			}
+			f.Value, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case Bytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return nil, errTruncated
+			}
+			f.Data, b = b[n:n+int(length)], b[n+int(length):]
+		default:
+			return nil, errors.New("protobuf: unsupported wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
@@ -0,0 +1,133 @@
+package protobuf
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The encodings in the Protocol Buffers documentation's guide to the wire
+// format, and a few more worked out from it
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"one byte varint", AppendVarint(nil, 1), []byte{0x01}},
+		{"two byte varint", AppendVarint(nil, 150), []byte{0x96, 0x01}},
+		{"largest varint", AppendVarint(nil, math.MaxUint64), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"varint field", AppendVarintField(nil, 1, 150), []byte{0x08, 0x96, 0x01}},
+		{"negative int64", AppendVarintField(nil, 3, math.MaxUint64-1), []byte{0x18, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{"string field", AppendStringField(nil, 2, "testing"), []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}},
+		{"empty string field", AppendStringField(nil, 1, ""), []byte{0x0a, 0x00}},
+		{"embedded message", AppendBytesField(nil, 3, AppendVarintField(nil, 1, 150)), []byte{0x1a, 0x03, 0x08, 0x96, 0x01}},
+		{"field number over 15", AppendVarintField(nil, 16, 1), []byte{0x80, 0x01, 0x01}},
+		{"fixed64 field", AppendFixed64Field(nil, 1, 0x0102030405060708), []byte{0x09, 0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01}},
+		{"double field", AppendDoubleField(nil, 4, 1.5), []byte{0x21, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f}},
+		{"appends", AppendVarintField([]byte{0xaa}, 1, 1), []byte{0xaa, 0x08, 0x01}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !bytes.Equal(test.got, test.want) {
+				t.Errorf("% x, want % x", test.got, test.want)
+			}
+		})
+	}
+}
+
+func TestFields(t *testing.T) {
+	var message []byte
+	message = AppendVarintField(message, 1, 150)
+	message = AppendStringField(message, 2, "testing")
+	message = AppendFixed64Field(message, 3, 42)
+	message = append(message, 0x25, 0x2a, 0, 0, 0) // field 4, fixed32 42
+	message = AppendBytesField(message, 1, nil)
+
+	got, err := Fields(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Field{
+		{Number: 1, WireType: Varint, Value: 150},
+		{Number: 2, WireType: Bytes, Data: []byte("testing")},
+		{Number: 3, WireType: Fixed64, Value: 42},
+		{Number: 4, WireType: Fixed32, Value: 42},
+		{Number: 1, WireType: Bytes, Data: []byte{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields(% x) = %+v, want %+v", message, got, want)
+	}
+}
+
+func TestFieldsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+	}{
+		{"truncated tag", []byte{0x80}},
+		{"truncated varint", []byte{0x08, 0x96}},
+		{"truncated fixed64", []byte{0x09, 0x01, 0x02}},
+		{"truncated fixed32", []byte{0x25, 0x01}},
+		{"truncated length", []byte{0x12}},
+		{"bytes longer than the message", []byte{0x12, 0x07, 't', 'e'}},
+		{"groups", []byte{0x0b}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if fields, err := Fields(test.message); err == nil {
+				t.Errorf("Fields(% x) = %+v, want an error", test.message, fields)
+			}
+		})
+	}
+}
+
+// What's appended is what protowire, the reference implementation's wire
+// format package, appends, and Fields reads what it writes
+func TestProtowire(t *testing.T) {
+	values := []uint64{0, 1, 127, 128, 150, 16383, 16384, 1<<32 - 1, 1 << 32, 1<<63 - 1, math.MaxUint64}
+	for _, v := range values {
+		if got, want := AppendVarint(nil, v), protowire.AppendVarint(nil, v); !bytes.Equal(got, want) {
+			t.Errorf("AppendVarint(%d) = % x, protowire appends % x", v, got, want)
+		}
+		if got, want := AppendFixed64Field(nil, 11, v), protowire.AppendFixed64(protowire.AppendTag(nil, 11, protowire.Fixed64Type), v); !bytes.Equal(got, want) {
+			t.Errorf("AppendFixed64Field(11, %d) = % x, protowire appends % x", v, got, want)
+		}
+	}
+	for _, field := range []int{1, 15, 16, 2047, 2048, 536870911} {
+		if got, want := AppendVarintField(nil, field, 1), protowire.AppendVarint(protowire.AppendTag(nil, protowire.Number(field), protowire.VarintType), 1); !bytes.Equal(got, want) {
+			t.Errorf("AppendVarintField(%d, 1) = % x, protowire appends % x", field, got, want)
+		}
+	}
+	long := strings.Repeat("x", 300)
+	if got, want := AppendStringField(nil, 2, long), protowire.AppendString(protowire.AppendTag(nil, 2, protowire.BytesType), long); !bytes.Equal(got, want) {
+		t.Errorf("AppendStringField of 300 bytes = % x, protowire appends % x", got, want)
+	}
+	if got, want := AppendDoubleField(nil, 4, -0.1), protowire.AppendFixed64(protowire.AppendTag(nil, 4, protowire.Fixed64Type), math.Float64bits(-0.1)); !bytes.Equal(got, want) {
+		t.Errorf("AppendDoubleField(-0.1) = % x, protowire appends % x", got, want)
+	}
+
+	var message []byte
+	message = protowire.AppendTag(message, 1, protowire.VarintType)
+	message = protowire.AppendVarint(message, math.MaxUint64)
+	message = protowire.AppendTag(message, 300, protowire.BytesType)
+	message = protowire.AppendBytes(message, []byte(long))
+	message = protowire.AppendTag(message, 3, protowire.Fixed32Type)
+	message = protowire.AppendFixed32(message, 7)
+	got, err := Fields(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Field{
+		{Number: 1, WireType: Varint, Value: math.MaxUint64},
+		{Number: 300, WireType: Bytes, Data: []byte(long)},
+		{Number: 3, WireType: Fixed32, Value: 7},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields read %+v from protowire's message, want %+v", got, want)
+	}
+}
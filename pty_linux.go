@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// winsize is the kernel's struct winsize, which the syscall package lacks
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+// openPTY opens a new pseudo-terminal, returning its master end, which we
+// read, and its slave end, for the child. The slave doesn't translate "\n"
+// into "\r\n", so lines read from the master end as the child wrote them.
+func openPTY() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var number uint32
+	unlock := 0
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	slave, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	var termios syscall.Termios
+	if err := ioctl(slave, syscall.TCGETS, unsafe.Pointer(&termios)); err == nil {
+		termios.Oflag &^= syscall.ONLCR
+		ioctl(slave, syscall.TCSETS, unsafe.Pointer(&termios))
+	}
+	return master, slave, nil
+}
+
+// attachPTY makes the slave end the child's stderr and controlling terminal.
+// It leads a session of its own for that, which also makes it the leader of
+// a process group for forwardSignals.
+func attachPTY(subcmd *exec.Cmd, slave *os.File) {
+	subcmd.Stderr = slave
+	subcmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 2}
+}
+
+// resizePTY gives the terminal our own terminal's size, or 80x24 if we don't
+// have one. The kernel sends the child SIGWINCH when it changes.
+func resizePTY(master *os.File) error {
+	size := winsize{rows: 24, cols: 80}
+	for _, ours := range []*os.File{os.Stdin, os.Stdout, os.Stderr} {
+		var got winsize
+		if ioctl(ours, syscall.TIOCGWINSZ, unsafe.Pointer(&got)) == nil && got.rows > 0 && got.cols > 0 {
+			size = got
+			break
+		}
+	}
+	return ioctl(master, syscall.TIOCSWINSZ, unsafe.Pointer(&size))
+}
+
+// ioctl goes through SyscallConn rather than Fd, which would put the file in
+// blocking mode, and then closing it wouldn't interrupt a read
+func ioctl(f *os.File, request uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
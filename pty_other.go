@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, errors.New("HABERDASHER_PTY is only supported on Linux")
+}
+
+func attachPTY(subcmd *exec.Cmd, slave *os.File) {}
+
+func resizePTY(master *os.File) error {
+	return nil
+}
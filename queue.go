@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+const backpressureReportInterval = time.Minute
+
+var backpressureStalls = metrics.NewCounter("haberdasher_backpressure_stalls_total", "Times reading from the child stopped because the queue was full.")
+var backpressureMilliseconds = metrics.NewCounter("haberdasher_backpressure_milliseconds_total", "Time spent not reading from the child because the queue was full.")
+
+// newQueue creates the queue between the child's streams and the emitter.
+// HABERDASHER_QUEUE_SIZE is the backlog each lane can hold before we stop
+// reading from the child, and HABERDASHER_QUEUE_WORKERS how many lines are
+// shipped concurrently.
+func newQueue(emitter logging.Emitter, handle func(source logging.Source, received time.Time, line string)) *logging.Queue {
+	size, _ := config.IntSetting("HABERDASHER_QUEUE_SIZE", 1)
+	workers, _ := config.IntSetting("HABERDASHER_QUEUE_WORKERS", 1)
+	queue := logging.NewQueue(size, workers, handle)
+
+	threshold, _ := config.DurationSetting("HABERDASHER_BACKPRESSURE_THRESHOLD")
+	if level, exists := config.Setting("HABERDASHER_LOG_LEVEL"); exists {
+		if err := queue.DropBelow(strings.ToLower(level)); err != nil {
+			log.Fatal("HABERDASHER_LOG_LEVEL: ", err)
+		}
+	}
+	reporter := &backpressureReporter{emitter: emitter}
+	queue.ReportStalls(threshold, reporter.stalled)
+	return queue
+}
+
+// Once the queue is full we stop reading from the child, and once the pipe's
+// buffer fills the child blocks writing to stderr: logging is throttling the
+// application. backpressureReporter makes that visible, with metrics for every
+// stall and an event at most once a minute summarizing them.
+type backpressureReporter struct {
+	emitter  logging.Emitter
+	lock     sync.Mutex
+	reported time.Time
+	stalls   int
+	total    time.Duration
+	longest  time.Duration
+}
+
+func (r *backpressureReporter) stalled(stalled time.Duration) {
+	backpressureStalls.Inc()
+	backpressureMilliseconds.Add(uint64(stalled / time.Millisecond))
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.stalls++
+	r.total += stalled
+	if stalled > r.longest {
+		r.longest = stalled
+	}
+	if logging.Clock.Since(r.reported) < backpressureReportInterval {
+		return
+	}
+	message := fmt.Sprintf("Stopped reading from the child %d times for %s in total (longest %s) because the emitter is falling behind",
+		r.stalls, r.total.Round(time.Millisecond), r.longest.Round(time.Millisecond))
+	go logging.EmitEvent(r.emitter, "backpressure", message)
+	r.reported = logging.Clock.Now()
+	r.stalls, r.total, r.longest = 0, 0, 0
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// A raw recording is this header, then a frame for every read from the
+// child's streams: the nanoseconds since recording started, the stream, and
+// the length of what was read (big-endian, 8, 1, and 4 bytes), then the bytes
+// themselves
+const rawRecordingHeader = "haberdasher-raw-recording 1\n"
+
+// The largest frame replay-raw will believe, so a corrupt length can't make it
+// allocate the world
+const maxRawFrame = 64 << 20
+
+const (
+	rawStderr byte = 0
+	rawStdout byte = 1
+)
+
+// A rawRecorder writes everything read from the child to a file, with when it
+// was read, for replay-raw
+type rawRecorder struct {
+	lock    sync.Mutex
+	file    *os.File
+	started time.Time
+	failed  bool
+}
+
+// openRawRecording starts a recording for HABERDASHER_RECORD_RAW, replacing
+// any earlier one. It holds the child's output before redaction, so it's
+// only readable by us.
+func openRawRecording(path string) *rawRecorder {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err == nil {
+		_, err = file.WriteString(rawRecordingHeader)
+	}
+	if err != nil {
+		log.Fatal("Couldn't open HABERDASHER_RECORD_RAW: ", err)
+	}
+	log.Println("Recording the child's raw output to", path)
+	return &rawRecorder{file: file, started: logging.Clock.Now()}
+}
+
+// wrap records everything read through reader as coming from stream
+func (r *rawRecorder) wrap(stream byte, reader io.Reader) io.Reader {
+	return &recordingReader{recorder: r, stream: stream, reader: reader}
+}
+
+func (r *rawRecorder) record(stream byte, data []byte) {
+	frame := make([]byte, 13, 13+len(data))
+	binary.BigEndian.PutUint64(frame, uint64(logging.Clock.Since(r.started)))
+	frame[8] = stream
+	binary.BigEndian.PutUint32(frame[9:], uint32(len(data)))
+	frame = append(frame, data...)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.failed {
+		return
+	}
+	if _, err := r.file.Write(frame); err != nil {
+		// A broken recording mustn't get in the way of shipping logs
+		log.Println("Error recording raw output, no longer recording:", err)
+		r.failed = true
+	}
+}
+
+// Close finishes the recording
+func (r *rawRecorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.failed = true
+	return r.file.Close()
+}
+
+type recordingReader struct {
+	recorder *rawRecorder
+	stream   byte
+	reader   io.Reader
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.recorder.record(r.stream, p[:n])
+	}
+	return n, err
+}
+
+// parseReplaySpeed reads replay-raw's speed: a multiple of the original, like
+// 10, or max to replay as fast as the pipeline takes it
+func parseReplaySpeed(arg string) (float64, error) {
+	if arg == "max" {
+		return 0, nil
+	}
+	speed, err := strconv.ParseFloat(arg, 64)
+	if err != nil || speed <= 0 {
+		return 0, errors.New("the speed must be a positive multiple, like 10, or max")
+	}
+	return speed, nil
+}
+
+// replay runs replay-raw: the emitter is set up, and virtual sources loaded,
+// as they would be for the child, then the recording named by args[0] is
+// replayed at the speed in args[1], by default the original
+func replay(child *supervisor, virtualSources []*virtualSource, args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		log.Fatal("Usage: haberdasher replay-raw <recording> [speed]")
+	}
+	speed := 1.0
+	if len(args) == 2 {
+		var err error
+		if speed, err = parseReplaySpeed(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	}
+	setUp(child.emitter)
+	routeEvents()
+	child.virtual = loadVirtualSources(child.emitter, virtualSources)
+	child.readiness = newReadinessGate()
+
+	err := replayRaw(child, args[0], speed)
+	child.queue.Close()
+	if cleanupErr := child.emitter.Cleanup(); cleanupErr != nil {
+		log.Println("Error cleaning up emitter:", cleanupErr)
+	}
+	if err != nil {
+		log.Println("Error replaying", args[0]+":", err)
+		return 1
+	}
+	return 0
+}
+
+// replayRaw feeds a raw recording through the child's pipeline, as if the
+// child were writing it again, with the same reads at the same pace divided
+// by speed, or as fast as possible if speed is 0. Stdout frames go through
+// the pipeline if it captures stdout, and to our stdout if not.
+func replayRaw(child *supervisor, path string, speed float64) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	header := make([]byte, len(rawRecordingHeader))
+	if _, err := io.ReadFull(reader, header); err != nil || string(header) != rawRecordingHeader {
+		return fmt.Errorf("%s isn't a raw recording", path)
+	}
+
+	stderrReader, stderrWriter := io.Pipe()
+	var stdoutReader io.Reader
+	var stdoutWriter io.Writer = os.Stdout
+	if child.captureStdout {
+		pipeReader, pipeWriter := io.Pipe()
+		stdoutReader, stdoutWriter = pipeReader, pipeWriter
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		err := replayFrames(reader, speed, stderrWriter, stdoutWriter)
+		stderrWriter.Close()
+		if closer, ok := stdoutWriter.(*io.PipeWriter); ok {
+			closer.Close()
+		}
+		result <- err
+	}()
+	child.consume(stderrReader, stdoutReader)
+	return <-result
+}
+
+func replayFrames(reader *bufio.Reader, speed float64, stderr io.Writer, stdout io.Writer) error {
+	started := logging.Clock.Now()
+	frames := 0
+	header := make([]byte, 13)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			log.Println("Replayed", frames, "reads in", logging.Clock.Since(started).Round(time.Millisecond))
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("the recording is truncated after %d reads", frames)
+		}
+		at := time.Duration(binary.BigEndian.Uint64(header))
+		length := binary.BigEndian.Uint32(header[9:])
+		if length > maxRawFrame {
+			return fmt.Errorf("the recording is corrupt after %d reads", frames)
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("the recording is truncated after %d reads", frames)
+		}
+		if speed > 0 {
+			logging.Clock.Sleep(started.Add(time.Duration(float64(at) / speed)).Sub(logging.Clock.Now()))
+		}
+		destination := stderr
+		if header[8] == rawStdout {
+			destination = stdout
+		}
+		if _, err := destination.Write(data); err != nil {
+			return err
+		}
+		frames++
+	}
+}
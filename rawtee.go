@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// openRawTee opens the destination for HABERDASHER_RAW_TEE: a file path,
+// tcp://host:port, or unix:///path/to/socket
+func openRawTee(destination string) io.WriteCloser {
+	var tee io.WriteCloser
+	var err error
+	switch {
+	case strings.HasPrefix(destination, "tcp://"):
+		tee, err = net.Dial("tcp", strings.TrimPrefix(destination, "tcp://"))
+	case strings.HasPrefix(destination, "unix://"):
+		tee, err = net.Dial("unix", strings.TrimPrefix(destination, "unix://"))
+	default:
+		// splice can't write to files opened with O_APPEND, so seek instead
+		var file *os.File
+		if file, err = os.OpenFile(destination, os.O_WRONLY|os.O_CREATE, 0644); err == nil {
+			_, err = file.Seek(0, io.SeekEnd)
+		}
+		tee = file
+	}
+	if err != nil {
+		log.Fatal("Couldn't open HABERDASHER_RAW_TEE: ", err)
+	}
+	return tee
+}
+
+// copyRaw forwards everything the child writes, as it's written, without
+// looking at it. Where the kernel can move the data itself, it doesn't pass
+// through our memory at all.
+func copyRaw(dst io.Writer, src io.Reader) {
+	srcConn, srcOK := src.(syscall.Conn)
+	dstConn, dstOK := dst.(syscall.Conn)
+	if srcOK && dstOK {
+		handled, err := spliceAll(dstConn, srcConn)
+		if err != nil {
+			log.Println("Error forwarding stderr:", err)
+		}
+		if handled {
+			return
+		}
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		log.Println("Error forwarding stderr:", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const spliceChunk = 1 << 20
+
+// spliceAll moves data from a pipe to a file or socket with splice(2), until
+// the pipe is closed. It reports whether splice could be used at all.
+func spliceAll(dst, src syscall.Conn) (bool, error) {
+	srcRaw, err := src.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+	dstRaw, err := dst.SyscallConn()
+	if err != nil {
+		return false, nil
+	}
+
+	moved := false
+	for {
+		var n int64
+		var spliceErr, waitErr error
+		readErr := srcRaw.Read(func(srcFd uintptr) bool {
+			waitErr = dstRaw.Write(func(dstFd uintptr) bool {
+				n, spliceErr = syscall.Splice(int(srcFd), nil, int(dstFd), nil, spliceChunk, spliceFlags)
+				// With data waiting, EAGAIN means the destination is full
+				return spliceErr != syscall.EAGAIN || pipeEmpty(srcFd)
+			})
+			// Otherwise it means there's nothing to move yet
+			return spliceErr != syscall.EAGAIN
+		})
+		switch {
+		case readErr != nil:
+			return true, readErr
+		case waitErr != nil:
+			return true, waitErr
+		case spliceErr == syscall.EINVAL && !moved:
+			return false, nil
+		case spliceErr != nil:
+			return true, spliceErr
+		case n == 0:
+			return true, nil
+		}
+		moved = true
+	}
+}
+
+const spliceFlags = 0x1 | 0x2 // SPLICE_F_MOVE | SPLICE_F_NONBLOCK
+
+func pipeEmpty(fd uintptr) bool {
+	var waiting int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCINQ, uintptr(unsafe.Pointer(&waiting)))
+	return errno != 0 || waiting == 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "syscall"
+
+func spliceAll(dst, src syscall.Conn) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/admin"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+const readinessCheckInterval = 2 * time.Second
+
+// A readinessGate decides when the pod is ready for other containers to rely
+// on: the emitter's backend must be reachable, and the child must have met its
+// startup criteria. HABERDASHER_READY_PATTERN is a regex one of the child's
+// log lines must match (e.g. "Listening on"), and HABERDASHER_READY_DELAY is
+// how long the child must have been running. Once ready, the gate writes
+// HABERDASHER_READY_FILE if set, and the admin listener's /ready endpoint
+// starts returning 200.
+type readinessGate struct {
+	pattern *regexp.Regexp
+	delay   time.Duration
+	file    string
+
+	lock       sync.Mutex
+	started    time.Time
+	matched    bool
+	ready      bool
+	configured bool
+}
+
+func newReadinessGate() *readinessGate {
+	g := &readinessGate{}
+	if pattern, exists := config.Setting("HABERDASHER_READY_PATTERN"); exists {
+		var err error
+		if g.pattern, err = regexp.Compile(pattern); err != nil {
+			log.Fatal("HABERDASHER_READY_PATTERN must be a valid regular expression: ", err)
+		}
+		g.configured = true
+	}
+	if delay, exists := config.DurationOrZeroSetting("HABERDASHER_READY_DELAY", "to be ready as soon as the child starts"); exists {
+		g.delay = delay
+		g.configured = true
+	}
+	if file, exists := config.Setting("HABERDASHER_READY_FILE"); exists {
+		g.file = file
+		g.configured = true
+		// A sentinel left over from a previous run would say we're ready early
+		os.Remove(file)
+	}
+	admin.HandlePublic("/ready", g)
+	return g
+}
+
+// childStarted starts the clock on HABERDASHER_READY_DELAY
+func (g *readinessGate) childStarted() {
+	g.lock.Lock()
+	g.started = logging.Clock.Now()
+	g.lock.Unlock()
+}
+
+// observe checks a line logged by the child against HABERDASHER_READY_PATTERN
+func (g *readinessGate) observe(line string) {
+	if g.pattern == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if !g.matched && g.pattern.MatchString(line) {
+		g.matched = true
+	}
+}
+
+func (g *readinessGate) childReady() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.started.IsZero() {
+		return false
+	}
+	if g.pattern != nil && !g.matched {
+		return false
+	}
+	return logging.Clock.Since(g.started) >= g.delay
+}
+
+// run waits for the child's startup criteria and a healthy emitter, then
+// marks us ready. Readiness is only ever gained, since it exists to order
+// startup.
+func (g *readinessGate) run(emitter logging.Emitter) {
+	for {
+		if g.childReady() {
+			if err := logging.CheckHealth(emitter); err != nil {
+				log.Println("Emitter is not healthy yet:", err)
+			} else {
+				break
+			}
+		}
+		logging.Clock.Sleep(readinessCheckInterval)
+	}
+
+	g.lock.Lock()
+	g.ready = true
+	g.lock.Unlock()
+	if g.configured {
+		log.Println("Child and emitter are ready")
+	}
+	if g.file != "" {
+		if err := ioutil.WriteFile(g.file, []byte(logging.Clock.Now().Format(time.RFC3339)+"\n"), 0644); err != nil {
+			log.Println("Error writing", g.file+":", err)
+		}
+	}
+}
+
+// ServeHTTP answers readiness probes
+func (g *readinessGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.lock.Lock()
+	ready := g.ready
+	g.lock.Unlock()
+	if !ready {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ready\n"))
+}
@@ -0,0 +1,91 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// The reaper can collect the child before runOnce's Wait does, so it keeps
+// the child's exit status for reapedStatus. reapLock is held while the child
+// is started, so it can't be collected before we know its pid.
+var reapLock sync.Mutex
+var reapChild int
+var reapChildStatus *syscall.WaitStatus
+
+// startReaper reaps zombie processes when we're standing in for init.
+//
+// Outside of PID 1, such as a sidecar in a shared PID namespace, reaping
+// would compete with the real init for exit statuses, so by default we only
+// reap as PID 1. HABERDASHER_REAPER=on reaps anyway, and
+// HABERDASHER_REAPER=off never reaps. It reports whether it's reaping.
+func startReaper() bool {
+	switch mode, _ := config.Setting("HABERDASHER_REAPER"); mode {
+	case "auto":
+		if os.Getpid() != 1 {
+			return false
+		}
+		go reap()
+	case "on":
+		go reap()
+	case "off":
+		return false
+	default:
+		log.Fatal("HABERDASHER_REAPER must be auto, on, or off")
+	}
+	return true
+}
+
+// reap collects every child which exits, keeping the status of the one we're
+// supervising
+func reap() {
+	exited := make(chan os.Signal, 1)
+	signal.Notify(exited, syscall.SIGCHLD)
+	for range exited {
+		reapLock.Lock()
+		for {
+			var status syscall.WaitStatus
+			pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || pid <= 0 {
+				break
+			}
+			if pid == reapChild {
+				reapChildStatus = &status
+			}
+		}
+		reapLock.Unlock()
+	}
+}
+
+// startChild starts the child so that the reaper knows it's ours
+func startChild(subcmd *exec.Cmd) error {
+	reapLock.Lock()
+	defer reapLock.Unlock()
+	if err := subcmd.Start(); err != nil {
+		return err
+	}
+	reapChild, reapChildStatus = subcmd.Process.Pid, nil
+	return nil
+}
+
+// reapedStatus returns the exit status of the child with pid, if the reaper
+// collected it
+func reapedStatus(pid int) (syscall.WaitStatus, bool) {
+	reapLock.Lock()
+	defer reapLock.Unlock()
+	if pid != reapChild || reapChildStatus == nil {
+		return 0, false
+	}
+	return *reapChildStatus, true
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+	"syscall"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// startReaper never reaps on Windows, where there are no zombies to collect
+func startReaper() bool {
+	if mode, _ := config.Setting("HABERDASHER_REAPER"); mode == "on" {
+		log.Fatal("HABERDASHER_REAPER isn't supported on Windows")
+	}
+	return false
+}
+
+func startChild(subcmd *exec.Cmd) error {
+	return subcmd.Start()
+}
+
+func reapedStatus(pid int) (syscall.WaitStatus, bool) {
+	return syscall.WaitStatus{}, false
+}
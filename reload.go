@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/RedHatInsights/haberdasher/admin"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// How many recent lines a policy reload is judged against
+const reloadSampleSize = 1000
+
+// How many of the lines a reload would newly drop its report shows
+const reloadExamples = 5
+
+// A lineSample remembers the most recent lines from the child
+type lineSample struct {
+	lock  sync.Mutex
+	lines []string
+	next  int
+}
+
+func (s *lineSample) add(line string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.lines) < reloadSampleSize {
+		s.lines = append(s.lines, line)
+		return
+	}
+	s.lines[s.next] = line
+	s.next = (s.next + 1) % reloadSampleSize
+}
+
+func (s *lineSample) snapshot() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+// A reloadReport describes what a policy reload would do to recent traffic
+type reloadReport struct {
+	Applied       bool     `json:"applied"`
+	Forced        bool     `json:"forced,omitempty"`
+	Reason        string   `json:"reason,omitempty"`
+	SampledLines  int      `json:"sampled_lines"`
+	DroppedBefore float64  `json:"dropped_before"`
+	DroppedAfter  float64  `json:"dropped_after"`
+	NewlyDropped  []string `json:"newly_dropped_examples,omitempty"`
+}
+
+// A policyReloader re-reads the policy fragments when asked to over the admin
+// API, refusing changes which would suddenly drop much more traffic, such as
+// an over-broad exclude pattern, unless they're forced
+type policyReloader struct {
+	child          *supervisor
+	defaultEmitter logging.Emitter
+	maxDrop        float64
+
+	lock   sync.Mutex
+	staged *reloadReport
+}
+
+// handlePolicyReloads serves the reload API when there are policy fragments
+// to reload:
+//
+//	POST /policies/reload              apply the fragments unless they'd drop too much
+//	POST /policies/reload?force=true   apply them anyway
+//	GET  /policies/staged              the report on the last refused reload
+//
+// HABERDASHER_RELOAD_MAX_DROP is how much larger a fraction of the recent
+// lines a reload may drop, by default 0.2.
+func handlePolicyReloads(child *supervisor, defaultEmitter logging.Emitter) {
+	if _, exists := config.Setting("HABERDASHER_POLICY_DIR"); !exists {
+		return
+	}
+	r := &policyReloader{child: child, defaultEmitter: defaultEmitter}
+	fromEnv, _ := config.Setting("HABERDASHER_RELOAD_MAX_DROP")
+	var err error
+	if r.maxDrop, err = strconv.ParseFloat(fromEnv, 64); err != nil || r.maxDrop < 0 || r.maxDrop > 1 {
+		log.Fatal("HABERDASHER_RELOAD_MAX_DROP must be a fraction between 0 and 1")
+	}
+	child.sample = &lineSample{}
+
+	admin.HandleControl("/policies/reload", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := r.reload(req.URL.Query().Get("force") == "true")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Applied {
+			w.WriteHeader(http.StatusConflict)
+		}
+		json.NewEncoder(w).Encode(report)
+	}))
+	admin.Handle("/policies/staged", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.lock.Lock()
+		staged := r.staged
+		r.lock.Unlock()
+		if staged == nil {
+			http.Error(w, "no reload is staged", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(staged)
+	}))
+}
+
+// reload reads the policies and applies them, if they pass the guard or are
+// forced. A refused reload is staged with its report until the next one.
+func (r *policyReloader) reload(force bool) (*reloadReport, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	redactions, sources, err := readPolicies()
+	if err != nil {
+		return nil, err
+	}
+	if err := compileVirtualSources(sources); err != nil {
+		return nil, fmt.Errorf("HABERDASHER_POLICY_DIR: %v", err)
+	}
+
+	r.child.policyLock.RLock()
+	current := r.child.virtual
+	r.child.policyLock.RUnlock()
+	report := r.evaluate(current, sources)
+	report.Forced = force
+	if report.DroppedAfter-report.DroppedBefore > r.maxDrop && !force {
+		report.Reason = fmt.Sprintf("the reload would drop %.0f%% of recent lines, up from %.0f%%; force it to apply it anyway",
+			report.DroppedAfter*100, report.DroppedBefore*100)
+		r.staged = report
+		logging.EmitEvent(r.defaultEmitter, "policy-reload-refused", "Refused to reload policies: "+report.Reason)
+		return report, nil
+	}
+
+	if err := routeVirtualSources(r.defaultEmitter, sources); err != nil {
+		return nil, fmt.Errorf("HABERDASHER_POLICY_DIR: %v", err)
+	}
+	if err := logging.SetRedactions(redactions); err != nil {
+		return nil, fmt.Errorf("HABERDASHER_POLICY_DIR: %v", err)
+	}
+	r.child.policyLock.Lock()
+	r.child.virtual = sources
+	r.child.policyLock.Unlock()
+	r.staged = nil
+	report.Applied = true
+	logging.EmitEvent(r.defaultEmitter, "policy-reload", fmt.Sprintf(
+		"Reloaded policies: %d redactions and %d virtual sources, dropping %.0f%% of recent lines, previously %.0f%%",
+		len(redactions), len(sources), report.DroppedAfter*100, report.DroppedBefore*100))
+	emitInventory(r.child, r.defaultEmitter)
+	return report, nil
+}
+
+// evaluate compares how much of the recent traffic the current and reloaded
+// virtual sources drop
+func (r *policyReloader) evaluate(current []*virtualSource, reloaded []*virtualSource) *reloadReport {
+	lines := r.child.sample.snapshot()
+	report := &reloadReport{SampledLines: len(lines)}
+	if len(lines) == 0 {
+		return report
+	}
+	before, after := 0, 0
+	for _, line := range lines {
+		droppedBefore, droppedAfter := drops(current, line), drops(reloaded, line)
+		if droppedBefore {
+			before++
+		}
+		if droppedAfter {
+			after++
+			if !droppedBefore && len(report.NewlyDropped) < reloadExamples {
+				report.NewlyDropped = append(report.NewlyDropped, logging.Redact(line))
+			}
+		}
+	}
+	report.DroppedBefore = float64(before) / float64(len(lines))
+	report.DroppedAfter = float64(after) / float64(len(lines))
+	return report
+}
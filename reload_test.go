@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// A recordingEmitter keeps the events it's sent
+type recordingEmitter struct {
+	lock   sync.Mutex
+	events []logging.Message
+}
+
+func (r *recordingEmitter) Setup() {}
+
+func (r *recordingEmitter) HandleLogMessage(message interface{}) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if m, ok := message.(logging.Message); ok && m.EventAction != "" {
+		r.events = append(r.events, m)
+	}
+	return nil
+}
+
+func (r *recordingEmitter) Cleanup() error { return nil }
+
+func (r *recordingEmitter) actions() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var actions []string
+	for _, m := range r.events {
+		actions = append(actions, m.EventAction)
+	}
+	return actions
+}
+
+// newReloader returns a reloader for a child which has logged lines, with
+// the policy fragments in HABERDASHER_POLICY_DIR already applied
+func newReloader(t *testing.T, lines ...string) (*policyReloader, *recordingEmitter) {
+	_, sources, err := readPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compileVirtualSources(sources); err != nil {
+		t.Fatal(err)
+	}
+	child := &supervisor{virtual: sources, sample: &lineSample{}}
+	for _, line := range lines {
+		child.sample.add(line)
+	}
+	emitter := &recordingEmitter{}
+	return &policyReloader{child: child, defaultEmitter: emitter, maxDrop: 0.2}, emitter
+}
+
+// traffic is ten lines, two of them health checks
+func traffic() []string {
+	lines := []string{"GET /healthz 200", "GET /healthz 200"}
+	for i := 0; i < 6; i++ {
+		lines = append(lines, fmt.Sprintf("GET /orders/%d paid with 411111111111111%d", i, i))
+	}
+	return append(lines, "POST /orders 201", "connection reset by peer")
+}
+
+func writeFragment(t *testing.T, dir, name, fragment string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(fragment), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A reload which would suddenly drop much more of the recent traffic is
+// staged rather than applied, until it's forced
+func TestReloadRefusesDroppingMore(t *testing.T) {
+	dir := withPolicies(t, []logging.Redaction{{Name: "card", Match: "[0-9]{16}", Replace: "[card]"}}, map[string]string{
+		"10-org.json": `{"virtual_sources": [{"name": "health", "match": "/healthz", "emitter": "drop"}]}`,
+	})
+	r, emitter := newReloader(t, traffic()...)
+	current := r.child.virtual
+	writeFragment(t, dir, "20-team.json", `{"virtual_sources": [{"name": "orders", "match": "^GET /orders", "emitter": "drop"}]}`)
+
+	report, err := r.reload(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Applied || report.Forced || report.SampledLines != 10 || report.DroppedBefore != 0.2 || report.DroppedAfter != 0.8 {
+		t.Errorf("reported %+v", report)
+	}
+	if want := "the reload would drop 80% of recent lines, up from 20%"; !strings.Contains(report.Reason, want) {
+		t.Errorf("refused because %q, want %q", report.Reason, want)
+	}
+	// The examples are redacted, like everything else shipped
+	if len(report.NewlyDropped) != reloadExamples || report.NewlyDropped[0] != "GET /orders/0 paid with [card]" {
+		t.Errorf("newly dropped %q", report.NewlyDropped)
+	}
+	if r.staged != report || !reflect.DeepEqual(r.child.virtual, current) {
+		t.Error("the refused reload wasn't just staged")
+	}
+
+	report, err = r.reload(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Applied || !report.Forced || report.Reason != "" {
+		t.Errorf("forcing it reported %+v", report)
+	}
+	if r.staged != nil || len(r.child.virtual) != 2 || !drops(r.child.virtual, "GET /orders/9") {
+		t.Error("forcing it didn't apply it")
+	}
+	if want := []string{"policy-reload-refused", "policy-reload", "inventory"}; !reflect.DeepEqual(emitter.actions(), want) {
+		t.Errorf("emitted %q, want %q", emitter.actions(), want)
+	}
+}
+
+func TestReloadApplies(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+		after    float64
+	}{
+		{"dropping a little more", `{"virtual_sources": [{"name": "resets", "match": "connection reset", "emitter": "drop"}]}`, 0.3},
+		{"dropping less", `{"virtual_sources": [{"name": "health", "match": "/healthz"}]}`, 0},
+		{"only redacting", `{"redactions": [{"name": "card", "match": "[0-9]{16}"}]}`, 0.2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := withPolicies(t, nil, map[string]string{
+				"10-org.json": `{"virtual_sources": [{"name": "health", "match": "/healthz", "emitter": "drop"}]}`,
+			})
+			r, _ := newReloader(t, traffic()...)
+			writeFragment(t, dir, "20-team.json", test.fragment)
+			report, err := r.reload(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !report.Applied || report.DroppedBefore != 0.2 || report.DroppedAfter != test.after {
+				t.Errorf("reported %+v", report)
+			}
+		})
+	}
+}
+
+// Nothing to judge a reload against doesn't stop it
+func TestReloadWithoutTraffic(t *testing.T) {
+	dir := withPolicies(t, nil, map[string]string{"10-org.json": `{}`})
+	r, _ := newReloader(t)
+	writeFragment(t, dir, "20-team.json", `{"virtual_sources": [{"name": "everything", "match": "", "emitter": "drop"}]}`)
+	if report, err := r.reload(false); err != nil || !report.Applied || report.SampledLines != 0 {
+		t.Errorf("reload() = %+v, %v", report, err)
+	}
+}
+
+func TestReloadErrors(t *testing.T) {
+	dir := withPolicies(t, nil, map[string]string{"10-org.json": `{}`})
+	r, _ := newReloader(t, traffic()...)
+	writeFragment(t, dir, "20-team.json", `{"virtual_sources": [{"name": "broken", "match": "(unclosed"}]}`)
+	if _, err := r.reload(true); err == nil || !strings.Contains(err.Error(), "broken: error parsing regexp") {
+		t.Errorf("returned %v", err)
+	}
+	if len(r.child.virtual) != 0 {
+		t.Error("applied a broken fragment")
+	}
+}
+
+// The sample keeps the most recent lines
+func TestLineSample(t *testing.T) {
+	s := &lineSample{}
+	for i := 0; i < reloadSampleSize+5; i++ {
+		s.add(fmt.Sprint(i))
+	}
+	lines := s.snapshot()
+	if len(lines) != reloadSampleSize {
+		t.Fatalf("sampled %d lines", len(lines))
+	}
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		seen[line] = true
+	}
+	if seen["4"] || !seen["5"] || !seen[fmt.Sprint(reloadSampleSize+4)] {
+		t.Error("didn't keep the most recent lines")
+	}
+	var unsampled *lineSample
+	unsampled.add("ignored")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// A restartPolicy says whether the child is started again when it exits of
+// its own accord, and how long to wait first
+type restartPolicy struct {
+	// never, on-failure, or always
+	mode string
+	// The first wait, doubling with every consecutive restart up to
+	// maxBackoff
+	backoff    time.Duration
+	maxBackoff time.Duration
+	// How many consecutive restarts to make before giving up, or 0 for no
+	// limit
+	maxRetries int
+}
+
+// restartPolicyFromEnv reads HABERDASHER_RESTART, never by default, and the
+// backoff from HABERDASHER_RESTART_BACKOFF, HABERDASHER_RESTART_MAX_BACKOFF,
+// and HABERDASHER_RESTART_MAX_RETRIES
+func restartPolicyFromEnv() restartPolicy {
+	var policy restartPolicy
+	switch policy.mode, _ = config.Setting("HABERDASHER_RESTART"); policy.mode {
+	case "never", "on-failure", "always":
+	default:
+		log.Fatal("HABERDASHER_RESTART must be never, on-failure, or always")
+	}
+	policy.backoff, _ = config.DurationSetting("HABERDASHER_RESTART_BACKOFF")
+	policy.maxBackoff, _ = config.DurationSetting("HABERDASHER_RESTART_MAX_BACKOFF")
+	if policy.maxBackoff < policy.backoff {
+		log.Fatal("HABERDASHER_RESTART_MAX_BACKOFF can't be less than HABERDASHER_RESTART_BACKOFF")
+	}
+	policy.maxRetries, _ = config.IntSetting("HABERDASHER_RESTART_MAX_RETRIES", 0)
+	return policy
+}
+
+// applies reports whether a child exiting with code should be restarted.
+// A child whose status was lost counts as having failed.
+func (p restartPolicy) applies(code int) bool {
+	switch p.mode {
+	case "always":
+		return true
+	case "on-failure":
+		return code != 0
+	}
+	return false
+}
+
+// delay is how long to wait before the restart following failures
+// consecutive ones
+func (p restartPolicy) delay(failures int) time.Duration {
+	delay := p.backoff
+	for i := 0; i < failures && delay < p.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+func TestRestartPolicyApplies(t *testing.T) {
+	tests := []struct {
+		mode string
+		code int
+		want bool
+	}{
+		{"never", 1, false},
+		{"on-failure", 0, false},
+		{"on-failure", 3, true},
+		{"on-failure", 143, true},
+		// The exit status was lost
+		{"on-failure", -1, true},
+		{"always", 0, true},
+	}
+	for _, test := range tests {
+		if got := (restartPolicy{mode: test.mode}).applies(test.code); got != test.want {
+			t.Errorf("%s applies to exit code %d: %v, want %v", test.mode, test.code, got, test.want)
+		}
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	p := restartPolicy{backoff: time.Second, maxBackoff: 30 * time.Second}
+	want := []time.Duration{1, 2, 4, 8, 16, 30, 30, 30}
+	for failures, seconds := range want {
+		if got := p.delay(failures); got != seconds*time.Second {
+			t.Errorf("delay after %d failures is %s, want %s", failures, got, seconds*time.Second)
+		}
+	}
+	// A huge number of failures can't overflow the doubling
+	if got := p.delay(1 << 20); got != 30*time.Second {
+		t.Errorf("delay after many failures is %s", got)
+	}
+}
+
+func TestRestartPolicyFromEnv(t *testing.T) {
+	if p := restartPolicyFromEnv(); p != (restartPolicy{mode: "never", backoff: time.Second, maxBackoff: time.Minute}) {
+		t.Errorf("defaults to %+v", p)
+	}
+	t.Setenv("HABERDASHER_RESTART", "on-failure")
+	t.Setenv("HABERDASHER_RESTART_BACKOFF", "500ms")
+	t.Setenv("HABERDASHER_RESTART_MAX_BACKOFF", "10s")
+	t.Setenv("HABERDASHER_RESTART_MAX_RETRIES", "5")
+	if p := restartPolicyFromEnv(); p != (restartPolicy{mode: "on-failure", backoff: 500 * time.Millisecond, maxBackoff: 10 * time.Second, maxRetries: 5}) {
+		t.Errorf("read %+v", p)
+	}
+}
+
+// restartingChild supervises a shell command under policy, on a fake clock
+func restartingChild(t *testing.T, command string, policy restartPolicy) (*supervisor, *recordingEmitter, *clock.Fake) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	original := logging.Clock
+	logging.Clock = fake
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() {
+		logging.Clock = original
+		log.SetOutput(os.Stderr)
+	})
+	emitter := &recordingEmitter{}
+	return &supervisor{
+		argv:      []string{"sh", "-c", command},
+		emitter:   emitter,
+		readiness: &readinessGate{},
+		rawTee:    ioutil.Discard,
+		restart:   policy,
+		stopping:  make(chan struct{}),
+	}, emitter, fake
+}
+
+// waitForBackoff waits until the supervisor is waiting out its backoff
+func waitForBackoff(t *testing.T, fake *clock.Fake) {
+	for deadline := time.Now().Add(10 * time.Second); fake.Waiters() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the supervisor isn't waiting to restart the child")
+		}
+	}
+}
+
+// A failing child is restarted after waits doubling up to the maximum, until
+// the supervisor gives up
+func TestRestartBackoff(t *testing.T) {
+	s, emitter, fake := restartingChild(t, "exit 3", restartPolicy{mode: "on-failure", backoff: time.Second, maxBackoff: 2 * time.Second, maxRetries: 3})
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second, 2 * time.Second} {
+		waitForBackoff(t, fake)
+		fake.Advance(delay - time.Millisecond)
+		if fake.Waiters() == 0 {
+			t.Fatalf("restarted before waiting %s", delay)
+		}
+		fake.Advance(time.Millisecond)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the supervisor didn't give up")
+	}
+
+	if want := []string{"child-restarted", "child-restarted", "child-restarted", "child-restart-limit"}; !reflect.DeepEqual(emitter.actions(), want) {
+		t.Fatalf("emitted %q, want %q", emitter.actions(), want)
+	}
+	for i, delay := range []string{"1.000", "2.000", "2.000"} {
+		labels := emitter.events[i].Labels
+		if labels["haberdasher_restart_attempt"] != strconv.Itoa(i+1) || labels["haberdasher_restart_delay_seconds"] != delay || labels["haberdasher_exit_code"] != "3" {
+			t.Errorf("restart %d labelled %v", i+1, labels)
+		}
+	}
+	if got, want := emitter.events[3].Message, "sh exited (exit status 3) after 3 restarts in a row, giving up"; got != want {
+		t.Errorf("gave up with %q, want %q", got, want)
+	}
+	if s.restarts != 3 || s.exitCode() != 3 {
+		t.Errorf("restarted %d times, exiting with %d", s.restarts, s.exitCode())
+	}
+}
+
+// Stopping doesn't wait for the backoff to end
+func TestRestartBackoffStopped(t *testing.T) {
+	s, _, fake := restartingChild(t, "exit 0", restartPolicy{mode: "always", backoff: time.Minute, maxBackoff: time.Minute})
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+	waitForBackoff(t, fake)
+	s.stopRestarting()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("stopping waited out the backoff")
+	}
+	if s.restarts != 0 {
+		t.Errorf("restarted %d times", s.restarts)
+	}
+}
+
+func TestRestartNotApplying(t *testing.T) {
+	s, emitter, _ := restartingChild(t, "exit 0", restartPolicy{mode: "on-failure", backoff: time.Second, maxBackoff: time.Second})
+	s.run()
+	if len(emitter.actions()) != 0 || s.restarts != 0 {
+		t.Errorf("restarted %d times, emitting %q", s.restarts, emitter.actions())
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"math"
+	"syscall"
+)
+
+// FreeBSD's limits are signed, with RLIM_INFINITY the largest of them
+func newRlimit(soft uint64, hard uint64) syscall.Rlimit {
+	clamp := func(value uint64) int64 {
+		if value > math.MaxInt64 {
+			return math.MaxInt64
+		}
+		return int64(value)
+	}
+	return syscall.Rlimit{Cur: clamp(soft), Max: clamp(hard)}
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !freebsd
+// +build !windows,!freebsd
+
+package main
+
+import "syscall"
+
+func newRlimit(soft uint64, hard uint64) syscall.Rlimit {
+	return syscall.Rlimit{Cur: soft, Max: hard}
+}
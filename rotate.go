@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"syscall"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+var signalsByName = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+}
+
+// parseSignal accepts signal names with or without the SIG prefix
+func parseSignal(name string) (syscall.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "SIG")
+	if sig, ok := signalsByName[name]; ok {
+		return sig, nil
+	}
+	if sig, ok := platformSignalsByName[name]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", name)
+}
+
+// Legacy applications which write their own log files often reopen them when
+// they receive a signal. If HABERDASHER_ROTATE_INTERVAL is set (e.g. "1h"),
+// send HABERDASHER_ROTATE_SIGNAL (SIGUSR1 by default) to the child at every
+// boundary of that interval. The file emitter rotates on the same boundaries,
+// unless HABERDASHER_FILE_MAX_AGE gives it its own.
+func startRotationSignals(child *supervisor) {
+	interval, exists := config.DurationSetting("HABERDASHER_ROTATE_INTERVAL")
+	if !exists {
+		return
+	}
+	signalName, _ := config.Setting("HABERDASHER_ROTATE_SIGNAL")
+	rotateSignal, err := parseSignal(signalName)
+	if err != nil {
+		log.Fatal("HABERDASHER_ROTATE_SIGNAL: ", err)
+	}
+	log.Println("Sending", rotateSignal, "to the child every", interval)
+
+	go func() {
+		for {
+			now := logging.Clock.Now()
+			logging.Clock.Sleep(logging.NextRotation(now, interval).Sub(now))
+			if child.Pid() <= 0 {
+				continue
+			}
+			log.Println("Rotation boundary reached")
+			if err := child.Signal(rotateSignal); err != nil {
+				log.Println("Error sending rotation signal:", err)
+			}
+		}
+	}()
+}
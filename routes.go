@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// Emitters which have already been set up, so routing rules sharing an
+// emitter only set it up once. Routing rules can be reloaded while we're
+// running, so routeTo holds setupLock.
+var setupEmitters = make(map[logging.Emitter]bool)
+var setupLock sync.Mutex
+
+// routeTo resolves the emitter named by a routing rule, setting it up the
+// first time it's used. "drop" resolves to nil, meaning the lines are
+// discarded.
+func routeTo(name string) (logging.Emitter, error) {
+	if name == "drop" {
+		return nil, nil
+	}
+	emitter, err := logging.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	setupLock.Lock()
+	defer setupLock.Unlock()
+	setUp(emitter)
+	return emitter, nil
+}
+
+// setUp sets an emitter up unless it already has been. The members of a
+// fanout are set up individually, since they may be shared.
+func setUp(emitter logging.Emitter) {
+	if setupEmitters[emitter] {
+		return
+	}
+	setupEmitters[emitter] = true
+	if fanout, ok := emitter.(*logging.Fanout); ok {
+		for _, member := range fanout.Members() {
+			setUp(member)
+		}
+		return
+	}
+	emitter.Setup()
+}
+
+// cleanupEmitters flushes every emitter that's been set up on the way out:
+// the main and events emitters, and those set up for routes, virtual sources
+// and pods. The members of a fanout are cleaned up individually, like they're
+// set up, and all at once, so a slow one doesn't hold up the rest, once the
+// fanouts have handed them what they've queued.
+func cleanupEmitters(mode processMode) {
+	setupLock.Lock()
+	defer setupLock.Unlock()
+	var cleaning sync.WaitGroup
+	for emitter := range setupEmitters {
+		if fanout, ok := emitter.(*logging.Fanout); ok {
+			cleaning.Add(1)
+			go func() {
+				defer cleaning.Done()
+				mode.drain(fanout)
+			}()
+		}
+	}
+	cleaning.Wait()
+	for emitter := range setupEmitters {
+		if _, fanout := emitter.(*logging.Fanout); fanout {
+			continue
+		}
+		cleaning.Add(1)
+		go func(emitter logging.Emitter) {
+			defer cleaning.Done()
+			mode.cleanup(emitter)
+		}(emitter)
+	}
+	cleaning.Wait()
+}
+
+// echoesToConsole reports whether an emitter already writes to stderr, or to
+// stdout for the platform's log collection, so lines needn't be echoed too
+func echoesToConsole(emitter logging.Emitter) bool {
+	if fanout, ok := emitter.(*logging.Fanout); ok {
+		for _, member := range fanout.Members() {
+			if echoesToConsole(member) {
+				return true
+			}
+		}
+		return false
+	}
+	return emitter == logging.Emitters["stderr"] || emitter == logging.Emitters["stdout-json"]
+}
+
+// writesStdout reports whether the stdout-json emitter has been set up, for
+// the default emitter or a route
+func writesStdout() bool {
+	setupLock.Lock()
+	defer setupLock.Unlock()
+	return setupEmitters[logging.Emitters["stdout-json"]]
+}
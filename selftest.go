@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+const selftestMessageCount = 5
+
+// selftest pushes a handful of synthetic messages through the configured
+// emitter and reports how many of them were accepted. Emitters which wait for
+// the backend to acknowledge a write (kafka waits for all in-sync replicas)
+// return an error from HandleLogMessage when delivery fails, so a clean run
+// means the backend has the messages. Each message is labelled with a shared
+// run id so they can be found downstream. A fanout only queues what it's
+// handed, so its members are sent the messages directly instead.
+func selftest(emitter logging.Emitter) int {
+	members := []logging.Emitter{emitter}
+	if fanout, ok := emitter.(*logging.Fanout); ok {
+		members = fanout.Members()
+	}
+
+	runID := strconv.FormatInt(time.Now().UnixNano(), 36)
+	log.Println("Running selftest", runID)
+
+	failures := 0
+	for i := 0; i < selftestMessageCount; i++ {
+		m := logging.NewMessage(fmt.Sprintf("haberdasher selftest %s message %d/%d", runID, i+1, selftestMessageCount))
+		m.AddLabel("haberdasher_selftest", runID)
+
+		for _, member := range members {
+			start := time.Now()
+			if err := member.HandleLogMessage(m); err != nil {
+				log.Println("Selftest message", i+1, "failed:", err)
+				failures++
+				continue
+			}
+			log.Println("Selftest message", i+1, "delivered in", time.Since(start))
+		}
+	}
+
+	for _, member := range members {
+		if err := member.Cleanup(); err != nil {
+			log.Println("Error cleaning up emitter:", err)
+			failures++
+		}
+	}
+
+	if failures > 0 {
+		fmt.Fprintf(os.Stderr, "selftest %s: FAILED (%d problems)\n", runID, failures)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "selftest %s: OK (%d messages delivered)\n", runID, selftestMessageCount)
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"app --port 8080", []string{"app", "--port", "8080"}},
+		{"  app\t--verbose\n", []string{"app", "--verbose"}},
+		{`echo 'hello world'`, []string{"echo", "hello world"}},
+		{`echo 'a "b" \c $d'`, []string{"echo", `a "b" \c $d`}},
+		{`echo "hello world"`, []string{"echo", "hello world"}},
+		{`echo "a 'b' $HOME"`, []string{"echo", "a 'b' $HOME"}},
+		{`echo "\" \\ \$ \` + "`" + `"`, []string{"echo", `" \ $ ` + "`"}},
+		{`echo "\n \a"`, []string{"echo", `\n \a`}},
+		{`echo hello\ world`, []string{"echo", "hello world"}},
+		{`echo \'quoted\'`, []string{"echo", "'quoted'"}},
+		{`echo \\`, []string{"echo", `\`}},
+		{`app --name=a"b c"d'e f'`, []string{"app", "--name=ab cde f"}},
+		{`app '' ""`, []string{"app", "", ""}},
+		{`app --empty= ''x`, []string{"app", "--empty=", "x"}},
+		{"app 'multi\nline'", []string{"app", "multi\nline"}},
+		{"app $HOME *.log | grep x", []string{"app", "$HOME", "*.log", "|", "grep", "x"}},
+	}
+	for _, test := range tests {
+		got, err := splitCommand(test.command)
+		if err != nil {
+			t.Errorf("splitCommand(%q): %v", test.command, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitCommand(%q) = %q, want %q", test.command, got, test.want)
+		}
+	}
+}
+
+func TestSplitCommandUnterminated(t *testing.T) {
+	for _, command := range []string{
+		`echo 'hello`,
+		`echo "hello`,
+		`echo "it's`,
+		`echo 'say "hi"`,
+		`echo hello\`,
+		`echo "hello\"`,
+	} {
+		if words, err := splitCommand(command); err == nil {
+			t.Errorf("splitCommand(%q) = %q, want an error", command, words)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Signals parseSignal accepts beyond those every platform has
+var platformSignalsByName = map[string]syscall.Signal{
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// Signals the child acts on without stopping, like SIGUSR1 asking it to
+// reopen its log files, or which are about job control rather than shutdown
+var forwardedSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGQUIT, syscall.SIGWINCH, syscall.SIGCONT, syscall.SIGTSTP}
+
+// forwardSignals passes forwardedSignals straight on to the child's process
+// group, leaving us running
+func forwardSignals(child *supervisor) {
+	signals := make(chan os.Signal, len(forwardedSignals))
+	signal.Notify(signals, forwardedSignals...)
+	for received := range signals {
+		sig := received.(syscall.Signal)
+		// The kernel signals the child itself when its terminal's resized
+		if sig == syscall.SIGWINCH && child.pty {
+			child.resizeTerminal()
+			continue
+		}
+		// Terminals send one every time they're resized
+		if sig != syscall.SIGWINCH {
+			log.Println("Forwarding", sig, "to the child")
+		}
+		if err := child.SignalGroup(sig); err != nil {
+			log.Println("Couldn't forward", sig, "to the child:", err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Signals we're sent reach the child and what it started, and we keep
+// running
+func TestForwardSignals(t *testing.T) {
+	dir := t.TempDir()
+	cmd := exec.Command("sh", "-c", `
+		trap 'echo child USR1 >> out' USR1
+		trap 'echo child USR2 >> out' USR2
+		(trap 'echo descendant USR1 >> out' USR1; touch ready; while :; do sleep 0.01; done) &
+		while :; do sleep 0.01; done`)
+	cmd.Dir = dir
+	newProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer killGroup(cmd.Process.Pid, syscall.SIGKILL)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Until forwardSignals is listening, the signals mustn't stop the test
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGUSR1, syscall.SIGUSR2)
+	go forwardSignals(&supervisor{pid: cmd.Process.Pid})
+
+	waitFor := func(what string, done func() bool) {
+		for deadline := time.Now().Add(10 * time.Second); !done(); time.Sleep(50 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal(what)
+			}
+		}
+	}
+	waitFor("the child didn't start", func() bool {
+		_, err := os.Stat(filepath.Join(dir, "ready"))
+		return err == nil
+	})
+	for name, sig := range map[string]syscall.Signal{"USR1": syscall.SIGUSR1, "USR2": syscall.SIGUSR2} {
+		waitFor(name+" wasn't forwarded", func() bool {
+			syscall.Kill(os.Getpid(), sig)
+			time.Sleep(50 * time.Millisecond)
+			out, _ := ioutil.ReadFile(filepath.Join(dir, "out"))
+			return strings.Contains(string(out), "child "+name)
+		})
+	}
+	waitFor("USR1 didn't reach the child's descendant", func() bool {
+		out, _ := ioutil.ReadFile(filepath.Join(dir, "out"))
+		return strings.Contains(string(out), "descendant USR1")
+	})
+}
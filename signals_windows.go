@@ -0,0 +1,10 @@
+package main
+
+import "syscall"
+
+// Windows has no user-defined signals
+var platformSignalsByName = map[string]syscall.Signal{}
+
+// forwardSignals does nothing on Windows, which has none of the signals
+// forwarded elsewhere
+func forwardSignals(child *supervisor) {}
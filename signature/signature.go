@@ -0,0 +1,164 @@
+// Package signature verifies detached signatures over configuration files,
+// made with cosign (sign-blob) or minisign.
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+
+	"github.com/RedHatInsights/haberdasher/fips"
+)
+
+// A Key is a public key configuration files must be signed with
+type Key struct {
+	// A cosign key, or an Ed25519 key in PEM
+	public crypto.PublicKey
+	// A minisign key, identified by its key id
+	minisign   ed25519.PublicKey
+	minisignID []byte
+}
+
+// LoadKey reads a public key: a PEM "PUBLIC KEY" such as cosign.pub, or a
+// minisign public key file
+func LoadKey(path string) (*Key, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(contents); block != nil {
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch public.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey:
+			return &Key{public: public}, nil
+		}
+		return nil, fmt.Errorf("%s: only ECDSA and Ed25519 keys are supported", path)
+	}
+
+	// minisign keys are "Ed", an 8 byte key id, and the Ed25519 key, after an
+	// untrusted comment
+	raw, err := base64.StdEncoding.DecodeString(lastLine(contents))
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, fmt.Errorf("%s isn't a PEM or minisign public key", path)
+	}
+	// Signatures are of a BLAKE2b hash
+	if err := fips.Check("minisign"); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &Key{minisign: ed25519.PublicKey(raw[10:]), minisignID: raw[2:10]}, nil
+}
+
+// ReadVerified reads a file and checks the detached signature next to it:
+// path.sig for cosign keys, path.minisig for minisign keys. It returns the
+// contents that were checked, which are what should be parsed: reading the
+// file again could pick up a different one swapped in since.
+func (k *Key) ReadVerified(path string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.verify(path, contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// verify checks the signature over contents, read from path
+func (k *Key) verify(path string, contents []byte) error {
+	if k.minisign != nil {
+		signature, err := ioutil.ReadFile(path + ".minisig")
+		if err != nil {
+			return err
+		}
+		return k.verifyMinisign(contents, signature)
+	}
+	signature, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("%s.sig isn't a base64 signature", path)
+	}
+	switch public := k.public.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(contents)
+		if !ecdsa.VerifyASN1(public, digest[:], decoded) {
+			return errors.New("signature verification failed")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(public, contents, decoded) {
+			return errors.New("signature verification failed")
+		}
+	}
+	return nil
+}
+
+// verifyMinisign checks both the signature over the file and the one over
+// its trusted comment
+func (k *Key) verifyMinisign(contents []byte, signatureFile []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(signatureFile)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("malformed minisign signature")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(signature) != 2+8+ed25519.SignatureSize {
+		return errors.New("malformed minisign signature")
+	}
+	if !bytes.Equal(signature[2:10], k.minisignID) {
+		return errors.New("signed with a different minisign key")
+	}
+	switch string(signature[:2]) {
+	case "Ed":
+	case "ED":
+		// Prehashed, the default since minisign 0.10
+		digest := blake2b.Sum512(contents)
+		contents = digest[:]
+	default:
+		return errors.New("unknown minisign signature algorithm")
+	}
+	if !ed25519.Verify(k.minisign, contents, signature[10:]) {
+		return errors.New("signature verification failed")
+	}
+
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil {
+		return errors.New("malformed minisign signature")
+	}
+	comment := strings.TrimSuffix(strings.TrimPrefix(lines[2], "trusted comment: "), "\r")
+	if !ed25519.Verify(k.minisign, append(signature[10:], comment...), global) {
+		return errors.New("trusted comment verification failed")
+	}
+	return nil
+}
+
+func lastLine(contents []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// FromEnv loads the key named by HABERDASHER_SIGNING_KEY, or returns nil if
+// configuration files needn't be signed. config imports this package, so the
+// setting is read from the environment.
+func FromEnv() (*Key, error) {
+	path, exists := os.LookupEnv("HABERDASHER_SIGNING_KEY")
+	if !exists {
+		return nil, nil
+	}
+	return LoadKey(path)
+}
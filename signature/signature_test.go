@@ -0,0 +1,186 @@
+package signature
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The vectors in testdata were made with OpenSSL rather than this package:
+// cosign's sign-blob signature is a base64 ASN.1 ECDSA signature over the
+// file's SHA-256, and the minisign ones were put together as its format
+// describes, over the file's BLAKE2b-512 ("ED") or the file itself ("Ed").
+// The private keys were thrown away.
+
+func loadKey(t *testing.T, name string) *Key {
+	key, err := LoadKey(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// vector copies a signed file and its signature somewhere they can be
+// tampered with, returning the file's path
+func vector(t *testing.T, dir string) string {
+	copied := t.TempDir()
+	files, err := ioutil.ReadDir(filepath.Join("testdata", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		contents, err := ioutil.ReadFile(filepath.Join("testdata", dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(copied, file.Name()), contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(copied, "policy.json")
+}
+
+func rewrite(t *testing.T, path string, change func(string) string) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(change(string(contents))), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// replaceLine replaces the ith line of a minisign signature file
+func replaceLine(i int, change func(string) string) func(string) string {
+	return func(contents string) string {
+		lines := strings.Split(contents, "\n")
+		lines[i] = change(lines[i])
+		return strings.Join(lines, "\n")
+	}
+}
+
+func TestReadVerified(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		dir  string
+	}{
+		{"cosign", "cosign.pub", "cosign"},
+		{"Ed25519", "ed25519.pub", "ed25519"},
+		{"minisign", "minisign.pub", "minisign"},
+		{"legacy minisign", "minisign.pub", "minisign-legacy"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join("testdata", test.dir, "policy.json")
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadKey(t, test.key).ReadVerified(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("read %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestReadVerifiedRejects(t *testing.T) {
+	tampered := func(contents string) string { return strings.Replace(contents, "[card]", "[xxxx]", 1) }
+	tests := []struct {
+		name string
+		key  string
+		dir  string
+		// file is changed by change, if given
+		file   string
+		change func(string) string
+		want   string
+	}{
+		{"cosign, tampered", "cosign.pub", "cosign", "policy.json", tampered, "signature verification failed"},
+		{"Ed25519, tampered", "ed25519.pub", "ed25519", "policy.json", tampered, "signature verification failed"},
+		{"minisign, tampered", "minisign.pub", "minisign", "policy.json", tampered, "signature verification failed"},
+		{"legacy minisign, tampered", "minisign.pub", "minisign-legacy", "policy.json", tampered, "signature verification failed"},
+
+		{"cosign, wrong key", "other.pub", "cosign", "", nil, "signature verification failed"},
+		{"cosign signature, Ed25519 key", "ed25519.pub", "cosign", "", nil, "signature verification failed"},
+		{"minisign, another key", "other.minisign.pub", "minisign", "", nil, "signed with a different minisign key"},
+		{"minisign, another key with the same id", "impostor.minisign.pub", "minisign", "", nil, "signature verification failed"},
+
+		{"cosign, not base64", "cosign.pub", "cosign", "policy.json.sig", func(string) string { return "not a signature!" }, "isn't a base64 signature"},
+		{"cosign, truncated", "cosign.pub", "cosign", "policy.json.sig", func(s string) string { return s[:40] }, "signature verification failed"},
+		{"cosign, empty", "cosign.pub", "cosign", "policy.json.sig", func(string) string { return "" }, "signature verification failed"},
+		{"minisign, truncated", "minisign.pub", "minisign", "policy.json.minisig", replaceLine(1, func(s string) string { return s[:40] }), "malformed minisign signature"},
+		{"minisign, not base64", "minisign.pub", "minisign", "policy.json.minisig", replaceLine(1, func(string) string { return "not a signature!" }), "malformed minisign signature"},
+		{"minisign, without a trusted comment", "minisign.pub", "minisign", "policy.json.minisig", func(s string) string {
+			lines := strings.Split(strings.TrimSpace(s), "\n")
+			return strings.Join(lines[:2], "\n") + "\n"
+		}, "malformed minisign signature"},
+		{"minisign, unknown algorithm", "minisign.pub", "minisign", "policy.json.minisig", replaceLine(1, func(s string) string {
+			decoded, _ := base64.StdEncoding.DecodeString(s)
+			return base64.StdEncoding.EncodeToString(append([]byte("Xx"), decoded[2:]...))
+		}), "unknown minisign signature algorithm"},
+
+		{"minisign, trusted comment changed", "minisign.pub", "minisign", "policy.json.minisig", replaceLine(2, func(s string) string {
+			return strings.Replace(s, "timestamp:1600000000", "timestamp:1700000000", 1)
+		}), "trusted comment verification failed"},
+		{"minisign, trusted comment signature changed", "minisign.pub", "minisign", "policy.json.minisig", replaceLine(3, func(s string) string {
+			decoded, _ := base64.StdEncoding.DecodeString(s)
+			decoded[0] ^= 1
+			return base64.StdEncoding.EncodeToString(decoded)
+		}), "trusted comment verification failed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := vector(t, test.dir)
+			if test.change != nil {
+				rewrite(t, filepath.Join(filepath.Dir(path), test.file), test.change)
+			}
+			contents, err := loadKey(t, test.key).ReadVerified(path)
+			if err == nil {
+				t.Fatalf("read %q, want an error", contents)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("failed with %q, want it to mention %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestReadVerifiedWithoutSignature(t *testing.T) {
+	for _, test := range []struct{ key, dir, signature string }{
+		{"cosign.pub", "cosign", "policy.json.sig"},
+		{"minisign.pub", "minisign", "policy.json.minisig"},
+	} {
+		path := vector(t, test.dir)
+		if err := os.Remove(filepath.Join(filepath.Dir(path), test.signature)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadKey(t, test.key).ReadVerified(path); !os.IsNotExist(err) {
+			t.Errorf("%s: an unsigned file gave %v", test.key, err)
+		}
+	}
+}
+
+func TestLoadKeyRejects(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"garbage":         "not a key\n",
+		"short minisign":  "untrusted comment: minisign public key\nRWQ7QZ8G0o4VxF7s\n",
+		"PEM, not a key":  "-----BEGIN PUBLIC KEY-----\nbm90IGEga2V5\n-----END PUBLIC KEY-----\n",
+		"secret minisign": "untrusted comment: minisign encrypted secret key\nRWRTY0Iy\n",
+	} {
+		path := filepath.Join(dir, strings.Replace(name, " ", "-", -1))
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadKey(path); err == nil {
+			t.Errorf("%s: loaded a key", name)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Package spiffe fetches the workload's X.509 SVID and trust bundles from the
+// SPIFFE Workload API, such as a SPIRE agent's, and keeps them current as
+// they're rotated, for the emitters' mutual TLS.
+package spiffe
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/RedHatInsights/haberdasher/protobuf"
+)
+
+// How long to wait for the first SVID, which the agent may only issue once it
+// has attested us
+const firstSVIDTimeout = 30 * time.Second
+
+// The longest a broken stream is retried after
+const maxBackoff = 30 * time.Second
+
+// A response bigger than this isn't an SVID
+const maxMessageSize = 16 << 20
+
+// An X509Context is our SVID and the bundles of the trust domains we trust
+type X509Context struct {
+	ID          *url.URL
+	Certificate tls.Certificate
+	// The bundles by trust domain, ours and any federated with it
+	Bundles map[string]*x509.CertPool
+}
+
+// A Source streams X509Contexts from the Workload API
+type Source struct {
+	address string
+	client  *http.Client
+
+	lock    sync.Mutex
+	current *X509Context
+	lastErr error
+	ready   chan struct{}
+}
+
+var (
+	sharedLock sync.Mutex
+	shared     *Source
+)
+
+// Default returns the Source for the Workload API at SPIFFE_ENDPOINT_SOCKET,
+// shared by every emitter, once it has an SVID
+func Default() (*Source, error) {
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	if shared == nil {
+		address := os.Getenv("SPIFFE_ENDPOINT_SOCKET")
+		if address == "" {
+			return nil, errors.New("SPIFFE_ENDPOINT_SOCKET must name the Workload API's socket, like unix:///run/spire/sockets/agent.sock")
+		}
+		source, err := NewSource(address)
+		if err != nil {
+			return nil, fmt.Errorf("SPIFFE_ENDPOINT_SOCKET: %v", err)
+		}
+		shared = source
+	}
+	return shared, shared.wait(firstSVIDTimeout)
+}
+
+// NewSource starts streaming from the Workload API at address, a unix:// path
+// or tcp://ip:port
+func NewSource(address string) (*Source, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	var network, dial string
+	switch parsed.Scheme {
+	case "unix":
+		network, dial = "unix", parsed.Path
+	case "tcp":
+		network, dial = "tcp", parsed.Host
+	}
+	if dial == "" {
+		return nil, fmt.Errorf("%q isn't a unix:// or tcp:// address", address)
+	}
+	s := &Source{
+		address: address,
+		// gRPC, in the clear, which the Workload API is
+		client: &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(_, _ string, _ *tls.Config) (net.Conn, error) {
+				return net.DialTimeout(network, dial, 10*time.Second)
+			},
+		}},
+		ready: make(chan struct{}),
+	}
+	go s.watch()
+	return s, nil
+}
+
+// Current returns the latest X509Context
+func (s *Source) Current() *X509Context {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.current
+}
+
+// ClientCertificate presents our current SVID, as tls.Config's
+// GetClientCertificate
+func (s *Source) ClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return &s.Current().Certificate, nil
+}
+
+// VerifyPeer verifies a peer's SVID against the bundle of its trust domain,
+// and that its SPIFFE ID is id, or if id is "", that it's in our trust domain
+func (s *Source) VerifyPeer(raw [][]byte, id string) error {
+	current := s.Current()
+	if len(raw) == 0 {
+		return errors.New("the peer sent no certificate")
+	}
+	certs := make([]*x509.Certificate, len(raw))
+	for i, der := range raw {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		certs[i] = cert
+	}
+	peer, err := spiffeID(certs[0])
+	if err != nil {
+		return err
+	}
+	bundle, trusted := current.Bundles[peer.Host]
+	if !trusted {
+		return fmt.Errorf("the peer's trust domain %s isn't trusted", peer.Host)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	options := x509.VerifyOptions{
+		Roots:         bundle,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := certs[0].Verify(options); err != nil {
+		return err
+	}
+	switch {
+	case id != "" && peer.String() != id:
+		return fmt.Errorf("the peer is %s, rather than %s", peer, id)
+	case id == "" && peer.Host != current.ID.Host:
+		return fmt.Errorf("the peer is %s, outside our trust domain %s", peer, current.ID.Host)
+	}
+	return nil
+}
+
+// spiffeID returns the SPIFFE ID of an SVID, its one URI SAN
+func spiffeID(cert *x509.Certificate) (*url.URL, error) {
+	if len(cert.URIs) != 1 || cert.URIs[0].Scheme != "spiffe" || cert.URIs[0].Host == "" {
+		return nil, errors.New("the certificate isn't an SVID, with a spiffe:// URI")
+	}
+	return cert.URIs[0], nil
+}
+
+func (s *Source) wait(timeout time.Duration) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-time.After(timeout):
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lastErr != nil {
+		return fmt.Errorf("no SVID from %s after %s: %v", s.address, timeout, s.lastErr)
+	}
+	return fmt.Errorf("no SVID from %s after %s", s.address, timeout)
+}
+
+// watch keeps a FetchX509SVID stream open, reconnecting when it breaks
+func (s *Source) watch() {
+	backoff := time.Second
+	for {
+		received, err := s.stream()
+		if received {
+			backoff = time.Second
+		}
+		s.lock.Lock()
+		s.lastErr = err
+		s.lock.Unlock()
+		log.Println("SPIFFE Workload API stream ended:", err, "- reconnecting in", backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// stream calls FetchX509SVID, updating the current X509Context with every
+// response until the stream ends, and reports whether there were any
+func (s *Source) stream() (bool, error) {
+	// An empty X509SVIDRequest, framed as uncompressed
+	request, err := http.NewRequest(http.MethodPost, "http://localhost/SpiffeWorkloadAPI/FetchX509SVID", bytes.NewReader(make([]byte, 5)))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+	// The Workload API only answers requests with this, so it isn't fooled
+	// into serving a browser
+	request.Header.Set("Workload.Spiffe.Io", "true")
+	response, err := s.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("HTTP status %s", response.Status)
+	}
+	if err := grpcStatus(response.Header); err != nil {
+		return false, err
+	}
+
+	received := false
+	reader := bufio.NewReader(response.Body)
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(reader, header[:]); err == io.EOF {
+			if err := grpcStatus(response.Trailer); err != nil {
+				return received, err
+			}
+			return received, errors.New("the stream was closed")
+		} else if err != nil {
+			return received, err
+		}
+		length := binary.BigEndian.Uint32(header[1:])
+		if header[0] != 0 || length > maxMessageSize {
+			return received, errors.New("unexpected gRPC message")
+		}
+		message := make([]byte, length)
+		if _, err := io.ReadFull(reader, message); err != nil {
+			return received, err
+		}
+		context, err := parseX509SVIDResponse(message)
+		if err != nil {
+			return received, err
+		}
+		received = true
+		s.update(context)
+	}
+}
+
+// grpcStatus returns the error a call's status describes, if it failed
+func grpcStatus(header http.Header) error {
+	status := header.Get("Grpc-Status")
+	if status == "" || status == "0" {
+		return nil
+	}
+	message := header.Get("Grpc-Message")
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return fmt.Errorf("gRPC status %s: %s", status, message)
+}
+
+func (s *Source) update(context *X509Context) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current == nil {
+		close(s.ready)
+		log.Println("Fetched the SVID for", context.ID, "valid until", context.Certificate.Leaf.NotAfter)
+	} else {
+		log.Println("Rotated the SVID for", context.ID, "valid until", context.Certificate.Leaf.NotAfter)
+	}
+	s.current = context
+	s.lastErr = nil
+}
+
+// parseX509SVIDResponse reads an X509SVIDResponse, taking its first SVID as
+// ours, as the Workload API says
+func parseX509SVIDResponse(message []byte) (*X509Context, error) {
+	fields, err := protobuf.Fields(message)
+	if err != nil {
+		return nil, err
+	}
+	var context *X509Context
+	federated := make(map[string][]byte)
+	for _, field := range fields {
+		switch {
+		case field.Number == 1 && field.WireType == protobuf.Bytes && context == nil:
+			if context, err = parseX509SVID(field.Data); err != nil {
+				return nil, err
+			}
+		case field.Number == 3 && field.WireType == protobuf.Bytes:
+			// A map<string, bytes> entry
+			entry, err := protobuf.Fields(field.Data)
+			if err != nil {
+				return nil, err
+			}
+			var trustDomain string
+			var bundle []byte
+			for _, f := range entry {
+				switch f.Number {
+				case 1:
+					trustDomain = strings.TrimPrefix(string(f.Data), "spiffe://")
+				case 2:
+					bundle = f.Data
+				}
+			}
+			federated[trustDomain] = bundle
+		}
+	}
+	if context == nil {
+		return nil, errors.New("the Workload API sent no SVID")
+	}
+	for trustDomain, bundle := range federated {
+		if _, ours := context.Bundles[trustDomain]; ours {
+			continue
+		}
+		pool, err := parseBundle(bundle)
+		if err != nil {
+			return nil, fmt.Errorf("the bundle of %s: %v", trustDomain, err)
+		}
+		context.Bundles[trustDomain] = pool
+	}
+	return context, nil
+}
+
+// parseX509SVID reads an X509SVID: our SPIFFE ID, our certificate chain and
+// PKCS#8 key in DER, and our trust domain's bundle
+func parseX509SVID(message []byte) (*X509Context, error) {
+	fields, err := protobuf.Fields(message)
+	if err != nil {
+		return nil, err
+	}
+	var chain, key, bundle []byte
+	for _, field := range fields {
+		switch field.Number {
+		case 2:
+			chain = field.Data
+		case 3:
+			key = field.Data
+		case 4:
+			bundle = field.Data
+		}
+	}
+	certs, err := x509.ParseCertificates(chain)
+	if err != nil || len(certs) == 0 {
+		return nil, fmt.Errorf("invalid SVID: %v", err)
+	}
+	id, err := spiffeID(certs[0])
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SVID key: %v", err)
+	}
+	pool, err := parseBundle(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("the bundle of %s: %v", id.Host, err)
+	}
+	context := &X509Context{
+		ID:          id,
+		Certificate: tls.Certificate{PrivateKey: privateKey, Leaf: certs[0]},
+		Bundles:     map[string]*x509.CertPool{id.Host: pool},
+	}
+	for _, cert := range certs {
+		context.Certificate.Certificate = append(context.Certificate.Certificate, cert.Raw)
+	}
+	return context, nil
+}
+
+func parseBundle(der []byte) (*x509.CertPool, error) {
+	certs, err := x509.ParseCertificates(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates")
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
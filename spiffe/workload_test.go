@@ -0,0 +1,302 @@
+package spiffe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// An authority is a trust domain's CA
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newAuthority(t *testing.T, trustDomain string) *authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{trustDomain}},
+		URIs:                  []*url.URL{{Scheme: "spiffe", Host: trustDomain}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authority{cert: cert, key: key}
+}
+
+// issue returns an SVID for id, or with no URI if id is "", and its PKCS#8
+// key
+func (a *authority) issue(t *testing.T, id string) (*x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if id != "" {
+		parsed, err := url.Parse(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.URIs = []*url.URL{parsed}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pkcs8
+}
+
+// response is an X509SVIDResponse for id, issued by ours, trusting the
+// federated authorities too
+func response(t *testing.T, id string, ours *authority, federated ...*authority) *workload.X509SVIDResponse {
+	cert, key := ours.issue(t, id)
+	r := &workload.X509SVIDResponse{
+		Svids: []*workload.X509SVID{{
+			SpiffeId:    id,
+			X509Svid:    cert.Raw,
+			X509SvidKey: key,
+			Bundle:      ours.cert.Raw,
+		}},
+		FederatedBundles: make(map[string][]byte),
+	}
+	for _, a := range federated {
+		r.FederatedBundles[a.cert.URIs[0].String()] = a.cert.Raw
+	}
+	return r
+}
+
+// A fakeAgent serves the Workload API, streaming the responses it's sent, or
+// failing with err
+type fakeAgent struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	responses chan *workload.X509SVIDResponse
+	err       error
+}
+
+func (a *fakeAgent) FetchX509SVID(_ *workload.X509SVIDRequest, stream grpc.ServerStreamingServer[workload.X509SVIDResponse]) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if strings.Join(md.Get("workload.spiffe.io"), "") != "true" {
+		return status.Error(codes.InvalidArgument, "security header missing from request")
+	}
+	if a.err != nil {
+		return a.err
+	}
+	for {
+		select {
+		case r := <-a.responses:
+			if err := stream.Send(r); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// startAgent serves the Workload API on a Unix socket, returning its address
+func startAgent(t *testing.T, agent *fakeAgent) string {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(server, agent)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	log.SetOutput(ioutil.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return "unix://" + path
+}
+
+func TestNewSourceAddress(t *testing.T) {
+	for _, address := range []string{"/run/spire/agent.sock", "http://localhost:8081", "unix://", "tcp:///agent.sock"} {
+		if _, err := NewSource(address); err == nil || !strings.Contains(err.Error(), "isn't a unix:// or tcp:// address") {
+			t.Errorf("%s: %v", address, err)
+		}
+	}
+}
+
+// The first SVID is ours, with its trust domain's bundle, and the federated
+// bundles are trusted too. Rotated SVIDs replace it.
+func TestSource(t *testing.T) {
+	ours, theirs := newAuthority(t, "example.org"), newAuthority(t, "partner.example")
+	agent := &fakeAgent{responses: make(chan *workload.X509SVIDResponse, 1)}
+	first := response(t, "spiffe://example.org/haberdasher", ours, theirs)
+	first.Svids = append(first.Svids, response(t, "spiffe://example.org/other", ours).Svids[0])
+	agent.responses <- first
+	source, err := NewSource(startAgent(t, agent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.wait(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	current := source.Current()
+	if current.ID.String() != "spiffe://example.org/haberdasher" {
+		t.Errorf("our SPIFFE ID is %s", current.ID)
+	}
+	if len(current.Certificate.Certificate) != 1 || string(current.Certificate.Certificate[0]) != string(first.Svids[0].X509Svid) {
+		t.Error("the certificate isn't the first SVID")
+	}
+	if _, err := current.Certificate.Leaf.Verify(x509.VerifyOptions{Roots: current.Bundles["example.org"], KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Errorf("our bundle doesn't verify our SVID: %v", err)
+	}
+	if len(current.Bundles) != 2 || current.Bundles["partner.example"] == nil {
+		t.Errorf("trusting %v", current.Bundles)
+	}
+	if cert, err := source.ClientCertificate(nil); err != nil || cert.Leaf != current.Certificate.Leaf {
+		t.Errorf("presenting %v, %v", cert, err)
+	}
+
+	agent.responses <- response(t, "spiffe://example.org/haberdasher", ours)
+	for deadline := time.Now().Add(10 * time.Second); source.Current() == current; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the SVID wasn't rotated")
+		}
+	}
+	if rotated := source.Current(); rotated.Certificate.Leaf.Equal(current.Certificate.Leaf) || len(rotated.Bundles) != 1 {
+		t.Errorf("rotated to %+v", rotated)
+	}
+}
+
+func TestSourceFailing(t *testing.T) {
+	agent := &fakeAgent{err: status.Error(codes.PermissionDenied, "no identity issued")}
+	source, err := NewSource(startAgent(t, agent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = source.wait(200 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "gRPC status 7: no identity issued") {
+		t.Errorf("waiting returned %v", err)
+	}
+}
+
+func TestParseX509SVIDResponseErrors(t *testing.T) {
+	ours := newAuthority(t, "example.org")
+	noURI, key := ours.issue(t, "")
+	tests := []struct {
+		name   string
+		change func(r *workload.X509SVIDResponse)
+		want   string
+	}{
+		{"no SVIDs", func(r *workload.X509SVIDResponse) { r.Svids = nil }, "sent no SVID"},
+		{"not an SVID", func(r *workload.X509SVIDResponse) {
+			r.Svids[0].X509Svid, r.Svids[0].X509SvidKey = noURI.Raw, key
+		}, "isn't an SVID"},
+		{"bad key", func(r *workload.X509SVIDResponse) { r.Svids[0].X509SvidKey = []byte("key") }, "invalid SVID key"},
+		{"no bundle", func(r *workload.X509SVIDResponse) { r.Svids[0].Bundle = nil }, "the bundle of example.org: no certificates"},
+		{"bad federated bundle", func(r *workload.X509SVIDResponse) {
+			r.FederatedBundles["spiffe://partner.example"] = []byte("bundle")
+		}, "the bundle of partner.example"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := response(t, "spiffe://example.org/haberdasher", ours)
+			test.change(r)
+			message, err := proto.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parseX509SVIDResponse(message); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestVerifyPeer(t *testing.T) {
+	ours, theirs, unknown := newAuthority(t, "example.org"), newAuthority(t, "partner.example"), newAuthority(t, "elsewhere.example")
+	impostor := newAuthority(t, "example.org")
+	message, err := proto.Marshal(response(t, "spiffe://example.org/haberdasher", ours, theirs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	context, err := parseX509SVIDResponse(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &Source{current: context}
+	svid := func(a *authority, id string) [][]byte {
+		cert, _ := a.issue(t, id)
+		return [][]byte{cert.Raw}
+	}
+
+	tests := []struct {
+		name string
+		peer [][]byte
+		id   string
+		// want is part of the error, or "" if the peer should be accepted
+		want string
+	}{
+		{"in our trust domain", svid(ours, "spiffe://example.org/collector"), "", ""},
+		{"the ID wanted", svid(ours, "spiffe://example.org/collector"), "spiffe://example.org/collector", ""},
+		{"another ID", svid(ours, "spiffe://example.org/collector"), "spiffe://example.org/gateway", "rather than spiffe://example.org/gateway"},
+		{"federated, by ID", svid(theirs, "spiffe://partner.example/collector"), "spiffe://partner.example/collector", ""},
+		{"federated, not by ID", svid(theirs, "spiffe://partner.example/collector"), "", "outside our trust domain"},
+		{"untrusted trust domain", svid(unknown, "spiffe://elsewhere.example/collector"), "", "isn't trusted"},
+		{"signed by another CA", svid(impostor, "spiffe://example.org/collector"), "", "certificate signed by unknown authority"},
+		{"claiming another trust domain", svid(theirs, "spiffe://example.org/collector"), "", "certificate signed by unknown authority"},
+		{"not an SVID", svid(ours, ""), "", "isn't an SVID"},
+		{"no certificate", nil, "", "sent no certificate"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := source.VerifyPeer(test.peer, test.id)
+			if test.want == "" && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if test.want != "" && (err == nil || !strings.Contains(err.Error(), test.want)) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
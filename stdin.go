@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// readStdin ships the lines piped to us, with `haberdasher --stdin`, as a
+// child's stderr would be, for shells and init systems which can't hand us a
+// command to wrap. With no child there's no reaping, signal forwarding, or
+// rotation; we're done once whatever's writing closes the pipe.
+func (s *supervisor) readStdin() {
+	log.Println("Shipping lines read from stdin")
+	s.readiness.childStarted()
+	var stdin io.Reader = os.Stdin
+	if s.recorder != nil {
+		stdin = s.recorder.wrap(rawStderr, stdin)
+	}
+	s.scan(stdin, logging.Source{}, s.queue.Push)
+	s.lock.Lock()
+	s.lastExit, s.lastExitCode = "stdin closed", -1
+	s.lock.Unlock()
+}
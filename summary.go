@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+var startedAt = logging.Clock.Now()
+
+// emitSummary accounts for the whole run on the way out: a summary event goes
+// to every emitter in use, or where events are routed, and to stderr
+func emitSummary(child *supervisor) {
+	stats := logging.CurrentStats()
+	status := child.Status()
+	duration := logging.Clock.Since(startedAt)
+
+	exit := status.LastExit
+	if status.Running {
+		exit = "still running"
+	} else if exit == "" {
+		exit = "not run"
+	}
+	text := fmt.Sprintf("Haberdasher ran for %s: %d lines (%d bytes) received, %d dropped, %d failed to ship; child %s",
+		duration.Round(time.Millisecond), stats.Lines, stats.Bytes, stats.Dropped, stats.Failed, exit)
+	log.Println(text)
+
+	m := logging.NewEvent("summary", text)
+	m.AddLabel("haberdasher_lines", strconv.FormatUint(stats.Lines, 10))
+	m.AddLabel("haberdasher_bytes", strconv.FormatUint(stats.Bytes, 10))
+	m.AddLabel("haberdasher_dropped", strconv.FormatUint(stats.Dropped, 10))
+	m.AddLabel("haberdasher_failed", strconv.FormatUint(stats.Failed, 10))
+	m.AddLabel("haberdasher_duration_seconds", strconv.FormatFloat(duration.Seconds(), 'f', 3, 64))
+	if status.ExitCode != nil {
+		m.AddLabel("haberdasher_exit_code", strconv.Itoa(*status.ExitCode))
+	}
+	if events := logging.RoutedEvents(); events != nil {
+		if err := events.HandleLogMessage(m); err != nil {
+			log.Println("Error emitting summary:", err)
+		}
+		return
+	}
+	setupLock.Lock()
+	defer setupLock.Unlock()
+	for emitter := range setupEmitters {
+		// Fanouts' members get it directly
+		if _, fanout := emitter.(*logging.Fanout); fanout {
+			continue
+		}
+		if err := emitter.HandleLogMessage(m); err != nil {
+			log.Println("Error emitting summary:", err)
+		}
+	}
+}
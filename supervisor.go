@@ -0,0 +1,612 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/multiline"
+)
+
+// The child's lines are echoed through their own logger, so they're never
+// mistaken for our log output when that's captured
+var consoleEcho = log.New(os.Stderr, "", log.LstdFlags)
+
+// A supervisor runs the wrapped command and keeps track of its lifecycle, so
+// it can be inspected and controlled over the admin API.
+type supervisor struct {
+	argv        []string
+	emitter     logging.Emitter
+	echo        bool
+	readiness   *readinessGate
+	dedupWindow time.Duration
+	// interleave is how long lines are held to put both streams' back in
+	// the order they were written in
+	interleave time.Duration
+	// captureStdout is set when stdout is shipped as well as stderr
+	captureStdout bool
+	virtual       []*virtualSource
+	sample        *lineSample
+	queue         *logging.Queue
+	pipeBuffer    int
+	rawTee        io.Writer
+	recorder      *rawRecorder
+	multiline     multiline.Rule
+	recordLimits  multiline.Limits
+	restart       restartPolicy
+	// lineStdout is set when stdout is mirrored to ours a line at a time,
+	// rather than passed through, so its lines don't tear the records of
+	// the stdout-json emitter
+	lineStdout bool
+	// How long an exited child's output is still read for during shutdown
+	pipeDrainTimeout time.Duration
+	// The variables our flags and the configuration file set, which the child
+	// doesn't get
+	configured map[string]bool
+	// piped is set when lines are read from our stdin instead of a child
+	piped bool
+	// pty is set when the child's stderr is a pseudo-terminal instead of a
+	// pipe, for applications which log differently if it isn't a TTY
+	pty bool
+
+	lock             sync.Mutex
+	pid              int
+	stderrPipe       string
+	terminal         *os.File
+	started          time.Time
+	restarts         int
+	lastExit         string
+	lastExitCode     int
+	restartRequested bool
+	// collected is set once the running child's exit status is, while its
+	// output may still be being read
+	collected bool
+	// stopped is set, and stopping closed, once the child mustn't be
+	// restarted any more
+	stopped  bool
+	stopping chan struct{}
+
+	// Guards virtual, which policy reloads replace
+	policyLock sync.RWMutex
+}
+
+// Pid returns the pid of the running child, or 0 if there isn't one
+func (s *supervisor) Pid() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pid
+}
+
+// Signal sends a signal to the running child
+func (s *supervisor) Signal(sig syscall.Signal) error {
+	pid := s.Pid()
+	if pid <= 0 {
+		return fmt.Errorf("the child is not running")
+	}
+	log.Println("Sending", sig, "to", pid)
+	return kill(pid, sig)
+}
+
+// SignalGroup sends a signal to the running child's process group, reaching
+// any processes it started too
+func (s *supervisor) SignalGroup(sig syscall.Signal) error {
+	pid := s.Pid()
+	if pid <= 0 {
+		return fmt.Errorf("the child is not running")
+	}
+	return killGroup(pid, sig)
+}
+
+// Restart stops the child and starts it again once it has exited
+func (s *supervisor) Restart() error {
+	s.lock.Lock()
+	s.restartRequested = true
+	s.lock.Unlock()
+	return s.Signal(syscall.SIGTERM)
+}
+
+// Stop asks the child to exit without being restarted
+func (s *supervisor) Stop() error {
+	s.stopRestarting()
+	return s.Signal(syscall.SIGTERM)
+}
+
+// stopRestarting keeps the child from being started again once it exits,
+// whatever the restart policy says
+func (s *supervisor) stopRestarting() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.restartRequested = false
+	if !s.stopped {
+		s.stopped = true
+		close(s.stopping)
+	}
+}
+
+// stderrIdentity returns the child's pid and its stderr pipe's identity
+func (s *supervisor) stderrIdentity() (int, string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pid, s.stderrPipe
+}
+
+// run starts the child, ships its stderr until it closes, and starts it again
+// if a restart was requested in the meantime, or the restart policy says to
+func (s *supervisor) run() {
+	failures := 0
+	for {
+		started := logging.Clock.Now()
+		if err := s.runOnce(); err != nil {
+			log.Fatal(err)
+		}
+		s.lock.Lock()
+		requested, stopped := s.restartRequested, s.stopped
+		exit, code := s.lastExit, s.lastExitCode
+		s.restartRequested = false
+		if requested {
+			s.restarts++
+		}
+		s.lock.Unlock()
+		if requested {
+			logging.SendEvent(s.emitter, logging.NewEvent("child-restarted", "Restarting "+s.argv[0]))
+			continue
+		}
+		if stopped || !s.restart.applies(code) {
+			return
+		}
+
+		// A child which stayed up longer than the longest wait was healthy
+		// for a while, so its failures start being counted again
+		if logging.Clock.Since(started) > s.restart.maxBackoff {
+			failures = 0
+		}
+		if s.restart.maxRetries > 0 && failures >= s.restart.maxRetries {
+			m := logging.NewEvent("child-restart-limit", fmt.Sprintf("%s exited (%s) after %d restarts in a row, giving up", s.argv[0], exit, failures))
+			m.AddLabel("haberdasher_restart_attempt", strconv.Itoa(failures))
+			if code >= 0 {
+				m.AddLabel("haberdasher_exit_code", strconv.Itoa(code))
+			}
+			logging.SendEvent(s.emitter, m)
+			return
+		}
+		delay := s.restart.delay(failures)
+		failures++
+		m := logging.NewEvent("child-restarted", fmt.Sprintf("%s exited (%s), restarting in %s (attempt %d)", s.argv[0], exit, delay, failures))
+		m.AddLabel("haberdasher_restart_attempt", strconv.Itoa(failures))
+		m.AddLabel("haberdasher_restart_delay_seconds", strconv.FormatFloat(delay.Seconds(), 'f', 3, 64))
+		if code >= 0 {
+			m.AddLabel("haberdasher_exit_code", strconv.Itoa(code))
+		}
+		logging.SendEvent(s.emitter, m)
+
+		// Stopping doesn't wait out the backoff
+		select {
+		case <-logging.Clock.After(delay):
+		case <-s.stopping:
+			return
+		}
+		s.lock.Lock()
+		stopped = s.stopped
+		if !stopped {
+			s.restarts++
+		}
+		s.lock.Unlock()
+		if stopped {
+			return
+		}
+	}
+}
+
+func (s *supervisor) runOnce() error {
+	subcmd := exec.Command(s.argv[0], s.argv[1:]...)
+	// pass through stdout, but capture stderr
+	subcmd.Stdout = os.Stdout
+	if len(s.configured) > 0 {
+		subcmd.Env = childEnv(s.configured)
+	}
+	var subcmdErr io.ReadCloser
+	var master, slave *os.File
+	var err error
+	if s.pty {
+		if master, slave, err = openPTY(); err != nil {
+			return fmt.Errorf("Couldn't open a pseudo-terminal for the child: %v", err)
+		}
+		subcmdErr = ptyOutput{master}
+		if err := resizePTY(master); err != nil {
+			log.Println("Warning: couldn't size the child's terminal:", err)
+		}
+	} else if subcmdErr, err = subcmd.StderrPipe(); err != nil {
+		return err
+	}
+	var subcmdOut io.Reader
+	if s.captureStdout || s.lineStdout {
+		subcmd.Stdout = nil
+		if subcmdOut, err = subcmd.StdoutPipe(); err != nil {
+			return err
+		}
+	}
+
+	if !s.pty {
+		s.growPipe(subcmdErr)
+	}
+	if subcmdOut != nil {
+		s.growPipe(subcmdOut)
+	}
+
+	configureChild(subcmd)
+	if s.pty {
+		attachPTY(subcmd, slave)
+	}
+	err = startChild(subcmd)
+	if slave != nil {
+		// Only the child holds it now, so the terminal hangs up when it's
+		// gone, as a pipe would close
+		slave.Close()
+	}
+	if err != nil {
+		if master != nil {
+			master.Close()
+		}
+		return err
+	}
+	s.lock.Lock()
+	s.pid = subcmd.Process.Pid
+	if s.pty {
+		s.stderrPipe = slave.Name()
+		s.terminal = master
+	} else {
+		s.stderrPipe = pipeIdentity(subcmdErr)
+	}
+	s.started = logging.Clock.Now()
+	s.collected = false
+	s.lock.Unlock()
+	s.readiness.childStarted()
+
+	// The child is waited for alongside reading its output, so we know when
+	// it's exited even if something it started holds its pipes open
+	exited := make(chan struct{})
+	go func() {
+		state, waitErr := subcmd.Process.Wait()
+		s.collect(subcmd.Process.Pid, state, waitErr)
+		close(exited)
+	}()
+
+	pipes := []io.Closer{subcmdErr}
+	if closer, ok := subcmdOut.(io.Closer); ok {
+		pipes = append(pipes, closer)
+	}
+	var stderr io.Reader = subcmdErr
+	if s.recorder != nil {
+		stderr = s.recorder.wrap(rawStderr, stderr)
+		if subcmdOut != nil {
+			subcmdOut = s.recorder.wrap(rawStdout, subcmdOut)
+		}
+	}
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		if s.rawTee != nil {
+			copyRaw(s.rawTee, stderr)
+		} else {
+			s.consume(stderr, subcmdOut)
+		}
+	}()
+	select {
+	case <-consumed:
+	case <-exited:
+		s.drain(consumed, pipes)
+	}
+	<-exited
+	for _, pipe := range pipes {
+		pipe.Close()
+	}
+	s.lock.Lock()
+	s.pid = 0
+	s.terminal = nil
+	s.lock.Unlock()
+	return nil
+}
+
+// collect records how the child exited, as soon as it has, rather than once
+// its output has been read. When we're reaping, the reaper may beat us to
+// collecting the exit status, in which case it has kept it for us.
+func (s *supervisor) collect(pid int, state *os.ProcessState, waitErr error) {
+	exit, code := "unknown", -1
+	if waitErr == nil {
+		exit, code = describeExit(state.Sys().(syscall.WaitStatus))
+	} else if status, reaped := reapedStatus(pid); reaped {
+		exit, code = describeExit(status)
+	}
+	s.lock.Lock()
+	s.lastExit = exit
+	s.lastExitCode = code
+	s.collected = true
+	s.lock.Unlock()
+}
+
+// drain waits for the rest of an exited child's output to be read, until
+// its pipes close. Something the child started may keep them open
+// indefinitely, so once we're shutting down it only gets pipeDrainTimeout
+// before they're closed on it.
+func (s *supervisor) drain(consumed chan struct{}, pipes []io.Closer) {
+	select {
+	case <-consumed:
+		return
+	case <-s.stopping:
+	}
+	select {
+	case <-consumed:
+	case <-logging.Clock.After(s.pipeDrainTimeout):
+		log.Println("Gave up reading the child's output after", s.pipeDrainTimeout, "since it exited")
+		for _, pipe := range pipes {
+			pipe.Close()
+		}
+		<-consumed
+	}
+}
+
+// describeExit says how the child exited, and gives its exit code, or as
+// shells do, 128 plus the number of the signal which killed it
+func describeExit(status syscall.WaitStatus) (string, int) {
+	if status.Signaled() {
+		return "signal: " + status.Signal().String(), 128 + int(status.Signal())
+	}
+	return fmt.Sprintf("exit status %d", status.ExitStatus()), status.ExitStatus()
+}
+
+// exitCode is what haberdasher exits with once the child has: the child's own
+// exit code, so restart policies and pipelines see how it went, or 1 if that
+// couldn't be collected
+func (s *supervisor) exitCode() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.exitCodeLocked()
+}
+
+// collectedExitCode is exitCode for a child which has exited, even if its
+// output is still being read, reporting whether it has
+func (s *supervisor) collectedExitCode() (int, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.exitCodeLocked(), s.collected
+}
+
+func (s *supervisor) exitCodeLocked() int {
+	switch {
+	case s.piped || s.lastExit == "":
+		return 0
+	case s.lastExitCode < 0:
+		return 1
+	}
+	return s.lastExitCode
+}
+
+// consume ships the lines of the child's stderr, and of its stdout if that's
+// captured, until they close. Both streams are scanned at once, each line
+// tagged with the stream it came from, and stdout is still mirrored to ours.
+// Lines written to both within HABERDASHER_DEDUP_WINDOW are shipped once, and
+// HABERDASHER_INTERLEAVE ships them in the order they were read.
+// Stdout which is only mirrored isn't shipped.
+func (s *supervisor) consume(stderr io.Reader, stdout io.Reader) {
+	if stdout == nil {
+		s.scan(stderr, logging.Source{}, s.queue.Push)
+		return
+	}
+	if !s.captureStdout {
+		mirrored := make(chan struct{})
+		go func() {
+			mirrorLines(stdout)
+			close(mirrored)
+		}()
+		s.scan(stderr, logging.Source{}, s.queue.Push)
+		<-mirrored
+		return
+	}
+	handle := s.queue.Push
+	var dedup *logging.Deduplicator
+	if s.dedupWindow > 0 {
+		dedup = logging.NewDeduplicator(s.dedupWindow, s.queue.Push)
+		handle = dedup.Add
+	}
+	var interleaver *logging.Interleaver
+	if s.interleave > 0 {
+		interleaver = logging.NewInterleaver(s.interleave, s.queue.PushAt)
+		stdout = interleaver.Watch("stdout", stdout)
+		stderr = interleaver.Watch("stderr", stderr)
+		handle = interleaver.Add
+	}
+	var scanners sync.WaitGroup
+	scanners.Add(2)
+	go func() {
+		s.scan(stdout, logging.Source{Stream: "stdout"}, func(source logging.Source, line string) {
+			logging.WriteStdout(line)
+			handle(source, line)
+		})
+		scanners.Done()
+	}()
+	go func() {
+		s.scan(stderr, logging.Source{Stream: "stderr"}, handle)
+		scanners.Done()
+	}()
+	scanners.Wait()
+	if dedup != nil {
+		dedup.Flush()
+	}
+	if interleaver != nil {
+		interleaver.Flush()
+	}
+}
+
+// parseStreams reads HABERDASHER_STREAMS, the comma separated streams of the
+// child's to ship, and reports whether stdout is one. Stderr always is, since
+// everything else hangs off it.
+func parseStreams(streams string) (bool, error) {
+	stdout, stderr := false, false
+	for _, stream := range strings.Split(streams, ",") {
+		switch strings.TrimSpace(stream) {
+		case "stdout":
+			stdout = true
+		case "stderr":
+			stderr = true
+		default:
+			return false, fmt.Errorf("unknown stream %q", stream)
+		}
+	}
+	if !stderr {
+		return false, fmt.Errorf("stderr is always shipped, so must be listed")
+	}
+	return stdout, nil
+}
+
+// mirrorLines copies the lines of the child's stdout to ours. They aren't
+// scanned, so there's no limit to how long they can be.
+func mirrorLines(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			logging.WriteStdout(strings.TrimSuffix(line, "\n"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// scan hands each line read from one of the child's streams to handle, or
+// each record, if HABERDASHER_MULTILINE joins lines into them. A panic
+// handling one line doesn't stop us reading the rest, which would leave the
+// child blocked on a full pipe.
+func (s *supervisor) scan(stream io.Reader, source logging.Source, handle func(source logging.Source, line string)) {
+	add := func(line string) { handle(source, line) }
+	var assembler *multiline.Assembler
+	if s.multiline != nil {
+		// Records held too long are completed by a timer, outside the
+		// reader's recovery
+		emit := add
+		assembler = multiline.New(s.multiline, s.recordLimits, func(record string) {
+			logging.Recover("the reader", func() { emit(record) })
+		})
+		add = assembler.Add
+	}
+	scanner := logging.NewScanner(stream)
+	for scanner.Scan() {
+		line := scanner.Text()
+		logging.Recover("the reader", func() {
+			s.readiness.observe(line)
+			add(line)
+		})
+	}
+	// The stream closing, usually because the child exited, completes the
+	// last record; there's no line coming to do it
+	if assembler != nil {
+		logging.Recover("the reader", assembler.Flush)
+	}
+}
+
+// growPipe enlarges the kernel buffer of a pipe from the child, if configured,
+// so bursts of output don't block the child before we can drain them
+func (s *supervisor) growPipe(pipe interface{}) {
+	if s.pipeBuffer == 0 {
+		return
+	}
+	size, err := setPipeSize(pipe, s.pipeBuffer)
+	if err != nil {
+		log.Println("Warning: couldn't resize the child's pipe buffer:", err)
+	} else if size < s.pipeBuffer {
+		log.Println("Warning: the child's pipe buffer is only", size, "bytes")
+	}
+}
+
+func (s *supervisor) emit(source logging.Source, received time.Time, line string) {
+	emitter := s.emitter
+	s.sample.add(line)
+	s.policyLock.RLock()
+	virtual := classify(s.virtual, line)
+	s.policyLock.RUnlock()
+	if virtual != nil {
+		source.Dataset = virtual.Name
+		source.Labels = virtual.Labels
+		emitter = virtual.emitter
+	}
+	if emitter != nil {
+		logging.EmitAt(emitter, source, received, received, line)
+	} else {
+		logging.Drop(line)
+	}
+	// Still want to send logs to console with non-console emitters
+	if s.echo && source.Stream != "stdout" {
+		consoleEcho.Println(logging.Redact(line))
+	}
+}
+
+// ptyOutput reads the child's terminal. Once every process holding its slave
+// end has closed it, reads fail with EIO, which is the end of the output, as
+// EOF is for a pipe.
+type ptyOutput struct {
+	*os.File
+}
+
+func (p ptyOutput) Read(b []byte) (int, error) {
+	n, err := p.File.Read(b)
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EIO {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// resizeTerminal passes a change in our terminal's size on to the child's
+func (s *supervisor) resizeTerminal() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.terminal != nil {
+		if err := resizePTY(s.terminal); err != nil {
+			log.Println("Couldn't resize the child's terminal:", err)
+		}
+	}
+}
+
+// A childStatus is the supervisor's state as reported over the admin API
+type childStatus struct {
+	Pid           int      `json:"pid"`
+	Running       bool     `json:"running"`
+	Command       []string `json:"command"`
+	Started       string   `json:"started,omitempty"`
+	UptimeSeconds float64  `json:"uptime_seconds"`
+	Restarts      int      `json:"restarts"`
+	LastExit      string   `json:"last_exit,omitempty"`
+	ExitCode      *int     `json:"exit_code,omitempty"`
+}
+
+// Status reports on the child
+func (s *supervisor) Status() childStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status := childStatus{
+		Pid:      s.pid,
+		Running:  s.pid > 0,
+		Command:  s.argv,
+		Restarts: s.restarts,
+		LastExit: s.lastExit,
+	}
+	if s.lastExit != "" && s.lastExitCode >= 0 {
+		code := s.lastExitCode
+		status.ExitCode = &code
+	}
+	if !s.started.IsZero() {
+		status.Started = s.started.Format(time.RFC3339)
+		if status.Running {
+			status.UptimeSeconds = logging.Clock.Since(s.started).Seconds()
+		}
+	}
+	return status
+}
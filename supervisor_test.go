@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// The child's exit code is known as soon as it's collected, before its
+// output has been read, so shutting down then still exits with it
+func TestCollectedExitCode(t *testing.T) {
+	s := &supervisor{}
+	if _, collected := s.collectedExitCode(); collected {
+		t.Fatal("a child which hasn't run has been collected")
+	}
+	cmd := exec.Command("sh", "-c", "exit 3")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := cmd.Process.Wait()
+	s.collect(cmd.Process.Pid, state, err)
+	if code, collected := s.collectedExitCode(); !collected || code != 3 {
+		t.Errorf("collectedExitCode() = %d, %v, want 3, true", code, collected)
+	}
+}
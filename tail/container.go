@@ -0,0 +1,102 @@
+package tail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A Line is a single log line decoded from a container runtime's log file
+type Line struct {
+	Time   time.Time
+	Stream string
+	Log    string
+}
+
+// A Decoder turns the lines of a log file into Lines. Container runtimes split
+// long lines into several partial records, so a Decoder holds on to partial
+// records until the line is complete.
+type Decoder struct {
+	format  string
+	partial strings.Builder
+}
+
+// NewDecoder creates a Decoder for one of the supported log file formats:
+// "raw" for plain text, "docker" for the Docker json-file driver, and "cri"
+// for the CRI format written by cri-o and containerd.
+func NewDecoder(format string) (*Decoder, error) {
+	switch format {
+	case "raw", "docker", "cri":
+		return &Decoder{format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown log file format %q", format)
+}
+
+// Decode returns the decoded line, and whether it is complete. Incomplete
+// lines have been buffered and will be returned with a later record.
+func (d *Decoder) Decode(record string) (Line, bool, error) {
+	switch d.format {
+	case "docker":
+		return d.decodeDocker(record)
+	case "cri":
+		return d.decodeCRI(record)
+	}
+	return Line{Time: time.Now(), Log: record}, true, nil
+}
+
+// Docker json-file records look like
+//
+//	{"log":"message\n","stream":"stderr","time":"2020-10-29T22:41:58.020345Z"}
+//
+// and lines too long for one record are split across several, with only the
+// last ending in a newline.
+func (d *Decoder) decodeDocker(record string) (Line, bool, error) {
+	var decoded struct {
+		Log    string    `json:"log"`
+		Stream string    `json:"stream"`
+		Time   time.Time `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(record), &decoded); err != nil {
+		return Line{}, false, err
+	}
+	d.partial.WriteString(decoded.Log)
+	if !strings.HasSuffix(decoded.Log, "\n") {
+		return Line{}, false, nil
+	}
+	return d.complete(decoded.Time, decoded.Stream), true, nil
+}
+
+// CRI records look like
+//
+//	2020-10-29T22:41:58.020345Z stderr F message
+//
+// where the third field is F for a full line or P for a partial one.
+func (d *Decoder) decodeCRI(record string) (Line, bool, error) {
+	fields := strings.SplitN(record, " ", 4)
+	if len(fields) < 3 {
+		return Line{}, false, errors.New("malformed CRI log record")
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return Line{}, false, err
+	}
+	if len(fields) == 4 {
+		d.partial.WriteString(fields[3])
+	}
+	if fields[2] == "P" {
+		return Line{}, false, nil
+	}
+	return d.complete(timestamp, fields[1]), true, nil
+}
+
+func (d *Decoder) complete(timestamp time.Time, stream string) Line {
+	line := Line{
+		Time:   timestamp,
+		Stream: stream,
+		Log:    strings.TrimRight(d.partial.String(), "\r\n"),
+	}
+	d.partial.Reset()
+	return line
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package tail
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode identifies the file behind f, so we can tell when a path has been
+// replaced by a different file
+func inode(f *os.File) uint64 {
+	info, err := f.Stat()
+	if err != nil {
+		return 0
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
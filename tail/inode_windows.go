@@ -0,0 +1,9 @@
+package tail
+
+import "os"
+
+// inode identifies the file behind f. Windows has no inodes, so checkpoints
+// there can't tell when a path has been replaced by a different file.
+func inode(f *os.File) uint64 {
+	return 0
+}
@@ -0,0 +1,302 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/checkpoint"
+	"github.com/RedHatInsights/haberdasher/clock"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// DefaultPollInterval is how often files are checked for new lines when
+// polling. With file notifications, it's how often we check regardless.
+const DefaultPollInterval = 250 * time.Millisecond
+
+// With file notifications working, we still check this often in case an
+// event was missed
+const notifiedCheckInterval = 5 * time.Second
+
+// A watcher waits for something to happen to a followed file
+type watcher interface {
+	wait(fallback time.Duration, stop <-chan struct{})
+	close()
+}
+
+// A poller is the watcher for when file notifications aren't available, or
+// don't work, as on NFS and some overlayfs setups
+type poller struct {
+	interval time.Duration
+	clock    clock.Clock
+}
+
+func (p poller) wait(fallback time.Duration, stop <-chan struct{}) {
+	select {
+	case <-p.clock.After(p.interval):
+	case <-stop:
+	}
+}
+
+func (p poller) close() {}
+
+// Options control how a file is followed
+type Options struct {
+	// FromStart reads whatever is already in the file, rather than only what
+	// is appended after we start following it
+	FromStart bool
+	// Truncate empties the file every time we've caught up with it, so the
+	// application's own log files don't grow without bound once their
+	// contents have been shipped. This relies on the application opening the
+	// file with O_APPEND; anything written between our last read and the
+	// truncation is lost, so it's best suited to applications that already
+	// tolerate losing their local log files. It implies FromStart.
+	Truncate bool
+	// StopWhenRemoved stops following once the file no longer exists, rather
+	// than waiting for it to come back
+	StopWhenRemoved bool
+	// Poll checks the file on an interval rather than waiting for file
+	// notifications (inotify), for filesystems where they don't work. Polling
+	// is also used automatically if notifications can't be set up.
+	Poll bool
+	// PollInterval is how often to check the file when polling, defaulting to
+	// DefaultPollInterval
+	PollInterval time.Duration
+	// Checkpoints, if set, records how far through the file we've shipped,
+	// keyed by its path, and resumes from there if we're restarted. A
+	// checkpoint for a different file at the same path is ignored and the
+	// new file read from the start.
+	Checkpoints *checkpoint.Checkpointer
+	// Stop, once closed, makes Follow return after the line it's handling.
+	// The rest of the file is left unread, and untruncated, with the
+	// checkpoint pointing at it.
+	Stop <-chan struct{}
+	// Clock times polling and rotation. Nil means the real clock.
+	Clock clock.Clock
+	// MaxLineBytes, if set, is the longest line handled whole. A longer one
+	// is cut short, as logging.NewScanner cuts them, and only that much of it
+	// is ever held in memory.
+	MaxLineBytes int
+}
+
+// How long a rotated file is still read before moving on to its replacement,
+// since applications usually keep writing to the old one until they're told
+// to reopen their log
+const rotateWait = 5 * time.Second
+
+// Follow tails the file at path, handing each complete line to handle. It
+// copes with the file not existing yet, with it being truncated out from
+// under us, and with it being rotated. Unless StopWhenRemoved or Stop is set,
+// it never returns.
+func Follow(path string, opts Options, handle func(line string)) {
+	var offset int64 = -1
+	if opts.FromStart || opts.Truncate {
+		offset = 0
+	}
+	flag := os.O_RDONLY
+	if opts.Truncate {
+		flag = os.O_RDWR
+	}
+	interval := opts.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real
+	}
+	var w watcher = poller{interval, opts.Clock}
+	fallback := interval
+	if !opts.Poll {
+		if notified, err := newWatcher(path, opts.Clock); err != nil {
+			log.Println("File notifications unavailable for", path+", polling instead:", err)
+		} else {
+			w = notified
+			fallback = notifiedCheckInterval
+		}
+	}
+	defer w.close()
+
+	var resume *checkpoint.Position
+	if opts.Checkpoints != nil {
+		if position, ok := opts.Checkpoints.Get(path); ok {
+			resume = &position
+		}
+	}
+
+	// The file is kept open between reads so that, when it's rotated away
+	// (renamed, with a new file created in its place, as logrotate does), we
+	// can still read whatever the application writes to it before it reopens
+	// its log, and only then move on to the new one
+	var f *os.File
+	var ino uint64
+	var partial pendingLine
+	var rotated time.Time
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	for !stopped(opts.Stop) {
+		if f == nil {
+			opened, err := os.OpenFile(path, flag, 0)
+			if err != nil {
+				if os.IsNotExist(err) && opts.StopWhenRemoved {
+					if opts.Checkpoints != nil {
+						opts.Checkpoints.Delete(path)
+					}
+					return
+				}
+				if !os.IsNotExist(err) {
+					log.Println("Error opening", path+":", err)
+				}
+				// A file that shows up later should be read from the start
+				offset = 0
+				w.wait(fallback, opts.Stop)
+				continue
+			}
+			f, ino = opened, inode(opened)
+			if resume != nil {
+				offset = 0
+				if info, err := f.Stat(); err == nil && resume.Inode == ino && resume.Offset <= info.Size() {
+					offset = resume.Offset
+				}
+				resume = nil
+			}
+		}
+		offset, partial = drain(f, path, offset, partial, opts.Truncate, opts.MaxLineBytes, opts.Stop, handle)
+		if opts.Checkpoints != nil && offset >= 0 {
+			// A partial line will be read again if we're restarted
+			opts.Checkpoints.Set(path, checkpoint.Position{Offset: offset - partial.size, Inode: ino})
+		}
+		w.wait(fallback, opts.Stop)
+
+		if rotated.IsZero() {
+			if replaced(path, f) {
+				log.Println(path, "was rotated or removed, finishing the old file first")
+				rotated = opts.Clock.Now()
+			}
+			continue
+		}
+		if opts.Clock.Since(rotated) < rotateWait {
+			continue
+		}
+		// The last lines written to the old file come before the new file's
+		offset, partial = drain(f, path, offset, partial, false, opts.MaxLineBytes, opts.Stop, handle)
+		if stopped(opts.Stop) {
+			return
+		}
+		if partial.size > 0 {
+			// Nothing will finish it now
+			handle(partial.line(opts.MaxLineBytes))
+		}
+		f.Close()
+		f, offset, partial, rotated = nil, 0, pendingLine{}, time.Time{}
+	}
+}
+
+// replaced reports whether path no longer names the file f has open
+func replaced(path string, f *os.File) bool {
+	current, err := os.Stat(path)
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+	opened, err := f.Stat()
+	return err == nil && !os.SameFile(current, opened)
+}
+
+// A pendingLine is the start of a line still waiting for its newline. Of a
+// line longer than max bytes, only enough is kept to cut it short.
+type pendingLine struct {
+	text string
+	// How much of the file it takes up, which is more than text once it's
+	// been cut short
+	size int64
+}
+
+func (p *pendingLine) add(chunk []byte, max int) {
+	p.size += int64(len(chunk))
+	if max > 0 && len(p.text)+len(chunk) > max+1 {
+		// One byte more than max tells a line which is too long
+		chunk = chunk[:max+1-len(p.text)]
+	}
+	p.text += string(chunk)
+}
+
+// line returns the line without its line ending, cut short if it's longer
+// than max bytes
+func (p pendingLine) line(max int) string {
+	line := strings.TrimRight(p.text, "\r\n")
+	if max > 0 && len(line) > max {
+		return string(logging.TruncateLine([]byte(line), max))
+	}
+	return line
+}
+
+// drain reads everything appended to f since offset, returning the new offset
+// and any trailing partial line still waiting for its newline. Once stop is
+// closed, it returns without reading any further.
+func drain(f *os.File, path string, offset int64, partial pendingLine, truncate bool, max int, stop <-chan struct{}, handle func(line string)) (int64, pendingLine) {
+	info, err := f.Stat()
+	if err != nil {
+		log.Println("Error reading", path+":", err)
+		return offset, partial
+	}
+	size := info.Size()
+	if offset < 0 {
+		offset = size
+	}
+	if size < offset {
+		log.Println(path, "was truncated, reading from the beginning")
+		offset = 0
+		partial = pendingLine{}
+	}
+	if size == offset {
+		return offset, partial
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Println("Error reading", path+":", err)
+		return offset, partial
+	}
+	reader := bufio.NewReader(f)
+	for {
+		if stopped(stop) {
+			return offset, partial
+		}
+		// ReadSlice reads no more than fits in the reader's buffer at a time,
+		// so a long line is never read whole
+		chunk, err := reader.ReadSlice('\n')
+		offset += int64(len(chunk))
+		partial.add(chunk, max)
+		if bytes.HasSuffix(chunk, []byte{'\n'}) {
+			handle(partial.line(max))
+			partial = pendingLine{}
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			break
+		}
+	}
+
+	if truncate && partial.size == 0 {
+		if err := f.Truncate(0); err != nil {
+			log.Println("Error truncating", path+":", err)
+		} else {
+			offset = 0
+		}
+	}
+	return offset, partial
+}
+
+// stopped reports whether stop has been closed
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,43 @@
+package tail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDrainCutsLongLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	long := strings.Repeat("x", 10000)
+	if err := ioutil.WriteFile(path, []byte("short\r\n"+"0123456789\n"+long+"\n"+"after\n"+long), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []string
+	offset, partial := drain(f, path, 0, pendingLine{}, false, 10, nil, func(line string) { lines = append(lines, line) })
+	want := []string{"short", "0123456789", "xxxxxxxxxx [truncated: line exceeded 10 bytes]", "after"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines %q, want %q", lines, want)
+	}
+	// The unfinished line is remembered from its start, but without keeping
+	// all of it
+	if info, _ := f.Stat(); offset != info.Size() || partial.size != int64(len(long)) || len(partial.text) != 11 {
+		t.Errorf("offset %d, pending line of %d bytes keeping %d", offset, partial.size, len(partial.text))
+	}
+	if line := partial.line(10); line != "xxxxxxxxxx [truncated: line exceeded 10 bytes]" {
+		t.Errorf("pending line %q", line)
+	}
+}
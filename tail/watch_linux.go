@@ -0,0 +1,61 @@
+package tail
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+// An inotifyWatcher wakes the follower as soon as anything happens in the
+// file's directory. Watching the directory rather than the file itself also
+// catches the file being created, removed, or replaced.
+type inotifyWatcher struct {
+	file   *os.File
+	events chan struct{}
+	clock  clock.Clock
+}
+
+func newWatcher(path string, c clock.Clock) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(syscall.IN_MODIFY | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB)
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), mask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// A non-blocking fd goes through the runtime's poller, so closing the
+	// file unblocks the reader below
+	w := &inotifyWatcher{file: os.NewFile(uintptr(fd), "inotify"), events: make(chan struct{}, 1), clock: c}
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			if _, err := w.file.Read(buf); err != nil {
+				return
+			}
+			select {
+			case w.events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return w, nil
+}
+
+func (w *inotifyWatcher) wait(fallback time.Duration, stop <-chan struct{}) {
+	// Wake up now and then regardless, in case an event was missed
+	select {
+	case <-w.events:
+	case <-w.clock.After(fallback):
+	case <-stop:
+	}
+}
+
+func (w *inotifyWatcher) close() {
+	w.file.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package tail
+
+import (
+	"errors"
+
+	"github.com/RedHatInsights/haberdasher/clock"
+)
+
+func newWatcher(path string, c clock.Clock) (watcher, error) {
+	return nil, errors.New("file notifications are only supported on Linux")
+}
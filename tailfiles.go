@@ -0,0 +1,214 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/checkpoint"
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/tail"
+)
+
+// tailOptions are the settings shared by everything that follows files.
+// File notifications (inotify) are used to notice new lines unless
+// HABERDASHER_TAIL_POLL is set, for filesystems like NFS where they don't
+// work; polling is used automatically if they can't be set up. When polling,
+// files are checked every HABERDASHER_TAIL_POLL_INTERVAL.
+func tailOptions() tail.Options {
+	var opts tail.Options
+	opts.Checkpoints = checkpoints
+	opts.Stop = producers.stopping()
+	opts.Clock = logging.Clock
+	opts.MaxLineBytes = logging.MaxLineBytes
+	opts.Poll = config.FlagSetting("HABERDASHER_TAIL_POLL")
+	opts.PollInterval, _ = config.DurationSetting("HABERDASHER_TAIL_POLL_INTERVAL")
+	return opts
+}
+
+// How often glob patterns in HABERDASHER_TAIL_FILES are checked for new files
+const tailGlobInterval = 10 * time.Second
+
+// How often a follower checks it's still the leader, or has become it
+var leadershipCheckInterval = time.Second
+
+// Some applications insist on writing their own log files. If
+// HABERDASHER_TAIL_FILES lists their paths (comma separated), follow each one
+// and ship its lines just like the child's stderr. Setting
+// HABERDASHER_TAIL_TRUNCATE truncates the files once they've been shipped.
+//
+// Entries may also be glob patterns, like /var/log/app/*.log. Files matching
+// them are picked up as they appear, and followed until they're removed.
+//
+// HABERDASHER_TAIL_FORMAT selects how the files are decoded. Besides plain
+// text, the log files written by container runtimes are understood, so
+// haberdasher can also run as a node-level collector with no child at all.
+// Files are only followed while shouldShip returns true, see
+// startLeaderElection: other replicas mustn't read, let alone truncate, what
+// the leader hasn't shipped yet. Returns whether any files are being tailed.
+func startFileTails(emitter logging.Emitter, shouldShip func() bool) bool {
+	pathsFromEnv, exists := config.Setting("HABERDASHER_TAIL_FILES")
+	if !exists {
+		return false
+	}
+	truncate := config.FlagSetting("HABERDASHER_TAIL_TRUNCATE")
+	format, _ := config.Setting("HABERDASHER_TAIL_FORMAT")
+	if _, err := tail.NewDecoder(format); err != nil {
+		log.Fatal("HABERDASHER_TAIL_FORMAT must be one of raw, docker, or cri")
+	}
+
+	f := &fileFollower{emitter: emitter, shouldShip: shouldShip, checkpoints: checkpoints, format: format, truncate: truncate}
+
+	var patterns []string
+	for _, path := range strings.Split(pathsFromEnv, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if isGlob(path) {
+			if _, err := filepath.Match(path, ""); err != nil {
+				log.Fatal("HABERDASHER_TAIL_FILES: invalid pattern ", path, ": ", err)
+			}
+			log.Println("Tailing log files matching:", path)
+			patterns = append(patterns, path)
+			continue
+		}
+		log.Println("Tailing log file:", path)
+		path := path
+		producers.start(func() { f.follow(path, false, false) })
+	}
+	if len(patterns) > 0 {
+		producers.start(func() { followGlobs(patterns, f.follow) })
+	}
+	return true
+}
+
+// A fileFollower follows tailed files while this replica should ship them
+type fileFollower struct {
+	emitter     logging.Emitter
+	shouldShip  func() bool
+	checkpoints *checkpoint.Checkpointer
+	format      string
+	truncate    bool
+}
+
+// follow ships path's lines whenever this replica is the leader, until
+// shutdown, or until it's removed if stopWhenRemoved. Its checkpoint is read
+// back from the store each time leadership is gained, since the checkpoints
+// are shared and the previous leader will have moved it on since we last had
+// it; while we aren't the leader, we don't save them at all.
+func (f *fileFollower) follow(path string, fromStart bool, stopWhenRemoved bool) {
+	for {
+		done := make(chan struct{})
+		lost, leading := awaitLeadership(f.shouldShip, done)
+		if !leading {
+			return
+		}
+		if f.checkpoints != nil {
+			if err := f.checkpoints.Refresh(path); err != nil {
+				log.Println("Unable to read the checkpoint for", path+", resuming from our own:", err)
+			}
+		}
+		decoder, _ := tail.NewDecoder(f.format)
+		opts := tailOptions()
+		opts.Checkpoints = f.checkpoints
+		opts.Truncate = f.truncate
+		opts.FromStart = fromStart
+		opts.StopWhenRemoved = stopWhenRemoved
+		opts.Stop = lost
+		tail.Follow(path, opts, func(record string) {
+			logging.Recover("the tail of "+path, func() {
+				line, complete, err := decoder.Decode(record)
+				if err != nil {
+					log.Println("Error decoding", path+":", err)
+					return
+				}
+				if complete && f.shouldShip() {
+					source := logging.Source{Path: path, Stream: line.Stream}
+					logging.EmitAt(f.emitter, source, line.Time, logging.Clock.Now(), line.Log)
+				}
+			})
+		})
+		close(done)
+		if !f.shouldShip() && !isClosed(producers.stopping()) {
+			log.Println("Stopped tailing", path, "until this replica is the leader again")
+			fromStart = false
+			continue
+		}
+		// Shutting down, or the file was removed
+		return
+	}
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// followGlobs follows every file matching the patterns, checking for new ones
+// every tailGlobInterval, until shutdown. As with pods, files which already exist when we
+// start are followed from their end (or their checkpoint), and anything that
+// shows up afterwards is new and read from the start.
+func followGlobs(patterns []string, follow func(path string, fromStart bool, stopWhenRemoved bool)) {
+	var lock sync.Mutex
+	following := make(map[string]bool)
+	fromStart := false
+	for {
+		for _, pattern := range patterns {
+			paths, _ := filepath.Glob(pattern)
+			for _, path := range paths {
+				lock.Lock()
+				known := following[path]
+				following[path] = true
+				lock.Unlock()
+				if known {
+					continue
+				}
+				if fromStart {
+					log.Println("Tailing new log file:", path)
+				}
+				path, fromStart := path, fromStart
+				producers.start(func() {
+					follow(path, fromStart, true)
+					lock.Lock()
+					delete(following, path)
+					lock.Unlock()
+				})
+			}
+		}
+		fromStart = true
+		if !producers.sleep(tailGlobInterval) {
+			return
+		}
+	}
+}
+
+// awaitLeadership waits until this replica should ship, returning a channel
+// which is closed once it no longer should, or shutdown begins. It returns
+// false instead if shutdown begins while waiting. Closing done stops watching.
+func awaitLeadership(shouldShip func() bool, done <-chan struct{}) (<-chan struct{}, bool) {
+	for !shouldShip() {
+		if !producers.sleep(leadershipCheckInterval) {
+			return nil, false
+		}
+	}
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		for producers.sleep(leadershipCheckInterval) && shouldShip() && !isClosed(done) {
+		}
+	}()
+	return lost, true
+}
+
+// isClosed reports whether a channel used as a signal has been closed
+func isClosed(signal <-chan struct{}) bool {
+	select {
+	case <-signal:
+		return true
+	default:
+		return false
+	}
+}
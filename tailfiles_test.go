@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/checkpoint"
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// A memoryStore is a Store shared by replicas in the same process, as a
+// ConfigMap is by replicas in a cluster
+type memoryStore struct {
+	lock      sync.Mutex
+	positions map[string]checkpoint.Position
+}
+
+func (s *memoryStore) Load() (map[string]checkpoint.Position, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	positions := make(map[string]checkpoint.Position, len(s.positions))
+	for k, v := range s.positions {
+		positions[k] = v
+	}
+	return positions, nil
+}
+
+func (s *memoryStore) Save(positions map[string]checkpoint.Position) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.positions = positions
+	return nil
+}
+
+// A recorder is an Emitter keeping the messages it's handed
+type recorder struct {
+	lock     sync.Mutex
+	messages []string
+}
+
+func (r *recorder) Setup() {}
+
+func (r *recorder) HandleLogMessage(message interface{}) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.messages = append(r.messages, message.(logging.Message).Message)
+	return nil
+}
+
+func (r *recorder) Cleanup() error { return nil }
+
+func (r *recorder) shipped() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.messages...)
+}
+
+// Two replicas follow the same file, handing leadership back and forth, and
+// each line is shipped once, by whichever was the leader when it was written
+func TestFollowHandsOverLeadership(t *testing.T) {
+	defer func(interval time.Duration) { leadershipCheckInterval = interval }(leadershipCheckInterval)
+	leadershipCheckInterval = 10 * time.Millisecond
+	defer func(group *producerGroup) { producers = group }(producers)
+	producers = &producerGroup{stop: make(chan struct{})}
+	defer producers.halt()
+
+	dir, err := ioutil.TempDir("", "tailfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	write := func(lines ...string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			fmt.Fprintln(f, line)
+		}
+		f.Close()
+	}
+	write("line 1", "line 2", "line 3")
+
+	store := &memoryStore{}
+	shipped := &recorder{}
+	var leader int32
+	replica := func(id int32) (*fileFollower, *checkpoint.Checkpointer) {
+		checkpoints, err := checkpoint.New(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leading := func() bool { return atomic.LoadInt32(&leader) == id }
+		checkpoints.SaveWhile(leading)
+		return &fileFollower{emitter: shipped, shouldShip: leading, checkpoints: checkpoints, format: "raw"}, checkpoints
+	}
+	a, aCheckpoints := replica(1)
+	b, bCheckpoints := replica(2)
+	atomic.StoreInt32(&leader, 1)
+	producers.start(func() { a.follow(path, true, false) })
+	producers.start(func() { b.follow(path, true, false) })
+
+	awaitShipped := func(want ...string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(shipped.shipped()) < len(want) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		// Long enough for a duplicate to show up
+		time.Sleep(100 * time.Millisecond)
+		if got := shipped.shipped(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("shipped %q, want %q", got, want)
+		}
+	}
+	awaitShipped("line 1", "line 2", "line 3")
+	if err := aCheckpoints.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&leader, 2)
+	write("line 4", "line 5")
+	awaitShipped("line 1", "line 2", "line 3", "line 4", "line 5")
+	if err := bCheckpoints.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// What a follower saw while it wasn't the leader isn't saved
+	saved, _ := store.Load()
+	if err := aCheckpoints.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := store.Load(); !reflect.DeepEqual(after, saved) || len(saved) != 1 {
+		t.Fatalf("a follower's flush changed the checkpoints from %v to %v", saved, after)
+	}
+
+	atomic.StoreInt32(&leader, 1)
+	write("line 6")
+	awaitShipped("line 1", "line 2", "line 3", "line 4", "line 5", "line 6")
+}
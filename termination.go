@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+)
+
+// A terminationReason tells the child why it's being stopped, and by when it
+// has to be gone
+type terminationReason struct {
+	Reason   string     `json:"reason"`
+	Signal   string     `json:"signal,omitempty"`
+	Received time.Time  `json:"received"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+// writeTerminationReason writes the reason to HABERDASHER_TERMINATION_FILE, if
+// set, before the child is signalled, so it can log or act on it. The file is
+// replaced atomically so the child never reads half of it.
+func writeTerminationReason(reason terminationReason) {
+	path, exists := config.Setting("HABERDASHER_TERMINATION_FILE")
+	if !exists {
+		return
+	}
+	encoded, err := json.Marshal(reason)
+	if err != nil {
+		return
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), ".termination")
+	if err == nil {
+		_, err = temp.Write(append(encoded, '\n'))
+		if closeErr := temp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			os.Chmod(temp.Name(), 0644)
+			err = os.Rename(temp.Name(), path)
+		}
+		if err != nil {
+			os.Remove(temp.Name())
+		}
+	}
+	if err != nil {
+		log.Println("Error writing termination reason:", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/multiline"
+)
+
+// testRules runs `haberdasher test-rules [--config fragment.yaml]`: each
+// message read from stdin, its lines joined as HABERDASHER_SPLITTER says, is
+// put through the redactions and virtual sources configured in the environment
+// and policy directory, with the fragment's rules merged over them, and what
+// matched is printed instead of anything being shipped
+func testRules(args []string, input io.Reader, output io.Writer) int {
+	var configPath string
+	for len(args) > 0 {
+		switch {
+		case args[0] == "--config" && len(args) > 1:
+			configPath, args = args[1], args[2:]
+		case strings.HasPrefix(args[0], "--config="):
+			configPath, args = strings.TrimPrefix(args[0], "--config="), args[1:]
+		default:
+			log.Fatal("Usage: haberdasher test-rules [--config fragment.yaml] < sample.log")
+		}
+	}
+
+	redactions, sources, err := readPolicies()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if configPath != "" {
+		// The fragment is read like any --config file, YAML, TOML, or JSON,
+		// and checked against HABERDASHER_SIGNING_KEY the same way
+		document, err := config.ReadFile(configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		contents, err := json.Marshal(document)
+		if err != nil {
+			log.Fatalf("%s: %v", configPath, err)
+		}
+		fragment, err := decodeFragment(contents)
+		if err != nil {
+			log.Fatalf("%s: %v", configPath, err)
+		}
+		for _, redaction := range fragment.Redactions {
+			redactions = mergeRedaction(redactions, redaction)
+		}
+		for _, source := range fragment.VirtualSources {
+			sources = mergeVirtualSource(sources, source)
+		}
+	}
+	if err := logging.SetRedactions(redactions); err != nil {
+		log.Fatal("Invalid redactions: ", err)
+	}
+	if err := compileVirtualSources(sources); err != nil {
+		log.Fatal("Invalid virtual sources: ", err)
+	}
+	var readyPattern *regexp.Regexp
+	if pattern, exists := config.Setting("HABERDASHER_READY_PATTERN"); exists {
+		if readyPattern, err = regexp.Compile(pattern); err != nil {
+			log.Fatal("HABERDASHER_READY_PATTERN must be a valid regular expression: ", err)
+		}
+	}
+
+	messages := 0
+	matches := make(map[string]int)
+	check := func(line string) {
+		messages++
+		fmt.Fprintf(output, "%d: %s\n", messages, strings.Replace(line, "\n", "\n   ", -1))
+
+		source := logging.Source{}
+		if virtual := classify(sources, line); virtual == nil {
+			fmt.Fprintln(output, "  virtual source: none")
+		} else if virtual.Emitter == "drop" {
+			fmt.Fprintln(output, "  virtual source:", virtual.Name, "(dropped)")
+			matches["virtual source "+virtual.Name]++
+			return
+		} else {
+			fmt.Fprintln(output, "  virtual source:", virtual.Name)
+			matches["virtual source "+virtual.Name]++
+			source.Dataset = virtual.Name
+			source.Labels = virtual.Labels
+		}
+
+		_, rules := logging.RedactExplained(line)
+		if len(rules) == 0 {
+			fmt.Fprintln(output, "  redactions: none")
+		} else {
+			fmt.Fprintln(output, "  redactions:", strings.Join(rules, ", "))
+		}
+		for _, rule := range rules {
+			matches["redaction "+rule]++
+		}
+		if readyPattern != nil && readyPattern.MatchString(line) {
+			fmt.Fprintln(output, "  ready pattern: matched")
+			matches["HABERDASHER_READY_PATTERN"]++
+		}
+
+		shipped, err := json.Marshal(logging.Preview(source, logging.Clock.Now(), line))
+		if err != nil {
+			fmt.Fprintln(output, "  shipped: unserializable:", err)
+			return
+		}
+		if level := logging.Severity(string(shipped)); level != "" {
+			fmt.Fprintln(output, "  level:", level)
+		}
+		fmt.Fprintln(output, "  shipped:", string(shipped))
+	}
+
+	// Lines are joined into messages as the child's would be
+	add := check
+	var assembler *multiline.Assembler
+	limits, err := multiline.LimitsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	limits.Clock = logging.Clock
+	if rule, err := multiline.FromEnv(); err != nil {
+		log.Fatal(err)
+	} else if rule != nil {
+		assembler = multiline.New(rule, limits, check)
+		add = assembler.Add
+	}
+	scanner := logging.NewScanner(input)
+	for scanner.Scan() {
+		add(scanner.Text())
+	}
+	if assembler != nil {
+		assembler.Flush()
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println("Error reading the sample:", err)
+		return 1
+	}
+
+	fmt.Fprintln(output)
+	fmt.Fprintln(output, messages, "messages")
+	for _, source := range sources {
+		fmt.Fprintf(output, "  virtual source %s: %d\n", source.Name, matches["virtual source "+source.Name])
+	}
+	for _, redaction := range redactions {
+		fmt.Fprintf(output, "  redaction %s: %d\n", redaction.Name, matches["redaction "+redaction.Name])
+	}
+	if readyPattern != nil {
+		fmt.Fprintf(output, "  HABERDASHER_READY_PATTERN: %d\n", matches["HABERDASHER_READY_PATTERN"])
+	}
+	return 0
+}
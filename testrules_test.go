@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+func TestTestRulesYAMLFragment(t *testing.T) {
+	defer logging.SetRedactions(nil)
+	path := filepath.Join(t.TempDir(), "new-rules.yaml")
+	fragment := `redactions:
+  - name: card
+    match: '[0-9]{16}'
+    replace: '[card]'
+`
+	if err := ioutil.WriteFile(path, []byte(fragment), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	input := strings.NewReader("paid with 4111111111111111\nnothing to see\n")
+	if code := testRules([]string{"--config", path}, input, &output); code != 0 {
+		t.Fatalf("test-rules exited with %d:\n%s", code, output.String())
+	}
+	for _, want := range []string{"redactions: card", "paid with [card]", "2 messages", "redaction card: 1"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("the output doesn't mention %q:\n%s", want, output.String())
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+var childMemoryUsage = metrics.NewGauge("haberdasher_child_memory_bytes", "Resident memory used by the child and its descendants.")
+var childCPUUsage = metrics.NewGauge("haberdasher_child_cpu_cores", "CPU used by the child and its descendants, in cores, over the last sample.")
+
+// A resourceLimit is what a threshold is a percentage of, and what to call it
+type resourceLimit struct {
+	amount float64
+	name   string
+}
+
+// startThresholds watches the memory and CPU used by the child and its
+// descendants, if HABERDASHER_CHILD_MEMORY_THRESHOLD or
+// HABERDASHER_CHILD_CPU_THRESHOLD is set: percentages of the container's
+// cgroup limits, or without one, of the machine. Crossing either sends a
+// warning event, and HABERDASHER_CHILD_THRESHOLD_SIGNAL to the child if set,
+// so there's warning before it's killed for running out of memory. Another
+// event marks usage dropping back below.
+func startThresholds(emitter logging.Emitter, child *supervisor) {
+	memoryThreshold, memorySet := config.PercentSetting("HABERDASHER_CHILD_MEMORY_THRESHOLD")
+	cpuThreshold, cpuSet := config.PercentSetting("HABERDASHER_CHILD_CPU_THRESHOLD")
+	if !memorySet && !cpuSet {
+		return
+	}
+	interval, _ := config.DurationSetting("HABERDASHER_CHILD_USAGE_INTERVAL")
+	var signal syscall.Signal
+	if name, exists := config.Setting("HABERDASHER_CHILD_THRESHOLD_SIGNAL"); exists {
+		var err error
+		if signal, err = parseSignal(name); err != nil {
+			log.Fatal("HABERDASHER_CHILD_THRESHOLD_SIGNAL: ", err)
+		}
+	}
+	crossed := func(action string, message string) {
+		logging.EmitEvent(emitter, action, message)
+		if signal != 0 {
+			if err := child.Signal(signal); err != nil {
+				log.Println("Error sending the threshold signal:", err)
+			}
+		}
+	}
+
+	producers.start(func() {
+		memoryOver, cpuOver := false, false
+		lastPid, lastCPU, lastSampled := 0, 0.0, logging.Clock.Now()
+		for producers.sleep(interval) {
+			pid := child.Pid()
+			if pid <= 0 {
+				lastPid = 0
+				continue
+			}
+			memory, cpu := 0, 0.0
+			for _, process := range descendantsOf(pid) {
+				memory += residentBytes(strconv.Itoa(process))
+				cpu += cpuSeconds(strconv.Itoa(process))
+			}
+			// A restarted child is a new process, which crosses the thresholds
+			// afresh
+			if pid != lastPid {
+				memoryOver, cpuOver = false, false
+			}
+			// A restarted child's CPU time starts again from nothing, and a
+			// descendant exiting takes its CPU time with it
+			cores := -1.0
+			if pid == lastPid && cpu >= lastCPU {
+				cores = (cpu - lastCPU) / logging.Clock.Since(lastSampled).Seconds()
+			}
+			lastPid, lastCPU, lastSampled = pid, cpu, logging.Clock.Now()
+			childMemoryUsage.Set(float64(memory))
+			if cores >= 0 {
+				childCPUUsage.Set(cores)
+			}
+
+			if limit := memoryLimit(); memorySet && limit.amount > 0 {
+				percent := 100 * float64(memory) / limit.amount
+				if over := percent > memoryThreshold; over && !memoryOver {
+					crossed("child-memory-threshold", fmt.Sprintf(
+						"The child's resident memory, %s, exceeded %g%% of %s, %s",
+						mebibytes(float64(memory)), memoryThreshold, limit.name, mebibytes(limit.amount)))
+				} else if !over && memoryOver {
+					logging.EmitEvent(emitter, "child-memory-threshold-cleared", fmt.Sprintf(
+						"The child's resident memory, %s, is back below %g%% of %s",
+						mebibytes(float64(memory)), memoryThreshold, limit.name))
+				}
+				memoryOver = percent > memoryThreshold
+			}
+			if cpuSet && cores >= 0 {
+				limit := cpuLimit()
+				percent := 100 * cores / limit.amount
+				if over := percent > cpuThreshold; over && !cpuOver {
+					crossed("child-cpu-threshold", fmt.Sprintf(
+						"The child's CPU use, %.2f cores, exceeded %g%% of %s, %g cores",
+						cores, cpuThreshold, limit.name, limit.amount))
+				} else if !over && cpuOver {
+					logging.EmitEvent(emitter, "child-cpu-threshold-cleared", fmt.Sprintf(
+						"The child's CPU use, %.2f cores, is back below %g%% of %s",
+						cores, cpuThreshold, limit.name))
+				}
+				cpuOver = percent > cpuThreshold
+			}
+		}
+	})
+}
+
+// memoryLimit returns the cgroup's memory limit, from cgroup v2 or v1, or
+// without one, the machine's memory, or nothing if that can't be read
+func memoryLimit() resourceLimit {
+	if limit, err := strconv.ParseFloat(readCgroup("memory.max"), 64); err == nil {
+		return resourceLimit{limit, "the cgroup memory limit"}
+	}
+	// cgroup v1 reports no limit as a huge number
+	if limit, err := strconv.ParseFloat(readCgroup("memory/memory.limit_in_bytes"), 64); err == nil && limit < 1<<60 {
+		return resourceLimit{limit, "the cgroup memory limit"}
+	}
+	meminfo, _ := ioutil.ReadFile("/proc/meminfo")
+	for _, line := range strings.Split(string(meminfo), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "MemTotal:" {
+			if kilobytes, err := strconv.ParseFloat(fields[1], 64); err == nil {
+				return resourceLimit{kilobytes * 1024, "the machine's memory"}
+			}
+		}
+	}
+	return resourceLimit{}
+}
+
+// cpuLimit returns the cgroup's CPU quota in cores, from cgroup v2 or v1, or
+// without one, the machine's CPUs
+func cpuLimit() resourceLimit {
+	if fields := strings.Fields(readCgroup("cpu.max")); len(fields) == 2 {
+		quota, quotaErr := strconv.ParseFloat(fields[0], 64)
+		period, periodErr := strconv.ParseFloat(fields[1], 64)
+		if quotaErr == nil && periodErr == nil && quota > 0 && period > 0 {
+			return resourceLimit{quota / period, "the cgroup CPU limit"}
+		}
+	}
+	quota, quotaErr := strconv.ParseFloat(readCgroup("cpu/cpu.cfs_quota_us"), 64)
+	period, periodErr := strconv.ParseFloat(readCgroup("cpu/cpu.cfs_period_us"), 64)
+	if quotaErr == nil && periodErr == nil && quota > 0 && period > 0 {
+		return resourceLimit{quota / period, "the cgroup CPU limit"}
+	}
+	return resourceLimit{float64(runtime.NumCPU()), "the machine's CPUs"}
+}
+
+// readCgroup reads a file of the container's cgroup, which the child shares
+func readCgroup(name string) string {
+	contents, err := ioutil.ReadFile("/sys/fs/cgroup/" + name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+func mebibytes(bytes float64) string {
+	return fmt.Sprintf("%.0f MiB", bytes/(1<<20))
+}
@@ -0,0 +1,575 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/RedHatInsights/haberdasher/fips"
+)
+
+// PKCS#12 (RFC 7292) is decoded here rather than with x/crypto's pkcs12,
+// which only knows the legacy ciphers: OpenSSL 3 and recent Java encrypt with
+// PBES2 and AES by default. Only password integrity and privacy are
+// supported, which is all anything writes.
+
+var errPassphrase = errors.New("incorrect passphrase")
+
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidPBEWithSHA3DES      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHA128BitRC2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHA40BitRC2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidDESEDE3CBC          = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidAES128CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1                = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidHMACWithSHA1        = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA224      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional"`
+}
+
+type safeBag struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"tag:0,explicit"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// passphrase is a passphrase in the forms the two kinds of encryption take it
+type passphrase struct {
+	// raw is for PBES2, which takes the bytes as they are
+	raw []byte
+	// bmp is for PKCS#12's own encryption and MAC: UTF-16 with a terminator
+	bmp []byte
+}
+
+func newPassphrase(s string) passphrase {
+	var bmp []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		bmp = append(bmp, byte(r>>8), byte(r))
+	}
+	return passphrase{raw: []byte(s), bmp: append(bmp, 0, 0)}
+}
+
+// decodePKCS12 returns the private keys and the certificates in a PKCS#12
+// file
+func decodePKCS12(data []byte, s string) ([]crypto.PrivateKey, []*x509.Certificate, error) {
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a PKCS#12 file: %v", err)
+	}
+	var pfx pfxPDU
+	if rest, err := asn1.Unmarshal(der, &pfx); err != nil || len(rest) > 0 {
+		return nil, nil, errors.New("not a PKCS#12 file")
+	}
+	if pfx.Version != 3 {
+		return nil, nil, fmt.Errorf("unsupported PKCS#12 version %d", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return nil, nil, errors.New("only password protected PKCS#12 files are supported")
+	}
+	var authSafe []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafe); err != nil {
+		return nil, nil, err
+	}
+
+	password := newPassphrase(s)
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
+		// An empty password has been written both with and without its
+		// terminator
+		candidates := []passphrase{password}
+		if s == "" {
+			candidates = append(candidates, passphrase{})
+		}
+		verified := false
+		for _, candidate := range candidates {
+			if verified, err = verifyMAC(&pfx.MacData, authSafe, candidate.bmp); err != nil {
+				return nil, nil, err
+			}
+			if verified {
+				password = candidate
+				break
+			}
+		}
+		if !verified {
+			return nil, nil, errPassphrase
+		}
+	}
+
+	var contents []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return nil, nil, err
+	}
+	var keys []crypto.PrivateKey
+	var certs []*x509.Certificate
+	for _, content := range contents {
+		var safeContents []byte
+		switch {
+		case content.ContentType.Equal(oidData):
+			if _, err := asn1.Unmarshal(content.Content.Bytes, &safeContents); err != nil {
+				return nil, nil, err
+			}
+		case content.ContentType.Equal(oidEncryptedData):
+			var encrypted encryptedData
+			if _, err := asn1.Unmarshal(content.Content.Bytes, &encrypted); err != nil {
+				return nil, nil, err
+			}
+			ciphertext, err := encryptedContent(encrypted.EncryptedContentInfo.EncryptedContent)
+			if err != nil {
+				return nil, nil, err
+			}
+			if safeContents, err = decrypt(encrypted.EncryptedContentInfo.ContentEncryptionAlgorithm, ciphertext, password); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("unsupported PKCS#12 content type %v", content.ContentType)
+		}
+
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(safeContents, &bags); err != nil {
+			// Garbage which happened to be padded properly
+			if content.ContentType.Equal(oidEncryptedData) {
+				return nil, nil, errPassphrase
+			}
+			return nil, nil, err
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidKeyBag):
+				key, err := x509.ParsePKCS8PrivateKey(bag.Value.Bytes)
+				if err != nil {
+					return nil, nil, err
+				}
+				keys = append(keys, key)
+			case bag.ID.Equal(oidShroudedKeyBag):
+				key, err := decryptPKCS8(bag.Value.Bytes, password)
+				if err != nil {
+					return nil, nil, err
+				}
+				keys = append(keys, key)
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+					return nil, nil, err
+				}
+				if !cb.ID.Equal(oidX509Certificate) {
+					continue
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return nil, nil, err
+				}
+				certs = append(certs, cert)
+			}
+		}
+	}
+	return keys, certs, nil
+}
+
+// encryptedContent returns the bytes of an EncryptedContentInfo's implicitly
+// tagged OCTET STRING, which BER allows in pieces
+func encryptedContent(value asn1.RawValue) ([]byte, error) {
+	if value.Class != asn1.ClassContextSpecific || value.Tag != 0 {
+		return nil, errors.New("PKCS#12 file has no encrypted content")
+	}
+	if !value.IsCompound {
+		return value.Bytes, nil
+	}
+	var joined []byte
+	for rest := value.Bytes; len(rest) > 0; {
+		var piece []byte
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &piece); err != nil {
+			return nil, err
+		}
+		joined = append(joined, piece...)
+	}
+	return joined, nil
+}
+
+// decryptPKCS8 decrypts a PKCS#8 EncryptedPrivateKeyInfo
+func decryptPKCS8(der []byte, password passphrase) (crypto.PrivateKey, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, errors.New("invalid encrypted private key")
+	}
+	plaintext, err := decrypt(info.Algorithm, info.EncryptedData, password)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(plaintext)
+	if err != nil {
+		return nil, errPassphrase
+	}
+	return key, nil
+}
+
+func verifyMAC(mac *macData, message, password []byte) (bool, error) {
+	newHash, blockSize, err := digest(mac.Mac.Algorithm.Algorithm)
+	if err != nil {
+		return false, fmt.Errorf("unsupported PKCS#12 MAC: %v", err)
+	}
+	key := pkcs12KDF(newHash, blockSize, mac.MacSalt, password, 3, mac.Iterations, newHash().Size())
+	h := hmac.New(newHash, key)
+	h.Write(message)
+	return hmac.Equal(h.Sum(nil), mac.Mac.Digest), nil
+}
+
+// digest returns a hash and its block size
+func digest(oid asn1.ObjectIdentifier) (func() hash.Hash, int, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return sha1.New, sha1.BlockSize, nil
+	case oid.Equal(oidSHA256):
+		return sha256.New, sha256.BlockSize, nil
+	case oid.Equal(oidSHA384):
+		return sha512.New384, sha512.BlockSize, nil
+	case oid.Equal(oidSHA512):
+		return sha512.New, sha512.BlockSize, nil
+	}
+	return nil, 0, fmt.Errorf("unknown digest %v", oid)
+}
+
+// decrypt decrypts data with a PKCS#12 or PBES2 password based encryption
+// scheme
+func decrypt(algorithm pkix.AlgorithmIdentifier, data []byte, password passphrase) ([]byte, error) {
+	var block cipher.Block
+	var iv []byte
+	switch oid := algorithm.Algorithm; {
+	case oid.Equal(oidPBEWithSHA3DES), oid.Equal(oidPBEWithSHA128BitRC2), oid.Equal(oidPBEWithSHA40BitRC2):
+		if err := fips.Check("PKCS#12's legacy 3DES and RC2 encryption"); err != nil {
+			return nil, err
+		}
+		var params pbeParams
+		if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		derive := func(id byte, size int) []byte {
+			return pkcs12KDF(sha1.New, sha1.BlockSize, params.Salt, password.bmp, id, params.Iterations, size)
+		}
+		var err error
+		switch {
+		case oid.Equal(oidPBEWithSHA3DES):
+			block, err = des.NewTripleDESCipher(derive(1, 24))
+		case oid.Equal(oidPBEWithSHA128BitRC2):
+			block, err = newRC2(derive(1, 16), 128)
+		default:
+			block, err = newRC2(derive(1, 5), 40)
+		}
+		if err != nil {
+			return nil, err
+		}
+		iv = derive(2, block.BlockSize())
+	case oid.Equal(oidPBES2):
+		var err error
+		if block, iv, err = pbes2(algorithm, password.raw); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encryption %v", oid)
+	}
+
+	size := block.BlockSize()
+	if len(data) == 0 || len(data)%size != 0 || len(iv) != size {
+		return nil, errors.New("invalid encrypted data")
+	}
+	plaintext := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, data)
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > size || !bytes.Equal(plaintext[len(plaintext)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errPassphrase
+	}
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+// pbes2 returns the cipher and IV of PBES2 (RFC 8018) with PBKDF2
+func pbes2(algorithm pkix.AlgorithmIdentifier, password []byte) (cipher.Block, []byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, nil, fmt.Errorf("unsupported key derivation %v", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, nil, err
+	}
+	prf := sha1.New
+	switch oid := kdf.PRF.Algorithm; {
+	case len(oid) == 0, oid.Equal(oidHMACWithSHA1):
+	case oid.Equal(oidHMACWithSHA224):
+		prf = sha256.New224
+	case oid.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case oid.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case oid.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, nil, fmt.Errorf("unsupported PBKDF2 function %v", oid)
+	}
+
+	scheme := params.EncryptionScheme
+	var keySize int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch oid := scheme.Algorithm; {
+	case oid.Equal(oidAES128CBC):
+		keySize, newCipher = 16, aes.NewCipher
+	case oid.Equal(oidAES192CBC):
+		keySize, newCipher = 24, aes.NewCipher
+	case oid.Equal(oidAES256CBC):
+		keySize, newCipher = 32, aes.NewCipher
+	case oid.Equal(oidDESEDE3CBC):
+		if err := fips.Check("3DES"); err != nil {
+			return nil, nil, err
+		}
+		keySize, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, nil, fmt.Errorf("unsupported encryption %v", oid)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(scheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, err
+	}
+	block, err := newCipher(pbkdf2.Key(password, kdf.Salt, kdf.Iterations, keySize, prf))
+	return block, iv, err
+}
+
+// pkcs12KDF derives size bytes of key material for the purpose id, 1 for
+// keys, 2 for IVs, and 3 for MACs, as RFC 7292 appendix B.2 describes
+func pkcs12KDF(newHash func() hash.Hash, v int, salt, password []byte, id byte, iterations, size int) []byte {
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		filled := make([]byte, v*((len(b)+v-1)/v))
+		for i := range filled {
+			filled[i] = b[i%len(b)]
+		}
+		return filled
+	}
+	diversifier := bytes.Repeat([]byte{id}, v)
+	input := append(fill(salt), fill(password)...)
+
+	var key []byte
+	for len(key) < size {
+		h := newHash()
+		h.Write(diversifier)
+		h.Write(input)
+		a := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			h.Reset()
+			h.Write(a)
+			a = h.Sum(a[:0])
+		}
+		key = append(key, a...)
+
+		// Add the output plus one to each block of the input, for the next
+		b := fill(a)
+		for j := 0; j < len(input); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(input[j+k]) + int(b[k]) + carry
+				input[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return key[:size]
+}
+
+// berToDER re-encodes BER, which Java and Windows write PKCS#12 files in,
+// as the DER encoding/asn1 insists on: lengths are definite and minimal, and
+// OCTET STRINGs in pieces are joined
+func berToDER(ber []byte) ([]byte, error) {
+	tag, value, rest, err := berElement(ber)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data")
+	}
+	return derElement(tag, value), nil
+}
+
+// berElement decodes the element at the start of ber, returning its tag, its
+// contents in DER, and what follows it
+func berElement(ber []byte) ([]byte, []byte, []byte, error) {
+	invalid := errors.New("invalid encoding")
+	if len(ber) < 2 {
+		return nil, nil, nil, invalid
+	}
+	tagSize := 1
+	if ber[0]&0x1f == 0x1f {
+		for tagSize < len(ber) && ber[tagSize]&0x80 != 0 {
+			tagSize++
+		}
+		tagSize++
+	}
+	if tagSize >= len(ber) {
+		return nil, nil, nil, invalid
+	}
+	tag, rest := ber[:tagSize], ber[tagSize:]
+	constructed := tag[0]&0x20 != 0
+
+	first := rest[0]
+	rest = rest[1:]
+	indefinite := first == 0x80
+	length := int(first)
+	if first > 0x80 {
+		n := int(first & 0x7f)
+		if n > 4 || n > len(rest) {
+			return nil, nil, nil, invalid
+		}
+		length = 0
+		for _, b := range rest[:n] {
+			length = length<<8 | int(b)
+		}
+		rest = rest[n:]
+	}
+	if indefinite && !constructed {
+		return nil, nil, nil, invalid
+	}
+	if !indefinite && (length < 0 || length > len(rest)) {
+		return nil, nil, nil, invalid
+	}
+	if !constructed {
+		return tag, rest[:length], rest[length:], nil
+	}
+
+	inner := rest
+	if !indefinite {
+		inner, rest = rest[:length], rest[length:]
+	}
+	octetString := len(tag) == 1 && tag[0] == 0x24
+	var value []byte
+	for {
+		if indefinite && len(inner) >= 2 && inner[0] == 0 && inner[1] == 0 {
+			rest = inner[2:]
+			break
+		}
+		if len(inner) == 0 {
+			if indefinite {
+				return nil, nil, nil, invalid
+			}
+			break
+		}
+		childTag, childValue, after, err := berElement(inner)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if octetString {
+			value = append(value, childValue...)
+		} else {
+			value = append(value, derElement(childTag, childValue)...)
+		}
+		inner = after
+	}
+	if octetString {
+		tag = []byte{0x04}
+	}
+	return tag, value, rest, nil
+}
+
+func derElement(tag, value []byte) []byte {
+	element := append([]byte{}, tag...)
+	if len(value) < 0x80 {
+		element = append(element, byte(len(value)))
+	} else {
+		var length []byte
+		for n := len(value); n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		element = append(element, 0x80|byte(len(length)))
+		element = append(element, length...)
+	}
+	return append(element, value...)
+}
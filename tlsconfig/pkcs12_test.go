@@ -0,0 +1,118 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures in testdata were exported from the PEM files next to them
+// with OpenSSL 3, with the passphrase "secret":
+//
+//	openssl pkcs12 -export -inkey client.key -in client.pem -certfile ca.pem -out client-aes.p12
+//	openssl pkcs12 -export -legacy -inkey client.key -in client.pem -certfile ca.pem -out client-rc2.p12
+//	openssl pkcs12 -export -keypbe PBE-SHA1-3DES -certpbe PBE-SHA1-3DES -macalg sha1 -inkey rsa.key -in rsa.pem -out rsa-3des.p12
+func TestLoadKeystore(t *testing.T) {
+	tests := []struct {
+		keystore string
+		cert     string
+		key      string
+		chain    []string
+	}{
+		{"client-aes.p12", "client.pem", "client.key", []string{"ca.pem"}},
+		{"client-rc2.p12", "client.pem", "client.key", []string{"ca.pem"}},
+		{"rsa-3des.p12", "rsa.pem", "rsa.key", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.keystore, func(t *testing.T) {
+			got, err := loadKeystore(filepath.Join("testdata", test.keystore), "secret")
+			if err != nil {
+				t.Fatalf("loadKeystore: %v", err)
+			}
+			want, err := tls.LoadX509KeyPair(filepath.Join("testdata", test.cert), filepath.Join("testdata", test.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range test.chain {
+				want.Certificate = append(want.Certificate, readCertificate(t, path).Raw)
+			}
+			if len(got.Certificate) != len(want.Certificate) {
+				t.Fatalf("got %d certificates, want %d", len(got.Certificate), len(want.Certificate))
+			}
+			for i := range want.Certificate {
+				if !bytes.Equal(got.Certificate[i], want.Certificate[i]) {
+					t.Errorf("certificate %d differs from the one exported", i)
+				}
+			}
+			if got.Leaf == nil || !bytes.Equal(got.Leaf.Raw, want.Certificate[0]) {
+				t.Error("the leaf isn't the certificate for the key")
+			}
+			gotKey, err := x509.MarshalPKCS8PrivateKey(got.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantKey, err := x509.MarshalPKCS8PrivateKey(want.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(gotKey, wantKey) {
+				t.Error("the private key differs from the one exported")
+			}
+		})
+	}
+}
+
+func TestLoadKeystoreWrongPassphrase(t *testing.T) {
+	for _, keystore := range []string{"client-aes.p12", "client-rc2.p12", "rsa-3des.p12"} {
+		if _, err := loadKeystore(filepath.Join("testdata", keystore), "wrong"); err == nil {
+			t.Errorf("%s: loaded with the wrong passphrase", keystore)
+		}
+	}
+}
+
+func TestBERToDER(t *testing.T) {
+	tests := []struct {
+		name string
+		ber  []byte
+		der  []byte
+	}{
+		{"already DER", []byte{0x04, 0x02, 'a', 'b'}, []byte{0x04, 0x02, 'a', 'b'}},
+		{"long form length", []byte{0x04, 0x81, 0x02, 'a', 'b'}, []byte{0x04, 0x02, 'a', 'b'}},
+		{"OCTET STRING in pieces", []byte{0x24, 0x80, 0x04, 0x02, 'a', 'b', 0x04, 0x01, 'c', 0x00, 0x00}, []byte{0x04, 0x03, 'a', 'b', 'c'}},
+		{"indefinite SEQUENCE", []byte{0x30, 0x80, 0x02, 0x01, 0x03, 0x00, 0x00}, []byte{0x30, 0x03, 0x02, 0x01, 0x03}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := berToDER(test.ber)
+			if err != nil {
+				t.Fatalf("berToDER(% x): %v", test.ber, err)
+			}
+			if !bytes.Equal(got, test.der) {
+				t.Errorf("berToDER(% x) = % x, want % x", test.ber, got, test.der)
+			}
+		})
+	}
+	if _, err := berToDER([]byte{0x30, 0x80, 0x02, 0x01}); err == nil {
+		t.Error("berToDER accepted a truncated element")
+	}
+}
+
+func readCertificate(t *testing.T, path string) *x509.Certificate {
+	contents, err := ioutil.ReadFile(filepath.Join("testdata", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		t.Fatalf("%s isn't PEM", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
@@ -0,0 +1,187 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/proto/spiffe/workload"
+	"google.golang.org/grpc"
+)
+
+// issueSVID returns a certificate for the SPIFFE ID id, signed by parent and
+// parentKey, or self-signed as a CA if they're nil
+func issueSVID(t *testing.T, id string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (tls.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		URIs:         []*url.URL{uri},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, key
+}
+
+// An svidAgent serves the Workload API, with one SVID which never rotates
+type svidAgent struct {
+	workload.UnimplementedSpiffeWorkloadAPIServer
+	svid *workload.X509SVIDResponse
+}
+
+func (a *svidAgent) FetchX509SVID(_ *workload.X509SVIDRequest, stream grpc.ServerStreamingServer[workload.X509SVIDResponse]) error {
+	if err := stream.Send(a.svid); err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// A workload in example.org connects to a collector which proves its SPIFFE
+// ID, with an SVID which isn't for the collector's address
+func TestFromEnvSPIFFE(t *testing.T) {
+	ca, caKey := issueSVID(t, "spiffe://example.org", nil, nil)
+	ours, ourKey := issueSVID(t, "spiffe://example.org/haberdasher", ca.Leaf, caKey)
+	collector, _ := issueSVID(t, "spiffe://example.org/collector", ca.Leaf, caKey)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ourKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := grpc.NewServer()
+	workload.RegisterSpiffeWorkloadAPIServer(agent, &svidAgent{svid: &workload.X509SVIDResponse{
+		Svids: []*workload.X509SVID{{
+			SpiffeId:    "spiffe://example.org/haberdasher",
+			X509Svid:    ours.Leaf.Raw,
+			X509SvidKey: pkcs8,
+			Bundle:      ca.Leaf.Raw,
+		}},
+	}})
+	go agent.Serve(listener)
+	defer agent.Stop()
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].URIs[0].String()))
+	}))
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{collector},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    x509.NewCertPool(),
+	}
+	server.TLS.ClientCAs.AddCert(ca.Leaf)
+	server.StartTLS()
+	defer server.Close()
+
+	t.Setenv("SPIFFE_ENDPOINT_SOCKET", "unix://"+socket)
+	tests := []struct {
+		name     string
+		serverID string
+		// want is part of the error, or "" if it should connect
+		want string
+	}{
+		{"in our trust domain", "", ""},
+		{"the collector", "spiffe://example.org/collector", ""},
+		{"another server", "spiffe://example.org/gateway", "the peer is spiffe://example.org/collector, rather than spiffe://example.org/gateway"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("HABERDASHER_TEST_SPIFFE", "1")
+			if test.serverID != "" {
+				t.Setenv("HABERDASHER_TEST_SPIFFE_SERVER_ID", test.serverID)
+			}
+			config, err := FromEnv("HABERDASHER_TEST")
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+			response, err := client.Get(server.URL)
+			if test.want != "" {
+				if err == nil || !strings.Contains(err.Error(), test.want) {
+					t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if presented, _ := ioutil.ReadAll(response.Body); string(presented) != "spiffe://example.org/haberdasher" {
+				t.Errorf("presented %s", presented)
+			}
+		})
+	}
+}
+
+func TestFromEnvSPIFFEMisconfigured(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"with a client certificate", map[string]string{
+			"HABERDASHER_TEST_SPIFFE":      "1",
+			"HABERDASHER_TEST_CLIENT_CERT": filepath.Join("testdata", "client.pem"),
+			"HABERDASHER_TEST_CLIENT_KEY":  filepath.Join("testdata", "client.key"),
+		}, "can't be combined with a client certificate"},
+		{"a server ID and CAs", map[string]string{
+			"HABERDASHER_TEST_SPIFFE_SERVER_ID": "spiffe://example.org/collector",
+			"HABERDASHER_TEST_CA_CERT":          filepath.Join("testdata", "ca.pem"),
+		}, "HABERDASHER_TEST_SPIFFE_SERVER_ID can't be combined"},
+		{"a server ID which isn't a SPIFFE ID", map[string]string{
+			"HABERDASHER_TEST_SPIFFE_SERVER_ID": "https://collector.example.org",
+		}, "must be a SPIFFE ID"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for env, value := range test.env {
+				t.Setenv(env, value)
+			}
+			if _, err := FromEnv("HABERDASHER_TEST"); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("returned %v, want an error mentioning %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+// Package tlsconfig builds the TLS settings shared by the network emitters
+// from their environment variables.
+package tlsconfig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/fips"
+	"github.com/RedHatInsights/haberdasher/spiffe"
+)
+
+// FromEnv builds a TLS configuration for an emitter from:
+//
+//	<prefix>_TLS                 non-empty to use TLS with the system's CAs
+//	<prefix>_CA_CERT             a PEM or PKCS#12 file of CAs to trust instead
+//	<prefix>_CLIENT_CERT         a PEM client certificate, for mutual TLS
+//	<prefix>_CLIENT_KEY          the client certificate's PEM private key
+//	<prefix>_CLIENT_P12          a PKCS#12 file of both, instead of the two
+//	<prefix>_TLS_PASSPHRASE      the passphrase of the key or PKCS#12 files
+//	<prefix>_TLS_PASSPHRASE_FILE a file holding the passphrase instead
+//	<prefix>_TLS_SKIP_VERIFY     non-empty to skip verifying the server
+//	<prefix>_SPIFFE              non-empty to use the workload's SPIFFE SVID
+//	<prefix>_SPIFFE_SERVER_ID    the SPIFFE ID the server must have
+//
+// With SPIFFE, the SVID is the client certificate, and unless CAs are given
+// the server is authenticated by its SPIFFE ID rather than its name: it must
+// be the one given, or by default in our trust domain.
+//
+// Setting any of them turns TLS on. It returns nil if TLS isn't wanted, and an
+// error for any misconfiguration, so emitters can fail fast during Setup.
+func FromEnv(prefix string) (*tls.Config, error) {
+	enabled := config.FlagSetting(prefix + "_TLS")
+	caCert, hasCA := config.Setting(prefix + "_CA_CERT")
+	clientCert, hasCert := config.Setting(prefix + "_CLIENT_CERT")
+	clientKey, hasKey := config.Setting(prefix + "_CLIENT_KEY")
+	clientP12, hasP12 := config.Setting(prefix + "_CLIENT_P12")
+	skipVerify := config.FlagSetting(prefix + "_TLS_SKIP_VERIFY")
+	serverID, hasServerID := config.Setting(prefix + "_SPIFFE_SERVER_ID")
+	useSPIFFE := config.FlagSetting(prefix+"_SPIFFE") || hasServerID
+	passphrase, hasPassphrase, err := readPassphrase(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled && !hasCA && !hasCert && !hasKey && !hasP12 && !hasPassphrase && !skipVerify && !useSPIFFE {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: skipVerify,
+	}
+	if hasCA {
+		contents, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("%s_CA_CERT: %v", prefix, err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if block, _ := pem.Decode(contents); block == nil {
+			// A PKCS#12 truststore, as Java uses
+			_, certs, err := decodePKCS12(contents, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("%s_CA_CERT: %s: %v", prefix, caCert, err)
+			}
+			for _, cert := range certs {
+				config.RootCAs.AddCert(cert)
+			}
+			if len(certs) == 0 {
+				return nil, fmt.Errorf("%s_CA_CERT: no certificates found in %s", prefix, caCert)
+			}
+		} else if !config.RootCAs.AppendCertsFromPEM(contents) {
+			return nil, fmt.Errorf("%s_CA_CERT: no certificates found in %s", prefix, caCert)
+		}
+	}
+	if hasP12 && (hasCert || hasKey) {
+		return nil, errors.New(prefix + "_CLIENT_P12 can't be combined with " + prefix + "_CLIENT_CERT or " + prefix + "_CLIENT_KEY")
+	}
+	if hasCert != hasKey {
+		return nil, errors.New(prefix + "_CLIENT_CERT and " + prefix + "_CLIENT_KEY must be set together")
+	}
+	if hasCert {
+		cert, err := loadKeyPair(clientCert, clientKey, passphrase, hasPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%s_CLIENT_CERT: %v", prefix, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if hasP12 {
+		cert, err := loadKeystore(clientP12, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%s_CLIENT_P12: %s: %v", prefix, clientP12, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if useSPIFFE {
+		if err := useSVID(config, prefix, serverID); err != nil {
+			return nil, err
+		}
+	}
+	return fips.TLS(config), nil
+}
+
+// useSVID authenticates with the workload's SVID, which is kept current as
+// it's rotated, and the server by its own, unless it's verified otherwise
+func useSVID(config *tls.Config, prefix, serverID string) error {
+	if len(config.Certificates) > 0 {
+		return errors.New(prefix + "_SPIFFE can't be combined with a client certificate")
+	}
+	verified := config.RootCAs != nil || config.InsecureSkipVerify
+	if serverID != "" && verified {
+		return errors.New(prefix + "_SPIFFE_SERVER_ID can't be combined with " + prefix + "_CA_CERT or " + prefix + "_TLS_SKIP_VERIFY")
+	}
+	if serverID != "" {
+		if id, err := url.Parse(serverID); err != nil || id.Scheme != "spiffe" || id.Host == "" {
+			return errors.New(prefix + "_SPIFFE_SERVER_ID must be a SPIFFE ID, like spiffe://example.org/collector")
+		}
+	}
+	source, err := spiffe.Default()
+	if err != nil {
+		return fmt.Errorf("%s_SPIFFE: %v", prefix, err)
+	}
+	config.GetClientCertificate = source.ClientCertificate
+	if !verified {
+		// The server proves its SPIFFE ID instead of its name, which its
+		// SVID may not have
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			return source.VerifyPeer(raw, serverID)
+		}
+	}
+	return nil
+}
+
+// readPassphrase returns the passphrase from <prefix>_TLS_PASSPHRASE or the
+// file <prefix>_TLS_PASSPHRASE_FILE names, and whether either is set
+func readPassphrase(prefix string) (string, bool, error) {
+	passphrase, hasPassphrase := config.Setting(prefix + "_TLS_PASSPHRASE")
+	path, hasFile := config.Setting(prefix + "_TLS_PASSPHRASE_FILE")
+	if hasPassphrase && hasFile {
+		return "", false, errors.New(prefix + "_TLS_PASSPHRASE and " + prefix + "_TLS_PASSPHRASE_FILE can't both be set")
+	}
+	if !hasFile {
+		return passphrase, hasPassphrase, nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_TLS_PASSPHRASE_FILE: %v", prefix, err)
+	}
+	return strings.TrimRight(string(contents), "\r\n"), true, nil
+}
+
+// loadKeyPair loads a PEM certificate and private key, decrypting the key if
+// it's encrypted
+func loadKeyPair(certFile, keyFile, passphrase string, hasPassphrase bool) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	for rest := keyPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		encrypted := block.Type == "ENCRYPTED PRIVATE KEY"
+		if !encrypted && !x509.IsEncryptedPEMBlock(block) {
+			continue
+		}
+		if !hasPassphrase {
+			return tls.Certificate{}, fmt.Errorf("%s is encrypted, but no passphrase is set", keyFile)
+		}
+		if keyPEM, err = decryptPEM(block, passphrase); err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %v", keyFile, err)
+		}
+		break
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// decryptPEM decrypts an encrypted PKCS#8 key, or a key in OpenSSL's legacy
+// encrypted PEM, returning it as PEM
+func decryptPEM(block *pem.Block, passphrase string) ([]byte, error) {
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		key, err := decryptPKCS8(block.Bytes, newPassphrase(passphrase))
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	// Its key is derived with MD5
+	if err := fips.Check("OpenSSL's legacy PEM encryption"); err != nil {
+		return nil, err
+	}
+	der, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+	if err == x509.IncorrectPasswordError {
+		return nil, errPassphrase
+	} else if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// loadKeystore loads a client certificate from a PKCS#12 file holding one
+// private key. The certificate is the one for the key, and any others are
+// sent as its chain.
+func loadKeystore(path, passphrase string) (tls.Certificate, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keys, certs, err := decodePKCS12(contents, passphrase)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if len(keys) != 1 {
+		return tls.Certificate{}, fmt.Errorf("found %d private keys, rather than one", len(keys))
+	}
+	signer, isSigner := keys[0].(crypto.Signer)
+	if !isSigner {
+		return tls.Certificate{}, errors.New("unsupported private key")
+	}
+	public, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := tls.Certificate{PrivateKey: keys[0]}
+	var chain [][]byte
+	for _, c := range certs {
+		if ours, err := x509.MarshalPKIXPublicKey(c.PublicKey); cert.Leaf == nil && err == nil && bytes.Equal(ours, public) {
+			cert.Leaf = c
+			continue
+		}
+		chain = append(chain, c.Raw)
+	}
+	if cert.Leaf == nil {
+		return tls.Certificate{}, errors.New("no certificate for the private key")
+	}
+	cert.Certificate = append([][]byte{cert.Leaf.Raw}, chain...)
+	return cert, nil
+}
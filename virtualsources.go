@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/RedHatInsights/haberdasher/logging"
+)
+
+// A virtualSource carves the lines matching a pattern out of the child's
+// streams, for applications which interleave several kinds of logs (such as
+// access and error logs) on stderr
+type virtualSource struct {
+	Name    string            `json:"name"`
+	Match   string            `json:"match"`
+	Labels  map[string]string `json:"labels"`
+	Emitter string            `json:"emitter"`
+
+	pattern *regexp.Regexp
+	emitter logging.Emitter
+}
+
+// HABERDASHER_VIRTUAL_SOURCES is a JSON array of virtual sources, each with a
+// name, a regex to match lines against, and optionally labels and the emitter
+// to route its lines to ("drop" discards them). A line belongs to the first
+// virtual source it matches; unmatched lines are shipped as usual. Matched
+// lines carry the virtual source's name in event.dataset. Policy fragments may
+// add more; see loadPolicies.
+func loadVirtualSources(defaultEmitter logging.Emitter, sources []*virtualSource) []*virtualSource {
+	if err := compileVirtualSources(sources); err != nil {
+		log.Fatal("HABERDASHER_VIRTUAL_SOURCES: ", err)
+	}
+	if err := routeVirtualSources(defaultEmitter, sources); err != nil {
+		log.Fatal("HABERDASHER_VIRTUAL_SOURCES: ", err)
+	}
+	for _, source := range sources {
+		log.Println("Configured virtual source:", source.Name)
+	}
+	return sources
+}
+
+// compileVirtualSources checks virtual sources and compiles their patterns
+func compileVirtualSources(sources []*virtualSource) error {
+	for _, source := range sources {
+		if source.Name == "" {
+			return fmt.Errorf("every virtual source needs a name")
+		}
+		var err error
+		if source.pattern, err = regexp.Compile(source.Match); err != nil {
+			return fmt.Errorf("%s: %v", source.Name, err)
+		}
+	}
+	return nil
+}
+
+// routeVirtualSources resolves the emitters of virtual sources, setting them
+// up if need be
+func routeVirtualSources(defaultEmitter logging.Emitter, sources []*virtualSource) error {
+	for _, source := range sources {
+		source.emitter = defaultEmitter
+		if source.Emitter != "" {
+			var err error
+			if source.emitter, err = routeTo(source.Emitter); err != nil {
+				return fmt.Errorf("%s: %v", source.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// classify finds the virtual source a line belongs to
+func classify(sources []*virtualSource, line string) *virtualSource {
+	for _, source := range sources {
+		if source.pattern.MatchString(line) {
+			return source
+		}
+	}
+	return nil
+}
+
+// drops reports whether virtual sources would discard a line
+func drops(sources []*virtualSource, line string) bool {
+	source := classify(sources, line)
+	return source != nil && source.Emitter == "drop"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"github.com/RedHatInsights/haberdasher/config"
+	"github.com/RedHatInsights/haberdasher/logging"
+	"github.com/RedHatInsights/haberdasher/metrics"
+)
+
+// How long the watchdog waits on the emitter's health check, which may be
+// caught up in the same deadlock
+const watchdogHealthTimeout = 5 * time.Second
+
+var pipelineStalls = metrics.NewCounter("haberdasher_pipeline_stalls_total", "Times the pipeline stopped shipping lines with no backend outage to explain it.")
+
+// startWatchdog watches for a stalled pipeline, if HABERDASHER_WATCHDOG_TIMEOUT
+// is set: lines waiting in the queue, but none shipped for that long. If the
+// emitter says its backend is healthy, there's no backpressure to explain it,
+// and it's most likely a deadlock, so every goroutine's stack is dumped to
+// stderr, and to a file in HABERDASHER_WATCHDOG_DIR if set, once per stall.
+func startWatchdog(emitter logging.Emitter, queue *logging.Queue) {
+	timeout, exists := config.DurationSetting("HABERDASHER_WATCHDOG_TIMEOUT")
+	if !exists {
+		return
+	}
+	dir, _ := config.Setting("HABERDASHER_WATCHDOG_DIR")
+
+	producers.start(func() {
+		var reported time.Time
+		for producers.sleep(timeout / 4) {
+			pending, lastHandled := queue.Progress()
+			stalled := logging.Clock.Since(lastHandled)
+			if pending == 0 || stalled < timeout || lastHandled.Equal(reported) {
+				continue
+			}
+			reported = lastHandled
+			if err := watchdogHealth(emitter); err != nil {
+				log.Printf("Nothing shipped for %s with %d lines waiting, but the emitter is unhealthy: %v", stalled.Round(time.Second), pending, err)
+				continue
+			}
+			pipelineStalls.Inc()
+			dumpGoroutines(dir, fmt.Sprintf("Nothing shipped for %s with %d lines waiting, and the emitter is healthy", stalled.Round(time.Second), pending))
+		}
+	})
+}
+
+// watchdogHealth checks the emitter's health, counting a check which doesn't
+// return as healthy, since it's as stuck as the rest
+func watchdogHealth(emitter logging.Emitter) error {
+	result := make(chan error, 1)
+	go func() { result <- logging.CheckHealth(emitter) }()
+	select {
+	case err := <-result:
+		return err
+	case <-logging.Clock.After(watchdogHealthTimeout):
+		return nil
+	}
+}
+
+// dumpGoroutines writes every goroutine's stack to stderr, and to a
+// timestamped file in dir if it's set
+func dumpGoroutines(dir string, reason string) {
+	var stacks bytes.Buffer
+	fmt.Fprintf(&stacks, "Pipeline stalled: %s. Goroutines:\n\n", reason)
+	pprof.Lookup("goroutine").WriteTo(&stacks, 2)
+	os.Stderr.Write(stacks.Bytes())
+	if dir == "" {
+		return
+	}
+	path := filepath.Join(dir, "goroutines-"+logging.Clock.Now().UTC().Format("20060102T150405Z")+".txt")
+	if err := ioutil.WriteFile(path, stacks.Bytes(), 0644); err != nil {
+		log.Println("Error writing goroutine dump:", err)
+		return
+	}
+	log.Println("Wrote goroutine dump to", path)
+}